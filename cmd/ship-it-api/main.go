@@ -54,13 +54,24 @@ func main() {
 	svc := service.New(
 		k8s,
 		helmstatus.New(helm.NewClient(helm.Host(cfg.TillerAddress))),
+		k8s,
 	)
 
+	var slackGroups api.SlackUserGroups
+	if cfg.SlackToken != "" {
+		slackGroups = api.NewSlackUserGroups(cfg.SlackToken)
+	}
+
 	srv := http.Server{
 		Addr: ":" + cfg.ServicePort,
 		Handler: api.NewRouter(
 			http.FileServer(http.Dir("dashboard")),
-			api.NewController(svc),
+			api.NewController(
+				svc,
+				api.SlackSigningSecret(cfg.SlackSigningSecret),
+				api.SlackApprovers(cfg.SlackApprovers, slackGroups),
+				api.ApprovalTokens(cfg.ApprovalTokens),
+			),
 			dd.NewTiming("api.time", 1.0),
 		),
 	}
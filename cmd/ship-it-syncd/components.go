@@ -0,0 +1,253 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"ship-it/internal/syncd"
+	"ship-it/internal/syncd/config"
+	"ship-it/internal/syncd/deadletter"
+	"ship-it/internal/syncd/integrations/ecr"
+	"ship-it/internal/syncd/integrations/github"
+	"ship-it/internal/syncd/integrations/k8s"
+	"ship-it/internal/syncd/integrations/registry"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	awsecr "github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	gogithub "github.com/google/go-github/v26/github"
+	"k8s.io/helm/pkg/helm"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// chartEditor edits the HelmReleases of the registry chart
+type chartEditor interface {
+	ecr.ChartEditor
+	ecr.PromotionEditor
+}
+
+// pullRequestMerger merges the pull requests of auto-merged releases, which
+// only the github chart editor opens
+type pullRequestMerger interface {
+	MergePullRequests(ctx context.Context) error
+}
+
+// newChartEditor returns the chart editor selected by CHART_EDITOR: "github"
+// commits with GitHub's API, and opens pull requests for the releases that
+// require review, and "git" pushes commits to GIT_REMOTE with plain git.
+// Either editor signs its commits with COMMIT_SIGNING_KEY, if it's set.
+func newChartEditor(l log.Logger, githubClient *gogithub.Client, conflicts metrics.Counter, cfg *config.Config) (chartEditor, error) {
+	opts := []ecr.ChartEditorOption{
+		ecr.WithConflictRetries(5, 200*time.Millisecond, conflicts),
+	}
+	if cfg.ImageDigests {
+		opts = append(opts, ecr.WithDigests())
+	}
+
+	if cfg.GitAuthorName != "" {
+		opts = append(opts, ecr.WithAuthor(ecr.GitAuthor{Name: cfg.GitAuthorName, Email: cfg.GitAuthorEmail}))
+	}
+	if cfg.GitCommitterName != "" {
+		opts = append(opts, ecr.WithCommitter(ecr.GitAuthor{Name: cfg.GitCommitterName, Email: cfg.GitCommitterEmail}))
+	}
+
+	if cfg.CommitSigningKey != "" {
+		signer, err := ecr.NewCommitSigner([]byte(cfg.CommitSigningKey), cfg.CommitSigningPassphrase)
+		if err != nil {
+			return nil, err
+		}
+
+		// GitHub only creates signed commits with an explicit author
+		if cfg.ChartEditor == "github" && cfg.GitAuthorName == "" {
+			return nil, errors.New("GIT_AUTHOR_NAME is required to sign commits with the github chart editor")
+		}
+
+		opts = append(opts, ecr.WithSigner(signer))
+	}
+
+	switch cfg.ChartEditor {
+	case "github":
+		switch cfg.AutoMergeMethod {
+		case "", "merge", "squash", "rebase":
+		default:
+			return nil, fmt.Errorf("unknown auto merge method %q, expected merge, squash or rebase", cfg.AutoMergeMethod)
+		}
+
+		autoMerge := ecr.AutoMerge{
+			Method:    cfg.AutoMergeMethod,
+			Unchecked: cfg.AutoMergeUnchecked,
+		}
+
+		opts = append(opts, ecr.WithPullRequests(l, githubClient.PullRequests, githubClient.Repositories, githubClient.Checks, githubClient.Repositories, autoMerge))
+
+		return ecr.NewChartEditor(
+			githubClient.Git,
+			cfg.GithubOrg,
+			cfg.OperationsRepository,
+			cfg.ReleaseBranch,
+			cfg.RegistryChartPath,
+			opts...,
+		), nil
+
+	case "git":
+		if cfg.GitRemote == "" {
+			return nil, errors.New("GIT_REMOTE is required by the git chart editor")
+		}
+
+		return ecr.NewGitChartEditor(
+			cfg.GitRemote,
+			cfg.ReleaseBranch,
+			cfg.RegistryChartPath,
+			cfg.GitWorkdir,
+			opts...,
+		), nil
+	}
+
+	return nil, fmt.Errorf("unknown chart editor %q", cfg.ChartEditor)
+}
+
+// newRegistry registers syncd's listeners and reconcilers, which are chosen
+// by IMAGE_LISTENER, IMAGE_RECONCILER, CHART_LISTENER and CHART_RECONCILER.
+//
+// Image listeners: "ecr" consumes ECR push events from SQS, "registry"
+// receives Docker Registry v2, Docker Hub and Harbor webhooks, and "poll"
+// polls the registries of the releases' images for new tags.
+//
+// Image reconcilers: "commit", the default, commits each image to the registry
+// chart on its own, and "batch" commits the images pushed within the batch
+// window together.
+//
+// Chart listeners: "sqs" consumes GitHub push events from SQS, and "webhook"
+// receives GitHub push webhooks.
+//
+// Chart reconcilers: "helm" installs the registry chart's release with Tiller,
+// and then applies the chart's changed HelmReleases with the Kubernetes API.
+// It posts a summary of the changed releases to CHART_WEBHOOK_URL, if it's
+// set.
+//
+// Push events from SQS that fail permanently are sent to the dead-letter
+// queue, if there is one.
+func newRegistry(ctx context.Context, l log.Logger, h metrics.Histogram, githubClient *gogithub.Client, editor ecr.ChartEditor, informer *k8s.ImageRepositoryInformer, kube client.Client, cfg *config.Config) (*syncd.Registry, error) {
+	awsSession, err := session.NewSession(cfg.AWS())
+	if err != nil {
+		return nil, err
+	}
+
+	sqsClient := sqs.New(awsSession)
+
+	var deadLetters *deadletter.Queue
+	if cfg.DeadLetterQueue != "" {
+		deadLetters, err = deadletter.NewQueue(ctx, sqsClient, cfg.DeadLetterQueue)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	r := syncd.NewRegistry()
+
+	r.RegisterImageListener("ecr", func() (syncd.ImageListener, error) {
+		if cfg.EcrQueue == "" {
+			return nil, errors.New("ECR_QUEUE is required by the ecr image listener")
+		}
+
+		imageListener, err := ecr.NewListener(l, h, cfg.AWSRegion, cfg.EcrQueue, sqsClient)
+		if err != nil {
+			return nil, err
+		}
+
+		if deadLetters != nil {
+			imageListener.WithDeadLetters(deadLetters)
+		}
+
+		if cfg.ImageDigests {
+			imageListener.WithDigests(awsecr.New(awsSession))
+		}
+
+		return imageListener, nil
+	})
+
+	r.RegisterImageListener("registry", func() (syncd.ImageListener, error) {
+		if cfg.RegistryWebhookSecret == "" {
+			return nil, errors.New("REGISTRY_WEBHOOK_SECRET is required by the registry image listener")
+		}
+
+		return registry.NewListener(l, h, cfg.RegistryWebhookAddr, cfg.RegistryWebhookSecret), nil
+	})
+
+	r.RegisterImageListener("poll", func() (syncd.ImageListener, error) {
+		tags := registry.NewTagClient(registry.Credentials(cfg.RegistryUsername, cfg.RegistryPassword))
+		store := registry.NewFileStore(cfg.PollStatePath)
+
+		return registry.NewPollingListener(l, h, informer, tags, store, cfg.PollInterval()), nil
+	})
+
+	r.RegisterImageReconciler("commit", func() (syncd.ImageReconciler, error) {
+		return ecr.NewReconciler(editor, informer), nil
+	})
+
+	r.RegisterImageReconciler("batch", func() (syncd.ImageReconciler, error) {
+		// a zero window commits every image on its own
+		if window := cfg.ImageBatchWindow(); window > 0 {
+			return ecr.NewBatchReconciler(ctx, editor, informer, window), nil
+		}
+		return ecr.NewReconciler(editor, informer), nil
+	})
+
+	r.RegisterChartListener("sqs", func() (syncd.RegistryChartListener, error) {
+		if cfg.GithubQueue == "" {
+			return nil, errors.New("GITHUB_QUEUE is required by the sqs chart listener")
+		}
+
+		chartListener, err := github.NewListener(l, h, cfg.GithubOrg, githubClient.Repositories, cfg.GithubQueue, sqsClient)
+		if err != nil {
+			return nil, err
+		}
+
+		if deadLetters != nil {
+			chartListener.WithDeadLetters(deadLetters)
+		}
+
+		return chartListener, nil
+	})
+
+	r.RegisterChartListener("webhook", func() (syncd.RegistryChartListener, error) {
+		if cfg.GithubWebhookSecret == "" {
+			return nil, errors.New("GITHUB_WEBHOOK_SECRET is required by the webhook chart listener")
+		}
+
+		return github.NewWebhookListener(
+			l,
+			h,
+			cfg.GithubOrg,
+			githubClient.Repositories,
+			cfg.GithubWebhookAddr,
+			cfg.GithubWebhookSecret,
+			cfg.OperationsRepository,
+			cfg.ReleaseBranch,
+			cfg.RegistryChartPath,
+		), nil
+	})
+
+	r.RegisterChartReconciler("helm", func() (syncd.RegistryChartReconciler, error) {
+		reconciler := github.NewReconciler(
+			l,
+			helm.NewClient(helm.Host(cfg.TillerHost)),
+			kube,
+			cfg.Namespace,
+			cfg.ReleaseName,
+			cfg.HelmTimeout(),
+		)
+
+		if cfg.ChartWebhookURL != "" {
+			reconciler = reconciler.WithNotifier(github.NewWebhookNotifier(cfg.ChartWebhookURL, cfg.ChartWebhookSecret))
+		}
+
+		return reconciler, nil
+	})
+
+	return r, nil
+}
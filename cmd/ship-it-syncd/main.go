@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"net"
 	"net/http"
 	"os"
@@ -10,24 +11,30 @@ import (
 	"time"
 
 	"ship-it/internal/syncd"
+	"ship-it/internal/syncd/admin"
 	"ship-it/internal/syncd/config"
 	"ship-it/internal/syncd/integrations/ecr"
 	"ship-it/internal/syncd/integrations/github"
 	"ship-it/internal/syncd/integrations/k8s"
 
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/bradleyfalzon/ghinstallation"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics/dogstatsd"
+	"github.com/go-kit/kit/metrics/multi"
+	kitprometheus "github.com/go-kit/kit/metrics/prometheus"
 	gogithub "github.com/google/go-github/v26/github"
-	"k8s.io/helm/pkg/helm"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 func main() {
 	logger := log.NewJSONLogger(log.NewSyncWriter(os.Stdout))
 	logger = log.With(logger, "timestamp", log.DefaultTimestampUTC, "caller", log.DefaultCaller)
 
+	if len(os.Args) > 1 && os.Args[1] == replayCommand {
+		os.Exit(replayDeadLetters(logger, os.Args[2:]))
+	}
+
 	logger.Log("event", "service.start")
 	defer logger.Log("event", "service.stop")
 
@@ -50,6 +57,46 @@ func main() {
 	dd := dogstatsd.New("wattpad.ship-it.", logger)
 	go dd.SendLoop(ctx, time.Tick(time.Second), "udp", cfg.DataDogAddress())
 
+	// metrics are sent to datadog, and served to prometheus by the admin server
+	syncHist := multi.NewHistogram(
+		dd.NewTiming("syncd.time", 1),
+		kitprometheus.NewHistogramFrom(prometheus.HistogramOpts{
+			Namespace: "shipit",
+			Subsystem: "syncd",
+			Name:      "time_milliseconds",
+			Help:      "Time to handle an event, by worker and status.",
+			Buckets:   []float64{10, 50, 100, 500, 1000, 5000, 10000, 30000, 60000},
+		}, []string{"worker", "status"}),
+	)
+
+	conflicts := multi.NewCounter(
+		dd.NewCounter("syncd.chart_editor.conflicts", 1),
+		kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "shipit",
+			Subsystem: "syncd",
+			Name:      "chart_editor_conflicts_total",
+			Help:      "Conflicting registry chart commits, by outcome.",
+		}, []string{"outcome"}),
+	)
+
+	restarts := multi.NewCounter(
+		dd.NewCounter("syncd.listener.restarts", 1),
+		kitprometheus.NewCounterFrom(prometheus.CounterOpts{
+			Namespace: "shipit",
+			Subsystem: "syncd",
+			Name:      "listener_restarts_total",
+			Help:      "Restarts of failed listeners, by listener.",
+		}, []string{"listener"}),
+	)
+
+	// the admin server starts first, so it answers probes while syncd starts
+	adminServer := admin.NewServer(logger, cfg.AdminAddr, cfg.AdminToken, promhttp.Handler())
+	go func() {
+		if err := adminServer.Run(ctx); err != nil && err != context.Canceled {
+			logger.Log("error", err)
+		}
+	}()
+
 	// adapted from http.DefaultTransport
 	defaultTransport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
@@ -80,60 +127,105 @@ func main() {
 		Transport: githubTransport,
 	})
 
-	registryEditor := ecr.NewChartEditor(
-		githubClient.Git,
-		cfg.GithubOrg,
-		cfg.OperationsRepository,
-		cfg.ReleaseBranch,
-		cfg.RegistryChartPath,
-	)
+	registryEditor, err := newChartEditor(logger, githubClient, conflicts, cfg)
+	if err != nil {
+		logger.Log("error", err)
+		os.Exit(1)
+	}
 
-	informer, err := k8s.NewInformer(ctx, cfg.Namespace)
+	if merger, ok := registryEditor.(pullRequestMerger); ok {
+		go func() {
+			if err := merger.MergePullRequests(ctx); err != nil && err != context.Canceled {
+				logger.Log("error", err)
+			}
+		}()
+	}
+
+	releaseCache, err := k8s.NewCache(cfg.Namespace)
 	if err != nil {
 		logger.Log("error", err)
 		os.Exit(1)
 	}
 
-	imageReconciler := ecr.NewReconciler(registryEditor, informer)
+	informer, err := k8s.NewInformerWithCache(ctx, releaseCache)
+	if err != nil {
+		logger.Log("error", err)
+		os.Exit(1)
+	}
 
-	chartReconciler := github.NewReconciler(
-		helm.NewClient(helm.Host(cfg.TillerHost)),
-		cfg.Namespace,
-		cfg.ReleaseName,
-		cfg.HelmTimeout(),
-	)
+	adminServer.AddCheck("informer", func() error {
+		if !informer.HasSynced() {
+			return errors.New("release cache hasn't synced")
+		}
+		return nil
+	})
 
-	imageListener, chartListener, err := initListeners(logger, githubClient, dd, cfg)
+	releaseClient, err := k8s.NewClient()
 	if err != nil {
 		logger.Log("error", err)
 		os.Exit(1)
 	}
 
-	// TODO: Allow configurable image/chart sync implementations. For now
-	// we'll just use our specific ecr+sqs/github+sqs implmentations.
-	syncd := syncd.New(chartListener, chartReconciler, imageListener, imageReconciler)
-	if err := syncd.Run(ctx); err != nil {
+	promotionListener, err := k8s.NewPromotionListener(logger, releaseCache, releaseClient)
+	if err != nil {
 		logger.Log("error", err)
 		os.Exit(1)
 	}
-}
 
-func initListeners(l log.Logger, githubClient *gogithub.Client, dd *dogstatsd.Dogstatsd, cfg *config.Config) (syncd.ImageListener, syncd.RegistryChartListener, error) {
-	syncHist := dd.NewTiming("syncd.time", 1)
+	components, err := newRegistry(ctx, logger, syncHist, githubClient, registryEditor, informer, releaseClient, cfg)
+	if err != nil {
+		logger.Log("error", err)
+		os.Exit(1)
+	}
 
-	awsSession, err := session.NewSession(cfg.AWS())
+	imageListener, err := components.ImageListener(cfg.ImageListener...)
 	if err != nil {
-		return nil, nil, err
+		logger.Log("error", err)
+		os.Exit(1)
 	}
 
-	sqsClient := sqs.New(awsSession)
+	imageReconciler, err := components.ImageReconciler(cfg.ImageReconciler)
+	if err != nil {
+		logger.Log("error", err)
+		os.Exit(1)
+	}
 
-	imageListener, err := ecr.NewListener(l, syncHist, cfg.EcrQueue, sqs.New(awsSession))
+	chartListener, err := components.ChartListener(cfg.ChartListener)
 	if err != nil {
-		return nil, nil, err
+		logger.Log("error", err)
+		os.Exit(1)
 	}
 
-	chartListener, err := github.NewListener(l, syncHist, cfg.GithubOrg, githubClient.Repositories, cfg.GithubQueue, sqsClient)
+	chartReconciler, err := components.ChartReconciler(cfg.ChartReconciler)
+	if err != nil {
+		logger.Log("error", err)
+		os.Exit(1)
+	}
+
+	syncd := syncd.New(chartListener, chartReconciler, imageListener, imageReconciler).
+		WithPromotions(promotionListener, ecr.NewPromotionReconciler(registryEditor)).
+		WithSupervision(logger, restarts)
+
+	adminServer.AddCheck("listeners", func() error {
+		if !syncd.Running() {
+			return errors.New("listeners aren't running")
+		}
+		return nil
+	})
+
+	adminServer.
+		WithImages(imageReconciler).
+		WithCharts(github.NewChartSyncer(
+			cfg.GithubOrg,
+			githubClient.Repositories,
+			chartReconciler,
+			cfg.OperationsRepository,
+			cfg.ReleaseBranch,
+			cfg.RegistryChartPath,
+		))
 
-	return imageListener, chartListener, err
+	if err := syncd.Run(ctx); err != nil {
+		logger.Log("error", err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"ship-it/internal/syncd/config"
+	"ship-it/internal/syncd/deadletter"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/go-kit/kit/log"
+)
+
+// replayCommand replays the messages in the dead-letter queue to the queues
+// they came from, once the cause of their failures has been fixed, e.g.
+//
+//	ship-it-syncd replay-dead-letters -limit 10
+const replayCommand = "replay-dead-letters"
+
+// replayDeadLetters runs the replay command, and returns its exit code
+func replayDeadLetters(logger log.Logger, args []string) int {
+	flags := flag.NewFlagSet(replayCommand, flag.ContinueOnError)
+	limit := flags.Int("limit", 0, "the maximum number of messages to replay, or 0 to replay every message")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	go func() {
+		<-signals
+		cancel()
+	}()
+
+	cfg, err := config.ReplayFromEnv()
+	if err != nil {
+		logger.Log("error", err)
+		return 1
+	}
+
+	awsSession, err := session.NewSession(cfg.AWS())
+	if err != nil {
+		logger.Log("error", err)
+		return 1
+	}
+
+	q, err := deadletter.NewQueue(ctx, sqs.New(awsSession), cfg.DeadLetterQueue)
+	if err != nil {
+		logger.Log("error", err)
+		return 1
+	}
+
+	n, err := q.Replay(ctx, *limit)
+	logger.Log("event", "dead_letters.replayed", "queue", cfg.DeadLetterQueue, "count", n)
+
+	if err != nil {
+		logger.Log("error", err)
+		return 1
+	}
+
+	return 0
+}
@@ -12,6 +12,23 @@ type Config struct {
 	DogstatsdPort string `split_words:"true" default:"8125"`
 	ServicePort   string `split_words:"true" default:"80"`
 	TillerAddress string `split_words:"true" required:"true"`
+
+	// SlackSigningSecret verifies Slack interactions, such as approving a
+	// release upgrade. Slack interactions are disabled if it's empty.
+	SlackSigningSecret string `split_words:"true"`
+
+	// SlackApprovers maps the names of the approvers who can approve releases
+	// with Slack to their Slack user IDs, or user group IDs, e.g.
+	// "alice:U123,releasers:S456". Everyone else is denied.
+	SlackApprovers map[string]string `split_words:"true"`
+
+	// SlackToken looks up the members of SlackApprovers' user groups. It
+	// needs the usergroups:read scope.
+	SlackToken string `split_words:"true"`
+
+	// ApprovalTokens maps the approvers' names to their API tokens, e.g.
+	// "alice:token1,bob:token2". API approvals are disabled if it's empty.
+	ApprovalTokens map[string]string `split_words:"true"`
 }
 
 // DataDogAddress returns the local address of the datadog agent.
@@ -2,16 +2,23 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
+	"encoding/json"
 	"errors"
 	"net/http"
 	"regexp"
+	"strings"
+	"time"
 
 	"ship-it/internal/api/models"
+	"ship-it/internal/api/service"
 
 	"github.com/go-chi/chi"
+	pkgerrors "github.com/pkg/errors"
 )
 
 type Service interface {
+	ApproveRelease(context.Context, string, string, int64) (*models.Release, error)
 	GetRelease(context.Context, string) (*models.Release, error)
 	GetReleaseResources(context.Context, string) (*models.ReleaseResources, error)
 	ListReleases(context.Context) ([]models.Release, error)
@@ -19,12 +26,54 @@ type Service interface {
 
 type controller struct {
 	svc Service
+
+	slackSigningSecret string
+	slackApprovers     map[string]string
+	slackGroups        SlackUserGroups
+	slackClient        *http.Client
+	approvalTokens     map[string]string
+}
+
+type ControllerOption func(*controller)
+
+// SlackSigningSecret enables Slack interactions, which are verified using the
+// Slack app's signing secret.
+func SlackSigningSecret(secret string) ControllerOption {
+	return func(c *controller) {
+		c.slackSigningSecret = secret
+	}
+}
+
+// SlackApprovers allows the approvers to approve releases with Slack. It maps
+// the approvers' names to their Slack user IDs, or to the IDs of user groups
+// whose members are looked up with the groups, if they aren't nil. Everyone
+// else is denied.
+func SlackApprovers(approvers map[string]string, groups SlackUserGroups) ControllerOption {
+	return func(c *controller) {
+		c.slackApprovers = approvers
+		c.slackGroups = groups
+	}
+}
+
+// ApprovalTokens enables approvals with the API. Each approver authenticates
+// with their token, which is sent as a bearer token.
+func ApprovalTokens(tokens map[string]string) ControllerOption {
+	return func(c *controller) {
+		c.approvalTokens = tokens
+	}
 }
 
-func NewController(s Service) Controller {
-	return &controller{
-		svc: s,
+func NewController(s Service, opts ...ControllerOption) Controller {
+	c := &controller{
+		svc:         s,
+		slackClient: &http.Client{Timeout: 10 * time.Second},
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
 func (c *controller) Health(w http.ResponseWriter, r *http.Request) {
@@ -75,6 +124,79 @@ func (c *controller) GetReleaseResources(w http.ResponseWriter, r *http.Request)
 	Success200(w, status)
 }
 
+// approvalRequest is the generation of the release's spec that the approver
+// reviewed
+type approvalRequest struct {
+	Generation int64 `json:"generation"`
+}
+
+func (c *controller) ApproveRelease(w http.ResponseWriter, r *http.Request) {
+	if len(c.approvalTokens) == 0 {
+		Error404(w, errors.New("approvals are disabled"))
+		return
+	}
+
+	approver, err := c.authenticateApprover(r)
+	if err != nil {
+		Error401(w, err)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+
+	if err := validateReleaseName(name); err != nil {
+		Error400(w, err)
+		return
+	}
+
+	var req approvalRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		Error400(w, err)
+		return
+	}
+
+	if req.Generation <= 0 {
+		Error400(w, errors.New("missing generation"))
+		return
+	}
+
+	c.approve(w, r.Context(), name, approver, req.Generation)
+}
+
+// authenticateApprover returns the name of the approver whose token the
+// request is authorized with
+func (c *controller) authenticateApprover(r *http.Request) (string, error) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", errors.New("missing approval token")
+	}
+
+	token := []byte(strings.TrimPrefix(auth, "Bearer "))
+
+	for approver, t := range c.approvalTokens {
+		if t != "" && subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+			return approver, nil
+		}
+	}
+
+	return "", errors.New("invalid approval token")
+}
+
+func (c *controller) approve(w http.ResponseWriter, ctx context.Context, name, approver string, generation int64) {
+	release, err := c.svc.ApproveRelease(ctx, name, approver, generation)
+	if err != nil {
+		if pkgerrors.Cause(err) == service.ErrNoPendingApproval {
+			Error409(w, err)
+			return
+		}
+
+		Error500(w, err)
+		return
+	}
+
+	Success200(w, release)
+}
+
 // importing "k8s.io/helm/pkg/tiller" breaks the build horribly, so we
 // copy-paste the pkg var instead.
 // https://github.com/helm/helm/blob/master/pkg/tiller/release_server.go#L82
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"ship-it/internal/api/models"
+	"ship-it/internal/api/service"
 
 	"github.com/go-chi/chi"
 	"github.com/stretchr/testify/assert"
@@ -30,6 +32,17 @@ func (m *mockService) ListReleases(ctx context.Context) ([]models.Release, error
 	return ret0, args.Error(1)
 }
 
+func (m *mockService) ApproveRelease(ctx context.Context, name, approver string, generation int64) (*models.Release, error) {
+	args := m.Called(ctx, name, approver, generation)
+
+	var ret0 *models.Release
+	if args0 := args.Get(0); args0 != nil {
+		ret0 = args0.(*models.Release)
+	}
+
+	return ret0, args.Error(1)
+}
+
 func (m *mockService) GetRelease(ctx context.Context, name string) (*models.Release, error) {
 	args := m.Called(ctx, name)
 
@@ -192,3 +205,80 @@ func TestGetReleaseResources(t *testing.T) {
 		assert.Equal(t, rec.Code, http.StatusInternalServerError)
 	})
 }
+
+func TestApproveRelease(t *testing.T) {
+	testRelease := "test-release"
+	tokens := map[string]string{"alice": "alice-token", "bob": "bob-token"}
+
+	newRequest := func(token, body string) *http.Request {
+		req := httptest.NewRequest(http.MethodPost, fmt.Sprintf("/releases/%s/approval", testRelease), strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		return withRouteContext(req, "name", testRelease)
+	}
+
+	t.Run("returns 200 on success", func(t *testing.T) {
+		var m mockService
+		m.On("ApproveRelease", mock.Anything, testRelease, "bob", int64(2)).Return(&models.Release{}, nil)
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m, ApprovalTokens(tokens))
+		c.ApproveRelease(rec, newRequest("bob-token", `{"generation":2}`))
+
+		m.AssertExpectations(t)
+		assert.Equal(t, rec.Code, http.StatusOK)
+	})
+
+	t.Run("returns 404 when approvals are disabled", func(t *testing.T) {
+		var m mockService
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m)
+		c.ApproveRelease(rec, newRequest("bob-token", `{"generation":2}`))
+
+		m.AssertNotCalled(t, "ApproveRelease")
+		assert.Equal(t, rec.Code, http.StatusNotFound)
+	})
+
+	t.Run("returns 401 for a missing or invalid token", func(t *testing.T) {
+		for _, token := range []string{"", "not-a-token"} {
+			var m mockService
+
+			rec := httptest.NewRecorder()
+
+			c := NewController(&m, ApprovalTokens(tokens))
+			c.ApproveRelease(rec, newRequest(token, `{"generation":2}`))
+
+			m.AssertNotCalled(t, "ApproveRelease")
+			assert.Equal(t, rec.Code, http.StatusUnauthorized)
+		}
+	})
+
+	t.Run("returns 400 for missing generation", func(t *testing.T) {
+		var m mockService
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m, ApprovalTokens(tokens))
+		c.ApproveRelease(rec, newRequest("bob-token", `{"approver":"alice"}`))
+
+		m.AssertNotCalled(t, "ApproveRelease")
+		assert.Equal(t, rec.Code, http.StatusBadRequest)
+	})
+
+	t.Run("returns 409 without a pending approval", func(t *testing.T) {
+		var m mockService
+		m.On("ApproveRelease", mock.Anything, testRelease, "alice", int64(2)).Return(nil, service.ErrNoPendingApproval)
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m, ApprovalTokens(tokens))
+		c.ApproveRelease(rec, newRequest("alice-token", `{"generation":2}`))
+
+		m.AssertExpectations(t)
+		assert.Equal(t, rec.Code, http.StatusConflict)
+	})
+}
@@ -4,8 +4,10 @@ import (
 	"context"
 	shipitv1beta1 "ship-it-operator/api/v1beta1"
 	"ship-it/internal/api/models"
+	"ship-it/internal/api/service"
 	"ship-it/internal/unstructured"
 
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
@@ -73,6 +75,37 @@ func (k *K8sClient) List(ctx context.Context, namespace string) ([]models.Releas
 	return releases, nil
 }
 
+// Approve approves the release's pending spec change, if the generation is
+// the one waiting for approval, so that a spec pushed after the approver
+// reviewed the release isn't approved with it.
+func (k *K8sClient) Approve(ctx context.Context, namespace, name, approver string, generation int64) (*models.Release, error) {
+	var release shipitv1beta1.HelmRelease
+
+	key := types.NamespacedName{
+		Name:      name,
+		Namespace: namespace,
+	}
+
+	if err := k.client.Get(ctx, key, &release); err != nil {
+		return nil, err
+	}
+
+	if !release.ApprovalPending() || generation != release.GetGeneration() {
+		return nil, service.ErrNoPendingApproval
+	}
+
+	release.Status.Approval.Phase = shipitv1beta1.ApprovalApproved
+	release.Status.Approval.ApprovedBy = approver
+	release.Status.Approval.ApprovedAt = metav1.Now()
+
+	if err := k.client.Status().Update(ctx, &release); err != nil {
+		return nil, err
+	}
+
+	modelRelease := transform(release)
+	return &modelRelease, nil
+}
+
 func transform(r shipitv1beta1.HelmRelease) models.Release {
 	annotations := r.Annotations()
 
@@ -81,6 +114,8 @@ func transform(r shipitv1beta1.HelmRelease) models.Release {
 		Created:      r.ObjectMeta.GetCreationTimestamp().Time,
 		LastDeployed: r.Status.GetCondition().LastUpdateTime.Time,
 		AutoDeploy:   annotations.AutoDeploy(),
+		ImageSync:    annotations.ImageSync(),
+		Pin:          pin(r),
 		Owner: models.Owner{
 			Squad: annotations.Squad(),
 			Slack: annotations.Slack(),
@@ -102,10 +137,74 @@ func transform(r shipitv1beta1.HelmRelease) models.Release {
 			},
 			Docker: dockerArtifacts(r),
 		},
-		Status: r.Status.GetCondition().Type,
+		Status:    r.Status.GetCondition().Type,
+		Promotion: promotion(r),
+		Approval:  approval(r),
 	}
 }
 
+func pin(hr shipitv1beta1.HelmRelease) *models.Pin {
+	annotations := hr.Annotations()
+	if annotations.PinnedBy() == "" {
+		return nil
+	}
+
+	return &models.Pin{
+		By:     annotations.PinnedBy(),
+		Reason: annotations.PinReason(),
+	}
+}
+
+func approval(hr shipitv1beta1.HelmRelease) *models.Approval {
+	spec, status := hr.Spec.Approval, hr.Status.Approval
+	if spec == nil && status == nil {
+		return nil
+	}
+
+	a := &models.Approval{
+		Required: spec != nil && spec.Required,
+	}
+
+	if status != nil {
+		a.Phase = string(status.Phase)
+		a.Generation = status.Generation
+		a.ChartVersion = status.ChartVersion
+		a.RequestedAt = status.RequestedAt.Time
+		a.ApprovedBy = status.ApprovedBy
+		a.ApprovedAt = status.ApprovedAt.Time
+	}
+
+	return a
+}
+
+func promotion(hr shipitv1beta1.HelmRelease) *models.Promotion {
+	spec := hr.Spec.Promotion
+	if spec == nil {
+		return nil
+	}
+
+	p := &models.Promotion{
+		Upstream: spec.Upstream,
+		SoakTime: spec.SoakTime.Duration.String(),
+		Strategy: string(spec.GetStrategy()),
+	}
+
+	if status := hr.Status.Promotion; status != nil {
+		p.Phase = string(status.Phase)
+		p.ChartVersion = status.ChartVersion
+		p.LastTransition = status.LastTransitionTime.Time
+
+		for _, img := range status.Images {
+			p.Images = append(p.Images, models.DockerArtifact{
+				Image: img.Repository,
+				Tag:   img.Tag,
+			})
+		}
+	}
+
+	return p
+}
+
 func dockerArtifacts(hr shipitv1beta1.HelmRelease) []models.DockerArtifact {
 	var artifacts []models.DockerArtifact
 
@@ -123,9 +222,12 @@ func dockerArtifacts(hr shipitv1beta1.HelmRelease) []models.DockerArtifact {
 				return
 			}
 
+			digest, _ := img["digest"].(string)
+
 			artifacts = append(artifacts, models.DockerArtifact{
-				Image: repo,
-				Tag:   tag,
+				Image:  repo,
+				Tag:    tag,
+				Digest: digest,
 			})
 		}
 	})
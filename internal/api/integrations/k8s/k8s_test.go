@@ -5,9 +5,11 @@ import (
 	"encoding/json"
 	"fmt"
 	"testing"
+	"time"
 
 	shipitv1beta1 "ship-it-operator/api/v1beta1"
 	"ship-it/internal/api/models"
+	"ship-it/internal/api/service"
 
 	"github.com/stretchr/testify/assert"
 	v1 "k8s.io/api/core/v1"
@@ -46,6 +48,11 @@ func TestGetAndList(t *testing.T) {
 		Name:       releaseName,
 		Created:    created.Time,
 		AutoDeploy: autodeploy,
+		ImageSync:  true,
+		Pin: &models.Pin{
+			By:     "jane",
+			Reason: "investigating",
+		},
 		Code: models.SourceCode{
 			Github: github,
 		},
@@ -63,8 +70,9 @@ func TestGetAndList(t *testing.T) {
 			},
 			Docker: []models.DockerArtifact{
 				{
-					Image: dockerImage,
-					Tag:   dockerImageTag,
+					Image:  dockerImage,
+					Tag:    dockerImageTag,
+					Digest: "sha256:abc",
 				},
 			},
 		},
@@ -73,6 +81,18 @@ func TestGetAndList(t *testing.T) {
 			Slack: slack,
 		},
 		Status: releaseStatus.String(),
+		Promotion: &models.Promotion{
+			Upstream: "staging",
+			SoakTime: "30m0s",
+			Strategy: "Commit",
+			Phase:    "Pending",
+			Images: []models.DockerArtifact{
+				{
+					Image: dockerImage,
+					Tag:   "newtag",
+				},
+			},
+		},
 	}
 
 	values := map[string]interface{}{
@@ -81,6 +101,7 @@ func TestGetAndList(t *testing.T) {
 			"image": map[string]interface{}{
 				"repository": dockerImage,
 				"tag":        dockerImageTag,
+				"digest":     "sha256:abc",
 			},
 		},
 		"bar": map[string]interface{}{
@@ -112,6 +133,8 @@ func TestGetAndList(t *testing.T) {
 				"helmreleases.shipit.wattpad.com/slack":      slack,
 				"helmreleases.shipit.wattpad.com/datadog":    dashboard,
 				"helmreleases.shipit.wattpad.com/sumologic":  sumologic,
+				"helmreleases.shipit.wattpad.com/pinned-by":  "jane",
+				"helmreleases.shipit.wattpad.com/pin-reason": "investigating",
 			},
 		},
 		Spec: shipitv1beta1.HelmReleaseSpec{
@@ -124,8 +147,23 @@ func TestGetAndList(t *testing.T) {
 			Values: runtime.RawExtension{
 				Raw: valuesRaw,
 			},
+			Promotion: &shipitv1beta1.PromotionSpec{
+				Upstream: "staging",
+				SoakTime: metav1.Duration{Duration: 30 * time.Minute},
+				Strategy: shipitv1beta1.PromotionStrategyCommit,
+			},
 		},
 		Status: shipitv1beta1.HelmReleaseStatus{
+			Promotion: &shipitv1beta1.PromotionStatus{
+				Upstream: "staging",
+				Phase:    shipitv1beta1.PromotionPending,
+				Images: []shipitv1beta1.PromotedImage{
+					{
+						Repository: dockerImage,
+						Tag:        "newtag",
+					},
+				},
+			},
 			Conditions: []shipitv1beta1.HelmReleaseCondition{
 				{
 					Type: releaseStatus.String(),
@@ -147,3 +185,48 @@ func TestGetAndList(t *testing.T) {
 		assert.Equal(t, expectedRelease, *apiRelease)
 	}
 }
+
+func TestApprove(t *testing.T) {
+	releaseName := "releaseName"
+
+	k8sRelease := shipitv1beta1.HelmRelease{
+		TypeMeta: metav1.TypeMeta{
+			Kind: "HelmRelease",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       releaseName,
+			Namespace:  v1.NamespaceDefault,
+			Generation: 2,
+		},
+		Spec: shipitv1beta1.HelmReleaseSpec{
+			Approval: &shipitv1beta1.ApprovalSpec{
+				Required: true,
+			},
+		},
+		Status: shipitv1beta1.HelmReleaseStatus{
+			Approval: &shipitv1beta1.ApprovalStatus{
+				Phase:      shipitv1beta1.ApprovalPending,
+				Generation: 2,
+			},
+		},
+	}
+
+	client := newTestK8sClient(&k8sRelease)
+	ctx := context.Background()
+
+	_, err := client.Approve(ctx, v1.NamespaceDefault, releaseName, "approver", 1)
+	assert.Equal(t, service.ErrNoPendingApproval, err)
+
+	_, err = client.Approve(ctx, v1.NamespaceDefault, releaseName, "approver", 0)
+	assert.Equal(t, service.ErrNoPendingApproval, err)
+
+	apiRelease, err := client.Approve(ctx, v1.NamespaceDefault, releaseName, "approver", 2)
+	if assert.NoError(t, err) && assert.NotNil(t, apiRelease.Approval) {
+		assert.True(t, apiRelease.Approval.Required)
+		assert.Equal(t, "Approved", apiRelease.Approval.Phase)
+		assert.Equal(t, "approver", apiRelease.Approval.ApprovedBy)
+	}
+
+	_, err = client.Approve(ctx, v1.NamespaceDefault, releaseName, "approver", 2)
+	assert.Equal(t, service.ErrNoPendingApproval, err)
+}
@@ -10,11 +10,15 @@ type Release struct {
 	LastDeployed time.Time  `json:"lastDeployed" jsonschema:"description=The time when the release was last deployed"`
 	Owner        Owner      `json:"owner" jsonschema:"description=Ownership and contact information"`
 	AutoDeploy   bool       `json:"autoDeploy" jsonschema:"description=The state of the release's auto-deployment option"`
+	ImageSync    bool       `json:"imageSync" jsonschema:"description=Whether the release's images are updated when new images are pushed"`
+	Pin          *Pin       `json:"pin,omitempty" jsonschema:"description=The pin holding the release's images at their current tags"`
 	Code         SourceCode `json:"code" jsonschema:"description=The repository and branch ref of the release's source code"`
 	Build        build      `json:"build" jsonschema:"description=The CI build page of current release,required=true"`
 	Monitoring   Monitoring `json:"monitoring" jsonschema:"description=The monitoring resources for the release"`
 	Artifacts    Artifacts  `json:"artifacts" jsonschema:"description=The build artifacts of the release"`
 	Status       string     `json:"status" jsonschema:"description=The status of the release,example=deployed,example=failed,example=pending_rollback,example=pending_install,example=pending_upgrade"`
+	Promotion    *Promotion `json:"promotion,omitempty" jsonschema:"description=The promotion of the release from an upstream release"`
+	Approval     *Approval  `json:"approval,omitempty" jsonschema:"description=The manual approval of the release's upgrades"`
 }
 
 type Owner struct {
@@ -47,8 +51,9 @@ type Artifacts struct {
 }
 
 type DockerArtifact struct {
-	Image string `json:"image"`
-	Tag   string `json:"tag"`
+	Image  string `json:"image"`
+	Tag    string `json:"tag"`
+	Digest string `json:"digest,omitempty" jsonschema:"description=The immutable digest of the tagged image,example=sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"`
 }
 
 type HelmArtifact struct {
@@ -56,3 +61,28 @@ type HelmArtifact struct {
 	Repository string `json:"repository" jsonschema:"format=uri"`
 	Version    string `json:"version" jsonschema:"example=1.2.3"`
 }
+
+type Promotion struct {
+	Upstream       string           `json:"upstream" jsonschema:"description=The name of the upstream release"`
+	SoakTime       string           `json:"soakTime" jsonschema:"description=How long the upstream release is deployed before it's promoted,example=30m0s"`
+	Strategy       string           `json:"strategy" jsonschema:"description=How the release is promoted,enum=Resource,enum=Commit"`
+	Phase          string           `json:"phase,omitempty" jsonschema:"description=The phase of the latest promotion,example=Pending,example=Promoted"`
+	ChartVersion   string           `json:"chartVersion,omitempty" jsonschema:"description=The promoted chart version,example=1.2.3"`
+	Images         []DockerArtifact `json:"images,omitempty" jsonschema:"description=The promoted images"`
+	LastTransition time.Time        `json:"lastTransition,omitempty" jsonschema:"description=The time of the latest promotion phase change"`
+}
+
+type Approval struct {
+	Required     bool      `json:"required" jsonschema:"description=Whether upgrades of the release must be manually approved"`
+	Phase        string    `json:"phase,omitempty" jsonschema:"description=The approval phase of the latest spec change,example=Pending,example=Approved"`
+	Generation   int64     `json:"generation,omitempty" jsonschema:"description=The HelmRelease generation waiting for approval or approved"`
+	ChartVersion string    `json:"chartVersion,omitempty" jsonschema:"description=The chart version waiting for approval or approved,example=1.2.3"`
+	RequestedAt  time.Time `json:"requestedAt,omitempty" jsonschema:"description=The time when the approval was requested"`
+	ApprovedBy   string    `json:"approvedBy,omitempty" jsonschema:"description=The user who approved the spec change"`
+	ApprovedAt   time.Time `json:"approvedAt,omitempty" jsonschema:"description=The time when the spec change was approved"`
+}
+
+type Pin struct {
+	By     string `json:"by" jsonschema:"description=The user who pinned the release"`
+	Reason string `json:"reason,omitempty" jsonschema:"description=Why the release is pinned"`
+}
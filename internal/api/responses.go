@@ -36,10 +36,18 @@ func Error400(w http.ResponseWriter, err error) error {
 	return ErrorJSON(w, NewError(http.StatusBadRequest, err))
 }
 
+func Error401(w http.ResponseWriter, err error) error {
+	return ErrorJSON(w, NewError(http.StatusUnauthorized, err))
+}
+
 func Error404(w http.ResponseWriter, err error) error {
 	return ErrorJSON(w, NewError(http.StatusNotFound, err))
 }
 
+func Error409(w http.ResponseWriter, err error) error {
+	return ErrorJSON(w, NewError(http.StatusConflict, err))
+}
+
 func Error500(w http.ResponseWriter, err error) error {
 	return ErrorJSON(w, NewError(http.StatusInternalServerError, err))
 }
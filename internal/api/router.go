@@ -10,10 +10,12 @@ import (
 )
 
 type Controller interface {
+	ApproveRelease(http.ResponseWriter, *http.Request)
 	GetRelease(http.ResponseWriter, *http.Request)
 	GetReleaseResources(http.ResponseWriter, *http.Request)
 	Health(http.ResponseWriter, *http.Request)
 	ListReleases(http.ResponseWriter, *http.Request)
+	SlackInteraction(http.ResponseWriter, *http.Request)
 }
 
 // New returns an 'http.Handler' that serves the ship-it API.
@@ -27,6 +29,8 @@ func NewRouter(root http.Handler, c Controller, t metrics.Histogram) http.Handle
 		r.Get("/releases", c.ListReleases)
 		r.Get("/releases/{name}", c.GetRelease)
 		r.Get("/releases/{name}/resources", c.GetReleaseResources)
+		r.Post("/releases/{name}/approval", c.ApproveRelease)
+		r.Post("/slack/interactions", c.SlackInteraction)
 	})
 
 	r.Mount("/", root)
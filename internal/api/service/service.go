@@ -2,6 +2,7 @@ package service
 
 import (
 	"context"
+	"errors"
 
 	"ship-it/internal/api/models"
 
@@ -17,11 +18,22 @@ type ResourcesGetter interface {
 	Get(release string) (string, error)
 }
 
-func New(rl ReleaseLister, rg ResourcesGetter) *Service {
+// ReleaseApprover approves a release's pending spec change, if the generation
+// is the one waiting for approval.
+type ReleaseApprover interface {
+	Approve(ctx context.Context, namespace, release, approver string, generation int64) (*models.Release, error)
+}
+
+// ErrNoPendingApproval is returned when approving a release that isn't
+// waiting for an approval, or is waiting to approve a different spec.
+var ErrNoPendingApproval = errors.New("release has no pending approval")
+
+func New(rl ReleaseLister, rg ResourcesGetter, ra ReleaseApprover) *Service {
 	return &Service{
 		Namespace: v1.NamespaceDefault,
 		releases:  rl,
 		resources: rg,
+		approver:  ra,
 	}
 }
 
@@ -29,6 +41,7 @@ type Service struct {
 	Namespace string
 	releases  ReleaseLister
 	resources ResourcesGetter
+	approver  ReleaseApprover
 }
 
 func (s *Service) ListReleases(ctx context.Context) ([]models.Release, error) {
@@ -39,6 +52,10 @@ func (s *Service) GetRelease(ctx context.Context, name string) (*models.Release,
 	return s.releases.Get(ctx, s.Namespace, name)
 }
 
+func (s *Service) ApproveRelease(ctx context.Context, name, approver string, generation int64) (*models.Release, error) {
+	return s.approver.Approve(ctx, s.Namespace, name, approver, generation)
+}
+
 func (s *Service) GetReleaseResources(ctx context.Context, name string) (*models.ReleaseResources, error) {
 	resources, err := s.resources.Get(name)
 	if err != nil {
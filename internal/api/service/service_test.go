@@ -39,6 +39,22 @@ func (k *mockK8sClient) Get(ctx context.Context, namespace, name string) (*model
 	return nil, errors.New("release not found")
 }
 
+func (k *mockK8sClient) Approve(ctx context.Context, namespace, name, approver string, generation int64) (*models.Release, error) {
+	r, err := k.Get(ctx, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Approval == nil || r.Approval.Phase != "Pending" || r.Approval.Generation != generation {
+		return nil, ErrNoPendingApproval
+	}
+
+	r.Approval.Phase = "Approved"
+	r.Approval.ApprovedBy = approver
+
+	return r, nil
+}
+
 func newMockK8sClient(name string, time time.Time) *mockK8sClient {
 	r := models.Release{
 		Name:    name,
@@ -55,7 +71,7 @@ func TestGetAndListReleases(t *testing.T) {
 	currentTime := time.Now()
 
 	mock := newMockK8sClient(name, currentTime)
-	svc := New(mock, nil)
+	svc := New(mock, nil, nil)
 
 	releases, err := svc.ListReleases(context.Background())
 	if !assert.NoError(t, err) {
@@ -81,7 +97,7 @@ func TestGetReleaseResources(t *testing.T) {
 		},
 	}
 
-	svc := New(nil, &mock)
+	svc := New(nil, &mock, nil)
 
 	res, err := svc.GetReleaseResources(context.Background(), name)
 	if assert.NoError(t, err) {
@@ -89,3 +105,28 @@ func TestGetReleaseResources(t *testing.T) {
 		assert.Equal(t, res.Resources, resources)
 	}
 }
+
+func TestApproveRelease(t *testing.T) {
+	name := "releaseName"
+
+	mock := newMockK8sClient(name, time.Now())
+	svc := New(mock, nil, mock)
+
+	_, err := svc.ApproveRelease(context.Background(), name, "approver", 2)
+	assert.Equal(t, ErrNoPendingApproval, err)
+
+	mock.releases[0].Approval = &models.Approval{
+		Required:   true,
+		Phase:      "Pending",
+		Generation: 2,
+	}
+
+	_, err = svc.ApproveRelease(context.Background(), name, "approver", 1)
+	assert.Equal(t, ErrNoPendingApproval, err)
+
+	release, err := svc.ApproveRelease(context.Background(), name, "approver", 2)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "Approved", release.Approval.Phase)
+		assert.Equal(t, "approver", release.Approval.ApprovedBy)
+	}
+}
@@ -0,0 +1,289 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slackApproveActionID must match the action ID of the operator's Slack
+// approval button. The button's value is formatted as "name/generation".
+const slackApproveActionID = "approve"
+
+// slackRequestMaxAge limits replays of signed Slack requests
+const slackRequestMaxAge = 5 * time.Minute
+
+// slackUserGroupPrefix starts the IDs of Slack user groups, rather than users
+const slackUserGroupPrefix = "S"
+
+// slackAPI is the base URL of Slack's Web API
+const slackAPI = "https://slack.com/api"
+
+type slackInteraction struct {
+	Type string `json:"type"`
+	User struct {
+		ID       string `json:"id"`
+		Username string `json:"username"`
+	} `json:"user"`
+	ResponseURL string `json:"response_url"`
+	Actions     []struct {
+		ActionID string `json:"action_id"`
+		Value    string `json:"value"`
+	} `json:"actions"`
+}
+
+func (i slackInteraction) approver() string {
+	if i.User.Username != "" {
+		return "@" + i.User.Username
+	}
+	return i.User.ID
+}
+
+func (c *controller) SlackInteraction(w http.ResponseWriter, r *http.Request) {
+	if c.slackSigningSecret == "" {
+		Error404(w, errors.New("slack interactions are disabled"))
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		Error400(w, err)
+		return
+	}
+
+	if err := verifySlackSignature(r.Header, body, c.slackSigningSecret, time.Now()); err != nil {
+		Error401(w, err)
+		return
+	}
+
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		Error400(w, err)
+		return
+	}
+
+	var interaction slackInteraction
+	if err := json.Unmarshal([]byte(form.Get("payload")), &interaction); err != nil {
+		Error400(w, err)
+		return
+	}
+
+	for _, action := range interaction.Actions {
+		if action.ActionID != slackApproveActionID {
+			continue
+		}
+
+		name, generation, err := parseApprovalValue(action.Value)
+		if err != nil {
+			Error400(w, err)
+			return
+		}
+
+		allowed, err := c.slackApprover(r.Context(), interaction.User.ID)
+		if err != nil {
+			Error500(w, err)
+			return
+		}
+
+		if !allowed {
+			// Slack only shows errors that are posted to the response URL
+			if err := c.slackEphemeral(r.Context(), interaction.ResponseURL, fmt.Sprintf("You aren't allowed to approve `%s`.", name)); err != nil {
+				Error500(w, err)
+				return
+			}
+
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		c.approve(w, r.Context(), name, interaction.approver(), generation)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// slackApprover tells whether the Slack user is one of the approvers, or a
+// member of one of their user groups
+func (c *controller) slackApprover(ctx context.Context, user string) (bool, error) {
+	for _, id := range c.slackApprovers {
+		if id == user {
+			return true, nil
+		}
+	}
+
+	if c.slackGroups == nil {
+		return false, nil
+	}
+
+	for _, id := range c.slackApprovers {
+		if !strings.HasPrefix(id, slackUserGroupPrefix) {
+			continue
+		}
+
+		members, err := c.slackGroups.Members(ctx, id)
+		if err != nil {
+			return false, err
+		}
+
+		for _, member := range members {
+			if member == user {
+				return true, nil
+			}
+		}
+	}
+
+	return false, nil
+}
+
+// slackEphemeral replies to an interaction with a message that only the user
+// who interacted sees
+func (c *controller) slackEphemeral(ctx context.Context, responseURL, text string) error {
+	if responseURL == "" {
+		return errors.New("missing slack response url")
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"response_type":    "ephemeral",
+		"replace_original": false,
+		"text":             text,
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.slackClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SlackUserGroups looks up the members of Slack user groups
+type SlackUserGroups interface {
+	Members(ctx context.Context, group string) ([]string, error)
+}
+
+type slackUserGroups struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewSlackUserGroups looks up user groups with Slack's Web API. The token
+// needs the usergroups:read scope.
+func NewSlackUserGroups(token string) SlackUserGroups {
+	return &slackUserGroups{
+		url:    slackAPI,
+		token:  token,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Members returns the IDs of the group's users
+// https://api.slack.com/methods/usergroups.users.list
+func (g *slackUserGroups) Members(ctx context.Context, group string) ([]string, error) {
+	req, err := http.NewRequest(http.MethodGet, g.url+"/usergroups.users.list?"+url.Values{"usergroup": {group}}.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+g.token)
+
+	resp, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var list struct {
+		OK    bool     `json:"ok"`
+		Error string   `json:"error"`
+		Users []string `json:"users"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, err
+	}
+
+	if !list.OK {
+		return nil, fmt.Errorf("failed to list the members of slack user group %s: %s", group, list.Error)
+	}
+
+	return list.Users, nil
+}
+
+func parseApprovalValue(value string) (string, int64, error) {
+	i := strings.LastIndex(value, "/")
+	if i < 0 {
+		return "", 0, fmt.Errorf("invalid approval value %q", value)
+	}
+
+	name := value[:i]
+	if err := validateReleaseName(name); err != nil {
+		return "", 0, err
+	}
+
+	generation, err := strconv.ParseInt(value[i+1:], 10, 64)
+	if err != nil || generation <= 0 {
+		return "", 0, fmt.Errorf("invalid approval generation %q", value[i+1:])
+	}
+
+	return name, generation, nil
+}
+
+// verifySlackSignature verifies that the request was sent by Slack.
+// https://api.slack.com/docs/verifying-requests-from-slack
+func verifySlackSignature(header http.Header, body []byte, secret string, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	signature := header.Get("X-Slack-Signature")
+
+	if timestamp == "" || signature == "" {
+		return errors.New("missing slack signature headers")
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errors.New("invalid slack request timestamp")
+	}
+
+	if age := now.Sub(time.Unix(ts, 0)); age > slackRequestMaxAge || age < -slackRequestMaxAge {
+		return errors.New("expired slack request timestamp")
+	}
+
+	sig, err := hex.DecodeString(strings.TrimPrefix(signature, "v0="))
+	if err != nil {
+		return errors.New("invalid slack signature")
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:", timestamp)
+	mac.Write(body)
+
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return errors.New("invalid slack signature")
+	}
+
+	return nil
+}
@@ -0,0 +1,183 @@
+package api
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"ship-it/internal/api/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func newSlackRequest(secret, payload string, ts time.Time) *http.Request {
+	body := url.Values{"payload": {payload}}.Encode()
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/slack/interactions", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("X-Slack-Request-Timestamp", timestamp)
+	req.Header.Set("X-Slack-Signature", "v0="+hex.EncodeToString(mac.Sum(nil)))
+
+	return req
+}
+
+type fakeSlackUserGroups map[string][]string
+
+func (g fakeSlackUserGroups) Members(ctx context.Context, group string) ([]string, error) {
+	return g[group], nil
+}
+
+func TestSlackInteraction(t *testing.T) {
+	var responses []map[string]interface{}
+	responder := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var response map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&response)
+		responses = append(responses, response)
+	}))
+	defer responder.Close()
+
+	secret := "signing-secret"
+	payload := fmt.Sprintf(`{
+		"type": "block_actions",
+		"user": {"id": "U123", "username": "approver"},
+		"response_url": %q,
+		"actions": [{"action_id": "approve", "value": "test-release/3"}]
+	}`, responder.URL)
+
+	t.Run("approves the release's generation", func(t *testing.T) {
+		var m mockService
+		m.On("ApproveRelease", mock.Anything, "test-release", "@approver", int64(3)).Return(&models.Release{}, nil)
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m, SlackSigningSecret(secret), SlackApprovers(map[string]string{"approver": "U123"}, nil))
+		c.SlackInteraction(rec, newSlackRequest(secret, payload, time.Now()))
+
+		m.AssertExpectations(t)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("approves for members of user groups", func(t *testing.T) {
+		var m mockService
+		m.On("ApproveRelease", mock.Anything, "test-release", "@approver", int64(3)).Return(&models.Release{}, nil)
+
+		rec := httptest.NewRecorder()
+
+		groups := fakeSlackUserGroups{"S456": {"U789", "U123"}}
+		c := NewController(&m, SlackSigningSecret(secret), SlackApprovers(map[string]string{"releasers": "S456"}, groups))
+		c.SlackInteraction(rec, newSlackRequest(secret, payload, time.Now()))
+
+		m.AssertExpectations(t)
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("tells other users that they aren't allowed to approve", func(t *testing.T) {
+		responses = nil
+
+		var m mockService
+
+		rec := httptest.NewRecorder()
+
+		groups := fakeSlackUserGroups{"S456": {"U789"}}
+		c := NewController(&m, SlackSigningSecret(secret), SlackApprovers(map[string]string{"alice": "U456", "releasers": "S456"}, groups))
+		c.SlackInteraction(rec, newSlackRequest(secret, payload, time.Now()))
+
+		m.AssertNotCalled(t, "ApproveRelease")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		if assert.Len(t, responses, 1) {
+			assert.Equal(t, "ephemeral", responses[0]["response_type"])
+			assert.Equal(t, false, responses[0]["replace_original"])
+			assert.Contains(t, responses[0]["text"], "test-release")
+		}
+	})
+
+	t.Run("returns 401 for invalid signatures", func(t *testing.T) {
+		var m mockService
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m, SlackSigningSecret(secret))
+		c.SlackInteraction(rec, newSlackRequest("wrong-secret", payload, time.Now()))
+
+		m.AssertNotCalled(t, "ApproveRelease")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("returns 401 for expired requests", func(t *testing.T) {
+		var m mockService
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m, SlackSigningSecret(secret))
+		c.SlackInteraction(rec, newSlackRequest(secret, payload, time.Now().Add(-time.Hour)))
+
+		m.AssertNotCalled(t, "ApproveRelease")
+		assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	})
+
+	t.Run("returns 404 when disabled", func(t *testing.T) {
+		var m mockService
+
+		rec := httptest.NewRecorder()
+
+		c := NewController(&m)
+		c.SlackInteraction(rec, newSlackRequest(secret, payload, time.Now()))
+
+		m.AssertNotCalled(t, "ApproveRelease")
+		assert.Equal(t, http.StatusNotFound, rec.Code)
+	})
+}
+
+func TestSlackUserGroups(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/usergroups.users.list", r.URL.Path)
+		assert.Equal(t, "Bearer slack-token", r.Header.Get("Authorization"))
+
+		switch r.URL.Query().Get("usergroup") {
+		case "S456":
+			fmt.Fprint(w, `{"ok": true, "users": ["U123", "U789"]}`)
+		default:
+			fmt.Fprint(w, `{"ok": false, "error": "no_such_subteam"}`)
+		}
+	}))
+	defer server.Close()
+
+	groups := NewSlackUserGroups("slack-token").(*slackUserGroups)
+	groups.url = server.URL
+
+	members, err := groups.Members(context.Background(), "S456")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"U123", "U789"}, members)
+	}
+
+	_, err = groups.Members(context.Background(), "S000")
+	assert.EqualError(t, err, "failed to list the members of slack user group S000: no_such_subteam")
+}
+
+func TestParseApprovalValue(t *testing.T) {
+	name, generation, err := parseApprovalValue("test-release/42")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "test-release", name)
+		assert.Equal(t, int64(42), generation)
+	}
+
+	for _, value := range []string{"test-release", "test-release/", "test-release/0", "bad$name/1"} {
+		_, _, err := parseApprovalValue(value)
+		assert.Error(t, err, value)
+	}
+}
@@ -0,0 +1,116 @@
+package image
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver"
+)
+
+// TagPolicy decides whether a pushed tag should replace a release's current
+// tag of the same image repository.
+type TagPolicy interface {
+	Allows(pushed string, current string) bool
+}
+
+// gitSHA matches the full git commit SHAs that images are tagged with by
+// default
+var gitSHA = regexp.MustCompile("^[0-9a-f]{40}$")
+
+// DefaultTagPolicy only allows tags that are git commit SHAs
+var DefaultTagPolicy TagPolicy = regexPolicy{gitSHA}
+
+// ParseTagPolicy parses a tag policy. The supported policies are:
+//
+//	sha               tags that are full git commit SHAs (the default)
+//	regex:<expr>      tags matching the regular expression
+//	semver            semantic versions newer than the current tag
+//	semver:<range>    semantic versions within the range, e.g. "~1.2"
+//	newest            the most recently pushed tag, whatever it is
+func ParseTagPolicy(policy string) (TagPolicy, error) {
+	kind, arg := policy, ""
+	if i := strings.Index(policy, ":"); i >= 0 {
+		kind, arg = policy[:i], policy[i+1:]
+	}
+
+	switch strings.TrimSpace(kind) {
+	case "", "sha":
+		return DefaultTagPolicy, nil
+
+	case "regex":
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid tag policy regex %q: %s", arg, err)
+		}
+		return regexPolicy{re}, nil
+
+	case "semver":
+		var p semverPolicy
+		if strings.TrimSpace(arg) != "" {
+			c, err := semver.NewConstraint(arg)
+			if err != nil {
+				return nil, fmt.Errorf("invalid tag policy semver range %q: %s", arg, err)
+			}
+			p.constraint = c
+		}
+		return p, nil
+
+	case "newest":
+		return newestPolicy{}, nil
+	}
+
+	return nil, fmt.Errorf("unknown tag policy %q", policy)
+}
+
+type regexPolicy struct {
+	re *regexp.Regexp
+}
+
+func (p regexPolicy) Allows(pushed, current string) bool {
+	return p.re.MatchString(pushed)
+}
+
+// semverPolicy allows semantic versions within its range. It doesn't allow
+// downgrades, so a patch of an older version doesn't replace a newer one.
+type semverPolicy struct {
+	constraint *semver.Constraints
+}
+
+func (p semverPolicy) Allows(pushed, current string) bool {
+	v, err := semver.NewVersion(pushed)
+	if err != nil {
+		return false
+	}
+
+	if p.constraint != nil && !p.constraint.Check(v) {
+		return false
+	}
+
+	if c, err := semver.NewVersion(current); err == nil {
+		return v.GreaterThan(c)
+	}
+
+	return true
+}
+
+// newestPolicy allows every tag. Push events can arrive out of order, so the
+// chart editor skips pushes older than the last one applied to a release,
+// which keeps the release at the newest tag.
+type newestPolicy struct{}
+
+func (newestPolicy) Allows(pushed, current string) bool {
+	return pushed != ""
+}
+
+// PinnedPolicy is the policy of a release whose images are held at their
+// current tags, because they're pinned or the release opted out of image
+// updates. It allows no tags.
+type PinnedPolicy struct {
+	// Reason describes why the images are held, e.g. "pinned by jane"
+	Reason string
+}
+
+func (PinnedPolicy) Allows(pushed, current string) bool {
+	return false
+}
@@ -0,0 +1,54 @@
+package image
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTagPolicies(t *testing.T) {
+	sha := "78bc9ccf64eb838c6a0e0492ded722274925e2bd"
+
+	tests := []struct {
+		policy  string
+		pushed  string
+		current string
+		allowed bool
+	}{
+		{"", sha, "", true},
+		{"", "latest", "", false},
+		{"sha", "78bc9ccf64eb838c6a0e0492ded722274925E2ND", "", false},
+		{"regex:^release-", "release-42", "release-41", true},
+		{"regex:^release-", "feature-42", "release-41", false},
+		{"semver", "1.2.3", "", true},
+		{"semver", "v1.3.0", "1.2.3", true},
+		{"semver", "1.2.2", "1.2.3", false},
+		{"semver", sha, "1.2.3", false},
+		{"semver:~1.2", "1.2.4", "1.2.3", true},
+		{"semver:~1.2", "1.3.0", "1.2.3", false},
+		{"semver:>=2.0.0, <3.0.0", "2.1.0", "not-semver", true},
+		{"newest", "latest", sha, true},
+		{"newest", "", sha, false},
+	}
+
+	for _, test := range tests {
+		p, err := ParseTagPolicy(test.policy)
+		require.NoError(t, err, test.policy)
+		assert.Equal(t, test.allowed, p.Allows(test.pushed, test.current), "%s allows %s over %s", test.policy, test.pushed, test.current)
+	}
+}
+
+func TestParseTagPolicyInvalid(t *testing.T) {
+	for _, policy := range []string{"regex:(", "semver:not a range", "oldest"} {
+		_, err := ParseTagPolicy(policy)
+		assert.Error(t, err, policy)
+	}
+}
+
+func TestPinnedPolicy(t *testing.T) {
+	p := PinnedPolicy{Reason: "pinned by jane"}
+
+	assert.False(t, p.Allows("78bc9ccf64eb838c6a0e0492ded722274925e2bd", ""))
+	assert.False(t, p.Allows("latest", "old"))
+}
@@ -2,8 +2,9 @@ package image
 
 import (
 	"errors"
-	"fmt"
-	"strings"
+	"time"
+
+	reference "ship-it-operator/image"
 
 	"gopkg.in/yaml.v2"
 )
@@ -13,6 +14,14 @@ type Ref struct {
 	Registry   string
 	Repository string
 	Tag        string
+
+	// Digest is the immutable content digest of the tagged image, e.g.
+	// "sha256:...". It's empty when the digest isn't known.
+	Digest string
+
+	// PushedAt is when the tag was pushed. It's zero when the push time
+	// isn't known.
+	PushedAt time.Time
 }
 
 // String formats the most canonical string representation of the image reference
@@ -22,60 +31,71 @@ func (r Ref) String() string {
 		tag = ":" + r.Tag
 	}
 
-	return r.URI() + tag
+	var digest string
+	if r.Digest != "" {
+		digest = "@" + r.Digest
+	}
+
+	return r.URI() + tag + digest
 }
 
+// URI formats the canonical "registry/repository" of the image reference
 func (r Ref) URI() string {
-	return r.Registry + "/" + r.Repository
+	n := r.normalized()
+	return n.Registry + "/" + n.Repository
 }
 
-// Refs match when their registries and repositories match
+// Refs match when their canonical registries and repositories match, e.g.
+// "index.docker.io/redis" matches "docker.io/library/redis"
 func (r Ref) Matches(other Ref) bool {
-	return r.Registry == other.Registry && r.Repository == other.Repository
+	return r.URI() == other.URI()
 }
 
+// Parse parses and normalizes an image repository, and sets its tag. The
+// repository can be any reference accepted by ParseReference, e.g.
+// "registry:5000/team/app" or "redis", and the tag replaces its tag, if any.
 func Parse(repo string, tag string) (*Ref, error) {
-	arr := strings.Split(repo, "/")
-	if len(arr) != 2 {
-		return nil, fmt.Errorf("invalid image repo: %s", repo)
-	}
-
-	return &Ref{
-		Registry:   arr[0],
-		Repository: arr[1],
-		Tag:        tag,
-	}, nil
+	return fromReference(reference.Parse(repo, tag))
 }
 
+// FromYaml parses an "image" item of a release's values. The image is either
+// a block with "repository", "tag" and optional "digest" keys, or a string
+// like "registry/repository:tag".
 func FromYaml(item yaml.MapItem) (*Ref, error) {
 	if key, ok := item.Key.(string); !ok || key != "image" {
 		return nil, errors.New("invalid yaml: missing \"image\" key")
 	}
 
 	var (
-		repo string
-		tag  string
+		repo   string
+		tag    string
+		digest string
 	)
 
-	if obj, ok := item.Value.(yaml.MapSlice); ok {
-		for _, item := range obj {
+	switch v := item.Value.(type) {
+	case string:
+		return FromValue(v)
+
+	case yaml.MapSlice:
+		for _, item := range v {
 			if key, ok := item.Key.(string); ok {
-				if key == "repository" {
-					repo = item.Value.(string)
-				} else if key == "tag" {
-					tag = item.Value.(string)
+				switch key {
+				case "repository":
+					repo, _ = item.Value.(string)
+				case "tag":
+					tag, _ = item.Value.(string)
+				case "digest":
+					digest, _ = item.Value.(string)
 				}
 			}
 		}
 	}
 
-	if repo == "" {
-		return nil, errors.New("invalid yaml: missing \"repository\" key")
-	}
-
-	if tag == "" {
-		return nil, errors.New("invalid yaml: missing \"tag\" key")
-	}
+	return fromReference(reference.FromFields(repo, tag, digest))
+}
 
-	return Parse(repo, tag)
+// FromValue parses an "image" value of a release's decoded Helm values, in
+// either of the forms accepted by FromYaml.
+func FromValue(x interface{}) (*Ref, error) {
+	return fromReference(reference.FromValue(x))
 }
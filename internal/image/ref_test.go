@@ -7,23 +7,80 @@ import (
 	"gopkg.in/yaml.v2"
 )
 
+const testDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
 func TestParse(t *testing.T) {
 	expected := Ref{
-		Registry:   "foo",
+		Registry:   "foo.io",
 		Repository: "bar",
 		Tag:        "baz",
 	}
 
-	image, err := Parse("foo/bar", "baz")
+	image, err := Parse("foo.io/bar", "baz")
 	assert.NoError(t, err)
 	assert.Equal(t, expected, *image)
 }
 
 func TestParseInvalid(t *testing.T) {
-	_, err := Parse("foo-bar", "baz")
+	for _, repo := range []string{"", "foo.io/", "/bar", "foo.io/Bar", "foo.io/bar//baz", "foo_.io/bar", "foo.io/bar:"} {
+		_, err := Parse(repo, "baz")
+		assert.Error(t, err, repo)
+	}
+
+	_, err := Parse("foo.io/bar", "-baz")
 	assert.Error(t, err)
 }
 
+func TestParseNamespaced(t *testing.T) {
+	ref, err := Parse("docker.io/library/redis", "5")
+	if assert.NoError(t, err) {
+		assert.Equal(t, Ref{Registry: "docker.io", Repository: "library/redis", Tag: "5"}, *ref)
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	testCases := map[string]Ref{
+		"foo.io/bar:baz":                    {Registry: "foo.io", Repository: "bar", Tag: "baz"},
+		"foo.io/team/bar:baz":               {Registry: "foo.io", Repository: "team/bar", Tag: "baz"},
+		"foo.io/bar:baz@" + testDigest:      {Registry: "foo.io", Repository: "bar", Tag: "baz", Digest: testDigest},
+		"foo.io/bar@" + testDigest:          {Registry: "foo.io", Repository: "bar", Digest: testDigest},
+		"foo.io/bar":                        {Registry: "foo.io", Repository: "bar"},
+		"foo:5000/team/bar:baz":             {Registry: "foo:5000", Repository: "team/bar", Tag: "baz"},
+		"localhost/bar":                     {Registry: "localhost", Repository: "bar"},
+		"foo.dkr.ecr.amazonaws.com/bar:1.2": {Registry: "foo.dkr.ecr.amazonaws.com", Repository: "bar", Tag: "1.2"},
+		"redis":                             {Registry: "docker.io", Repository: "library/redis"},
+		"redis:5":                           {Registry: "docker.io", Repository: "library/redis", Tag: "5"},
+		"squad/app:1":                       {Registry: "docker.io", Repository: "squad/app", Tag: "1"},
+		"docker.io/redis":                   {Registry: "docker.io", Repository: "library/redis"},
+		"index.docker.io/squad/app":         {Registry: "docker.io", Repository: "squad/app"},
+		"foo.io/my_team/my-app.v2":          {Registry: "foo.io", Repository: "my_team/my-app.v2"},
+	}
+
+	for s, expected := range testCases {
+		ref, err := ParseReference(s)
+		if assert.NoError(t, err, s) {
+			assert.Equal(t, expected, *ref, s)
+		}
+	}
+
+	for _, s := range []string{"foo.io/bar:baz@sha256:abc", "foo.io/bar@", "Redis", "foo.io/bar:baz:qux"} {
+		_, err := ParseReference(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	name, tag, digest := Split("foo:5000/bar:baz@" + testDigest)
+	assert.Equal(t, "foo:5000/bar", name)
+	assert.Equal(t, "baz", tag)
+	assert.Equal(t, testDigest, digest)
+
+	name, tag, digest = Split("foo:5000/bar")
+	assert.Equal(t, "foo:5000/bar", name)
+	assert.Empty(t, tag)
+	assert.Empty(t, digest)
+}
+
 func TestRefString(t *testing.T) {
 	type testCase struct {
 		in  Ref
@@ -32,14 +89,19 @@ func TestRefString(t *testing.T) {
 
 	testCases := map[string]testCase{
 		"without tag": {
-			in:  Ref{"registry", "repository", ""},
+			in:  Ref{Registry: "registry", Repository: "repository", Tag: ""},
 			out: "registry/repository",
 		},
 
 		"with tag": {
-			in:  Ref{"registry", "repository", "tag"},
+			in:  Ref{Registry: "registry", Repository: "repository", Tag: "tag"},
 			out: "registry/repository:tag",
 		},
+
+		"with digest": {
+			in:  Ref{Registry: "registry", Repository: "repository", Tag: "tag", Digest: "sha256:abc"},
+			out: "registry/repository:tag@sha256:abc",
+		},
 	}
 
 	for name, tc := range testCases {
@@ -59,13 +121,18 @@ func TestRefMatches(t *testing.T) {
 
 	testCases := map[string]testCase{
 		"matches": {
-			this:    Ref{"foo", "bar", "baz"},
-			that:    Ref{"foo", "bar", "qux"},
+			this:    Ref{Registry: "foo", Repository: "bar", Tag: "baz"},
+			that:    Ref{Registry: "foo", Repository: "bar", Tag: "qux"},
+			matches: true,
+		},
+		"matches docker hub aliases": {
+			this:    Ref{Registry: "index.docker.io", Repository: "redis"},
+			that:    Ref{Registry: "docker.io", Repository: "library/redis", Tag: "5"},
 			matches: true,
 		},
 		"doesn't match": {
-			this:    Ref{"foo", "bar", "baz"},
-			that:    Ref{"foo", "baz", "qux"},
+			this:    Ref{Registry: "foo", Repository: "bar", Tag: "baz"},
+			that:    Ref{Registry: "foo", Repository: "baz", Tag: "qux"},
 			matches: false,
 		},
 	}
@@ -84,7 +151,7 @@ func TestFromYaml(t *testing.T) {
 		Value: yaml.MapSlice{
 			{
 				Key:   "repository",
-				Value: "registry/repository",
+				Value: "registry.io/repository",
 			},
 			{
 				Key:   "tag",
@@ -94,7 +161,7 @@ func TestFromYaml(t *testing.T) {
 	}
 
 	expected := Ref{
-		Registry:   "registry",
+		Registry:   "registry.io",
 		Repository: "repository",
 		Tag:        "tag",
 	}
@@ -104,6 +171,54 @@ func TestFromYaml(t *testing.T) {
 	assert.Equal(t, expected, *ref)
 }
 
+func TestFromYamlDigest(t *testing.T) {
+	ref, err := FromYaml(yaml.MapItem{
+		Key: "image",
+		Value: yaml.MapSlice{
+			{Key: "repository", Value: "registry.io/repository"},
+			{Key: "tag", Value: "tag"},
+			{Key: "digest", Value: testDigest},
+		},
+	})
+	if assert.NoError(t, err) {
+		assert.Equal(t, testDigest, ref.Digest)
+	}
+}
+
+func TestFromYamlString(t *testing.T) {
+	ref, err := FromYaml(yaml.MapItem{Key: "image", Value: "registry.io/repository:tag"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, Ref{Registry: "registry.io", Repository: "repository", Tag: "tag"}, *ref)
+	}
+}
+
+func TestFromValue(t *testing.T) {
+	expected := Ref{Registry: "registry.io", Repository: "repository", Tag: "tag", Digest: testDigest}
+
+	for _, value := range []interface{}{
+		"registry.io/repository:tag@" + testDigest,
+		map[string]interface{}{
+			"repository": "registry.io/repository",
+			"tag":        "tag",
+			"digest":     testDigest,
+		},
+	} {
+		ref, err := FromValue(value)
+		if assert.NoError(t, err) {
+			assert.Equal(t, expected, *ref)
+		}
+	}
+
+	for _, value := range []interface{}{
+		"registry.io/repository",
+		map[string]interface{}{"repository": "registry.io/repository", "tag": 2},
+		[]interface{}{"registry.io/repository:tag"},
+	} {
+		_, err := FromValue(value)
+		assert.Error(t, err, value)
+	}
+}
+
 func TestFromYamlInvalid(t *testing.T) {
 	testCases := map[string]yaml.MapItem{
 		"not an image block": yaml.MapItem{
@@ -111,7 +226,7 @@ func TestFromYamlInvalid(t *testing.T) {
 			Value: yaml.MapSlice{
 				{
 					Key:   "repository",
-					Value: "registry/repository",
+					Value: "registry.io/repository",
 				},
 				{
 					Key:   "tag",
@@ -124,7 +239,7 @@ func TestFromYamlInvalid(t *testing.T) {
 			Value: yaml.MapSlice{
 				{
 					Key:   "repository",
-					Value: "repository",
+					Value: "registry.io/Repository",
 				},
 				{
 					Key:   "tag",
@@ -141,6 +256,23 @@ func TestFromYamlInvalid(t *testing.T) {
 				},
 			},
 		},
+		"string without a tag": yaml.MapItem{
+			Key:   "image",
+			Value: "registry.io/repository",
+		},
+		"non-string repository field": yaml.MapItem{
+			Key: "image",
+			Value: yaml.MapSlice{
+				{
+					Key:   "repository",
+					Value: 2,
+				},
+				{
+					Key:   "tag",
+					Value: "tag",
+				},
+			},
+		},
 		"missing tag field": yaml.MapItem{
 			Key: "image",
 			Value: yaml.MapSlice{
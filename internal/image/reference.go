@@ -0,0 +1,41 @@
+package image
+
+import (
+	reference "ship-it-operator/image"
+)
+
+// DockerHub is the canonical registry of images without a registry
+const DockerHub = reference.DockerHub
+
+// Split splits an image reference into its name, tag and digest, without
+// validating them, e.g. "registry:5000/app:1.0@sha256:..." is split into
+// "registry:5000/app", "1.0" and "sha256:...".
+func Split(s string) (name, tag, digest string) {
+	return reference.Split(s)
+}
+
+// ParseReference parses and normalizes an image reference of the form
+// "[registry/]repository[:tag][@digest]". The grammar and normalization are
+// shared with the operator, e.g. "redis:5" is "docker.io/library/redis:5".
+func ParseReference(s string) (*Ref, error) {
+	return fromReference(reference.ParseReference(s))
+}
+
+func fromReference(r *reference.Reference, err error) (*Ref, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return &Ref{
+		Registry:   r.Registry,
+		Repository: r.Repository,
+		Tag:        r.Tag,
+		Digest:     r.Digest,
+	}, nil
+}
+
+// normalized returns the reference with its canonical registry and repository
+func (r Ref) normalized() Ref {
+	r.Registry, r.Repository = reference.Normalize(r.Registry, r.Repository)
+	return r
+}
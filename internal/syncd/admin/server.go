@@ -0,0 +1,270 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-chi/chi"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// maxBodySize limits the size of the requests that are read
+const maxBodySize = 1 << 20
+
+// Check reports why a component isn't ready, or nil if it is
+type Check func() error
+
+// ChartSyncer reconciles the registry chart at a ref of the operations
+// repository
+type ChartSyncer interface {
+	Sync(ctx context.Context, ref string) error
+}
+
+type check struct {
+	name  string
+	check Check
+}
+
+// Server is syncd's admin HTTP server. It serves:
+//
+//	GET  /healthz   liveness, which succeeds while the server is up
+//	GET  /readyz    readiness, which succeeds once every check passes
+//	GET  /metrics   Prometheus metrics
+//	POST /images    reconciles an image, e.g. {"image": "registry/app:1.0"}
+//	POST /chart     reconciles the registry chart at a ref, e.g. {"ref": "master"}
+//
+// The POST endpoints are authenticated with the admin token, sent as
+// "Authorization: Bearer <token>". They're disabled without a token.
+//
+// Checks, and the components used by the POST endpoints, can be added while
+// the server is running, so it can answer probes while syncd starts.
+type Server struct {
+	logger  log.Logger
+	addr    string
+	token   string
+	metrics http.Handler
+
+	mu     sync.RWMutex
+	checks []check
+	images syncd.ImageReconciler
+	charts ChartSyncer
+}
+
+func NewServer(l log.Logger, addr, token string, metrics http.Handler) *Server {
+	return &Server{
+		logger:  log.With(l, "worker", "admin"),
+		addr:    addr,
+		token:   token,
+		metrics: metrics,
+	}
+}
+
+// AddCheck adds a readiness check
+func (s *Server) AddCheck(name string, c Check) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checks = append(s.checks, check{name, c})
+}
+
+// WithImages reconciles the images posted to /images with the reconciler
+func (s *Server) WithImages(r syncd.ImageReconciler) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.images = r
+	return s
+}
+
+// WithCharts reconciles the refs posted to /chart with the syncer
+func (s *Server) WithCharts(c ChartSyncer) *Server {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.charts = c
+	return s
+}
+
+func (s *Server) Run(ctx context.Context) error {
+	srv := &http.Server{
+		Addr:    s.addr,
+		Handler: s.Handler(),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "admin server failed")
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv.Shutdown(shutdown)
+
+	return ctx.Err()
+}
+
+func (s *Server) Handler() http.Handler {
+	router := chi.NewRouter()
+
+	router.Get("/healthz", s.healthz)
+	router.Get("/readyz", s.readyz)
+
+	if s.metrics != nil {
+		router.Method(http.MethodGet, "/metrics", s.metrics)
+	}
+
+	router.Group(func(r chi.Router) {
+		r.Use(s.authenticate)
+		r.Post("/images", s.reconcileImage)
+		r.Post("/chart", s.syncChart)
+	})
+
+	return router
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	checks := s.checks
+	s.mu.RUnlock()
+
+	if len(checks) == 0 {
+		http.Error(w, "starting", http.StatusServiceUnavailable)
+		return
+	}
+
+	var (
+		report strings.Builder
+		ready  = true
+	)
+
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			ready = false
+			fmt.Fprintf(&report, "%s: %v\n", c.name, err)
+		} else {
+			fmt.Fprintf(&report, "%s: ok\n", c.name)
+		}
+	}
+
+	if !ready {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	fmt.Fprint(w, report.String())
+}
+
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token == "" {
+			http.Error(w, "admin token isn't configured", http.StatusUnauthorized)
+			return
+		}
+
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) reconcileImage(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Image string `json:"image"`
+	}
+
+	if err := decode(w, r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ref, err := image.ParseReference(req.Image)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if ref.Tag == "" {
+		http.Error(w, "image reference doesn't have a tag", http.StatusBadRequest)
+		return
+	}
+
+	s.mu.RLock()
+	images := s.images
+	s.mu.RUnlock()
+
+	if images == nil {
+		http.Error(w, "image reconciler isn't available", http.StatusServiceUnavailable)
+		return
+	}
+
+	err = images.Reconcile(r.Context(), ref)
+	if errors.Cause(err) == syncd.ErrNoRegisteredReleasesAffected {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s.respond(w, err, "image", ref)
+}
+
+func (s *Server) syncChart(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Ref string `json:"ref"`
+	}
+
+	if err := decode(w, r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.RLock()
+	charts := s.charts
+	s.mu.RUnlock()
+
+	if charts == nil {
+		http.Error(w, "chart syncer isn't available", http.StatusServiceUnavailable)
+		return
+	}
+
+	s.respond(w, charts.Sync(r.Context(), req.Ref), "ref", req.Ref)
+}
+
+// respond logs the manual trigger, and reports its error
+func (s *Server) respond(w http.ResponseWriter, err error, keyvals ...interface{}) {
+	if err != nil {
+		s.logger.Log(append([]interface{}{"event", "trigger.failed", "error", err}, keyvals...)...)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	s.logger.Log(append([]interface{}{"event", "trigger.succeeded"}, keyvals...)...)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func decode(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize)).Decode(v)
+	return errors.Wrap(err, "invalid request")
+}
@@ -0,0 +1,143 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type mockImageReconciler struct {
+	mock.Mock
+}
+
+func (m *mockImageReconciler) Reconcile(ctx context.Context, ref *image.Ref) error {
+	return m.Called(ref).Error(0)
+}
+
+type mockChartSyncer struct {
+	mock.Mock
+}
+
+func (m *mockChartSyncer) Sync(ctx context.Context, ref string) error {
+	return m.Called(ref).Error(0)
+}
+
+func serve(s *Server, method, path, token, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	return w
+}
+
+func TestHealthz(t *testing.T) {
+	s := NewServer(log.NewNopLogger(), "", "", nil)
+
+	assert.Equal(t, http.StatusOK, serve(s, http.MethodGet, "/healthz", "", "").Code)
+}
+
+func TestReadyz(t *testing.T) {
+	s := NewServer(log.NewNopLogger(), "", "", nil)
+
+	// syncd is starting until its checks are added
+	assert.Equal(t, http.StatusServiceUnavailable, serve(s, http.MethodGet, "/readyz", "", "").Code)
+
+	var running bool
+	s.AddCheck("informer", func() error { return nil })
+	s.AddCheck("listeners", func() error {
+		if !running {
+			return errors.New("not running")
+		}
+		return nil
+	})
+
+	w := serve(s, http.MethodGet, "/readyz", "", "")
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "informer: ok\nlisteners: not running\n", w.Body.String())
+
+	running = true
+	assert.Equal(t, http.StatusOK, serve(s, http.MethodGet, "/readyz", "", "").Code)
+}
+
+func TestMetrics(t *testing.T) {
+	metrics := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("shipit_syncd_time_milliseconds_count 1\n"))
+	})
+
+	s := NewServer(log.NewNopLogger(), "", "", metrics)
+
+	w := serve(s, http.MethodGet, "/metrics", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "shipit_syncd_time_milliseconds")
+}
+
+func TestAuthentication(t *testing.T) {
+	r := new(mockImageReconciler)
+	body := `{"image": "registry.example.com/app:1.0"}`
+
+	unconfigured := NewServer(log.NewNopLogger(), "", "", nil).WithImages(r)
+	assert.Equal(t, http.StatusUnauthorized, serve(unconfigured, http.MethodPost, "/images", "secret", body).Code)
+
+	s := NewServer(log.NewNopLogger(), "", "secret", nil).WithImages(r)
+	assert.Equal(t, http.StatusUnauthorized, serve(s, http.MethodPost, "/images", "", body).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(s, http.MethodPost, "/images", "wrong", body).Code)
+
+	r.AssertNotCalled(t, "Reconcile", mock.Anything)
+}
+
+func TestReconcileImage(t *testing.T) {
+	r := new(mockImageReconciler)
+	r.On("Reconcile", &image.Ref{Registry: "registry.example.com", Repository: "app", Tag: "1.0"}).Return(nil)
+	r.On("Reconcile", &image.Ref{Registry: "registry.example.com", Repository: "unused", Tag: "1.0"}).Return(syncd.ErrNoRegisteredReleasesAffected)
+	r.On("Reconcile", &image.Ref{Registry: "registry.example.com", Repository: "broken", Tag: "1.0"}).Return(errors.New("conflict"))
+
+	s := NewServer(log.NewNopLogger(), "", "secret", nil)
+
+	assert.Equal(t, http.StatusServiceUnavailable, serve(s, http.MethodPost, "/images", "secret", `{"image": "registry.example.com/app:1.0"}`).Code)
+
+	s.WithImages(r)
+
+	tests := []struct {
+		body string
+		code int
+	}{
+		{`{"image": "registry.example.com/app:1.0"}`, http.StatusNoContent},
+		{`{"image": "registry.example.com/unused:1.0"}`, http.StatusNotFound},
+		{`{"image": "registry.example.com/broken:1.0"}`, http.StatusInternalServerError},
+		{`{"image": "registry.example.com/app"}`, http.StatusBadRequest},
+		{`{"image": "registry.example.com/App:1.0"}`, http.StatusBadRequest},
+		{`not json`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.code, serve(s, http.MethodPost, "/images", "secret", tt.body).Code, tt.body)
+	}
+
+	r.AssertExpectations(t)
+}
+
+func TestSyncChart(t *testing.T) {
+	c := new(mockChartSyncer)
+	c.On("Sync", "").Return(nil)
+	c.On("Sync", "abc123").Return(errors.New("not found"))
+
+	s := NewServer(log.NewNopLogger(), "", "secret", nil).WithCharts(c)
+
+	assert.Equal(t, http.StatusNoContent, serve(s, http.MethodPost, "/chart", "secret", `{}`).Code)
+	assert.Equal(t, http.StatusInternalServerError, serve(s, http.MethodPost, "/chart", "secret", `{"ref": "abc123"}`).Code)
+
+	c.AssertExpectations(t)
+}
@@ -10,22 +10,52 @@ import (
 
 // Config provides the service's configuration options.
 type Config struct {
-	AWSRegion            string `envconfig:"AWS_REGION" required:"true"`
-	DogstatsdHost        string `split_words:"true" required:"true"`
-	DogstatsdPort        string `split_words:"true" default:"8125"`
-	EcrQueue             string `split_words:"true" required:"true"`
-	GithubAppID          int    `envconfig:"GITHUB_APP_ID" required:"true"`
-	GithubAppSecret      string `split_words:"true" required:"true"`
-	GithubInstallationID int    `envconfig:"GITHUB_INSTALLATION_ID" required:"true"`
-	GithubOrg            string `split_words:"true" required:"true"`
-	GithubQueue          string `split_words:"true" required:"true"`
-	HelmTimeoutSeconds   int64  `split_words:"true" default:"10"`
-	Namespace            string `split_words:"true" default:"default"`
-	OperationsRepository string `split_words:"true" required:"true"`
-	RegistryChartPath    string `split_words:"true" required:"true"`
-	ReleaseBranch        string `split_words:"true" default:"master"`
-	ReleaseName          string `split_words:"true" required:"true"`
-	TillerHost           string `split_words:"true" required:"true"`
+	AWSRegion               string   `envconfig:"AWS_REGION" required:"true"`
+	AdminAddr               string   `split_words:"true" default:":8080"`
+	AdminToken              string   `split_words:"true"`
+	AutoMergeMethod         string   `split_words:"true"`
+	AutoMergeUnchecked      bool     `split_words:"true" default:"false"`
+	ChartEditor             string   `split_words:"true" default:"github"`
+	ChartListener           string   `split_words:"true" default:"sqs"`
+	ChartReconciler         string   `split_words:"true" default:"helm"`
+	ChartWebhookSecret      string   `split_words:"true"`
+	ChartWebhookURL         string   `envconfig:"CHART_WEBHOOK_URL"`
+	CommitSigningKey        string   `split_words:"true"`
+	CommitSigningPassphrase string   `split_words:"true"`
+	DeadLetterQueue         string   `split_words:"true"`
+	DogstatsdHost           string   `split_words:"true" required:"true"`
+	DogstatsdPort           string   `split_words:"true" default:"8125"`
+	EcrQueue                string   `split_words:"true"`
+	GitAuthorEmail          string   `split_words:"true"`
+	GitAuthorName           string   `split_words:"true"`
+	GitCommitterEmail       string   `split_words:"true"`
+	GitCommitterName        string   `split_words:"true"`
+	GitRemote               string   `split_words:"true"`
+	GitWorkdir              string   `split_words:"true" default:"/tmp/ship-it-operations"`
+	GithubAppID             int      `envconfig:"GITHUB_APP_ID" required:"true"`
+	GithubAppSecret         string   `split_words:"true" required:"true"`
+	GithubInstallationID    int      `envconfig:"GITHUB_INSTALLATION_ID" required:"true"`
+	GithubOrg               string   `split_words:"true" required:"true"`
+	GithubQueue             string   `split_words:"true"`
+	GithubWebhookAddr       string   `split_words:"true" default:":8082"`
+	GithubWebhookSecret     string   `split_words:"true"`
+	HelmTimeoutSeconds      int64    `split_words:"true" default:"10"`
+	ImageBatchWindowSeconds int64    `split_words:"true" default:"10"`
+	ImageDigests            bool     `split_words:"true" default:"false"`
+	ImageListener           []string `split_words:"true" default:"ecr"`
+	ImageReconciler         string   `split_words:"true" default:"commit"`
+	Namespace               string   `split_words:"true" default:"default"`
+	OperationsRepository    string   `split_words:"true" required:"true"`
+	PollIntervalSeconds     int64    `split_words:"true" default:"60"`
+	PollStatePath           string   `split_words:"true" default:"/var/lib/ship-it/poller.json"`
+	RegistryChartPath       string   `split_words:"true" required:"true"`
+	RegistryPassword        string   `split_words:"true"`
+	RegistryUsername        string   `split_words:"true"`
+	RegistryWebhookAddr     string   `split_words:"true" default:":8081"`
+	RegistryWebhookSecret   string   `split_words:"true"`
+	ReleaseBranch           string   `split_words:"true" default:"master"`
+	ReleaseName             string   `split_words:"true" required:"true"`
+	TillerHost              string   `split_words:"true" required:"true"`
 }
 
 // DataDogAddress returns the local address of the datadog agent.
@@ -44,6 +74,18 @@ func (c *Config) HelmTimeout() time.Duration {
 	return time.Duration(c.HelmTimeoutSeconds) * time.Second
 }
 
+// ImageBatchWindow is how long image pushes are collected before they're
+// committed together. A zero window commits every image on its own.
+func (c *Config) ImageBatchWindow() time.Duration {
+	return time.Duration(c.ImageBatchWindowSeconds) * time.Second
+}
+
+// PollInterval is how often the polling image listener lists the tags of the
+// releases' image repositories.
+func (c *Config) PollInterval() time.Duration {
+	return time.Duration(c.PollIntervalSeconds) * time.Second
+}
+
 // FromEnv returns a config using environment values.
 func FromEnv() (*Config, error) {
 	env := new(Config)
@@ -52,3 +94,26 @@ func FromEnv() (*Config, error) {
 	}
 	return env, nil
 }
+
+// ReplayConfig provides the options of the command that replays dead-letter
+// messages.
+type ReplayConfig struct {
+	AWSRegion       string `envconfig:"AWS_REGION" required:"true"`
+	DeadLetterQueue string `split_words:"true" required:"true"`
+}
+
+// AWS returns an AWS config using the command's config values.
+func (c *ReplayConfig) AWS() *aws.Config {
+	return &aws.Config{
+		Region: aws.String(c.AWSRegion),
+	}
+}
+
+// ReplayFromEnv returns a replay config using environment values.
+func ReplayFromEnv() (*ReplayConfig, error) {
+	env := new(ReplayConfig)
+	if err := envconfig.Process("", env); err != nil {
+		return nil, err
+	}
+	return env, nil
+}
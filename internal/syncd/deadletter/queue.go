@@ -0,0 +1,222 @@
+package deadletter
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"strings"
+
+	"github.com/Wattpad/sqsconsumer/sqsmessage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/pkg/errors"
+)
+
+const (
+	// errorAttribute is the message attribute with the error that the
+	// message failed with
+	errorAttribute = "Error"
+
+	// sourceAttribute is the message attribute with the name of the queue
+	// that the message is replayed to
+	sourceAttribute = "SourceQueue"
+
+	// maxErrorLength bounds the error attribute, since a message's
+	// attributes count towards its size limit
+	maxErrorLength = 1024
+
+	// replayGroup is the message group of replayed FIFO messages
+	replayGroup = "replay"
+)
+
+// SQSAPI is the subset of the SQS API used by dead-letter queues
+type SQSAPI interface {
+	GetQueueUrlWithContext(aws.Context, *sqs.GetQueueUrlInput, ...request.Option) (*sqs.GetQueueUrlOutput, error)
+	SendMessageWithContext(aws.Context, *sqs.SendMessageInput, ...request.Option) (*sqs.SendMessageOutput, error)
+	ReceiveMessageWithContext(aws.Context, *sqs.ReceiveMessageInput, ...request.Option) (*sqs.ReceiveMessageOutput, error)
+	DeleteMessageWithContext(aws.Context, *sqs.DeleteMessageInput, ...request.Option) (*sqs.DeleteMessageOutput, error)
+}
+
+// Queue is an SQS queue of messages that failed permanently. Each message
+// keeps the error it failed with and the queue it came from, so that it can
+// be replayed to its source queue once the cause of the failure is fixed.
+type Queue struct {
+	sqs  SQSAPI
+	name string
+	url  string
+
+	// sources caches the URLs of the source queues by name
+	sources map[string]string
+}
+
+func NewQueue(ctx context.Context, sqs SQSAPI, name string) (*Queue, error) {
+	q := &Queue{
+		sqs:     sqs,
+		name:    name,
+		sources: make(map[string]string),
+	}
+
+	url, err := q.queueURL(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	q.url = url
+
+	return q, nil
+}
+
+// Send adds the message from the source queue to the dead-letter queue, with
+// the error it failed with.
+func (q *Queue) Send(ctx context.Context, source, msg string, cause error) error {
+	reason := cause.Error()
+	if len(reason) > maxErrorLength {
+		reason = reason[:maxErrorLength]
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(q.url),
+		MessageBody: aws.String(msg),
+		MessageAttributes: map[string]*sqs.MessageAttributeValue{
+			errorAttribute:  stringAttribute(reason),
+			sourceAttribute: stringAttribute(source),
+		},
+	}
+
+	if isFIFO(q.name) {
+		input.MessageGroupId = aws.String(source)
+		input.MessageDeduplicationId = aws.String(deduplicationID(ctx, msg))
+	}
+
+	if _, err := q.sqs.SendMessageWithContext(ctx, input); err != nil {
+		return errors.Wrapf(err, "failed to send message to dead-letter queue %s", q.name)
+	}
+
+	return nil
+}
+
+// Replay sends up to limit messages back to their source queues, and removes
+// them from the dead-letter queue. A limit of zero replays every message. It
+// returns the number of messages replayed.
+func (q *Queue) Replay(ctx context.Context, limit int) (int, error) {
+	replayed := 0
+
+	for limit <= 0 || replayed < limit {
+		batch := int64(10)
+		if limit > 0 && int64(limit-replayed) < batch {
+			batch = int64(limit - replayed)
+		}
+
+		out, err := q.sqs.ReceiveMessageWithContext(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:              aws.String(q.url),
+			MaxNumberOfMessages:   aws.Int64(batch),
+			MessageAttributeNames: aws.StringSlice([]string{"All"}),
+			WaitTimeSeconds:       aws.Int64(1),
+		})
+		if err != nil {
+			return replayed, errors.Wrapf(err, "failed to receive messages from dead-letter queue %s", q.name)
+		}
+
+		if len(out.Messages) == 0 {
+			return replayed, nil
+		}
+
+		for _, msg := range out.Messages {
+			if err := q.replay(ctx, msg); err != nil {
+				return replayed, err
+			}
+			replayed++
+		}
+	}
+
+	return replayed, nil
+}
+
+// replay sends the message to its source queue, then deletes it
+func (q *Queue) replay(ctx context.Context, msg *sqs.Message) error {
+	id := aws.StringValue(msg.MessageId)
+
+	source := attribute(msg, sourceAttribute)
+	if source == "" {
+		return errors.Errorf("dead-letter message %s doesn't have a source queue", id)
+	}
+
+	url, err := q.sourceURL(ctx, source)
+	if err != nil {
+		return err
+	}
+
+	input := &sqs.SendMessageInput{
+		QueueUrl:    aws.String(url),
+		MessageBody: msg.Body,
+	}
+
+	if isFIFO(source) {
+		input.MessageGroupId = aws.String(replayGroup)
+		input.MessageDeduplicationId = aws.String(id)
+	}
+
+	if _, err := q.sqs.SendMessageWithContext(ctx, input); err != nil {
+		return errors.Wrapf(err, "failed to replay message %s to %s", id, source)
+	}
+
+	_, err = q.sqs.DeleteMessageWithContext(ctx, &sqs.DeleteMessageInput{
+		QueueUrl:      aws.String(q.url),
+		ReceiptHandle: msg.ReceiptHandle,
+	})
+
+	return errors.Wrapf(err, "failed to delete replayed message %s", id)
+}
+
+func (q *Queue) sourceURL(ctx context.Context, name string) (string, error) {
+	if url, ok := q.sources[name]; ok {
+		return url, nil
+	}
+
+	url, err := q.queueURL(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	q.sources[name] = url
+	return url, nil
+}
+
+func (q *Queue) queueURL(ctx context.Context, name string) (string, error) {
+	out, err := q.sqs.GetQueueUrlWithContext(ctx, &sqs.GetQueueUrlInput{
+		QueueName: aws.String(name),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get the url of queue %s", name)
+	}
+
+	return aws.StringValue(out.QueueUrl), nil
+}
+
+func isFIFO(queue string) bool {
+	return strings.HasSuffix(queue, ".fifo")
+}
+
+// deduplicationID identifies the message by its SQS message ID, or by its
+// body if it isn't an SQS message
+func deduplicationID(ctx context.Context, msg string) string {
+	if m, ok := sqsmessage.FromContext(ctx); ok && aws.StringValue(m.MessageId) != "" {
+		return aws.StringValue(m.MessageId)
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(msg)))
+}
+
+func stringAttribute(v string) *sqs.MessageAttributeValue {
+	return &sqs.MessageAttributeValue{
+		DataType:    aws.String("String"),
+		StringValue: aws.String(v),
+	}
+}
+
+func attribute(msg *sqs.Message, name string) string {
+	if v, ok := msg.MessageAttributes[name]; ok {
+		return aws.StringValue(v.StringValue)
+	}
+	return ""
+}
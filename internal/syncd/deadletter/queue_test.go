@@ -0,0 +1,192 @@
+package deadletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/Wattpad/sqsconsumer/sqsmessage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/sqs"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeSQS is an in-memory SQS with queues that are addressed by their names
+type fakeSQS struct {
+	queues map[string][]*sqs.SendMessageInput
+	sent   int
+}
+
+func newFakeSQS(queues ...string) *fakeSQS {
+	f := &fakeSQS{queues: make(map[string][]*sqs.SendMessageInput)}
+	for _, q := range queues {
+		f.queues[q] = nil
+	}
+	return f
+}
+
+func (f *fakeSQS) GetQueueUrlWithContext(_ aws.Context, in *sqs.GetQueueUrlInput, _ ...request.Option) (*sqs.GetQueueUrlOutput, error) {
+	name := aws.StringValue(in.QueueName)
+	if _, ok := f.queues[name]; !ok {
+		return nil, errors.New("queue does not exist")
+	}
+	return &sqs.GetQueueUrlOutput{QueueUrl: aws.String("https://sqs/" + name)}, nil
+}
+
+func (f *fakeSQS) SendMessageWithContext(_ aws.Context, in *sqs.SendMessageInput, _ ...request.Option) (*sqs.SendMessageOutput, error) {
+	name := strings.TrimPrefix(aws.StringValue(in.QueueUrl), "https://sqs/")
+	if strings.HasSuffix(name, ".fifo") && (in.MessageGroupId == nil || in.MessageDeduplicationId == nil) {
+		return nil, errors.New("fifo messages require a group and deduplication id")
+	}
+
+	f.sent++
+	in.MessageAttributes = copyAttributes(in.MessageAttributes)
+	f.queues[name] = append(f.queues[name], in)
+
+	return &sqs.SendMessageOutput{MessageId: aws.String(fmt.Sprint(f.sent))}, nil
+}
+
+func (f *fakeSQS) ReceiveMessageWithContext(_ aws.Context, in *sqs.ReceiveMessageInput, _ ...request.Option) (*sqs.ReceiveMessageOutput, error) {
+	name := strings.TrimPrefix(aws.StringValue(in.QueueUrl), "https://sqs/")
+
+	var out sqs.ReceiveMessageOutput
+	for i, msg := range f.queues[name] {
+		if int64(i) == aws.Int64Value(in.MaxNumberOfMessages) {
+			break
+		}
+		out.Messages = append(out.Messages, &sqs.Message{
+			MessageId:         aws.String(fmt.Sprintf("dlq-%d", i)),
+			ReceiptHandle:     aws.String(fmt.Sprint(i)),
+			Body:              msg.MessageBody,
+			MessageAttributes: msg.MessageAttributes,
+		})
+	}
+
+	return &out, nil
+}
+
+func (f *fakeSQS) DeleteMessageWithContext(_ aws.Context, in *sqs.DeleteMessageInput, _ ...request.Option) (*sqs.DeleteMessageOutput, error) {
+	name := strings.TrimPrefix(aws.StringValue(in.QueueUrl), "https://sqs/")
+
+	// messages are always deleted in the order they were received
+	f.queues[name] = f.queues[name][1:]
+
+	return &sqs.DeleteMessageOutput{}, nil
+}
+
+func copyAttributes(attrs map[string]*sqs.MessageAttributeValue) map[string]*sqs.MessageAttributeValue {
+	if attrs == nil {
+		return nil
+	}
+	c := make(map[string]*sqs.MessageAttributeValue, len(attrs))
+	for k, v := range attrs {
+		c[k] = v
+	}
+	return c
+}
+
+func TestNewQueueMissing(t *testing.T) {
+	_, err := NewQueue(context.Background(), newFakeSQS(), "ship-it-dlq")
+	assert.Error(t, err)
+}
+
+func TestSend(t *testing.T) {
+	fake := newFakeSQS("ship-it-dlq.fifo")
+
+	q, err := NewQueue(context.Background(), fake, "ship-it-dlq.fifo")
+	require.NoError(t, err)
+
+	ctx := sqsmessage.NewContext(context.Background(), &sqs.Message{MessageId: aws.String("abc")})
+
+	err = q.Send(ctx, "ship-it-ecr.fifo", "{", errors.New("unexpected end of JSON input"))
+	require.NoError(t, err)
+
+	require.Len(t, fake.queues["ship-it-dlq.fifo"], 1)
+
+	msg := fake.queues["ship-it-dlq.fifo"][0]
+	assert.Equal(t, "{", aws.StringValue(msg.MessageBody))
+	assert.Equal(t, "unexpected end of JSON input", aws.StringValue(msg.MessageAttributes[errorAttribute].StringValue))
+	assert.Equal(t, "ship-it-ecr.fifo", aws.StringValue(msg.MessageAttributes[sourceAttribute].StringValue))
+	assert.Equal(t, "ship-it-ecr.fifo", aws.StringValue(msg.MessageGroupId))
+	assert.Equal(t, "abc", aws.StringValue(msg.MessageDeduplicationId))
+}
+
+func TestSendTruncatesError(t *testing.T) {
+	fake := newFakeSQS("ship-it-dlq")
+
+	q, err := NewQueue(context.Background(), fake, "ship-it-dlq")
+	require.NoError(t, err)
+
+	err = q.Send(context.Background(), "ship-it-ecr", "{}", errors.New(strings.Repeat("x", 2*maxErrorLength)))
+	require.NoError(t, err)
+
+	msg := fake.queues["ship-it-dlq"][0]
+	assert.Len(t, aws.StringValue(msg.MessageAttributes[errorAttribute].StringValue), maxErrorLength)
+	assert.Nil(t, msg.MessageGroupId)
+}
+
+func TestReplay(t *testing.T) {
+	fake := newFakeSQS("ship-it-dlq.fifo", "ship-it-ecr.fifo", "ship-it-github.fifo")
+
+	q, err := NewQueue(context.Background(), fake, "ship-it-dlq.fifo")
+	require.NoError(t, err)
+
+	for i := 0; i < 12; i++ {
+		source := "ship-it-ecr.fifo"
+		if i%2 == 1 {
+			source = "ship-it-github.fifo"
+		}
+		require.NoError(t, q.Send(context.Background(), source, fmt.Sprintf("message %d", i), errors.New("failed")))
+	}
+
+	n, err := q.Replay(context.Background(), 0)
+	require.NoError(t, err)
+
+	assert.Equal(t, 12, n)
+	assert.Empty(t, fake.queues["ship-it-dlq.fifo"])
+	assert.Len(t, fake.queues["ship-it-ecr.fifo"], 6)
+	assert.Len(t, fake.queues["ship-it-github.fifo"], 6)
+
+	replayed := fake.queues["ship-it-ecr.fifo"][0]
+	assert.Equal(t, "message 0", aws.StringValue(replayed.MessageBody))
+	assert.Empty(t, replayed.MessageAttributes)
+	assert.Equal(t, replayGroup, aws.StringValue(replayed.MessageGroupId))
+}
+
+func TestReplayLimit(t *testing.T) {
+	fake := newFakeSQS("ship-it-dlq", "ship-it-ecr")
+
+	q, err := NewQueue(context.Background(), fake, "ship-it-dlq")
+	require.NoError(t, err)
+
+	for i := 0; i < 5; i++ {
+		require.NoError(t, q.Send(context.Background(), "ship-it-ecr", fmt.Sprintf("message %d", i), errors.New("failed")))
+	}
+
+	n, err := q.Replay(context.Background(), 3)
+	require.NoError(t, err)
+
+	assert.Equal(t, 3, n)
+	assert.Len(t, fake.queues["ship-it-dlq"], 2)
+	assert.Len(t, fake.queues["ship-it-ecr"], 3)
+}
+
+func TestReplayMissingSource(t *testing.T) {
+	fake := newFakeSQS("ship-it-dlq")
+
+	q, err := NewQueue(context.Background(), fake, "ship-it-dlq")
+	require.NoError(t, err)
+
+	require.NoError(t, q.Send(context.Background(), "ship-it-deleted", "{}", errors.New("failed")))
+
+	n, err := q.Replay(context.Background(), 0)
+	assert.Error(t, err)
+	assert.Equal(t, 0, n)
+
+	// the message isn't lost
+	assert.Len(t, fake.queues["ship-it-dlq"], 1)
+}
@@ -1,13 +1,15 @@
 package ecr
 
 import (
+	"bytes"
 	"context"
 	"encoding/base64"
 	"fmt"
 	"strings"
+	"time"
 
 	"ship-it/internal/image"
-	"ship-it/internal/unstructured"
+	"ship-it/internal/syncd"
 
 	"github.com/google/go-github/v26/github"
 	"github.com/pkg/errors"
@@ -23,6 +25,7 @@ type GitService interface {
 	GetRef(ctx context.Context, owner string, repo string, ref string) (*github.Reference, *github.Response, error)
 	GetTree(ctx context.Context, owner string, repo string, sha string, recursive bool) (*github.Tree, *github.Response, error)
 	UpdateRef(ctx context.Context, owner string, repo string, ref *github.Reference, force bool) (*github.Reference, *github.Response, error)
+	CreateRef(ctx context.Context, owner string, repo string, ref *github.Reference) (*github.Reference, *github.Response, error)
 }
 
 type chartEditor struct {
@@ -31,21 +34,155 @@ type chartEditor struct {
 	Org        string
 	Repository string
 	Ref        string
+
+	pullRequests *pullRequests
+	retries      retries
+	digests      bool
+	author       GitAuthor
+	committer    GitAuthor
+	signer       CommitSigner
+}
+
+// GitAuthor is the identity of a commit's author or committer
+type GitAuthor struct {
+	Name  string
+	Email string
 }
 
-func NewChartEditor(g GitService, org, repo, ref, path string) *chartEditor {
-	return &chartEditor{
+type ChartEditorOption func(*chartEditor)
+
+// WithDigests writes the images' digests next to their tags, so releases are
+// pinned to the exact images that were pushed.
+func WithDigests() ChartEditorOption {
+	return func(c *chartEditor) {
+		c.digests = true
+	}
+}
+
+// WithAuthor sets the author of the commits. By default, the GitHub editor's
+// commits are authored by the GitHub App.
+func WithAuthor(author GitAuthor) ChartEditorOption {
+	return func(c *chartEditor) {
+		c.author = author
+	}
+}
+
+// WithCommitter sets the committer of the commits, which is the author by
+// default
+func WithCommitter(committer GitAuthor) ChartEditorOption {
+	return func(c *chartEditor) {
+		c.committer = committer
+	}
+}
+
+// WithSigner signs the commits. A signed commit needs an author, since the
+// signature covers its author and committer.
+func WithSigner(s CommitSigner) ChartEditorOption {
+	return func(c *chartEditor) {
+		c.signer = s
+	}
+}
+
+func NewChartEditor(g GitService, org, repo, ref, path string, opts ...ChartEditorOption) *chartEditor {
+	c := &chartEditor{
 		github:     g,
 		ChartPath:  path,
 		Org:        org,
 		Repository: repo,
 		Ref:        ref,
+		retries:    defaultRetries(),
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Edit commits the images to the named releases' HelmReleases. Every image
+// is applied to each of the releases, in a single commit.
+func (c *chartEditor) Edit(ctx context.Context, releases []types.NamespacedName, images ...*image.Ref) error {
+	return editImages(ctx, c.commit, c.digests, releases, images)
 }
 
-var errNoRegisteredReleasesAffected = errors.New("no registered releases affected")
+// Promote commits a release promotion to the promoted release's HelmRelease.
+// Only the promoted release is edited, even if other releases use the same
+// images.
+func (c *chartEditor) Promote(ctx context.Context, p *syncd.Promotion) error {
+	return promote(ctx, c.commit, p)
+}
+
+// committer applies an edit to the HelmRelease files of the named releases,
+// and commits them with the message
+type committer func(ctx context.Context, releases []types.NamespacedName, message func([]types.NamespacedName) string, edit func(*document)) error
+
+func editImages(ctx context.Context, commit committer, digests bool, releases []types.NamespacedName, images []*image.Ref) error {
+	// the trailers identify the pushed images, even if their digests aren't
+	// written to the files
+	trailers := imageTrailers(images)
+
+	if !digests {
+		images = withoutDigests(images)
+	}
+
+	// pushes that are older than the ones already applied to a release
+	// are skipped
+	edit := func(doc *document) {
+		for _, img := range images {
+			editPushedImage(doc, img)
+		}
+	}
+
+	message := func(releases []types.NamespacedName) string {
+		return commitMessage(releases, images...) + "\n\n" + trailers
+	}
+
+	err := commit(ctx, releases, message, edit)
+	if errors.Cause(err) == syncd.ErrNoRegisteredReleasesAffected {
+		return errors.Wrapf(err, "no registry chart changes for new images %s", imageList(images))
+	}
 
-func (c *chartEditor) Edit(ctx context.Context, releases []types.NamespacedName, image *image.Ref) error {
+	return err
+}
+
+func promote(ctx context.Context, commit committer, p *syncd.Promotion) error {
+	edit := func(doc *document) {
+		for i := range p.Images {
+			editYaml(doc, &p.Images[i])
+		}
+
+		if p.ChartVersion != "" {
+			editChartVersion(doc, p.ChartVersion)
+		}
+	}
+
+	releases := []types.NamespacedName{p.Release}
+
+	message := func([]types.NamespacedName) string {
+		return promotionMessage(p)
+	}
+
+	err := commit(ctx, releases, message, edit)
+	if errors.Cause(err) == syncd.ErrNoRegisteredReleasesAffected {
+		return errors.Wrapf(err, "no registry chart changes for promotion of %s", p.Release.Name)
+	}
+
+	return err
+}
+
+// commit applies the edit to the HelmRelease files of the named releases, and
+// commits the result to the targeted branch. The files of releases that
+// require review are committed to a pull request instead. If the branch moves
+// while the edit is being committed, the edit is re-applied to the branch's
+// new head.
+func (c *chartEditor) commit(ctx context.Context, releases []types.NamespacedName, message func([]types.NamespacedName) string, edit func(*document)) error {
+	return c.retries.do(ctx, c.Ref, func() error {
+		return c.tryCommit(ctx, releases, message, edit)
+	})
+}
+
+func (c *chartEditor) tryCommit(ctx context.Context, releases []types.NamespacedName, message func([]types.NamespacedName) string, edit func(*document)) error {
 	// Get commit SHA of the targeted branch (ref)
 	ref, _, err := c.github.GetRef(ctx, c.Org, c.Repository, "refs/heads/"+c.Ref)
 	if err != nil {
@@ -65,133 +202,293 @@ func (c *chartEditor) Edit(ctx context.Context, releases []types.NamespacedName,
 	}
 
 	// Modify the content tree that the commit points to
-	entries := c.editTreeEntries(ctx, releases, image, tree.Entries)
-	if len(entries) == 0 {
-		return errors.Wrapf(errNoRegisteredReleasesAffected, "no registry chart changes for new image %s", image)
+	files := c.editTreeEntries(ctx, releases, edit, tree.Entries)
+	if len(files) == 0 {
+		return syncd.ErrNoRegisteredReleasesAffected
+	}
+
+	// Direct commits go first, since they're the ones that conflict with
+	// a moving branch. Pull requests are only opened once.
+	direct, reviewed := splitReviewed(files)
+
+	if len(direct) > 0 {
+		commit, err := c.createCommit(ctx, ref, parent, direct, message(releasesOf(direct)))
+		if err != nil {
+			return err
+		}
+
+		// Update the reference of the branch to point to the new
+		// commit SHA
+		branch := *ref
+		branch.Object = &github.GitObject{SHA: commit.SHA}
+
+		if _, _, err := c.github.UpdateRef(ctx, c.Org, c.Repository, &branch, false /* force */); err != nil {
+			return errors.Wrap(err, "failed to update reference")
+		}
+	}
+
+	if len(reviewed) > 0 {
+		return c.openPullRequest(ctx, ref, parent, reviewed, message)
+	}
+
+	return nil
+}
+
+// createCommit commits the edited files on top of the parent commit
+func (c *chartEditor) createCommit(ctx context.Context, ref *github.Reference, parent *github.Commit, files []editedFile, message string) (*github.Commit, error) {
+	var entries []github.TreeEntry
+	for _, f := range files {
+		entries = append(entries, f.entry)
 	}
 
 	// Create a new content tree with the modified entries, computing
 	// Merkle-esque SHAs and so forth
-	tree, _, err = c.github.CreateTree(ctx, c.Org, c.Repository, ref.GetObject().GetSHA(), entries)
+	tree, _, err := c.github.CreateTree(ctx, c.Org, c.Repository, ref.GetObject().GetSHA(), entries)
 	if err != nil {
-		return errors.Wrap(err, "failed to create tree")
+		return nil, errors.Wrap(err, "failed to create tree")
 	}
 
 	// Create a new commit object with the current commit as the parent and
 	// the new tree, getting a new commit back
 	commit := &github.Commit{
-		Message: github.String(commitMessage(releases, image)),
+		Message: github.String(message),
 		Tree:    tree,
 		Parents: []github.Commit{*parent},
 	}
 
-	commit, _, err = c.github.CreateCommit(ctx, c.Org, c.Repository, commit)
+	if c.author.Name != "" {
+		now := commitTime()
+
+		committer := c.committer
+		if committer.Name == "" {
+			committer = c.author
+		}
+
+		commit.Author = commitAuthor(c.author, now)
+		commit.Committer = commitAuthor(committer, now)
+	}
+
+	var signed []byte
+
+	if c.signer != nil {
+		if commit.Author == nil {
+			return nil, errors.New("failed to sign commit: signed commits need an author")
+		}
+
+		payload := signaturePayload(commit)
+
+		signature, err := c.signer.Sign(payload)
+		if err != nil {
+			return nil, err
+		}
+
+		commit.Verification = &github.SignatureVerification{Signature: github.String(signature)}
+		signed = signedCommit(payload, signature)
+	}
+
+	created, _, err := c.github.CreateCommit(ctx, c.Org, c.Repository, commit)
 	if err != nil {
-		return errors.Wrap(err, "failed to create commit")
+		return nil, errors.Wrap(err, "failed to create commit")
+	}
+
+	// GitHub creates a commit whose object differs from the signed one, e.g.
+	// in its dates or message, without failing, and its signature is then
+	// invalid. It would be rejected by branches that require signed commits.
+	if signed != nil && created.GetSHA() != commitSHA(signed) {
+		return nil, errors.Errorf("failed to sign commit: GitHub created commit %s instead of the signed commit %s", created.GetSHA(), commitSHA(signed))
 	}
 
-	// Update the reference of the branch to point to the new commit SHA
-	ref.Object.SHA = commit.SHA
-	_, _, err = c.github.UpdateRef(ctx, c.Org, c.Repository, ref, false /* force */)
-	return errors.Wrap(err, "failed to update reference")
+	return created, nil
+}
+
+func commitAuthor(a GitAuthor, date time.Time) *github.CommitAuthor {
+	return &github.CommitAuthor{
+		Name:  github.String(a.Name),
+		Email: github.String(a.Email),
+		Date:  &date,
+	}
 }
 
-func commitMessage(releases []types.NamespacedName, image *image.Ref) string {
+func commitMessage(releases []types.NamespacedName, images ...*image.Ref) string {
 	names := make([]string, 0, len(releases))
 
 	for _, r := range releases {
 		names = append(names, r.Name)
 	}
 
-	return fmt.Sprintf("Updated %d helm charts using image %s\n\n%s", len(names), image, strings.Join(names, "\n"))
+	if len(images) == 1 {
+		return fmt.Sprintf("Updated %d helm charts using image %s\n\n%s", len(names), images[0], strings.Join(names, "\n"))
+	}
+
+	refs := make([]string, 0, len(images))
+	for _, img := range images {
+		refs = append(refs, img.String())
+	}
+
+	return fmt.Sprintf("Updated %d helm charts using %d images\n\n%s\n\n%s", len(names), len(images), strings.Join(refs, "\n"), strings.Join(names, "\n"))
+}
+
+// imageTrailers formats a git trailer for each pushed image, which links the
+// commit to the image push that triggered it
+func imageTrailers(images []*image.Ref) string {
+	trailers := make([]string, 0, len(images))
+	for _, img := range images {
+		trailers = append(trailers, "Image: "+img.String())
+	}
+	return strings.Join(trailers, "\n")
+}
+
+// withoutDigests copies the images without their digests
+func withoutDigests(images []*image.Ref) []*image.Ref {
+	refs := make([]*image.Ref, 0, len(images))
+	for _, img := range images {
+		ref := *img
+		ref.Digest = ""
+		refs = append(refs, &ref)
+	}
+	return refs
+}
+
+func imageList(images []*image.Ref) string {
+	refs := make([]string, 0, len(images))
+	for _, img := range images {
+		refs = append(refs, img.String())
+	}
+	return strings.Join(refs, ", ")
 }
 
-func (c *chartEditor) editTreeEntries(ctx context.Context, names []types.NamespacedName, image *image.Ref, entries []github.TreeEntry) []github.TreeEntry {
-	var edited []github.TreeEntry
+func promotionMessage(p *syncd.Promotion) string {
+	var changes []string
+
+	if p.ChartVersion != "" {
+		changes = append(changes, "chart version "+p.ChartVersion)
+	}
+
+	for _, img := range p.Images {
+		changes = append(changes, "image "+img.String())
+	}
+
+	return fmt.Sprintf("Promoted %s from %s\n\n%s", p.Release.Name, p.Upstream, strings.Join(changes, "\n"))
+}
+
+// editedFile is a HelmRelease file edited for a release
+type editedFile struct {
+	entry    github.TreeEntry
+	release  types.NamespacedName
+	manifest yaml.MapSlice
+}
+
+func releasesOf(files []editedFile) []types.NamespacedName {
+	var releases []types.NamespacedName
+	for _, f := range files {
+		releases = append(releases, f.release)
+	}
+	return releases
+}
+
+func (c *chartEditor) editTreeEntries(ctx context.Context, names []types.NamespacedName, edit func(*document), entries []github.TreeEntry) []editedFile {
+	var edited []editedFile
 
 	for _, e := range entries {
-		// // skip entries that don't belong to the chart
-		if !strings.HasPrefix(e.GetPath(), c.ChartPath) {
+		name, ok := releaseFile(e.GetPath(), c.ChartPath, names)
+		if !ok {
 			continue
 		}
 
-		for _, name := range names {
-			// skip tree entries that don't have the expected
-			// HelmRelease filename for the associated release
-			valuesYaml := fmt.Sprintf("%s.yaml", name.Name)
-
-			if !strings.HasSuffix(e.GetPath(), valuesYaml) {
-				continue
-			}
-
-			content, err := c.editBlob(ctx, e.GetSHA(), image)
-			if err == nil {
-				edited = append(edited, github.TreeEntry{
+		// files that can't be edited, or that the edit didn't change,
+		// are skipped
+		content, manifest, err := c.editBlob(ctx, e.GetSHA(), edit)
+		if err == nil {
+			edited = append(edited, editedFile{
+				entry: github.TreeEntry{
 					Content: github.String(content),
 					Mode:    e.Mode,
 					Path:    e.Path,
 					Size:    e.Size,
 					Type:    e.Type,
-				})
-			}
-
-			break
+				},
+				release:  name,
+				manifest: manifest,
+			})
 		}
 	}
 
 	return edited
 }
 
-func (c *chartEditor) editBlob(ctx context.Context, sha string, image *image.Ref) (string, error) {
+// releaseFile returns the release whose HelmRelease is the file at the path,
+// if it's in the chart.
+func releaseFile(path, chartPath string, names []types.NamespacedName) (types.NamespacedName, bool) {
+	// skip files that don't belong to the chart
+	if !strings.HasPrefix(path, chartPath) {
+		return types.NamespacedName{}, false
+	}
+
+	for _, name := range names {
+		// skip files that don't have the expected HelmRelease filename
+		// for the associated release
+		if strings.HasSuffix(path, fmt.Sprintf("%s.yaml", name.Name)) {
+			return name, true
+		}
+	}
+
+	return types.NamespacedName{}, false
+}
+
+func (c *chartEditor) editBlob(ctx context.Context, sha string, edit func(*document)) (string, yaml.MapSlice, error) {
 	blob, _, err := c.github.GetBlob(ctx, c.Org, c.Repository, sha)
 	if err != nil {
-		return "", errors.Wrap(err, "failed to get blob")
+		return "", nil, errors.Wrap(err, "failed to get blob")
 	}
 
 	content, err := base64.StdEncoding.DecodeString(blob.GetContent())
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to decode blob content")
+		return "", nil, errors.Wrapf(err, "failed to decode blob content")
 	}
 
-	// use MapSlice to preserve the order of fields
-	var values yaml.MapSlice
-	if err := yaml.Unmarshal(content, &values); err != nil {
-		return "", errors.Wrapf(err, "failed to unmarshal values YAML")
-	}
+	return editContent(content, edit)
+}
 
-	edited := editYaml(values, image)
+// errUnchanged is returned when an edit doesn't change a HelmRelease file,
+// which is skipped so that it isn't committed again
+var errUnchanged = errors.New("the edit didn't change the file")
 
-	bytes, err := yaml.Marshal(edited)
+// editContent applies the edit to a HelmRelease file's content, and returns
+// the edited content and manifest
+func editContent(content []byte, edit func(*document)) (string, yaml.MapSlice, error) {
+	// edit the file in place, to preserve its comments and formatting
+	doc, err := parseDocument(content)
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to marshal values YAML")
+		return "", nil, err
 	}
 
-	return string(bytes), nil
-}
+	edit(doc)
 
-func editYaml(spec yaml.MapSlice, desired *image.Ref) yaml.MapSlice {
-	// this predicate selects the matching image block
-	pred := func(item yaml.MapItem) bool {
-		other, err := image.FromYaml(item)
-		if err != nil {
-			return false
-		}
+	edited, err := doc.Bytes()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if bytes.Equal(edited, content) {
+		return "", nil, errUnchanged
+	}
 
-		return desired.Matches(*other)
+	// use MapSlice to preserve the order of fields
+	var manifest yaml.MapSlice
+	if err := yaml.Unmarshal(edited, &manifest); err != nil {
+		return "", nil, errors.Wrapf(err, "failed to unmarshal values YAML")
 	}
 
-	// this visitor mutates the selected image block by setting the desired tag
-	visit := func(item *yaml.MapItem) {
-		if obj, ok := item.Value.(yaml.MapSlice); ok {
-			for i := range obj {
-				if k, ok := obj[i].Key.(string); ok && k == "tag" {
-					obj[i].Value = desired.Tag
-					break
-				}
-			}
+	return string(edited), manifest, nil
+}
+
+func lookup(obj yaml.MapSlice, key string) yaml.MapSlice {
+	for _, item := range obj {
+		if k, ok := item.Key.(string); ok && k == key {
+			nested, _ := item.Value.(yaml.MapSlice)
+			return nested
 		}
 	}
 
-	unstructured.VisitOne(spec, pred, visit)
-	return spec
+	return nil
 }
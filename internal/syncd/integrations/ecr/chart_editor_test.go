@@ -7,11 +7,11 @@ import (
 	"testing"
 
 	"ship-it/internal/image"
+	"ship-it/internal/syncd"
 
 	"github.com/google/go-github/v26/github"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
-	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/types"
 )
 
@@ -29,6 +29,11 @@ func (m *mockGitService) CreateTree(ctx context.Context, owner string, repo stri
 	return args.Get(0).(*github.Tree), args.Get(1).(*github.Response), args.Error(2)
 }
 
+func (m *mockGitService) CreateRef(ctx context.Context, owner string, repo string, ref *github.Reference) (*github.Reference, *github.Response, error) {
+	args := m.Called(ctx, owner, repo, ref)
+	return args.Get(0).(*github.Reference), args.Get(1).(*github.Response), args.Error(2)
+}
+
 func (m *mockGitService) GetBlob(ctx context.Context, owner string, repo string, sha string) (*github.Blob, *github.Response, error) {
 	args := m.Called(ctx, owner, repo, sha)
 	return args.Get(0).(*github.Blob), args.Get(1).(*github.Response), args.Error(2)
@@ -92,7 +97,7 @@ metadata:
 spec:
     values:
         image:
-            repository: hub.docker.com/foo,
+            repository: test-registry.io/test-repository
             tag: fooreleaseoldtag
 `),
 		[]byte(`kind: HelmRelease
@@ -102,7 +107,7 @@ metadata:
 spec:
     values:
         image:
-            repository: hub.docker.com/bar,
+            repository: test-registry.io/test-repository
             tag: barreleaseoldtag
 `),
 	}
@@ -119,7 +124,7 @@ spec:
 	}
 
 	desired := image.Ref{
-		Registry:   "test-registry",
+		Registry:   "test-registry.io",
 		Repository: "test-repository",
 		Tag:        "new-tag",
 	}
@@ -185,56 +190,129 @@ spec:
 	assert.NoError(t, editor.Edit(ctx, releases, &desired))
 }
 
-func TestEditYaml(t *testing.T) {
-	original := yaml.MapSlice{
-		{
-			Key: "values",
-			Value: yaml.MapSlice{
-				{
-					Key: "image",
-					Value: yaml.MapSlice{
-						{
-							Key:   "repository",
-							Value: "foo/bar",
-						},
-						{
-
-							Key:   "tag",
-							Value: "oldtag",
-						},
-					},
-				},
+func TestWithoutDigests(t *testing.T) {
+	img := &image.Ref{Registry: "foo", Repository: "bar", Tag: "baz", Digest: "sha256:abc"}
+
+	refs := withoutDigests([]*image.Ref{img})
+
+	assert.Equal(t, "", refs[0].Digest)
+	assert.Equal(t, "sha256:abc", img.Digest)
+}
+
+func TestPromotionMessage(t *testing.T) {
+	p := &syncd.Promotion{
+		Release: types.NamespacedName{
+			Name: "production",
+		},
+		Upstream:     "staging",
+		ChartVersion: "1.1.0",
+		Images: []image.Ref{
+			{
+				Registry:   "foo",
+				Repository: "bar",
+				Tag:        "newtag",
 			},
 		},
 	}
 
-	expected := yaml.MapSlice{
-		{
-			Key: "values",
-			Value: yaml.MapSlice{
-				{
-					Key: "image",
-					Value: yaml.MapSlice{
-						{
-							Key:   "repository",
-							Value: "foo/bar",
-						},
-						{
-
-							Key:   "tag",
-							Value: "newtag",
-						},
-					},
-				},
-			},
-		},
+	assert.Equal(t, "Promoted production from staging\n\nchart version 1.1.0\nimage foo/bar:newtag", promotionMessage(p))
+}
+
+func TestCommitMessage(t *testing.T) {
+	releases := []types.NamespacedName{{Name: "foo"}, {Name: "bar"}}
+	first := &image.Ref{Registry: "registry", Repository: "foo", Tag: "one"}
+	second := &image.Ref{Registry: "registry", Repository: "bar", Tag: "two"}
+
+	assert.Equal(t, "Updated 2 helm charts using image registry/foo:one\n\nfoo\nbar", commitMessage(releases, first))
+	assert.Equal(t, "Updated 2 helm charts using 2 images\n\nregistry/foo:one\nregistry/bar:two\n\nfoo\nbar", commitMessage(releases, first, second))
+}
+
+func TestImageTrailers(t *testing.T) {
+	first := &image.Ref{Registry: "registry", Repository: "foo", Tag: "one", Digest: "sha256:abc"}
+	second := &image.Ref{Registry: "registry", Repository: "bar", Tag: "two"}
+
+	assert.Equal(t, "Image: registry/foo:one@sha256:abc\nImage: registry/bar:two", imageTrailers([]*image.Ref{first, second}))
+}
+
+func TestCreateSignedCommit(t *testing.T) {
+	ctx := context.Background()
+
+	signer := newTestSSHSigner(t)
+
+	mockGit := new(mockGitService)
+
+	mockGit.On("CreateTree", ctx, "org", "repo", "base-sha", mock.AnythingOfType("[]github.TreeEntry")).Return(
+		&github.Tree{SHA: github.String("tree-sha")}, &github.Response{}, nil,
+	)
+
+	// GitHub creates the object that was signed
+	var created *github.Commit
+	result := &github.Commit{}
+	mockGit.On("CreateCommit", ctx, "org", "repo", mock.MatchedBy(func(c *github.Commit) bool {
+		created = c
+		return true
+	})).Run(func(args mock.Arguments) {
+		result.SHA = github.String(commitSHA(signedCommit(signaturePayload(created), created.GetVerification().GetSignature())))
+	}).Return(result, &github.Response{}, nil)
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "charts/registry",
+		WithAuthor(GitAuthor{Name: "ship-it", Email: "ship-it@example.com"}),
+		WithCommitter(GitAuthor{Name: "bot", Email: "bot@example.com"}),
+		WithSigner(signer),
+	)
+
+	ref := &github.Reference{Object: &github.GitObject{SHA: github.String("base-sha")}}
+	parent := &github.Commit{SHA: github.String("base-sha")}
+
+	_, err := editor.createCommit(ctx, ref, parent, []editedFile{{}}, "message")
+	assert.NoError(t, err)
+
+	if assert.NotNil(t, created) {
+		assert.Equal(t, "ship-it@example.com", created.GetAuthor().GetEmail())
+		assert.Equal(t, "bot@example.com", created.GetCommitter().GetEmail())
+		assert.Equal(t, created.GetAuthor().GetDate(), created.GetCommitter().GetDate())
+
+		verifySSHSignature(t, signer.signer.PublicKey(), signaturePayload(created), created.GetVerification().GetSignature())
 	}
+}
 
-	desired := image.Ref{
-		Registry:   "foo",
-		Repository: "bar",
-		Tag:        "newtag",
+func TestCreateSignedCommitMismatch(t *testing.T) {
+	ctx := context.Background()
+
+	mockGit := new(mockGitService)
+	mockGit.On("CreateTree", ctx, "org", "repo", "base-sha", mock.AnythingOfType("[]github.TreeEntry")).Return(
+		&github.Tree{SHA: github.String("tree-sha")}, &github.Response{}, nil,
+	)
+	mockGit.On("CreateCommit", ctx, "org", "repo", mock.AnythingOfType("*github.Commit")).Return(
+		&github.Commit{SHA: github.String("head-sha")}, &github.Response{}, nil,
+	)
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "charts/registry",
+		WithAuthor(GitAuthor{Name: "ship-it", Email: "ship-it@example.com"}),
+		WithSigner(newTestSSHSigner(t)),
+	)
+
+	ref := &github.Reference{Object: &github.GitObject{SHA: github.String("base-sha")}}
+
+	_, err := editor.createCommit(ctx, ref, &github.Commit{SHA: github.String("base-sha")}, []editedFile{{}}, "message")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "GitHub created commit head-sha instead of the signed commit")
 	}
+}
+
+func TestCreateSignedCommitWithoutAuthor(t *testing.T) {
+	ctx := context.Background()
+
+	mockGit := new(mockGitService)
+	mockGit.On("CreateTree", ctx, "org", "repo", "base-sha", mock.AnythingOfType("[]github.TreeEntry")).Return(
+		&github.Tree{SHA: github.String("tree-sha")}, &github.Response{}, nil,
+	)
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "charts/registry", WithSigner(newTestSSHSigner(t)))
+
+	ref := &github.Reference{Object: &github.GitObject{SHA: github.String("base-sha")}}
 
-	assert.Equal(t, expected, editYaml(original, &desired))
+	_, err := editor.createCommit(ctx, ref, &github.Commit{SHA: github.String("base-sha")}, []editedFile{{}}, "message")
+	assert.Error(t, err)
+	mockGit.AssertNotCalled(t, "CreateCommit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
 }
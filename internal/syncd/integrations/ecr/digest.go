@@ -0,0 +1,52 @@
+package ecr
+
+import (
+	"context"
+
+	"ship-it/internal/image"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsecr "github.com/aws/aws-sdk-go/service/ecr"
+	"github.com/pkg/errors"
+)
+
+// ImageDescriber describes the images of an ECR repository
+type ImageDescriber interface {
+	DescribeImagesWithContext(ctx aws.Context, input *awsecr.DescribeImagesInput, opts ...request.Option) (*awsecr.DescribeImagesOutput, error)
+}
+
+// WithDigests looks up the digests of pushed images whose events don't
+// include them.
+func (l *ImageListener) WithDigests(d ImageDescriber) *ImageListener {
+	l.images = d
+	return l
+}
+
+// resolveDigest sets the image's digest, using the registry when the push
+// event didn't include it.
+func (l *ImageListener) resolveDigest(ctx context.Context, event pushEvent, img *image.Ref) error {
+	if img.Digest != "" || l.images == nil {
+		return nil
+	}
+
+	out, err := l.images.DescribeImagesWithContext(ctx, &awsecr.DescribeImagesInput{
+		RegistryId:     aws.String(event.RegistryId),
+		RepositoryName: aws.String(event.RepositoryName),
+		ImageIds: []*awsecr.ImageIdentifier{
+			{ImageTag: aws.String(event.Tag)},
+		},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to describe image %s", img)
+	}
+
+	for _, detail := range out.ImageDetails {
+		if digest := aws.StringValue(detail.ImageDigest); digest != "" {
+			img.Digest = digest
+			return nil
+		}
+	}
+
+	return errors.Errorf("image %s not found in registry", img)
+}
@@ -0,0 +1,404 @@
+package ecr
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/google/go-github/v26/github"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// errNonFastForward is returned when a push is rejected because the branch
+// has moved
+var errNonFastForward = errors.New("push rejected: the branch has moved")
+
+// GitChartEditor edits the registry chart in a local working copy of the
+// operations repository, and pushes its commits with plain git over HTTPS or
+// SSH. Unlike the GitHub chart editor, it works with any git server, e.g.
+// GitLab, Gitea or a bare repository.
+//
+// The git binary authenticates with the remote as usual: with credentials in
+// the remote's URL or a credential helper for HTTPS, and with the SSH agent,
+// keys or GIT_SSH_COMMAND for SSH.
+//
+// Plain git can't open pull requests, so the files of releases that require
+// review are pushed to a branch of their own, to be reviewed in the git
+// server's UI.
+type GitChartEditor struct {
+	remote    string
+	branch    string
+	chartPath string
+	dir       string
+	author    GitAuthor
+	committer GitAuthor
+	signer    CommitSigner
+
+	digests bool
+	retries retries
+
+	// mu serializes the edits, which share the working copy
+	mu sync.Mutex
+}
+
+// defaultAuthor authors the git editor's commits when WithAuthor isn't used
+var defaultAuthor = GitAuthor{Name: "ship-it", Email: "ship-it@localhost"}
+
+// NewGitChartEditor returns an editor of the chart at the path of the remote
+// repository's branch, which uses dir as its working copy. The options are
+// the GitHub chart editor's, except for WithPullRequests, which is ignored.
+func NewGitChartEditor(remote, branch, path, dir string, opts ...ChartEditorOption) *GitChartEditor {
+	settings := &chartEditor{
+		author:  defaultAuthor,
+		retries: defaultRetries(),
+	}
+	for _, opt := range opts {
+		opt(settings)
+	}
+
+	committer := settings.committer
+	if committer.Name == "" {
+		committer = settings.author
+	}
+
+	return &GitChartEditor{
+		remote:    remote,
+		branch:    branch,
+		chartPath: path,
+		dir:       dir,
+		author:    settings.author,
+		committer: committer,
+		signer:    settings.signer,
+		digests:   settings.digests,
+		retries:   settings.retries,
+	}
+}
+
+// Edit commits the images to the named releases' HelmReleases. Every image
+// is applied to each of the releases, in a single commit.
+func (e *GitChartEditor) Edit(ctx context.Context, releases []types.NamespacedName, images ...*image.Ref) error {
+	return editImages(ctx, e.commit, e.digests, releases, images)
+}
+
+// Promote commits a release promotion to the promoted release's HelmRelease.
+func (e *GitChartEditor) Promote(ctx context.Context, p *syncd.Promotion) error {
+	return promote(ctx, e.commit, p)
+}
+
+// commit applies the edit to the HelmRelease files of the named releases, and
+// pushes the result to the branch. If the branch moves before the push, the
+// edit is re-applied to the branch's new head.
+func (e *GitChartEditor) commit(ctx context.Context, releases []types.NamespacedName, message func([]types.NamespacedName) string, edit func(*document)) error {
+	return e.retries.do(ctx, e.branch, func() error {
+		return e.tryCommit(ctx, releases, message, edit)
+	})
+}
+
+func (e *GitChartEditor) tryCommit(ctx context.Context, releases []types.NamespacedName, message func([]types.NamespacedName) string, edit func(*document)) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if err := e.checkout(ctx); err != nil {
+		return err
+	}
+
+	base, err := e.git(ctx, "rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	files, err := e.editFiles(releases, edit)
+	if err != nil {
+		return err
+	}
+
+	if len(files) == 0 {
+		return syncd.ErrNoRegisteredReleasesAffected
+	}
+
+	direct, reviewed := splitReviewed(files)
+
+	if len(direct) > 0 {
+		committed, err := e.commitFiles(ctx, direct, message(releasesOf(direct)))
+		if err != nil {
+			return err
+		}
+
+		if committed {
+			if err := e.push(ctx, e.branch); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(reviewed) > 0 {
+		branch := branchName(reviewed)
+
+		// the branch of an edit that was already pushed, e.g. by a
+		// redelivered push, is left as it is
+		existing, err := e.git(ctx, "ls-remote", "origin", "refs/heads/"+branch)
+		if err != nil || existing != "" {
+			return err
+		}
+
+		// the review branch starts from the same commit as the edit,
+		// without the direct changes
+		if _, err := e.git(ctx, "checkout", "--force", "--detach", base); err != nil {
+			return err
+		}
+
+		committed, err := e.commitFiles(ctx, reviewed, message(releasesOf(reviewed)))
+		if err != nil || !committed {
+			return err
+		}
+
+		return e.push(ctx, branch)
+	}
+
+	return nil
+}
+
+// checkout fetches the branch, and checks out its head in the working copy.
+// The working copy is created the first time.
+func (e *GitChartEditor) checkout(ctx context.Context) error {
+	if _, err := os.Stat(filepath.Join(e.dir, ".git")); os.IsNotExist(err) {
+		if err := os.MkdirAll(e.dir, 0755); err != nil {
+			return errors.Wrap(err, "failed to create working copy")
+		}
+
+		if _, err := e.git(ctx, "init", "--quiet"); err != nil {
+			return err
+		}
+
+		if _, err := e.git(ctx, "remote", "add", "origin", e.remote); err != nil {
+			return err
+		}
+	}
+
+	remoteBranch := "refs/remotes/origin/" + e.branch
+
+	commands := [][]string{
+		{"remote", "set-url", "origin", e.remote},
+		{"fetch", "--quiet", "--prune", "origin", "+refs/heads/" + e.branch + ":" + remoteBranch},
+		{"checkout", "--quiet", "--force", "--detach", remoteBranch},
+		{"clean", "--quiet", "-ffdx"},
+	}
+
+	for _, args := range commands {
+		if _, err := e.git(ctx, args...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// editFiles applies the edit to the HelmRelease files of the named releases
+// in the working copy. The files aren't written.
+func (e *GitChartEditor) editFiles(names []types.NamespacedName, edit func(*document)) ([]editedFile, error) {
+	var edited []editedFile
+
+	root := filepath.Join(e.dir, e.chartPath)
+
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		rel, err := filepath.Rel(e.dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		name, ok := releaseFile(rel, strings.Trim(e.chartPath, "/"), names)
+		if !ok {
+			return nil
+		}
+
+		original, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		content, manifest, err := editContent(original, edit)
+		if err != nil {
+			// like the GitHub editor, files that can't be edited, or
+			// that the edit didn't change, are skipped
+			return nil
+		}
+
+		edited = append(edited, editedFile{
+			entry: github.TreeEntry{
+				Content: github.String(content),
+				Path:    github.String(rel),
+			},
+			release:  name,
+			manifest: manifest,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the registry chart")
+	}
+
+	return edited, nil
+}
+
+// commitFiles writes the files to the working copy, and commits them. It
+// reports whether there was anything to commit.
+func (e *GitChartEditor) commitFiles(ctx context.Context, files []editedFile, message string) (bool, error) {
+	paths := make([]string, 0, len(files))
+
+	for _, f := range files {
+		path := filepath.Join(e.dir, filepath.FromSlash(f.entry.GetPath()))
+		if err := ioutil.WriteFile(path, []byte(f.entry.GetContent()), 0644); err != nil {
+			return false, errors.Wrap(err, "failed to write the registry chart")
+		}
+
+		paths = append(paths, f.entry.GetPath())
+	}
+
+	if _, err := e.git(ctx, append([]string{"add", "--"}, paths...)...); err != nil {
+		return false, err
+	}
+
+	status, err := e.git(ctx, "status", "--porcelain")
+	if err != nil || status == "" {
+		return false, err
+	}
+
+	if _, err := e.run(ctx, e.authorEnv(), "commit", "--quiet", "--no-gpg-sign", "--message", message); err != nil {
+		return false, err
+	}
+
+	if e.signer != nil {
+		if err := e.sign(ctx); err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// sign replaces the checked out commit with a signed copy. The signature
+// covers the commit object, which is only known once it's been created.
+func (e *GitChartEditor) sign(ctx context.Context) error {
+	object, err := e.runRaw(ctx, nil, nil, "cat-file", "commit", "HEAD")
+	if err != nil {
+		return err
+	}
+
+	signature, err := e.signer.Sign([]byte(object))
+	if err != nil {
+		return err
+	}
+
+	signed := signedCommit([]byte(object), signature)
+
+	sha, err := e.runRaw(ctx, nil, bytes.NewReader(signed), "hash-object", "-t", "commit", "-w", "--stdin")
+	if err != nil {
+		return err
+	}
+
+	_, err = e.git(ctx, "reset", "--quiet", "--soft", strings.TrimSpace(sha))
+	return err
+}
+
+// push pushes the checked out commit to the remote branch
+func (e *GitChartEditor) push(ctx context.Context, branch string) error {
+	_, err := e.git(ctx, "push", "--quiet", "origin", "HEAD:refs/heads/"+branch)
+	if err != nil && isRejected(err.Error()) {
+		return errors.Wrapf(errNonFastForward, "failed to push to %s", branch)
+	}
+
+	return err
+}
+
+// isRejected checks if git's output is a push rejected because the remote
+// branch has commits that aren't in the pushed commit's history
+func isRejected(output string) bool {
+	for _, reason := range []string{"non-fast-forward", "fetch first", "stale info"} {
+		if strings.Contains(output, reason) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *GitChartEditor) authorEnv() []string {
+	return []string{
+		"GIT_AUTHOR_NAME=" + e.author.Name,
+		"GIT_AUTHOR_EMAIL=" + e.author.Email,
+		"GIT_COMMITTER_NAME=" + e.committer.Name,
+		"GIT_COMMITTER_EMAIL=" + e.committer.Email,
+	}
+}
+
+func (e *GitChartEditor) git(ctx context.Context, args ...string) (string, error) {
+	return e.run(ctx, nil, args...)
+}
+
+// run runs git in the working copy, and returns its output
+func (e *GitChartEditor) run(ctx context.Context, env []string, args ...string) (string, error) {
+	output, err := e.runRaw(ctx, env, nil, args...)
+	return strings.TrimSpace(output), err
+}
+
+// runRaw runs git in the working copy with the input, and returns its
+// untrimmed output
+func (e *GitChartEditor) runRaw(ctx context.Context, env []string, stdin io.Reader, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = e.dir
+	cmd.Stdin = stdin
+
+	// never prompt for credentials, which would block the editor
+	cmd.Env = append(append(os.Environ(), "GIT_TERMINAL_PROMPT=0"), env...)
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Run(); err != nil {
+		output := strings.TrimSpace(stderr.String())
+		if output == "" {
+			output = err.Error()
+		}
+		return "", errors.Errorf("git %s failed: %s", args[0], e.redact(output))
+	}
+
+	return stdout.String(), nil
+}
+
+// redact removes the remote's password from git's output
+func (e *GitChartEditor) redact(output string) string {
+	u, err := url.Parse(e.remote)
+	if err != nil || u.User == nil {
+		return output
+	}
+
+	if password, ok := u.User.Password(); ok && password != "" {
+		output = strings.Replace(output, password, "xxxxx", -1)
+	}
+
+	return output
+}
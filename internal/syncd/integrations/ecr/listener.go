@@ -4,7 +4,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"regexp"
 	"time"
 
 	"ship-it/internal/image"
@@ -22,6 +21,16 @@ type ImageListener struct {
 	logger  log.Logger
 	service *sqsconsumer.SQSService
 	timer   metrics.Histogram
+	images  ImageDescriber
+
+	// queue is the name of the queue of push events, and deadLetters
+	// stores the events that can't be handled
+	queue       string
+	deadLetters middleware.DeadLetterQueue
+
+	// region is the region of the registries whose push events don't
+	// include one
+	region string
 }
 
 type pushEvent struct {
@@ -29,19 +38,28 @@ type pushEvent struct {
 	RepositoryName string    `json:"repositoryName"`
 	Tag            string    `json:"tag"`
 	RegistryId     string    `json:"registryId"`
+	ImageDigest    string    `json:"imageDigest"`
+	Region         string    `json:"region"`
 }
 
-var validImageTagRegex = regexp.MustCompile("^[0-9a-f]{40}$")
+// Image returns the pushed image. Its registry is in the event's region, or
+// the default region when the event doesn't have one.
+func (e pushEvent) Image(defaultRegion string) image.Ref {
+	region := e.Region
+	if region == "" {
+		region = defaultRegion
+	}
 
-func (e pushEvent) Image() image.Ref {
 	return image.Ref{
-		Registry:   e.RegistryId + ".dkr.ecr.us-east-1.amazonaws.com",
+		Registry:   fmt.Sprintf("%s.dkr.ecr.%s.amazonaws.com", e.RegistryId, region),
 		Repository: e.RepositoryName,
 		Tag:        e.Tag,
+		Digest:     e.ImageDigest,
+		PushedAt:   e.EventTime,
 	}
 }
 
-func NewListener(l log.Logger, h metrics.Histogram, queue string, sqs sqsconsumer.SQSAPI) (*ImageListener, error) {
+func NewListener(l log.Logger, h metrics.Histogram, region string, queue string, sqs sqsconsumer.SQSAPI) (*ImageListener, error) {
 	svc, err := sqsconsumer.NewSQSService(queue, sqs)
 	if err != nil {
 		return nil, err
@@ -51,12 +69,22 @@ func NewListener(l log.Logger, h metrics.Histogram, queue string, sqs sqsconsume
 		logger:  log.With(l, "worker", "ecr"),
 		service: svc,
 		timer:   h.With("worker", "ecr"),
+		queue:   queue,
+		region:  region,
 	}, nil
 }
 
+// WithDeadLetters sends the push events that fail permanently to the
+// dead-letter queue, instead of dropping them.
+func (l *ImageListener) WithDeadLetters(q middleware.DeadLetterQueue) *ImageListener {
+	l.deadLetters = q
+	return l
+}
+
 func (l *ImageListener) Listen(ctx context.Context, r syncd.ImageReconciler) error {
 	stack := sqsmiddleware.ApplyDecoratorsToHandler(
 		l.handler(r),
+		middleware.DeadLetter(l.logger, l.queue, l.deadLetters),
 		middleware.Timer(l.timer),
 		middleware.Logger(l.logger),
 	)
@@ -67,18 +95,23 @@ func (l *ImageListener) handler(r syncd.ImageReconciler) sqsconsumer.MessageHand
 	return func(ctx context.Context, msg string) error {
 		var event pushEvent
 		if err := json.Unmarshal([]byte(msg), &event); err != nil {
-			return errors.Wrap(err, "failure to parse ecr push event")
+			return syncd.Permanent(errors.Wrap(err, "failure to parse ecr push event"))
+		}
+
+		if event.RepositoryName == "" || event.Tag == "" || event.RegistryId == "" {
+			return syncd.Permanent(errors.Errorf("incomplete ecr push event: %s", msg))
 		}
 
-		image := event.Image()
+		// the reconciler decides which releases the image's tag
+		// is allowed to update, using their tag policies
+		image := event.Image(l.region)
 
-		if !validImageTagRegex.MatchString(image.Tag) {
-			l.logger.Log("error", fmt.Sprintf("ignoring event for invalid image \"%s\"", image))
-			return nil
+		if err := l.resolveDigest(ctx, event, &image); err != nil {
+			return err
 		}
 
 		err := r.Reconcile(ctx, &image)
-		if errors.Cause(err) == errNoRegisteredReleasesAffected {
+		if errors.Cause(err) == syncd.ErrNoRegisteredReleasesAffected {
 			// if no releases were affected by the new image, then
 			// the event was successfully handled. Log it to be able
 			// to detect when syncd successfully does nothing.
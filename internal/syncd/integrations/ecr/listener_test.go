@@ -3,9 +3,14 @@ package ecr
 import (
 	"context"
 	"testing"
+	"time"
 
 	"ship-it/internal/image"
+	"ship-it/internal/syncd"
 
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awsecr "github.com/aws/aws-sdk-go/service/ecr"
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/metrics/discard"
 	"github.com/pkg/errors"
@@ -32,38 +37,52 @@ func TestMakeImageFromEvent(t *testing.T) {
 		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
 		Repository: "ship-it",
 		Tag:        "shipped",
-	}, event.Image())
+	}, event.Image("us-east-1"))
+
+	event.Region = "eu-west-1"
+	assert.Equal(t, "723255503624.dkr.ecr.eu-west-1.amazonaws.com", event.Image("us-east-1").Registry)
 }
 
-func TestValidateTag(t *testing.T) {
-	tests := []struct {
-		inputString string
-		expected    bool
-	}{
-		{"78bc9ccf64eb838c6a0e0492ded722274925e2bd", true},
-		{"latest", false},
-		{"78bc9ccf64eb838c6a0e0492ded722274925E2ND", false},
-	}
-	for _, test := range tests {
-		assert.Equal(t, test.expected, validImageTagRegex.MatchString(test.inputString))
+func TestECRHandlerAnyTag(t *testing.T) {
+	testListener := &ImageListener{
+		logger: log.NewNopLogger(),
+		timer:  discard.NewHistogram(),
+		region: "us-east-1",
 	}
+
+	// tags are filtered by the releases' tag policies, not the listener
+	mockReconciler := new(MockReconciler)
+	mockReconciler.On("Reconcile", mock.Anything, &image.Ref{
+		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
+		Repository: "monolith-php",
+		Tag:        "v1.2.3",
+	}).Return(nil)
+
+	err := testListener.handler(mockReconciler)(context.Background(), `{"repositoryName": "monolith-php", "tag": "v1.2.3", "registryId": "723255503624"}`)
+	assert.NoError(t, err)
+	mockReconciler.AssertExpectations(t)
 }
 
 func TestECRHandler(t *testing.T) {
 	testListener := &ImageListener{
 		logger: log.NewNopLogger(),
 		timer:  discard.NewHistogram(),
+		region: "us-east-1",
 	}
 
 	mockReconciler := new(MockReconciler)
 
 	err := testListener.handler(mockReconciler)(context.Background(), "some bad message")
-	assert.Error(t, err)
+	assert.True(t, syncd.IsPermanent(err))
+
+	err = testListener.handler(mockReconciler)(context.Background(), `{"repositoryName": "monolith-php"}`)
+	assert.True(t, syncd.IsPermanent(err))
 
 	mockReconciler.On("Reconcile", mock.Anything, &image.Ref{
 		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
 		Repository: "monolith-php",
 		Tag:        "78bc9ccf64eb838c6a0e0492ded722274925e2bd",
+		PushedAt:   time.Date(2019, time.July, 11, 14, 19, 59, 0, time.UTC),
 	}).Return(nil)
 
 	inputJSON := `
@@ -78,6 +97,63 @@ func TestECRHandler(t *testing.T) {
 	mockReconciler.AssertExpectations(t)
 }
 
+type mockImageDescriber struct {
+	mock.Mock
+}
+
+func (m *mockImageDescriber) DescribeImagesWithContext(ctx aws.Context, input *awsecr.DescribeImagesInput, opts ...request.Option) (*awsecr.DescribeImagesOutput, error) {
+	args := m.Called(ctx, input)
+	out, _ := args.Get(0).(*awsecr.DescribeImagesOutput)
+	return out, args.Error(1)
+}
+
+func TestECRHandlerDigests(t *testing.T) {
+	describer := new(mockImageDescriber)
+
+	testListener := (&ImageListener{
+		logger: log.NewNopLogger(),
+		timer:  discard.NewHistogram(),
+		region: "us-east-1",
+	}).WithDigests(describer)
+
+	describer.On("DescribeImagesWithContext", mock.Anything, &awsecr.DescribeImagesInput{
+		RegistryId:     aws.String("723255503624"),
+		RepositoryName: aws.String("monolith-php"),
+		ImageIds: []*awsecr.ImageIdentifier{
+			{ImageTag: aws.String("lookedup")},
+		},
+	}).Return(&awsecr.DescribeImagesOutput{
+		ImageDetails: []*awsecr.ImageDetail{
+			{ImageDigest: aws.String("sha256:registry")},
+		},
+	}, nil)
+
+	mockReconciler := new(MockReconciler)
+	mockReconciler.On("Reconcile", mock.Anything, &image.Ref{
+		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
+		Repository: "monolith-php",
+		Tag:        "included",
+		Digest:     "sha256:event",
+	}).Return(nil)
+	mockReconciler.On("Reconcile", mock.Anything, &image.Ref{
+		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
+		Repository: "monolith-php",
+		Tag:        "lookedup",
+		Digest:     "sha256:registry",
+	}).Return(nil)
+
+	handler := testListener.handler(mockReconciler)
+
+	// the event's digest is used as is
+	assert.NoError(t, handler(context.Background(), `{"repositoryName": "monolith-php", "tag": "included", "registryId": "723255503624", "imageDigest": "sha256:event"}`))
+
+	// a missing digest is looked up in the registry
+	assert.NoError(t, handler(context.Background(), `{"repositoryName": "monolith-php", "tag": "lookedup", "registryId": "723255503624"}`))
+
+	mockReconciler.AssertExpectations(t)
+	describer.AssertNumberOfCalls(t, "DescribeImagesWithContext", 1)
+}
+
 type mockLogger struct {
 	mock.Mock
 }
@@ -88,7 +164,7 @@ func (m *mockLogger) Log(kvs ...interface{}) error {
 }
 
 func TestReconcilerNoRegisteredReleasesAffected(t *testing.T) {
-	testErr := errors.Wrap(errNoRegisteredReleasesAffected, "wrapping with test context")
+	testErr := errors.Wrap(syncd.ErrNoRegisteredReleasesAffected, "wrapping with test context")
 
 	mockLogger := new(mockLogger)
 	mockLogger.On("Log", mock.AnythingOfType("string"), testErr.Error()).Return(nil)
@@ -96,6 +172,7 @@ func TestReconcilerNoRegisteredReleasesAffected(t *testing.T) {
 	testListener := &ImageListener{
 		logger: mockLogger,
 		timer:  discard.NewHistogram(),
+		region: "us-east-1",
 	}
 
 	inputJSON := `
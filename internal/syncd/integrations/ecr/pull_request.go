@@ -0,0 +1,531 @@
+package ecr
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"ship-it/internal/image"
+	"ship-it/internal/unstructured"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-github/v26/github"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// pullRequestAnnotation opts a release into having its registry chart
+	// changes reviewed in a pull request, rather than committed directly to
+	// the release branch.
+	pullRequestAnnotation = "helmreleases.shipit.wattpad.com/pullrequest"
+
+	// autoMergeAnnotation merges a release's pull requests once their
+	// commit statuses and check runs have passed.
+	autoMergeAnnotation = "helmreleases.shipit.wattpad.com/automerge"
+
+	// autoMergeNotice marks the pull requests that are merged automatically
+	autoMergeNotice = "This pull request will be merged automatically once its checks pass."
+
+	// branchPrefix prefixes the names of the pull request branches
+	branchPrefix = "ship-it/"
+)
+
+// bodyFilePattern matches the release files listed in a pull request's body
+var bodyFilePattern = regexp.MustCompile("(?m)^\\* File: `([^`]+)`$")
+
+// AutoMerge configures how the pull requests of auto-merged releases are
+// merged
+type AutoMerge struct {
+	// Method is the merge method, "merge", "squash" or "rebase". If it's
+	// empty, the first of squash, merge and rebase that the repository
+	// allows is used.
+	Method string
+
+	// Unchecked merges the pull requests without any commit statuses or
+	// check runs, once they've waited for them for a grace period.
+	// Otherwise, a pull request isn't merged until a check has passed.
+	Unchecked bool
+}
+
+type PullRequestService interface {
+	Create(ctx context.Context, owner string, repo string, pull *github.NewPullRequest) (*github.PullRequest, *github.Response, error)
+	List(ctx context.Context, owner string, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error)
+	Merge(ctx context.Context, owner string, repo string, number int, commitMessage string, options *github.PullRequestOptions) (*github.PullRequestMergeResult, *github.Response, error)
+	Edit(ctx context.Context, owner string, repo string, number int, pull *github.PullRequest) (*github.PullRequest, *github.Response, error)
+}
+
+// RepositoryService gets a repository's settings, such as the merge methods
+// that it allows
+type RepositoryService interface {
+	Get(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error)
+}
+
+type StatusService interface {
+	GetCombinedStatus(ctx context.Context, owner, repo, ref string, opt *github.ListOptions) (*github.CombinedStatus, *github.Response, error)
+}
+
+// ChecksService lists the check runs of a commit, such as GitHub Actions,
+// which aren't part of its combined status
+type ChecksService interface {
+	ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opt *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error)
+}
+
+type pullRequests struct {
+	logger    log.Logger
+	pulls     PullRequestService
+	statuses  StatusService
+	checks    ChecksService
+	repos     RepositoryService
+	autoMerge AutoMerge
+
+	// pollInterval and mergeTimeout bound how often, and for how long, the
+	// checks of an auto-merged pull request are polled
+	pollInterval time.Duration
+	mergeTimeout time.Duration
+
+	// checksGracePeriod is how long a pull request without any checks
+	// waits for them to be reported, before it's merged, if unchecked pull
+	// requests are merged
+	checksGracePeriod time.Duration
+
+	// failed are the pull requests whose checks failed, which are only
+	// logged once
+	failed map[int]bool
+}
+
+// WithPullRequests allows the editor to open pull requests for the releases
+// annotated with helmreleases.shipit.wattpad.com/pullrequest: "true". The
+// pull requests of releases annotated with automerge: "true" are merged as
+// configured by the auto merge.
+func WithPullRequests(l log.Logger, pulls PullRequestService, statuses StatusService, checks ChecksService, repos RepositoryService, autoMerge AutoMerge) ChartEditorOption {
+	return func(c *chartEditor) {
+		c.pullRequests = &pullRequests{
+			logger:            l,
+			pulls:             pulls,
+			statuses:          statuses,
+			checks:            checks,
+			repos:             repos,
+			autoMerge:         autoMerge,
+			pollInterval:      time.Minute,
+			mergeTimeout:      time.Hour,
+			checksGracePeriod: 5 * time.Minute,
+			failed:            make(map[int]bool),
+		}
+	}
+}
+
+// splitReviewed separates the files of releases that are committed directly
+// from the ones that are reviewed in a pull request.
+func splitReviewed(files []editedFile) (direct []editedFile, reviewed []editedFile) {
+	for _, f := range files {
+		if annotation(f.manifest, pullRequestAnnotation) == "true" {
+			reviewed = append(reviewed, f)
+		} else {
+			direct = append(direct, f)
+		}
+	}
+
+	return direct, reviewed
+}
+
+// openPullRequest commits the files to a new branch, and opens a pull request
+// to merge the branch into the release branch. The branch is named after the
+// edited files, so an edit that's already waiting in an open pull request,
+// e.g. a redelivered push, reuses it.
+func (c *chartEditor) openPullRequest(ctx context.Context, base *github.Reference, parent *github.Commit, files []editedFile, message func([]types.NamespacedName) string) error {
+	if c.pullRequests == nil {
+		return errors.New("pull requests are required, but haven't been configured")
+	}
+
+	branch := branchName(files)
+
+	existing, err := c.pullRequests.find(ctx, c.Org, c.Repository, c.Ref, branch)
+	if err != nil {
+		return err
+	}
+
+	if existing != nil {
+		c.pullRequests.logger.Log("event", "pullrequest.exists", "pull_request", existing.GetNumber(), "branch", branch)
+		return nil
+	}
+
+	text := message(releasesOf(files))
+
+	commit, err := c.createCommit(ctx, base, parent, files, text)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = c.github.CreateRef(ctx, c.Org, c.Repository, &github.Reference{
+		Ref:    github.String("refs/heads/" + branch),
+		Object: &github.GitObject{SHA: commit.SHA},
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create branch %s", branch)
+	}
+
+	title := strings.SplitN(text, "\n", 2)[0]
+
+	pr, _, err := c.pullRequests.pulls.Create(ctx, c.Org, c.Repository, &github.NewPullRequest{
+		Title: github.String(title),
+		Head:  github.String(branch),
+		Base:  github.String(c.Ref),
+		Body:  github.String(pullRequestBody(title, files)),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to open pull request for branch %s", branch)
+	}
+
+	// the new pull request is already open, so failing to close the ones it
+	// supersedes doesn't fail the edit
+	if err := c.pullRequests.closeSuperseded(ctx, c.Org, c.Repository, c.Ref, pr, files); err != nil {
+		c.pullRequests.logger.Log("event", "pullrequest.supersede.error", "pull_request", pr.GetNumber(), "error", err)
+	}
+
+	return nil
+}
+
+// closeSuperseded closes the open pull requests whose releases' files are all
+// edited by the new pull request, e.g. the pull request of an older push of
+// the same image, so an older edit can't be merged after the newer one.
+func (p *pullRequests) closeSuperseded(ctx context.Context, owner, repo, base string, newer *github.PullRequest, files []editedFile) error {
+	edited := make(map[string]bool)
+	for _, f := range files {
+		edited[f.entry.GetPath()] = true
+	}
+
+	return p.eachOpen(ctx, owner, repo, base, func(pr *github.PullRequest) error {
+		if pr.GetNumber() == newer.GetNumber() {
+			return nil
+		}
+
+		paths := bodyFiles(pr.GetBody())
+		if len(paths) == 0 {
+			return nil
+		}
+
+		for _, path := range paths {
+			if !edited[path] {
+				return nil
+			}
+		}
+
+		_, _, err := p.pulls.Edit(ctx, owner, repo, pr.GetNumber(), &github.PullRequest{
+			State: github.String("closed"),
+		})
+		if err != nil {
+			return errors.Wrapf(err, "failed to close pull request %d", pr.GetNumber())
+		}
+
+		p.logger.Log("event", "pullrequest.superseded", "pull_request", pr.GetNumber(), "superseded_by", newer.GetNumber())
+		return nil
+	})
+}
+
+// bodyFiles returns the release files listed in a pull request's body
+func bodyFiles(body string) []string {
+	var paths []string
+	for _, match := range bodyFilePattern.FindAllStringSubmatch(body, -1) {
+		paths = append(paths, match[1])
+	}
+	return paths
+}
+
+// MergePullRequests merges the open pull requests of auto-merged releases
+// once their checks pass, until the context is done. The pull requests are
+// listed on every poll, so the ones opened before a restart are merged too.
+func (c *chartEditor) MergePullRequests(ctx context.Context) error {
+	if c.pullRequests == nil {
+		return nil
+	}
+
+	ticker := time.NewTicker(c.pullRequests.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := c.pullRequests.mergeReady(ctx, c.Org, c.Repository, c.Ref); err != nil {
+			c.pullRequests.logger.Log("event", "automerge.error", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// find returns the open pull request of the branch, if there is one
+func (p *pullRequests) find(ctx context.Context, owner, repo, base, branch string) (*github.PullRequest, error) {
+	prs, _, err := p.pulls.List(ctx, owner, repo, &github.PullRequestListOptions{
+		State: "open",
+		Head:  owner + ":" + branch,
+		Base:  base,
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list pull requests of branch %s", branch)
+	}
+
+	for _, pr := range prs {
+		if pr.GetHead().GetRef() == branch {
+			return pr, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// mergeReady merges the open auto-merged pull requests whose checks passed.
+// Pull requests that are older than the merge timeout are left for review.
+func (p *pullRequests) mergeReady(ctx context.Context, owner, repo, base string) error {
+	return p.eachOpen(ctx, owner, repo, base, func(pr *github.PullRequest) error {
+		if !strings.Contains(pr.GetBody(), autoMergeNotice) || time.Since(pr.GetCreatedAt()) > p.mergeTimeout {
+			return nil
+		}
+
+		p.mergeIfReady(ctx, owner, repo, pr)
+		return nil
+	})
+}
+
+// eachOpen calls fn with every open pull request of a syncd branch
+func (p *pullRequests) eachOpen(ctx context.Context, owner, repo, base string, fn func(*github.PullRequest) error) error {
+	opt := &github.PullRequestListOptions{
+		State:       "open",
+		Base:        base,
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+
+	for {
+		prs, res, err := p.pulls.List(ctx, owner, repo, opt)
+		if err != nil {
+			return errors.Wrap(err, "failed to list pull requests")
+		}
+
+		for _, pr := range prs {
+			if !strings.HasPrefix(pr.GetHead().GetRef(), branchPrefix) {
+				continue
+			}
+
+			if err := fn(pr); err != nil {
+				return err
+			}
+		}
+
+		if res == nil || res.NextPage == 0 {
+			return nil
+		}
+		opt.Page = res.NextPage
+	}
+}
+
+func (p *pullRequests) mergeIfReady(ctx context.Context, owner, repo string, pr *github.PullRequest) {
+	number, sha := pr.GetNumber(), pr.GetHead().GetSHA()
+	logger := log.With(p.logger, "pull_request", number)
+
+	state, err := p.checksState(ctx, owner, repo, sha)
+	if err != nil {
+		logger.Log("event", "automerge.status.error", "error", err)
+		return
+	}
+
+	switch state {
+	case "success":
+		p.merge(ctx, logger, owner, repo, number, sha)
+	case "failure":
+		if !p.failed[number] {
+			p.failed[number] = true
+			logger.Log("event", "automerge.skipped", "state", state)
+		}
+	case "":
+		// the checks may not have been reported yet, so a commit without
+		// any is only merged after the grace period, if unchecked pull
+		// requests are merged at all
+		if p.autoMerge.Unchecked && time.Since(pr.GetCreatedAt()) > p.checksGracePeriod {
+			p.merge(ctx, logger, owner, repo, number, sha)
+		}
+	}
+}
+
+// checksState combines the commit's statuses and check runs into "success",
+// "failure", "pending", or "" when none of them has run. Neutral and skipped
+// check runs don't count as a success on their own.
+func (p *pullRequests) checksState(ctx context.Context, owner, repo, sha string) (string, error) {
+	status, _, err := p.statuses.GetCombinedStatus(ctx, owner, repo, sha, nil)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get combined status")
+	}
+
+	runs, _, err := p.checks.ListCheckRunsForRef(ctx, owner, repo, sha, &github.ListCheckRunsOptions{
+		ListOptions: github.ListOptions{PerPage: 100},
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "failed to list check runs")
+	}
+
+	state := ""
+
+	// the combined status is pending until a status is reported
+	if status.GetTotalCount() > 0 {
+		state = status.GetState()
+		if state == "error" {
+			state = "failure"
+		}
+	}
+
+	for _, run := range runs.CheckRuns {
+		switch {
+		case run.GetStatus() != "completed":
+			if state != "failure" {
+				state = "pending"
+			}
+		case run.GetConclusion() == "success":
+			if state == "" {
+				state = "success"
+			}
+		case run.GetConclusion() == "neutral", run.GetConclusion() == "skipped":
+			// they neither pass nor fail the commit
+		default:
+			state = "failure"
+		}
+	}
+
+	return state, nil
+}
+
+func (p *pullRequests) merge(ctx context.Context, logger log.Logger, owner, repo string, number int, sha string) {
+	method, err := p.mergeMethod(ctx, owner, repo)
+	if err != nil {
+		logger.Log("event", "automerge.error", "error", err)
+		return
+	}
+
+	_, _, err = p.pulls.Merge(ctx, owner, repo, number, "", &github.PullRequestOptions{
+		SHA:         sha,
+		MergeMethod: method,
+	})
+	if err != nil {
+		logger.Log("event", "automerge.error", "error", err)
+		return
+	}
+
+	logger.Log("event", "automerge.merged", "method", method)
+}
+
+// mergeMethod returns the configured merge method, or else the first of
+// squash, merge and rebase that the repository allows. The repository is
+// looked up on every merge, since its settings can change.
+func (p *pullRequests) mergeMethod(ctx context.Context, owner, repo string) (string, error) {
+	if p.autoMerge.Method != "" {
+		return p.autoMerge.Method, nil
+	}
+
+	r, _, err := p.repos.Get(ctx, owner, repo)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to get repository")
+	}
+
+	switch {
+	case r.GetAllowSquashMerge():
+		return "squash", nil
+	case r.GetAllowMergeCommit():
+		return "merge", nil
+	case r.GetAllowRebaseMerge():
+		return "rebase", nil
+	}
+
+	return "", errors.Errorf("%s/%s doesn't allow merging pull requests", owner, repo)
+}
+
+func autoMerge(files []editedFile) bool {
+	for _, f := range files {
+		if annotation(f.manifest, autoMergeAnnotation) != "true" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// branchName names a pull request's branch after its releases and the hash of
+// their edited files, so the same edit always gets the same branch
+func branchName(files []editedFile) string {
+	name := files[0].release.Name
+	if len(files) > 1 {
+		name = fmt.Sprintf("%s-and-%d-more", name, len(files)-1)
+	}
+
+	h := sha1.New()
+	for _, f := range files {
+		fmt.Fprintf(h, "%s\x00%s\x00", f.entry.GetPath(), f.entry.GetContent())
+	}
+
+	return branchPrefix + name + "-" + hex.EncodeToString(h.Sum(nil))[:7]
+}
+
+// pullRequestBody describes the pull request's images and affected releases
+func pullRequestBody(title string, files []editedFile) string {
+	var b strings.Builder
+
+	b.WriteString(title)
+	b.WriteString("\n\n### Releases\n")
+
+	for _, f := range files {
+		fmt.Fprintf(&b, "\n#### `%s`\n\n", f.release.Name)
+		fmt.Fprintf(&b, "* File: `%s`\n", f.entry.GetPath())
+
+		for _, img := range manifestImages(f.manifest) {
+			fmt.Fprintf(&b, "* Image: `%s`\n", img)
+		}
+
+		for _, link := range []struct{ name, annotation string }{
+			{"Code", "helmreleases.shipit.wattpad.com/code"},
+			{"Datadog", "helmreleases.shipit.wattpad.com/datadog"},
+			{"Sumologic", "helmreleases.shipit.wattpad.com/sumologic"},
+		} {
+			if url := annotation(f.manifest, link.annotation); url != "" {
+				fmt.Fprintf(&b, "* %s: %s\n", link.name, url)
+			}
+		}
+	}
+
+	if autoMerge(files) {
+		b.WriteString("\n" + autoMergeNotice + "\n")
+	}
+
+	return b.String()
+}
+
+// manifestImages lists every image in the manifest
+func manifestImages(manifest yaml.MapSlice) []string {
+	var images []string
+
+	isImage := func(item yaml.MapItem) bool {
+		_, err := image.FromYaml(item)
+		return err == nil
+	}
+
+	unstructured.VisitAll(manifest, isImage, func(item *yaml.MapItem) {
+		ref, _ := image.FromYaml(*item)
+		images = append(images, ref.String())
+	})
+
+	return images
+}
+
+// annotation returns the value of a HelmRelease manifest's annotation
+func annotation(manifest yaml.MapSlice, key string) string {
+	for _, item := range lookup(lookup(manifest, "metadata"), "annotations") {
+		if k, ok := item.Key.(string); ok && k == key {
+			return fmt.Sprint(item.Value)
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,469 @@
+package ecr
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"ship-it/internal/image"
+
+	"github.com/go-kit/kit/log"
+	"github.com/google/go-github/v26/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type mockPullRequestService struct {
+	mock.Mock
+}
+
+func (m *mockPullRequestService) Create(ctx context.Context, owner string, repo string, pull *github.NewPullRequest) (*github.PullRequest, *github.Response, error) {
+	args := m.Called(ctx, owner, repo, pull)
+	return args.Get(0).(*github.PullRequest), args.Get(1).(*github.Response), args.Error(2)
+}
+
+func (m *mockPullRequestService) List(ctx context.Context, owner string, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	args := m.Called(owner, repo, opt)
+	return args.Get(0).([]*github.PullRequest), args.Get(1).(*github.Response), args.Error(2)
+}
+
+func (m *mockPullRequestService) Merge(ctx context.Context, owner string, repo string, number int, commitMessage string, options *github.PullRequestOptions) (*github.PullRequestMergeResult, *github.Response, error) {
+	args := m.Called(owner, repo, number, options)
+	return args.Get(0).(*github.PullRequestMergeResult), args.Get(1).(*github.Response), args.Error(2)
+}
+
+func (m *mockPullRequestService) Edit(ctx context.Context, owner string, repo string, number int, pull *github.PullRequest) (*github.PullRequest, *github.Response, error) {
+	args := m.Called(owner, repo, number, pull)
+	return args.Get(0).(*github.PullRequest), args.Get(1).(*github.Response), args.Error(2)
+}
+
+type mockRepositoryService struct {
+	mock.Mock
+}
+
+func (m *mockRepositoryService) Get(ctx context.Context, owner, repo string) (*github.Repository, *github.Response, error) {
+	args := m.Called(owner, repo)
+	return args.Get(0).(*github.Repository), args.Get(1).(*github.Response), args.Error(2)
+}
+
+type mockStatusService struct {
+	mock.Mock
+}
+
+func (m *mockStatusService) GetCombinedStatus(ctx context.Context, owner, repo, ref string, opt *github.ListOptions) (*github.CombinedStatus, *github.Response, error) {
+	args := m.Called(owner, repo, ref)
+	return args.Get(0).(*github.CombinedStatus), args.Get(1).(*github.Response), args.Error(2)
+}
+
+// openPullRequestService has an open pull request for every branch
+type openPullRequestService struct {
+	mockPullRequestService
+}
+
+func (m *openPullRequestService) List(ctx context.Context, owner string, repo string, opt *github.PullRequestListOptions) ([]*github.PullRequest, *github.Response, error) {
+	return []*github.PullRequest{{
+		Number: github.Int(42),
+		Head:   &github.PullRequestBranch{Ref: github.String(strings.TrimPrefix(opt.Head, owner+":"))},
+	}}, &github.Response{}, nil
+}
+
+type mockChecksService struct {
+	mock.Mock
+}
+
+func (m *mockChecksService) ListCheckRunsForRef(ctx context.Context, owner, repo, ref string, opt *github.ListCheckRunsOptions) (*github.ListCheckRunsResults, *github.Response, error) {
+	args := m.Called(owner, repo, ref)
+	return args.Get(0).(*github.ListCheckRunsResults), args.Get(1).(*github.Response), args.Error(2)
+}
+
+func checkRun(status, conclusion string) *github.CheckRun {
+	run := &github.CheckRun{Status: github.String(status)}
+	if conclusion != "" {
+		run.Conclusion = github.String(conclusion)
+	}
+	return run
+}
+
+const reviewedRelease = `kind: HelmRelease
+apiVersion: shipit.wattpad.com/v1beta1
+metadata:
+  name: foo
+  annotations:
+    helmreleases.shipit.wattpad.com/pullrequest: "true"
+    helmreleases.shipit.wattpad.com/automerge: "true"
+    helmreleases.shipit.wattpad.com/code: https://github.com/example/foo
+spec:
+  values:
+    image:
+      repository: test-registry.io/test-repository
+      tag: old-tag
+`
+
+// mockReviewedEdit mocks the git service for an edit of the reviewed release
+func mockReviewedEdit(ctx context.Context, baseSHA string) *mockGitService {
+	mockGit := new(mockGitService)
+	mockGit.On("GetRef", ctx, "org", "repo", "refs/heads/master").Return(
+		&github.Reference{Object: &github.GitObject{SHA: github.String(baseSHA)}}, &github.Response{}, nil,
+	)
+	mockGit.On("GetCommit", ctx, "org", "repo", baseSHA).Return(
+		&github.Commit{SHA: github.String(baseSHA)}, &github.Response{}, nil,
+	)
+	mockGit.On("GetTree", ctx, "org", "repo", baseSHA, true).Return(
+		&github.Tree{
+			SHA: github.String(baseSHA),
+			Entries: []github.TreeEntry{{
+				SHA:  github.String("foo-sha"),
+				Path: github.String("chart/templates/foo.yaml"),
+			}},
+		}, &github.Response{}, nil,
+	)
+	mockGit.On("GetBlob", ctx, "org", "repo", "foo-sha").Return(
+		&github.Blob{Content: github.String(base64.StdEncoding.EncodeToString([]byte(reviewedRelease)))}, &github.Response{}, nil,
+	)
+
+	return mockGit
+}
+
+func TestEditOpensPullRequest(t *testing.T) {
+	ctx := context.Background()
+
+	baseSHA, headSHA := "base-sha", "0123456789abcdef"
+
+	mockGit := mockReviewedEdit(ctx, baseSHA)
+	mockGit.On("CreateTree", ctx, "org", "repo", baseSHA, mock.AnythingOfType("[]github.TreeEntry")).Return(
+		&github.Tree{SHA: github.String("tree-sha")}, &github.Response{}, nil,
+	)
+	mockGit.On("CreateCommit", ctx, "org", "repo", mock.AnythingOfType("*github.Commit")).Return(
+		&github.Commit{SHA: github.String(headSHA)}, &github.Response{}, nil,
+	)
+
+	var branch string
+	mockGit.On("CreateRef", ctx, "org", "repo", mock.MatchedBy(func(ref *github.Reference) bool {
+		branch = strings.TrimPrefix(ref.GetRef(), "refs/heads/")
+		return strings.HasPrefix(branch, "ship-it/foo-") && ref.GetObject().GetSHA() == headSHA
+	})).Return(&github.Reference{}, &github.Response{}, nil)
+
+	pulls := new(mockPullRequestService)
+	pulls.On("List", "org", "repo", mock.MatchedBy(func(opt *github.PullRequestListOptions) bool {
+		return strings.HasPrefix(opt.Head, "org:ship-it/foo-") && opt.Base == "master" && opt.State == "open"
+	})).Return([]*github.PullRequest{}, &github.Response{}, nil)
+
+	// the open pull request of an older push of the image is superseded,
+	// while the ones of other releases and other branches are kept
+	superseded := &github.PullRequest{
+		Number: github.Int(41),
+		Body:   github.String("Updated 1 helm charts\n\n* File: `chart/templates/foo.yaml`\n"),
+		Head:   &github.PullRequestBranch{Ref: github.String("ship-it/foo-1234567")},
+	}
+	pulls.On("List", "org", "repo", mock.MatchedBy(func(opt *github.PullRequestListOptions) bool {
+		return opt.Head == "" && opt.Base == "master" && opt.State == "open"
+	})).Return([]*github.PullRequest{
+		superseded,
+		{
+			Number: github.Int(40),
+			Body:   github.String("* File: `chart/templates/foo.yaml`\n* File: `chart/templates/bar.yaml`\n"),
+			Head:   &github.PullRequestBranch{Ref: github.String("ship-it/foo-and-1-more-1234567")},
+		},
+		{
+			Number: github.Int(39),
+			Body:   github.String("* File: `chart/templates/foo.yaml`\n"),
+			Head:   &github.PullRequestBranch{Ref: github.String("feature")},
+		},
+		{Number: github.Int(42), Head: &github.PullRequestBranch{Ref: github.String("ship-it/foo-new")}},
+	}, &github.Response{}, nil)
+	pulls.On("Edit", "org", "repo", 41, &github.PullRequest{State: github.String("closed")}).Return(
+		superseded, &github.Response{}, nil,
+	)
+	pulls.On("Create", ctx, "org", "repo", mock.MatchedBy(func(pr *github.NewPullRequest) bool {
+		return pr.GetHead() == branch &&
+			pr.GetBase() == "master" &&
+			pr.GetTitle() == "Updated 1 helm charts using image test-registry.io/test-repository:new-tag"
+	})).Return(&github.PullRequest{Number: github.Int(42)}, &github.Response{}, nil)
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "chart", WithPullRequests(log.NewNopLogger(), pulls, nil, nil, nil, AutoMerge{}))
+
+	desired := &image.Ref{
+		Registry:   "test-registry.io",
+		Repository: "test-repository",
+		Tag:        "new-tag",
+	}
+
+	require.NoError(t, editor.Edit(ctx, []types.NamespacedName{{Name: "foo"}}, desired))
+
+	pulls.AssertExpectations(t)
+	pulls.AssertNumberOfCalls(t, "Edit", 1)
+
+	// the release branch isn't updated directly, and the pull request is
+	// merged by MergePullRequests, not while editing
+	mockGit.AssertNotCalled(t, "UpdateRef", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	pulls.AssertNotCalled(t, "Merge", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEditReusesOpenPullRequest(t *testing.T) {
+	ctx := context.Background()
+
+	mockGit := mockReviewedEdit(ctx, "base-sha")
+
+	// a redelivered push makes the same edit, whose branch already has an
+	// open pull request
+	pulls := new(openPullRequestService)
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "chart", WithPullRequests(log.NewNopLogger(), pulls, nil, nil, nil, AutoMerge{}))
+
+	require.NoError(t, editor.Edit(ctx, []types.NamespacedName{{Name: "foo"}}, &image.Ref{
+		Registry:   "test-registry.io",
+		Repository: "test-repository",
+		Tag:        "new-tag",
+	}))
+
+	mockGit.AssertNotCalled(t, "CreateCommit", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	mockGit.AssertNotCalled(t, "CreateRef", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	pulls.AssertNotCalled(t, "Create", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestEditRequiresPullRequests(t *testing.T) {
+	ctx := context.Background()
+
+	mockGit := new(mockGitService)
+	mockGit.On("GetRef", ctx, "org", "repo", "refs/heads/master").Return(
+		&github.Reference{Object: &github.GitObject{SHA: github.String("base-sha")}}, &github.Response{}, nil,
+	)
+	mockGit.On("GetCommit", ctx, "org", "repo", "base-sha").Return(
+		&github.Commit{SHA: github.String("base-sha")}, &github.Response{}, nil,
+	)
+	mockGit.On("GetTree", ctx, "org", "repo", "base-sha", true).Return(
+		&github.Tree{Entries: []github.TreeEntry{{
+			SHA:  github.String("foo-sha"),
+			Path: github.String("chart/templates/foo.yaml"),
+		}}}, &github.Response{}, nil,
+	)
+	mockGit.On("GetBlob", ctx, "org", "repo", "foo-sha").Return(
+		&github.Blob{Content: github.String(base64.StdEncoding.EncodeToString([]byte(reviewedRelease)))}, &github.Response{}, nil,
+	)
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "chart")
+
+	err := editor.Edit(ctx, []types.NamespacedName{{Name: "foo"}}, &image.Ref{
+		Registry:   "test-registry.io",
+		Repository: "test-repository",
+		Tag:        "new-tag",
+	})
+	assert.EqualError(t, err, "pull requests are required, but haven't been configured")
+}
+
+func TestMergeReady(t *testing.T) {
+	now := time.Now()
+
+	pr := func(number int, ref string, created time.Time, autoMerge bool) *github.PullRequest {
+		body := "Updated 1 helm charts"
+		if autoMerge {
+			body += "\n\n" + autoMergeNotice + "\n"
+		}
+
+		return &github.PullRequest{
+			Number:    github.Int(number),
+			Body:      github.String(body),
+			CreatedAt: &created,
+			Head:      &github.PullRequestBranch{Ref: github.String(ref), SHA: github.String(fmt.Sprintf("sha-%d", number))},
+		}
+	}
+
+	pulls := new(mockPullRequestService)
+	pulls.On("List", "org", "repo", mock.MatchedBy(func(opt *github.PullRequestListOptions) bool {
+		return opt.Base == "master" && opt.State == "open"
+	})).Return([]*github.PullRequest{
+		pr(1, "ship-it/passed", now, true),
+		pr(2, "ship-it/pending", now, true),
+		pr(3, "ship-it/failed", now, true),
+		pr(4, "ship-it/unchecked-new", now, true),
+		pr(5, "ship-it/unchecked-old", now.Add(-10*time.Minute), true),
+		pr(6, "ship-it/reviewed", now, false),
+		pr(7, "feature", now, true),
+		pr(8, "ship-it/expired", now.Add(-2*time.Hour), true),
+	}, &github.Response{}, nil)
+	pulls.On("Merge", "org", "repo", 1, &github.PullRequestOptions{SHA: "sha-1", MergeMethod: "rebase"}).Return(
+		&github.PullRequestMergeResult{}, &github.Response{}, nil,
+	)
+
+	noStatuses := &github.CombinedStatus{State: github.String("pending"), TotalCount: github.Int(0)}
+
+	statuses := new(mockStatusService)
+	statuses.On("GetCombinedStatus", "org", "repo", "sha-1").Return(
+		&github.CombinedStatus{State: github.String("success"), TotalCount: github.Int(1)}, &github.Response{}, nil,
+	)
+	for _, sha := range []string{"sha-2", "sha-3", "sha-4", "sha-5"} {
+		statuses.On("GetCombinedStatus", "org", "repo", sha).Return(noStatuses, &github.Response{}, nil)
+	}
+
+	checks := new(mockChecksService)
+	checks.On("ListCheckRunsForRef", "org", "repo", "sha-1").Return(
+		&github.ListCheckRunsResults{CheckRuns: []*github.CheckRun{checkRun("completed", "success")}}, &github.Response{}, nil,
+	)
+	checks.On("ListCheckRunsForRef", "org", "repo", "sha-2").Return(
+		&github.ListCheckRunsResults{CheckRuns: []*github.CheckRun{checkRun("in_progress", "")}}, &github.Response{}, nil,
+	)
+	checks.On("ListCheckRunsForRef", "org", "repo", "sha-3").Return(
+		&github.ListCheckRunsResults{CheckRuns: []*github.CheckRun{checkRun("completed", "failure")}}, &github.Response{}, nil,
+	)
+	for _, sha := range []string{"sha-4", "sha-5"} {
+		checks.On("ListCheckRunsForRef", "org", "repo", sha).Return(&github.ListCheckRunsResults{}, &github.Response{}, nil)
+	}
+
+	repos := new(mockRepositoryService)
+	repos.On("Get", "org", "repo").Return(
+		&github.Repository{AllowSquashMerge: github.Bool(false), AllowMergeCommit: github.Bool(false), AllowRebaseMerge: github.Bool(true)}, &github.Response{}, nil,
+	)
+
+	editor := NewChartEditor(new(mockGitService), "org", "repo", "master", "chart", WithPullRequests(log.NewNopLogger(), pulls, statuses, checks, repos, AutoMerge{}))
+
+	require.NoError(t, editor.pullRequests.mergeReady(context.Background(), "org", "repo", "master"))
+
+	// the old pull request without any checks isn't merged, since unchecked
+	// pull requests aren't
+	pulls.AssertExpectations(t)
+	pulls.AssertNumberOfCalls(t, "Merge", 1)
+}
+
+func TestMergeReadyUnchecked(t *testing.T) {
+	created := time.Now().Add(-10 * time.Minute)
+
+	pulls := new(mockPullRequestService)
+	pulls.On("List", "org", "repo", mock.Anything).Return([]*github.PullRequest{{
+		Number:    github.Int(5),
+		Body:      github.String(autoMergeNotice),
+		CreatedAt: &created,
+		Head:      &github.PullRequestBranch{Ref: github.String("ship-it/unchecked-old"), SHA: github.String("sha-5")},
+	}}, &github.Response{}, nil)
+	pulls.On("Merge", "org", "repo", 5, &github.PullRequestOptions{SHA: "sha-5", MergeMethod: "merge"}).Return(
+		&github.PullRequestMergeResult{}, &github.Response{}, nil,
+	)
+
+	statuses := new(mockStatusService)
+	statuses.On("GetCombinedStatus", "org", "repo", "sha-5").Return(
+		&github.CombinedStatus{State: github.String("pending"), TotalCount: github.Int(0)}, &github.Response{}, nil,
+	)
+
+	checks := new(mockChecksService)
+	checks.On("ListCheckRunsForRef", "org", "repo", "sha-5").Return(&github.ListCheckRunsResults{}, &github.Response{}, nil)
+
+	// the configured method is used without looking up the repository
+	repos := new(mockRepositoryService)
+
+	editor := NewChartEditor(new(mockGitService), "org", "repo", "master", "chart",
+		WithPullRequests(log.NewNopLogger(), pulls, statuses, checks, repos, AutoMerge{Method: "merge", Unchecked: true}),
+	)
+
+	require.NoError(t, editor.pullRequests.mergeReady(context.Background(), "org", "repo", "master"))
+
+	pulls.AssertExpectations(t)
+	repos.AssertNotCalled(t, "Get", mock.Anything, mock.Anything)
+}
+
+func TestMergeMethod(t *testing.T) {
+	tests := []struct {
+		name     string
+		repo     *github.Repository
+		expected string
+	}{
+		{"squash", &github.Repository{AllowSquashMerge: github.Bool(true), AllowMergeCommit: github.Bool(true)}, "squash"},
+		{"merge", &github.Repository{AllowMergeCommit: github.Bool(true), AllowRebaseMerge: github.Bool(true)}, "merge"},
+		{"rebase", &github.Repository{AllowRebaseMerge: github.Bool(true)}, "rebase"},
+		{"none", &github.Repository{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repos := new(mockRepositoryService)
+			repos.On("Get", "org", "repo").Return(tt.repo, &github.Response{}, nil)
+
+			p := &pullRequests{repos: repos}
+
+			method, err := p.mergeMethod(context.Background(), "org", "repo")
+			if tt.expected == "" {
+				assert.EqualError(t, err, "org/repo doesn't allow merging pull requests")
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, method)
+		})
+	}
+}
+
+func TestChecksState(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   *github.CombinedStatus
+		runs     []*github.CheckRun
+		expected string
+	}{
+		{"no checks", &github.CombinedStatus{State: github.String("pending"), TotalCount: github.Int(0)}, nil, ""},
+		{"statuses passed", &github.CombinedStatus{State: github.String("success"), TotalCount: github.Int(2)}, nil, "success"},
+		{"status errored", &github.CombinedStatus{State: github.String("error"), TotalCount: github.Int(1)}, nil, "failure"},
+		{"check runs passed", &github.CombinedStatus{TotalCount: github.Int(0)}, []*github.CheckRun{checkRun("completed", "success"), checkRun("completed", "skipped")}, "success"},
+		{"check runs skipped", &github.CombinedStatus{TotalCount: github.Int(0)}, []*github.CheckRun{checkRun("completed", "skipped"), checkRun("completed", "neutral")}, ""},
+		{"check run queued", &github.CombinedStatus{State: github.String("success"), TotalCount: github.Int(1)}, []*github.CheckRun{checkRun("queued", "")}, "pending"},
+		{"check run failed", &github.CombinedStatus{State: github.String("success"), TotalCount: github.Int(1)}, []*github.CheckRun{checkRun("completed", "timed_out"), checkRun("queued", "")}, "failure"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			statuses := new(mockStatusService)
+			statuses.On("GetCombinedStatus", "org", "repo", "sha").Return(tt.status, &github.Response{}, nil)
+
+			checks := new(mockChecksService)
+			checks.On("ListCheckRunsForRef", "org", "repo", "sha").Return(&github.ListCheckRunsResults{CheckRuns: tt.runs}, &github.Response{}, nil)
+
+			p := &pullRequests{statuses: statuses, checks: checks}
+
+			state, err := p.checksState(context.Background(), "org", "repo", "sha")
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, state)
+		})
+	}
+}
+
+func TestPullRequestBody(t *testing.T) {
+	var manifest yaml.MapSlice
+	require.NoError(t, yaml.Unmarshal([]byte(reviewedRelease), &manifest))
+
+	files := []editedFile{{
+		entry:    github.TreeEntry{Path: github.String("chart/templates/foo.yaml")},
+		release:  types.NamespacedName{Name: "foo"},
+		manifest: manifest,
+	}}
+
+	expected := "Updated 1 helm charts\n\n" +
+		"### Releases\n\n" +
+		"#### `foo`\n\n" +
+		"* File: `chart/templates/foo.yaml`\n" +
+		"* Image: `test-registry.io/test-repository:old-tag`\n" +
+		"* Code: https://github.com/example/foo\n" +
+		"\n" + autoMergeNotice + "\n"
+
+	assert.Equal(t, expected, pullRequestBody("Updated 1 helm charts", files))
+}
+
+func TestBranchName(t *testing.T) {
+	file := func(name, content string) editedFile {
+		return editedFile{
+			entry:   github.TreeEntry{Path: github.String("chart/templates/" + name + ".yaml"), Content: github.String(content)},
+			release: types.NamespacedName{Name: name},
+		}
+	}
+
+	files := []editedFile{file("foo", "tag: one"), file("bar", "tag: one")}
+
+	assert.Regexp(t, "^ship-it/foo-[0-9a-f]{7}$", branchName(files[:1]))
+	assert.Regexp(t, "^ship-it/foo-and-1-more-[0-9a-f]{7}$", branchName(files))
+
+	// the same edit gets the same branch, and a different one doesn't
+	assert.Equal(t, branchName(files[:1]), branchName([]editedFile{file("foo", "tag: one")}))
+	assert.NotEqual(t, branchName(files[:1]), branchName([]editedFile{file("foo", "tag: two")}))
+}
@@ -2,22 +2,39 @@ package ecr
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"ship-it/internal/image"
+	"ship-it/internal/syncd"
 
+	"github.com/pkg/errors"
 	"k8s.io/apimachinery/pkg/types"
 )
 
 // ChartEditor edits a remote chart containing HelmReleases. It updates the
-// named release specs using the given image reference.
+// named release specs using the given image references.
 type ChartEditor interface {
-	Edit(ctx context.Context, releases []types.NamespacedName, image *image.Ref) error
+	Edit(ctx context.Context, releases []types.NamespacedName, images ...*image.Ref) error
+}
+
+// PromotionEditor edits a remote chart containing HelmReleases. It promotes
+// a release spec to its upstream's chart version and images.
+type PromotionEditor interface {
+	Promote(ctx context.Context, p *syncd.Promotion) error
 }
 
 // ReleaseIndexer provides access to an index of container images and the
 // deployed releases that are using them.
 type ReleaseIndexer interface {
 	Lookup(image *image.Ref) ([]types.NamespacedName, error)
+
+	// TagPolicy returns the release's image tag policy, and its current
+	// tag of the image's repository. Pinned releases have an
+	// image.PinnedPolicy.
+	TagPolicy(release types.NamespacedName, image *image.Ref) (image.TagPolicy, string, error)
 }
 
 type ImageReconciler struct {
@@ -33,14 +50,312 @@ func NewReconciler(e ChartEditor, i ReleaseIndexer) *ImageReconciler {
 }
 
 func (r *ImageReconciler) Reconcile(ctx context.Context, image *image.Ref) error {
-	releases, err := r.indexer.Lookup(image)
+	return reconcileImages(ctx, r.editor, r.indexer, image)
+}
+
+// BatchReconciler collects the images pushed within a short window, and
+// commits them to the remote chart together. Reconcile blocks until the
+// image's batch has been committed, so an image is only acknowledged once
+// its change is in the chart. Batches are committed with the context the
+// reconciler was created with, rather than the one of the image that opened
+// them, so they're abandoned when the listeners stop.
+type BatchReconciler struct {
+	ctx     context.Context
+	editor  ChartEditor
+	indexer ReleaseIndexer
+	window  time.Duration
+
+	mu    sync.Mutex
+	batch *batch
+}
+
+type batch struct {
+	images []*image.Ref
+	done   chan struct{}
+	err    error
+}
+
+func NewBatchReconciler(ctx context.Context, e ChartEditor, i ReleaseIndexer, window time.Duration) *BatchReconciler {
+	return &BatchReconciler{
+		ctx:     ctx,
+		editor:  e,
+		indexer: i,
+		window:  window,
+	}
+}
+
+func (r *BatchReconciler) Reconcile(ctx context.Context, image *image.Ref) error {
+	b := r.add(image)
+
+	select {
+	case <-b.done:
+		return b.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// add adds the image to the open batch. The first image of a batch starts
+// the window, after which the batch is flushed.
+func (r *BatchReconciler) add(image *image.Ref) *batch {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.batch == nil {
+		b := &batch{done: make(chan struct{})}
+		r.batch = b
+		time.AfterFunc(r.window, func() { r.flush(b) })
+	}
+
+	r.batch.images = append(r.batch.images, image)
+	return r.batch
+}
+
+func (r *BatchReconciler) flush(b *batch) {
+	r.mu.Lock()
+	if r.batch == b {
+		r.batch = nil
+	}
+	r.mu.Unlock()
+
+	if err := r.ctx.Err(); err != nil {
+		b.err = err
+	} else {
+		b.err = reconcileImages(r.ctx, r.editor, r.indexer, b.images...)
+	}
+	close(b.done)
+}
+
+// imageEdit is a set of images to commit to a set of releases. used holds
+// the repositories of the releases, including the ones whose pushed tags
+// weren't allowed.
+type imageEdit struct {
+	releases []types.NamespacedName
+	images   []*image.Ref
+	used     map[string]bool
+}
+
+// reconcileImages commits the pushed images to the releases whose tag
+// policies allow them. Releases are edited together unless their policies
+// allow different tags of a repository they share, so a batch is usually a
+// single commit.
+func reconcileImages(ctx context.Context, editor ChartEditor, indexer ReleaseIndexer, images ...*image.Ref) error {
+	p, err := planEdits(indexer, images)
+
+	var errs []error
 	if err != nil {
-		return err
+		errs = append(errs, err)
+	}
+
+	for _, e := range p.edits {
+		if err := editor.Edit(ctx, e.releases, e.images...); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) == 0 && len(p.edits) == 0 && p.found {
+		if len(p.pinned) > 0 {
+			return errors.Wrapf(syncd.ErrNoRegisteredReleasesAffected, "no tag policies allow new images %s (%s)", imageList(images), strings.Join(p.pinned, ", "))
+		}
+		return errors.Wrapf(syncd.ErrNoRegisteredReleasesAffected, "no tag policies allow new images %s", imageList(images))
+	}
+
+	return combineErrors(errs)
+}
+
+// plan is the edits of a reconciliation. found reports whether any releases
+// use the images, and pinned describes the ones that are held at their
+// current images.
+type plan struct {
+	edits  []imageEdit
+	found  bool
+	pinned []string
+}
+
+// planEdits looks up the releases that use the images and evaluates their tag
+// policies. plan.edits sets each release to the latest pushed image of each
+// repository that its policy allows, grouping releases that agree on the
+// tags, found reports whether any release uses the images, and pinned lists
+// the pinned releases, which aren't edited. The error combines the lookups
+// and policies that failed, whose releases are left out of the plan.
+func planEdits(indexer ReleaseIndexer, images []*image.Ref) (plan, error) {
+	var (
+		order    []types.NamespacedName
+		releases = make(map[types.NamespacedName]*imageEdit)
+		pinned   = make(map[types.NamespacedName]bool)
+		p        plan
+		errs     []error
+	)
+
+	for _, img := range images {
+		names, err := indexer.Lookup(img)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		for _, name := range names {
+			policy, current, err := indexer.TagPolicy(name, img)
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+
+			r, ok := releases[name]
+			if !ok {
+				r = &imageEdit{
+					releases: []types.NamespacedName{name},
+					used:     make(map[string]bool),
+				}
+				releases[name] = r
+				order = append(order, name)
+			}
+
+			r.used[img.URI()] = true
+
+			if pin, ok := policy.(image.PinnedPolicy); ok {
+				if !pinned[name] {
+					pinned[name] = true
+					p.pinned = append(p.pinned, fmt.Sprintf("%s is %s", name.Name, pin.Reason))
+				}
+				continue
+			}
+
+			i := indexOf(r.images, img)
+			if i >= 0 {
+				// a batch's pushes can be out of order, so an
+				// older push doesn't replace a newer one
+				if img.PushedAt.Before(r.images[i].PushedAt) {
+					continue
+				}
+				current = r.images[i].Tag
+			}
+
+			if !policy.Allows(img.Tag, current) {
+				continue
+			}
+
+			if i >= 0 {
+				r.images[i] = img
+			} else {
+				r.images = append(r.images, img)
+			}
+		}
+	}
+
+	for _, name := range order {
+		r := releases[name]
+		if len(r.images) == 0 {
+			continue
+		}
+
+		merged := false
+		for i := range p.edits {
+			if p.edits[i].merge(r) {
+				merged = true
+				break
+			}
+		}
+
+		if !merged {
+			p.edits = append(p.edits, *r)
+		}
+	}
+
+	p.found = len(order) > 0
+
+	return p, combineErrors(errs)
+}
+
+// merge adds the release edit r to the edit, unless they would disagree on
+// the tag of a repository they both use.
+func (e *imageEdit) merge(r *imageEdit) bool {
+	for uri := range r.used {
+		if e.used[uri] && tagOf(e.images, uri) != tagOf(r.images, uri) {
+			return false
+		}
+	}
+
+	for _, img := range r.images {
+		if indexOf(e.images, img) < 0 {
+			e.images = append(e.images, img)
+		}
+	}
+
+	for uri := range r.used {
+		e.used[uri] = true
+	}
+
+	e.releases = append(e.releases, r.releases...)
+	return true
+}
+
+// tagOf returns the tag of the repository's image, if there is one
+func tagOf(images []*image.Ref, uri string) string {
+	for _, img := range images {
+		if img.URI() == uri {
+			return img.Tag
+		}
+	}
+	return ""
+}
+
+// indexOf returns the index of the image with the same repository as img
+func indexOf(images []*image.Ref, img *image.Ref) int {
+	for i, other := range images {
+		if other.Matches(*img) {
+			return i
+		}
+	}
+	return -1
+}
+
+// combineErrors combines the errors of a reconciliation. When some of its
+// edits failed, the edits that had no changes aren't reported.
+func combineErrors(errs []error) error {
+	if len(errs) <= 1 {
+		if len(errs) == 0 {
+			return nil
+		}
+		return errs[0]
+	}
+
+	var msgs []string
+	for _, err := range errs {
+		if errors.Cause(err) != syncd.ErrNoRegisteredReleasesAffected {
+			msgs = append(msgs, err.Error())
+		}
+	}
+
+	if len(msgs) == 0 {
+		return errs[0]
+	}
+
+	return fmt.Errorf("failed to reconcile images: %s", strings.Join(msgs, "; "))
+}
+
+// PromotionReconciler commits pending release promotions to the remote chart.
+type PromotionReconciler struct {
+	editor PromotionEditor
+}
+
+func NewPromotionReconciler(e PromotionEditor) *PromotionReconciler {
+	return &PromotionReconciler{
+		editor: e,
+	}
+}
+
+func (r *PromotionReconciler) Reconcile(ctx context.Context, p *syncd.Promotion) error {
+	if p.ChartVersion == "" && len(p.Images) == 0 {
+		return nil
 	}
 
-	if len(releases) == 0 {
+	// a promotion that doesn't change the release's file has already been
+	// committed, e.g. before syncd restarted
+	err := r.editor.Promote(ctx, p)
+	if errors.Cause(err) == syncd.ErrNoRegisteredReleasesAffected {
 		return nil
 	}
 
-	return r.editor.Edit(ctx, releases, image)
+	return err
 }
@@ -3,10 +3,14 @@ package ecr
 import (
 	"context"
 	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"ship-it/internal/image"
+	"ship-it/internal/syncd"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"k8s.io/apimachinery/pkg/types"
@@ -16,8 +20,8 @@ type MockReleaseEditor struct {
 	mock.Mock
 }
 
-func (m *MockReleaseEditor) Edit(ctx context.Context, releases []types.NamespacedName, image *image.Ref) error {
-	args := m.Called(ctx, releases, image)
+func (m *MockReleaseEditor) Edit(ctx context.Context, releases []types.NamespacedName, images ...*image.Ref) error {
+	args := m.Called(ctx, releases, images)
 	return args.Error(0)
 }
 
@@ -30,6 +34,20 @@ func (m *MockReleaseIndexer) Lookup(image *image.Ref) ([]types.NamespacedName, e
 	return args.Get(0).([]types.NamespacedName), args.Error(1)
 }
 
+func (m *MockReleaseIndexer) TagPolicy(release types.NamespacedName, ref *image.Ref) (image.TagPolicy, string, error) {
+	args := m.Called(release, ref)
+	policy, _ := args.Get(0).(image.TagPolicy)
+	return policy, args.String(1), args.Error(2)
+}
+
+func mustParseTagPolicy(t *testing.T, policy string) image.TagPolicy {
+	p, err := image.ParseTagPolicy(policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
 func TestReconcileLookupFailure(t *testing.T) {
 	mockEditor := new(MockReleaseEditor)
 	mockIndexer := new(MockReleaseIndexer)
@@ -68,8 +86,9 @@ func TestReconcilerUpdateFailure(t *testing.T) {
 	}
 
 	mockIndexer.On("Lookup", inputImage).Return(releaseNames, error(nil))
+	mockIndexer.On("TagPolicy", mock.Anything, inputImage).Return(image.DefaultTagPolicy, "", nil)
 
-	mockEditor.On("Edit", mock.Anything, releaseNames, inputImage).Return(fmt.Errorf("some update image error"))
+	mockEditor.On("Edit", mock.Anything, releaseNames, []*image.Ref{inputImage}).Return(fmt.Errorf("some update image error"))
 
 	err := reconciler.Reconcile(context.Background(), inputImage)
 
@@ -98,8 +117,9 @@ func TestReconcilerSuccess(t *testing.T) {
 	}
 
 	mockIndexer.On("Lookup", inputImage).Return(releaseNames, error(nil))
+	mockIndexer.On("TagPolicy", mock.Anything, inputImage).Return(image.DefaultTagPolicy, "", nil)
 
-	mockEditor.On("Edit", mock.Anything, releaseNames, inputImage).Return(nil)
+	mockEditor.On("Edit", mock.Anything, releaseNames, []*image.Ref{inputImage}).Return(nil)
 
 	err := reconciler.Reconcile(context.Background(), inputImage)
 
@@ -107,3 +127,294 @@ func TestReconcilerSuccess(t *testing.T) {
 	mockIndexer.AssertExpectations(t)
 	mockEditor.AssertExpectations(t)
 }
+
+func TestBatchReconciler(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+	mockIndexer := new(MockReleaseIndexer)
+
+	reconciler := NewBatchReconciler(context.Background(), mockEditor, mockIndexer, 50*time.Millisecond)
+
+	registry := "723255503624.dkr.ecr.us-east-1.amazonaws.com"
+	foo := &image.Ref{Registry: registry, Repository: "foo", Tag: "one"}
+	newerFoo := &image.Ref{Registry: registry, Repository: "foo", Tag: "two"}
+	bar := &image.Ref{Registry: registry, Repository: "bar", Tag: "three"}
+
+	fooRelease := types.NamespacedName{Namespace: "default", Name: "foo"}
+	barRelease := types.NamespacedName{Namespace: "default", Name: "bar"}
+
+	mockIndexer.On("Lookup", foo).Return([]types.NamespacedName{fooRelease}, error(nil))
+	mockIndexer.On("Lookup", newerFoo).Return([]types.NamespacedName{fooRelease}, error(nil))
+	mockIndexer.On("Lookup", bar).Return([]types.NamespacedName{fooRelease, barRelease}, error(nil))
+	mockIndexer.On("TagPolicy", mock.Anything, mock.Anything).Return(mustParseTagPolicy(t, "newest"), "zero", nil)
+
+	mockEditor.On("Edit", mock.Anything, []types.NamespacedName{fooRelease, barRelease}, []*image.Ref{newerFoo, bar}).Return(nil).Once()
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+
+	for i, img := range []*image.Ref{foo, bar, newerFoo} {
+		wg.Add(1)
+		go func(i int, img *image.Ref) {
+			defer wg.Done()
+			errs[i] = reconciler.Reconcile(context.Background(), img)
+		}(i, img)
+
+		// keep the push order stable
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	wg.Wait()
+
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+
+	mockIndexer.AssertExpectations(t)
+	mockEditor.AssertExpectations(t)
+}
+
+func TestReconcilerTagPolicy(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+	mockIndexer := new(MockReleaseIndexer)
+
+	reconciler := NewReconciler(mockEditor, mockIndexer)
+
+	inputImage := &image.Ref{
+		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
+		Repository: "bar",
+		Tag:        "1.3.0",
+	}
+
+	semverRelease := types.NamespacedName{Namespace: "default", Name: "semver"}
+	shaRelease := types.NamespacedName{Namespace: "default", Name: "sha"}
+
+	mockIndexer.On("Lookup", inputImage).Return([]types.NamespacedName{semverRelease, shaRelease}, error(nil))
+	mockIndexer.On("TagPolicy", semverRelease, inputImage).Return(mustParseTagPolicy(t, "semver"), "1.2.0", nil)
+	mockIndexer.On("TagPolicy", shaRelease, inputImage).Return(image.DefaultTagPolicy, "78bc9ccf64eb838c6a0e0492ded722274925e2bd", nil)
+
+	mockEditor.On("Edit", mock.Anything, []types.NamespacedName{semverRelease}, []*image.Ref{inputImage}).Return(nil)
+
+	assert.NoError(t, reconciler.Reconcile(context.Background(), inputImage))
+	mockEditor.AssertExpectations(t)
+
+	// a tag that no release allows isn't committed
+	downgrade := &image.Ref{Registry: inputImage.Registry, Repository: "bar", Tag: "1.1.0"}
+	mockIndexer.On("Lookup", downgrade).Return([]types.NamespacedName{semverRelease}, error(nil))
+	mockIndexer.On("TagPolicy", semverRelease, downgrade).Return(mustParseTagPolicy(t, "semver"), "1.2.0", nil)
+
+	err := reconciler.Reconcile(context.Background(), downgrade)
+	assert.Equal(t, syncd.ErrNoRegisteredReleasesAffected, errors.Cause(err))
+	mockEditor.AssertNumberOfCalls(t, "Edit", 1)
+}
+
+func TestReconcilerPinnedRelease(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+	mockIndexer := new(MockReleaseIndexer)
+
+	reconciler := NewReconciler(mockEditor, mockIndexer)
+
+	inputImage := &image.Ref{
+		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
+		Repository: "bar",
+		Tag:        "78bc9ccf64eb838c6a0e0492ded722274925e2bd",
+	}
+
+	pinned := types.NamespacedName{Namespace: "default", Name: "pinned"}
+	unpinned := types.NamespacedName{Namespace: "default", Name: "unpinned"}
+
+	mockIndexer.On("Lookup", inputImage).Return([]types.NamespacedName{pinned, unpinned}, error(nil))
+	mockIndexer.On("TagPolicy", pinned, inputImage).Return(image.PinnedPolicy{Reason: "pinned by jane"}, "old", nil)
+	mockIndexer.On("TagPolicy", unpinned, inputImage).Return(image.DefaultTagPolicy, "old", nil)
+
+	// only the unpinned release is updated
+	mockEditor.On("Edit", mock.Anything, []types.NamespacedName{unpinned}, []*image.Ref{inputImage}).Return(nil)
+
+	assert.NoError(t, reconciler.Reconcile(context.Background(), inputImage))
+	mockEditor.AssertExpectations(t)
+
+	// a push that only pinned releases use isn't committed, and says why
+	other := &image.Ref{Registry: inputImage.Registry, Repository: "baz", Tag: inputImage.Tag}
+	mockIndexer.On("Lookup", other).Return([]types.NamespacedName{pinned}, error(nil))
+	mockIndexer.On("TagPolicy", pinned, other).Return(image.PinnedPolicy{Reason: "pinned by jane"}, "old", nil)
+
+	err := reconciler.Reconcile(context.Background(), other)
+	assert.Equal(t, syncd.ErrNoRegisteredReleasesAffected, errors.Cause(err))
+	assert.Contains(t, err.Error(), "pinned is pinned by jane")
+	mockEditor.AssertNumberOfCalls(t, "Edit", 1)
+}
+
+func TestReconcilerInvalidTagPolicy(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+	mockIndexer := new(MockReleaseIndexer)
+
+	reconciler := NewReconciler(mockEditor, mockIndexer)
+
+	inputImage := &image.Ref{
+		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
+		Repository: "bar",
+		Tag:        "78bc9ccf64eb838c6a0e0492ded722274925e2bd",
+	}
+
+	valid := types.NamespacedName{Namespace: "default", Name: "valid"}
+	invalid := types.NamespacedName{Namespace: "default", Name: "invalid"}
+
+	mockIndexer.On("Lookup", inputImage).Return([]types.NamespacedName{invalid, valid}, error(nil))
+	mockIndexer.On("TagPolicy", invalid, inputImage).Return(nil, "", fmt.Errorf("unknown tag policy"))
+	mockIndexer.On("TagPolicy", valid, inputImage).Return(image.DefaultTagPolicy, "", nil)
+
+	// the other releases are still updated
+	mockEditor.On("Edit", mock.Anything, []types.NamespacedName{valid}, []*image.Ref{inputImage}).Return(nil)
+
+	assert.EqualError(t, reconciler.Reconcile(context.Background(), inputImage), "unknown tag policy")
+	mockEditor.AssertExpectations(t)
+}
+
+func TestBatchReconcilerTagPolicies(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+	mockIndexer := new(MockReleaseIndexer)
+
+	reconciler := NewBatchReconciler(context.Background(), mockEditor, mockIndexer, time.Hour)
+
+	registry := "723255503624.dkr.ecr.us-east-1.amazonaws.com"
+	patch := &image.Ref{Registry: registry, Repository: "foo", Tag: "1.2.1"}
+	major := &image.Ref{Registry: registry, Repository: "foo", Tag: "2.0.0"}
+
+	stable := types.NamespacedName{Namespace: "default", Name: "stable"}
+	edge := types.NamespacedName{Namespace: "default", Name: "edge"}
+
+	for _, img := range []*image.Ref{patch, major} {
+		mockIndexer.On("Lookup", img).Return([]types.NamespacedName{stable, edge}, error(nil))
+	}
+	mockIndexer.On("TagPolicy", stable, mock.Anything).Return(mustParseTagPolicy(t, "semver:~1.2"), "1.2.0", nil)
+	mockIndexer.On("TagPolicy", edge, mock.Anything).Return(mustParseTagPolicy(t, "newest"), "1.2.0", nil)
+
+	// the releases disagree on the repository's tag, so they're edited
+	// separately
+	mockEditor.On("Edit", mock.Anything, []types.NamespacedName{stable}, []*image.Ref{patch}).Return(nil).Once()
+	mockEditor.On("Edit", mock.Anything, []types.NamespacedName{edge}, []*image.Ref{major}).Return(nil).Once()
+
+	reconciler.add(patch)
+	b := reconciler.add(major)
+	reconciler.flush(b)
+
+	assert.NoError(t, b.err)
+	mockEditor.AssertExpectations(t)
+}
+
+func TestBatchReconcilerPushOrder(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+	mockIndexer := new(MockReleaseIndexer)
+
+	reconciler := NewBatchReconciler(context.Background(), mockEditor, mockIndexer, time.Hour)
+
+	pushedAt := time.Date(2019, time.July, 11, 14, 19, 59, 0, time.UTC)
+
+	registry := "723255503624.dkr.ecr.us-east-1.amazonaws.com"
+	newer := &image.Ref{Registry: registry, Repository: "foo", Tag: "newer", PushedAt: pushedAt}
+	older := &image.Ref{Registry: registry, Repository: "foo", Tag: "older", PushedAt: pushedAt.Add(-time.Minute)}
+
+	release := types.NamespacedName{Namespace: "default", Name: "edge"}
+
+	for _, img := range []*image.Ref{newer, older} {
+		mockIndexer.On("Lookup", img).Return([]types.NamespacedName{release}, error(nil))
+	}
+	mockIndexer.On("TagPolicy", release, mock.Anything).Return(mustParseTagPolicy(t, "newest"), "current", nil)
+
+	// the older push was received last, but doesn't replace the newer one
+	mockEditor.On("Edit", mock.Anything, []types.NamespacedName{release}, []*image.Ref{newer}).Return(nil).Once()
+
+	reconciler.add(newer)
+	b := reconciler.add(older)
+	reconciler.flush(b)
+
+	assert.NoError(t, b.err)
+	mockEditor.AssertExpectations(t)
+}
+
+func TestBatchReconcilerEditFailure(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+	mockIndexer := new(MockReleaseIndexer)
+
+	reconciler := NewBatchReconciler(context.Background(), mockEditor, mockIndexer, time.Millisecond)
+
+	inputImage := &image.Ref{
+		Registry:   "723255503624.dkr.ecr.us-east-1.amazonaws.com",
+		Repository: "bar",
+		Tag:        "78bc9ccf64eb838c6a0e0492ded722274925e2bd",
+	}
+	releaseNames := []types.NamespacedName{{Namespace: "default", Name: "bar"}}
+
+	mockIndexer.On("Lookup", inputImage).Return(releaseNames, error(nil))
+	mockIndexer.On("TagPolicy", mock.Anything, inputImage).Return(image.DefaultTagPolicy, "", nil)
+	mockEditor.On("Edit", mock.Anything, releaseNames, []*image.Ref{inputImage}).Return(fmt.Errorf("some update image error"))
+
+	assert.Error(t, reconciler.Reconcile(context.Background(), inputImage))
+
+	// the next image starts a new batch
+	assert.Error(t, reconciler.Reconcile(context.Background(), inputImage))
+	mockEditor.AssertNumberOfCalls(t, "Edit", 2)
+}
+
+func TestBatchReconcilerCancelled(t *testing.T) {
+	reconciler := NewBatchReconciler(context.Background(), new(MockReleaseEditor), new(MockReleaseIndexer), time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := reconciler.Reconcile(ctx, &image.Ref{Registry: "foo", Repository: "bar", Tag: "baz"})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestBatchReconcilerStopped(t *testing.T) {
+	mockEditor := new(MockReleaseEditor)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	reconciler := NewBatchReconciler(ctx, mockEditor, new(MockReleaseIndexer), time.Hour)
+
+	b := reconciler.add(&image.Ref{Registry: "foo", Repository: "bar", Tag: "baz"})
+	cancel()
+	reconciler.flush(b)
+
+	// the listeners stopped before the batch was flushed, so it's abandoned
+	assert.Equal(t, context.Canceled, b.err)
+	mockEditor.AssertNotCalled(t, "Edit", mock.Anything, mock.Anything, mock.Anything)
+}
+
+type MockPromotionEditor struct {
+	mock.Mock
+}
+
+func (m *MockPromotionEditor) Promote(ctx context.Context, p *syncd.Promotion) error {
+	args := m.Called(ctx, p)
+	return args.Error(0)
+}
+
+func TestPromotionReconciler(t *testing.T) {
+	mockEditor := new(MockPromotionEditor)
+	reconciler := NewPromotionReconciler(mockEditor)
+
+	promotion := &syncd.Promotion{
+		Release: types.NamespacedName{
+			Namespace: "default",
+			Name:      "production",
+		},
+		Upstream:     "staging",
+		ChartVersion: "1.1.0",
+	}
+
+	// the file of an already committed promotion is unchanged
+	committed := &syncd.Promotion{
+		Release:      promotion.Release,
+		Upstream:     "staging",
+		ChartVersion: "1.0.0",
+	}
+
+	mockEditor.On("Promote", mock.Anything, promotion).Return(nil)
+	mockEditor.On("Promote", mock.Anything, committed).Return(errors.Wrap(syncd.ErrNoRegisteredReleasesAffected, "no registry chart changes"))
+
+	assert.NoError(t, reconciler.Reconcile(context.Background(), promotion))
+	assert.NoError(t, reconciler.Reconcile(context.Background(), &syncd.Promotion{Release: promotion.Release}))
+	assert.NoError(t, reconciler.Reconcile(context.Background(), committed))
+
+	mockEditor.AssertNumberOfCalls(t, "Promote", 2)
+}
@@ -0,0 +1,105 @@
+package ecr
+
+import (
+	"context"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/google/go-github/v26/github"
+	"github.com/pkg/errors"
+)
+
+// retries bounds how often the editor re-applies an edit after the targeted
+// branch moves
+type retries struct {
+	max       int
+	base      time.Duration
+	conflicts metrics.Counter
+}
+
+func defaultRetries() retries {
+	return retries{
+		max:       5,
+		base:      200 * time.Millisecond,
+		conflicts: discard.NewCounter(),
+	}
+}
+
+// WithConflictRetries re-applies an edit up to max times when the targeted
+// branch moves before the edit is committed. Retries back off exponentially
+// from the base delay, with jitter. Every conflict is counted, labeled with
+// whether it was "retried" or the retries were "exhausted".
+func WithConflictRetries(max int, base time.Duration, conflicts metrics.Counter) ChartEditorOption {
+	return func(c *chartEditor) {
+		c.retries = retries{
+			max:       max,
+			base:      base,
+			conflicts: conflicts,
+		}
+	}
+}
+
+// do calls try until it succeeds, or fails with an error other than a
+// conflict with the branch moving, or the retries are exhausted
+func (r retries) do(ctx context.Context, branch string, try func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := try()
+		if !isNonFastForward(errors.Cause(err)) {
+			return err
+		}
+
+		if attempt > r.max {
+			r.conflicts.With("outcome", "exhausted").Add(1)
+			return errors.Wrapf(err, "branch %s kept moving after %d attempts", branch, attempt)
+		}
+
+		r.conflicts.With("outcome", "retried").Add(1)
+
+		if err := sleep(ctx, r.backoff(attempt)); err != nil {
+			return err
+		}
+	}
+}
+
+// backoff is the delay before the attempt's retry. It's a random duration
+// between the exponential delay and twice that.
+func (r retries) backoff(attempt int) time.Duration {
+	d := r.base << uint(attempt-1)
+	if d <= 0 {
+		return 0
+	}
+
+	return d + time.Duration(rand.Int63n(int64(d)))
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// isNonFastForward checks if a reference update failed because the
+// reference no longer points to the new commit's parent
+func isNonFastForward(err error) bool {
+	if err == errNonFastForward {
+		return true
+	}
+
+	resp, ok := err.(*github.ErrorResponse)
+	if !ok || resp.Response == nil {
+		return false
+	}
+
+	return resp.Response.StatusCode == http.StatusUnprocessableEntity &&
+		strings.Contains(strings.ToLower(resp.Message), "fast forward")
+}
@@ -0,0 +1,167 @@
+package ecr
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"ship-it/internal/image"
+
+	"github.com/go-kit/kit/metrics"
+	"github.com/google/go-github/v26/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func nonFastForward() error {
+	req, _ := http.NewRequest(http.MethodPatch, "https://api.github.com/repos/org/repo/git/refs/heads/master", nil)
+
+	return &github.ErrorResponse{
+		Response: &http.Response{StatusCode: http.StatusUnprocessableEntity, Request: req},
+		Message:  "Update is not a fast forward",
+	}
+}
+
+// labeledCounter counts the additions to each of its label values
+type labeledCounter struct {
+	counts map[string]float64
+	labels []string
+}
+
+func newLabeledCounter() *labeledCounter {
+	return &labeledCounter{counts: make(map[string]float64)}
+}
+
+func (c *labeledCounter) With(labelValues ...string) metrics.Counter {
+	return &labeledCounter{counts: c.counts, labels: labelValues}
+}
+
+func (c *labeledCounter) Add(delta float64) {
+	c.counts[strings.Join(c.labels, ",")] += delta
+}
+
+// mockMovingBranch mocks a branch whose head moves from each of the SHAs to
+// the next, as each of the edits is committed
+func mockMovingBranch(ctx context.Context, shas []string, updateErrs []error) *mockGitService {
+	mockGit := new(mockGitService)
+
+	release := base64.StdEncoding.EncodeToString([]byte(`kind: HelmRelease
+metadata:
+  name: foo
+spec:
+  values:
+    image:
+      repository: test-registry.io/test-repository
+      tag: old-tag
+`))
+
+	for i, sha := range shas {
+		mockGit.On("GetRef", ctx, "org", "repo", "refs/heads/master").Return(
+			&github.Reference{Object: &github.GitObject{SHA: github.String(sha)}}, &github.Response{}, nil,
+		).Once()
+
+		mockGit.On("GetCommit", ctx, "org", "repo", sha).Return(
+			&github.Commit{SHA: github.String(sha)}, &github.Response{}, nil,
+		)
+		mockGit.On("GetTree", ctx, "org", "repo", sha, true).Return(
+			&github.Tree{Entries: []github.TreeEntry{{
+				SHA:  github.String("foo-sha"),
+				Path: github.String("chart/templates/foo.yaml"),
+			}}}, &github.Response{}, nil,
+		)
+		mockGit.On("CreateTree", ctx, "org", "repo", sha, mock.AnythingOfType("[]github.TreeEntry")).Return(
+			&github.Tree{SHA: github.String("tree-" + sha)}, &github.Response{}, nil,
+		)
+
+		mockGit.On("UpdateRef", ctx, "org", "repo", mock.Anything, false).Return(
+			&github.Reference{}, &github.Response{}, updateErrs[i],
+		).Once()
+	}
+
+	mockGit.On("GetBlob", ctx, "org", "repo", "foo-sha").Return(
+		&github.Blob{Content: github.String(release)}, &github.Response{}, nil,
+	)
+	mockGit.On("CreateCommit", ctx, "org", "repo", mock.AnythingOfType("*github.Commit")).Return(
+		&github.Commit{SHA: github.String("head-sha")}, &github.Response{}, nil,
+	)
+
+	return mockGit
+}
+
+var testImage = &image.Ref{
+	Registry:   "test-registry.io",
+	Repository: "test-repository",
+	Tag:        "new-tag",
+}
+
+func TestEditRetriesConflicts(t *testing.T) {
+	ctx := context.Background()
+
+	mockGit := mockMovingBranch(ctx, []string{"sha1", "sha2"}, []error{nonFastForward(), nil})
+	conflicts := newLabeledCounter()
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "chart", WithConflictRetries(3, time.Millisecond, conflicts))
+
+	assert.NoError(t, editor.Edit(ctx, []types.NamespacedName{{Name: "foo"}}, testImage))
+
+	// the edit is re-applied to the branch's new head
+	mockGit.AssertCalled(t, "CreateTree", ctx, "org", "repo", "sha2", mock.AnythingOfType("[]github.TreeEntry"))
+	mockGit.AssertNumberOfCalls(t, "UpdateRef", 2)
+	assert.Equal(t, map[string]float64{"outcome,retried": 1}, conflicts.counts)
+}
+
+func TestEditExhaustsRetries(t *testing.T) {
+	ctx := context.Background()
+
+	mockGit := mockMovingBranch(ctx, []string{"sha1", "sha2", "sha3"}, []error{nonFastForward(), nonFastForward(), nonFastForward()})
+	conflicts := newLabeledCounter()
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "chart", WithConflictRetries(2, time.Millisecond, conflicts))
+
+	err := editor.Edit(ctx, []types.NamespacedName{{Name: "foo"}}, testImage)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "branch master kept moving after 3 attempts")
+
+	mockGit.AssertNumberOfCalls(t, "UpdateRef", 3)
+	assert.Equal(t, map[string]float64{"outcome,retried": 2, "outcome,exhausted": 1}, conflicts.counts)
+}
+
+func TestEditDoesNotRetryOtherErrors(t *testing.T) {
+	ctx := context.Background()
+
+	mockGit := mockMovingBranch(ctx, []string{"sha1"}, []error{errors.New("boom")})
+
+	editor := NewChartEditor(mockGit, "org", "repo", "master", "chart", WithConflictRetries(3, time.Millisecond, newLabeledCounter()))
+
+	assert.Error(t, editor.Edit(ctx, []types.NamespacedName{{Name: "foo"}}, testImage))
+	mockGit.AssertNumberOfCalls(t, "UpdateRef", 1)
+}
+
+func TestBackoff(t *testing.T) {
+	r := retries{base: 100 * time.Millisecond}
+
+	for attempt, min := range map[int]time.Duration{
+		1: 100 * time.Millisecond,
+		2: 200 * time.Millisecond,
+		3: 400 * time.Millisecond,
+	} {
+		d := r.backoff(attempt)
+		assert.True(t, d >= min && d < 2*min, "attempt %d backed off %s", attempt, d)
+	}
+
+	assert.Zero(t, retries{}.backoff(1))
+}
+
+func TestIsNonFastForward(t *testing.T) {
+	assert.True(t, isNonFastForward(nonFastForward()))
+	assert.False(t, isNonFastForward(errors.New("Update is not a fast forward")))
+	assert.False(t, isNonFastForward(&github.ErrorResponse{
+		Response: &http.Response{StatusCode: http.StatusNotFound, Request: &http.Request{}},
+		Message:  "Not Found",
+	}))
+}
@@ -0,0 +1,222 @@
+package ecr
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v26/github"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/ssh"
+)
+
+// CommitSigner signs the commits of the chart editors, so they're accepted by
+// branches that require signed commits
+type CommitSigner interface {
+	// Sign returns the armored detached signature of a commit object's
+	// payload
+	Sign(payload []byte) (string, error)
+}
+
+// NewCommitSigner returns a signer for an armored GPG private key, or an
+// OpenSSH or PEM private key. The passphrase decrypts the key, if it's
+// encrypted.
+func NewCommitSigner(key []byte, passphrase string) (CommitSigner, error) {
+	if bytes.Contains(key, []byte("BEGIN PGP PRIVATE KEY BLOCK")) {
+		return newGPGSigner(key, passphrase)
+	}
+
+	if bytes.Contains(key, []byte("PRIVATE KEY-----")) {
+		return newSSHSigner(key, passphrase)
+	}
+
+	return nil, errors.New("commit signing key is neither a GPG nor an SSH private key")
+}
+
+type gpgSigner struct {
+	entity *openpgp.Entity
+}
+
+func newGPGSigner(key []byte, passphrase string) (*gpgSigner, error) {
+	entities, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(key))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read GPG key")
+	}
+
+	if len(entities) != 1 {
+		return nil, errors.Errorf("expected 1 GPG key, got %d", len(entities))
+	}
+
+	entity := entities[0]
+	if entity.PrivateKey == nil {
+		return nil, errors.New("GPG key has no private key")
+	}
+
+	// the key's signing subkey may be used instead of its primary key
+	keys := []*openpgp.Key{{PrivateKey: entity.PrivateKey}}
+	for i := range entity.Subkeys {
+		keys = append(keys, &openpgp.Key{PrivateKey: entity.Subkeys[i].PrivateKey})
+	}
+
+	for _, k := range keys {
+		if k.PrivateKey == nil || !k.PrivateKey.Encrypted {
+			continue
+		}
+
+		if err := k.PrivateKey.Decrypt([]byte(passphrase)); err != nil {
+			return nil, errors.Wrap(err, "failed to decrypt GPG key")
+		}
+	}
+
+	return &gpgSigner{entity: entity}, nil
+}
+
+func (s *gpgSigner) Sign(payload []byte) (string, error) {
+	var signature bytes.Buffer
+	if err := openpgp.ArmoredDetachSign(&signature, s.entity, bytes.NewReader(payload), nil); err != nil {
+		return "", errors.Wrap(err, "failed to sign commit")
+	}
+
+	return signature.String() + "\n", nil
+}
+
+// sshSignatureNamespace is the namespace of git's SSH signatures, which keeps
+// them from being valid for other purposes
+const sshSignatureNamespace = "git"
+
+type sshSigner struct {
+	signer ssh.Signer
+}
+
+func newSSHSigner(key []byte, passphrase string) (*sshSigner, error) {
+	var signer ssh.Signer
+	var err error
+
+	if passphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read SSH key")
+	}
+
+	return &sshSigner{signer: signer}, nil
+}
+
+// Sign returns the payload's signature in the SSHSIG format of ssh-keygen -Y
+// sign, which is what git uses for SSH signatures
+func (s *sshSigner) Sign(payload []byte) (string, error) {
+	hash := sha512.Sum512(payload)
+
+	signed := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Hash          []byte
+	}{sshSignatureNamespace, "", "sha512", hash[:]})...)
+
+	var signature *ssh.Signature
+	var err error
+
+	// RSA keys must not use SHA-1 signatures
+	if algorithmSigner, ok := s.signer.(ssh.AlgorithmSigner); ok && s.signer.PublicKey().Type() == ssh.KeyAlgoRSA {
+		signature, err = algorithmSigner.SignWithAlgorithm(rand.Reader, signed, ssh.SigAlgoRSASHA2512)
+	} else {
+		signature, err = s.signer.Sign(rand.Reader, signed)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to sign commit")
+	}
+
+	blob := append([]byte("SSHSIG"), ssh.Marshal(struct {
+		Version       uint32
+		PublicKey     []byte
+		Namespace     string
+		Reserved      string
+		HashAlgorithm string
+		Signature     []byte
+	}{1, s.signer.PublicKey().Marshal(), sshSignatureNamespace, "", "sha512", ssh.Marshal(signature)})...)
+
+	return armorSSHSignature(blob), nil
+}
+
+// armorSSHSignature encodes the signature like ssh-keygen, in lines of 70
+// characters
+func armorSSHSignature(blob []byte) string {
+	encoded := base64.StdEncoding.EncodeToString(blob)
+
+	var b strings.Builder
+	b.WriteString("-----BEGIN SSH SIGNATURE-----\n")
+	for len(encoded) > 70 {
+		b.WriteString(encoded[:70] + "\n")
+		encoded = encoded[70:]
+	}
+	b.WriteString(encoded + "\n-----END SSH SIGNATURE-----\n")
+
+	return b.String()
+}
+
+// signaturePayload formats the commit object that GitHub creates for a
+// commit, which is what its signature has to sign
+func signaturePayload(commit *github.Commit) []byte {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "tree %s\n", commit.GetTree().GetSHA())
+	for _, parent := range commit.Parents {
+		fmt.Fprintf(&b, "parent %s\n", parent.GetSHA())
+	}
+
+	committer := commit.Committer
+	if committer == nil {
+		committer = commit.Author
+	}
+
+	fmt.Fprintf(&b, "author %s\n", identity(commit.Author))
+	fmt.Fprintf(&b, "committer %s\n", identity(committer))
+	fmt.Fprintf(&b, "\n%s", commit.GetMessage())
+
+	return []byte(b.String())
+}
+
+func identity(a *github.CommitAuthor) string {
+	date := a.GetDate()
+	return fmt.Sprintf("%s <%s> %d %s", a.GetName(), a.GetEmail(), date.Unix(), date.Format("-0700"))
+}
+
+// signedCommit adds the signature to a git commit object, as the gpgsig
+// header that follows its committer
+func signedCommit(object []byte, signature string) []byte {
+	end := bytes.Index(object, []byte("\n\n"))
+	if end < 0 {
+		end = len(object)
+	}
+
+	lines := strings.Split(strings.TrimRight(signature, "\n"), "\n")
+	header := "\ngpgsig " + strings.Join(lines, "\n ")
+
+	signed := append([]byte{}, object[:end]...)
+	signed = append(signed, header...)
+	return append(signed, object[end:]...)
+}
+
+// commitSHA returns the SHA of a git commit object, which is how git
+// identifies it
+func commitSHA(object []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "commit %d\x00", len(object))
+	h.Write(object)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// commitTime is the time of a commit, which git records in seconds
+func commitTime() time.Time {
+	return time.Now().Truncate(time.Second)
+}
@@ -0,0 +1,681 @@
+package ecr
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"ship-it/internal/image"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// document is a HelmRelease file that's edited in place. Edits are made to
+// the file's node tree, and written back by replacing the text of the edited
+// scalars, so the rest of the file, like its comments, quoting, indentation
+// and anchors, is left untouched.
+//
+// Edits that can't be written back in place, e.g. removing a key from a flow
+// mapping, fall back to encoding the whole tree. Comments, key order and
+// anchors are kept, but the file's formatting is normalized.
+type document struct {
+	content []byte
+	root    yaml.Node
+
+	// lines holds the offset of the start of each line of the content
+	lines []int
+
+	// original holds the original values of the edited scalars
+	original map[*yaml.Node]string
+
+	inserted []insertion
+	removed  []span
+
+	// reformat is set by edits that can't be written back in place
+	reformat bool
+}
+
+// insertion is a key added to a mapping
+type insertion struct {
+	mapping, key *yaml.Node
+}
+
+// span is a range of the document's content
+type span struct {
+	start, end int
+	text       string
+}
+
+func parseDocument(content []byte) (*document, error) {
+	d := &document{
+		content:  content,
+		lines:    []int{0},
+		original: make(map[*yaml.Node]string),
+	}
+
+	if err := yaml.Unmarshal(content, &d.root); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal values YAML")
+	}
+
+	for i, b := range content {
+		if b == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+
+	return d, nil
+}
+
+// set sets the value of a scalar
+func (d *document) set(n *yaml.Node, value string) {
+	if n.Value == value && n.ShortTag() == "!!str" {
+		return
+	}
+
+	if _, ok := d.original[n]; !ok {
+		d.original[n] = n.Value
+	}
+
+	n.Value = value
+	n.Tag = "!!str"
+}
+
+// insert adds a key with a scalar value to the mapping, after the pair at the
+// given index
+func (d *document) insert(mapping *yaml.Node, after int, key, value string) {
+	d.insertNode(mapping, after, key, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value})
+}
+
+// insertNode adds a key with a scalar value, or a mapping of scalars, to the
+// mapping, after the pair at the given index
+func (d *document) insertNode(mapping *yaml.Node, after int, key string, v *yaml.Node) {
+	k := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}
+
+	i := 2*after + 2
+
+	content := append([]*yaml.Node{}, mapping.Content[:i]...)
+	content = append(content, k, v)
+	mapping.Content = append(content, mapping.Content[i:]...)
+
+	d.inserted = append(d.inserted, insertion{mapping, k})
+}
+
+// remove removes the pair at the given index from the mapping
+func (d *document) remove(mapping *yaml.Node, pair int) {
+	key, value := mapping.Content[2*pair], mapping.Content[2*pair+1]
+
+	original, ok := d.original[value]
+	if !ok {
+		original = value.Value
+	}
+
+	mapping.Content = append(mapping.Content[:2*pair], mapping.Content[2*pair+2:]...)
+	delete(d.original, value)
+
+	// inserted keys aren't in the content
+	if key.Line == 0 {
+		return
+	}
+
+	s, ok := d.pairLine(mapping, key, value, original)
+	if !ok {
+		d.reformat = true
+		return
+	}
+
+	d.removed = append(d.removed, s)
+}
+
+// Bytes returns the edited document
+func (d *document) Bytes() ([]byte, error) {
+	spans, ok := d.spans()
+	if !ok || d.reformat {
+		return d.encode()
+	}
+
+	sort.Slice(spans, func(i, j int) bool {
+		return spans[i].start < spans[j].start
+	})
+
+	var b bytes.Buffer
+	last := 0
+
+	for _, s := range spans {
+		// overlapping edits can't be written back in place
+		if s.start < last {
+			return d.encode()
+		}
+
+		b.Write(d.content[last:s.start])
+		b.WriteString(s.text)
+		last = s.end
+	}
+
+	b.Write(d.content[last:])
+
+	return b.Bytes(), nil
+}
+
+func (d *document) encode() ([]byte, error) {
+	var b bytes.Buffer
+
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+
+	if err := enc.Encode(&d.root); err != nil {
+		return nil, errors.Wrap(err, "failed to marshal values YAML")
+	}
+
+	return b.Bytes(), enc.Close()
+}
+
+// spans returns the replacements of the edited document's content. It
+// reports whether every edit can be written back in place.
+func (d *document) spans() ([]span, bool) {
+	spans := append([]span{}, d.removed...)
+
+	for n, original := range d.original {
+		// inserted values are written with their keys
+		if n.Line == 0 {
+			continue
+		}
+
+		start, end, ok := d.scalar(n, original)
+		if !ok {
+			return nil, false
+		}
+
+		spans = append(spans, span{start, end, scalarText(n)})
+	}
+
+	for _, ins := range d.inserted {
+		s, ok := d.insertion(ins)
+		if !ok {
+			return nil, false
+		}
+
+		if s != nil {
+			spans = append(spans, *s)
+		}
+	}
+
+	return spans, true
+}
+
+// insertion returns the text of the inserted key and value, which follows the
+// previous pair of the mapping. It returns nil if the key has been removed.
+func (d *document) insertion(ins insertion) (*span, bool) {
+	i := nodeIndex(ins.mapping.Content, ins.key)
+	if i < 0 {
+		return nil, true
+	}
+
+	// the previous pair must be a scalar that's in the content
+	if i < 2 || ins.mapping.Content[i-2].Line == 0 {
+		return nil, false
+	}
+
+	prevKey, prevValue := ins.mapping.Content[i-2], ins.mapping.Content[i-1]
+	if prevValue.Kind != yaml.ScalarNode {
+		return nil, false
+	}
+
+	original, ok := d.original[prevValue]
+	if !ok {
+		original = prevValue.Value
+	}
+
+	_, end, ok := d.scalar(prevValue, original)
+	if !ok {
+		return nil, false
+	}
+
+	flow := ins.mapping.Style&yaml.FlowStyle != 0
+
+	// the pair goes on its own line, at the previous key's indentation
+	indent := strings.Repeat(" ", prevKey.Column-1)
+
+	pair, ok := pairText(ins.key, ins.mapping.Content[i+1], indent, flow)
+	if !ok {
+		return nil, false
+	}
+
+	if flow {
+		return &span{end, end, ", " + pair}, true
+	}
+
+	eol := d.lineEnd(end)
+
+	return &span{eol, eol, "\n" + indent + pair}, true
+}
+
+// pairText formats an inserted pair, whose value is a scalar or a mapping of
+// scalars. The pairs of a block mapping are indented under the key.
+func pairText(key, value *yaml.Node, indent string, flow bool) (string, bool) {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		return key.Value + ": " + scalarText(value), true
+
+	case yaml.MappingNode:
+		var pairs []string
+		for i := 0; i+1 < len(value.Content); i += 2 {
+			if value.Content[i+1].Kind != yaml.ScalarNode {
+				return "", false
+			}
+			pairs = append(pairs, scalarText(value.Content[i])+": "+scalarText(value.Content[i+1]))
+		}
+
+		if flow {
+			return key.Value + ": {" + strings.Join(pairs, ", ") + "}", true
+		}
+
+		text := key.Value + ":"
+		for _, pair := range pairs {
+			text += "\n" + indent + "  " + pair
+		}
+		return text, true
+	}
+
+	return "", false
+}
+
+// pairLine returns the line of a block mapping's pair, including its line
+// break. The line can only be removed if it holds nothing but the pair.
+func (d *document) pairLine(mapping, key, value *yaml.Node, original string) (span, bool) {
+	if mapping.Style&yaml.FlowStyle != 0 || value.Kind != yaml.ScalarNode || key.Line != value.Line {
+		return span{}, false
+	}
+
+	start := d.lines[key.Line-1]
+
+	keyStart, ok := d.offset(key.Line, key.Column)
+	if !ok || strings.TrimSpace(string(d.content[start:keyStart])) != "" {
+		return span{}, false
+	}
+
+	_, end, ok := d.scalar(value, original)
+	if !ok {
+		return span{}, false
+	}
+
+	eol := d.lineEnd(end)
+
+	rest := strings.TrimSpace(string(d.content[end:eol]))
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return span{}, false
+	}
+
+	// include the line break, if there is one
+	for _, b := range []byte("\r\n") {
+		if eol < len(d.content) && d.content[eol] == b {
+			eol++
+		}
+	}
+
+	return span{start: start, end: eol}, true
+}
+
+// scalar returns the range of a single line scalar in the content, given the
+// scalar's original value.
+func (d *document) scalar(n *yaml.Node, original string) (int, int, bool) {
+	start, ok := d.offset(n.Line, n.Column)
+	if !ok {
+		return 0, 0, false
+	}
+
+	text := d.content[start:d.lineEnd(start)]
+
+	switch {
+	case n.Style&yaml.DoubleQuotedStyle != 0:
+		for i := 1; i < len(text); i++ {
+			switch text[i] {
+			case '\\':
+				i++
+			case '"':
+				return start, start + i + 1, true
+			}
+		}
+
+	case n.Style&yaml.SingleQuotedStyle != 0:
+		for i := 1; i < len(text); i++ {
+			if text[i] != '\'' {
+				continue
+			}
+
+			if i+1 < len(text) && text[i+1] == '\'' {
+				i++
+				continue
+			}
+
+			return start, start + i + 1, true
+		}
+
+	case n.Style&(yaml.LiteralStyle|yaml.FoldedStyle|yaml.TaggedStyle) == 0:
+		// plain scalars are written as they're parsed, unless they're
+		// preceded by an anchor or tag, or span lines
+		if bytes.HasPrefix(text, []byte(original)) {
+			return start, start + len(original), true
+		}
+	}
+
+	return 0, 0, false
+}
+
+// offset converts a line and column, which counts characters, to an offset
+// in the content
+func (d *document) offset(line, column int) (int, bool) {
+	if line < 1 || line > len(d.lines) {
+		return 0, false
+	}
+
+	i := d.lines[line-1]
+	for c := 1; c < column; c++ {
+		if i >= len(d.content) {
+			return 0, false
+		}
+
+		_, size := utf8.DecodeRune(d.content[i:])
+		i += size
+	}
+
+	return i, true
+}
+
+// lineEnd returns the offset of the end of the line, excluding its line break
+func (d *document) lineEnd(offset int) int {
+	i := bytes.IndexByte(d.content[offset:], '\n')
+	if i < 0 {
+		return len(d.content)
+	}
+
+	end := offset + i
+	if end > offset && d.content[end-1] == '\r' {
+		end--
+	}
+
+	return end
+}
+
+// scalarText formats a scalar in its style. Plain scalars that wouldn't be
+// read back as the same string, e.g. "1.10", are double quoted.
+func scalarText(n *yaml.Node) string {
+	switch {
+	case n.Style&yaml.DoubleQuotedStyle != 0:
+		return strconv.Quote(n.Value)
+
+	case n.Style&yaml.SingleQuotedStyle != 0:
+		return "'" + strings.Replace(n.Value, "'", "''", -1) + "'"
+	}
+
+	var v interface{}
+	if err := yaml.Unmarshal([]byte(n.Value), &v); err != nil || v != n.Value {
+		return strconv.Quote(n.Value)
+	}
+
+	return n.Value
+}
+
+func nodeIndex(nodes []*yaml.Node, n *yaml.Node) int {
+	for i := range nodes {
+		if nodes[i] == n {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// field returns the index of the key's pair in the mapping, or -1
+func field(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i / 2
+		}
+	}
+
+	return -1
+}
+
+// visitImages calls visit with every image in the node, and the mapping and
+// index of its pair. Images are either blocks with "repository" and "tag"
+// keys, or strings. Aliases aren't followed, since the nodes they refer to are
+// visited where they're defined.
+func visitImages(n *yaml.Node, visit func(mapping *yaml.Node, pair int, ref *image.Ref)) {
+	switch n.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range n.Content {
+			visitImages(child, visit)
+		}
+
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+
+			if key.Value == "image" && value.Kind != yaml.AliasNode {
+				var x interface{}
+				if err := value.Decode(&x); err == nil {
+					if ref, err := image.FromValue(x); err == nil {
+						visit(n, i/2, ref)
+						continue
+					}
+				}
+			}
+
+			visitImages(value, visit)
+		}
+	}
+}
+
+// editYaml sets the tag and digest of every image in the values that uses the
+// desired image's repository, whether the image is a block or a string, and
+// wherever it's nested, e.g. in a list of sidecars.
+func editYaml(doc *document, desired *image.Ref) {
+	visitImages(&doc.root, func(mapping *yaml.Node, pair int, ref *image.Ref) {
+		if !desired.Matches(*ref) {
+			return
+		}
+
+		value := mapping.Content[2*pair+1]
+
+		// images set as strings keep their name as it's written, e.g.
+		// "redis:5" isn't expanded to "docker.io/library/redis:6"
+		if value.Kind == yaml.ScalarNode {
+			name, _, _ := image.Split(value.Value)
+
+			s := name + ":" + desired.Tag
+			if desired.Digest != "" {
+				s += "@" + desired.Digest
+			}
+
+			doc.set(value, s)
+			return
+		}
+
+		tag := field(value, "tag")
+		if tag < 0 {
+			return
+		}
+
+		doc.set(value.Content[2*tag+1], desired.Tag)
+
+		// a digest that isn't known is removed, since it would pin the
+		// image to the previous one
+		digest := field(value, "digest")
+
+		switch {
+		case desired.Digest != "" && digest >= 0:
+			doc.set(value.Content[2*digest+1], desired.Digest)
+		case desired.Digest != "":
+			doc.insert(value, tag, "digest", desired.Digest)
+		case digest >= 0:
+			doc.remove(value, digest)
+		}
+	})
+}
+
+// editChartVersion sets the chart version of a HelmRelease manifest
+func editChartVersion(doc *document, version string) {
+	if len(doc.root.Content) == 0 {
+		return
+	}
+
+	n := doc.root.Content[0]
+
+	for _, key := range []string{"spec", "chart", "version"} {
+		if n.Kind != yaml.MappingNode {
+			return
+		}
+
+		i := field(n, key)
+		if i < 0 {
+			return
+		}
+
+		n = n.Content[2*i+1]
+	}
+
+	if n.Kind == yaml.ScalarNode {
+		doc.set(n, version)
+	}
+}
+
+// pushedAtAnnotation records when the images applied to a HelmRelease were
+// pushed, as a JSON object of image repositories and push times
+const pushedAtAnnotation = "helmreleases.shipit.wattpad.com/pushed-at"
+
+// editPushedImage edits the document's images like editYaml, unless the
+// images of the repository applied to the HelmRelease were pushed after the
+// image, e.g. when push events are delivered out of order. The image's push
+// time is recorded when it changes the document.
+func editPushedImage(doc *document, img *image.Ref) {
+	if img.PushedAt.IsZero() {
+		editYaml(doc, img)
+		return
+	}
+
+	pushed := doc.pushedAt()
+	uri := img.URI()
+
+	if last, ok := pushed[uri]; ok && img.PushedAt.Before(last) {
+		return
+	}
+
+	edits := doc.edits()
+	if editYaml(doc, img); doc.edits() == edits {
+		return
+	}
+
+	pushed[uri] = img.PushedAt.UTC()
+
+	if value, err := json.Marshal(pushed); err == nil {
+		doc.setAnnotation(pushedAtAnnotation, string(value))
+	}
+}
+
+// edits counts the document's edits
+func (d *document) edits() int {
+	return len(d.original) + len(d.inserted) + len(d.removed)
+}
+
+// pushedAt returns the push times recorded in the HelmRelease's annotation.
+// An annotation that can't be parsed is ignored.
+func (d *document) pushedAt() map[string]time.Time {
+	pushed := make(map[string]time.Time)
+
+	annotations := d.mapping("metadata", "annotations")
+	if annotations == nil {
+		return pushed
+	}
+
+	if i := field(annotations, pushedAtAnnotation); i >= 0 {
+		if err := json.Unmarshal([]byte(annotations.Content[2*i+1].Value), &pushed); err != nil {
+			return make(map[string]time.Time)
+		}
+	}
+
+	return pushed
+}
+
+// setAnnotation sets an annotation of the HelmRelease, adding its annotations
+// if it has none
+func (d *document) setAnnotation(key, value string) {
+	metadata := d.mapping("metadata")
+	if metadata == nil {
+		return
+	}
+
+	annotations := d.mapping("metadata", "annotations")
+	if annotations == nil {
+		if field(metadata, "annotations") >= 0 {
+			return
+		}
+
+		mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		mapping.Content = []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			annotationValue(value),
+		}
+
+		d.insertNode(metadata, lastScalarPair(metadata), "annotations", mapping)
+		return
+	}
+
+	if i := field(annotations, key); i >= 0 {
+		d.set(annotations.Content[2*i+1], value)
+		return
+	}
+
+	d.insertNode(annotations, lastScalarPair(annotations), key, annotationValue(value))
+}
+
+// annotationValue is an inserted annotation's value. It's single quoted, so
+// that JSON values don't need escaping.
+func annotationValue(value string) *yaml.Node {
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: value, Style: yaml.SingleQuotedStyle}
+}
+
+// mapping returns the mapping at the path of keys from the document's root,
+// or nil
+func (d *document) mapping(path ...string) *yaml.Node {
+	if len(d.root.Content) == 0 {
+		return nil
+	}
+
+	n := d.root.Content[0]
+
+	for _, key := range path {
+		if n.Kind != yaml.MappingNode {
+			return nil
+		}
+
+		i := field(n, key)
+		if i < 0 {
+			return nil
+		}
+
+		n = n.Content[2*i+1]
+	}
+
+	if n.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	return n
+}
+
+// lastScalarPair returns the index of the mapping's last pair with a scalar
+// value, which an inserted pair can follow in place, or its last pair
+func lastScalarPair(mapping *yaml.Node) int {
+	for i := len(mapping.Content) - 2; i >= 0; i -= 2 {
+		if mapping.Content[i+1].Kind == yaml.ScalarNode {
+			return i / 2
+		}
+	}
+
+	return len(mapping.Content)/2 - 1
+}
@@ -0,0 +1,267 @@
+package ecr
+
+import (
+	"testing"
+	"time"
+
+	"ship-it/internal/image"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDigest = "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"
+
+func editDocument(t *testing.T, values string, edit func(*document)) string {
+	doc, err := parseDocument([]byte(values))
+	require.NoError(t, err)
+
+	edit(doc)
+
+	edited, err := doc.Bytes()
+	require.NoError(t, err)
+
+	return string(edited)
+}
+
+func editImage(t *testing.T, values string, desired *image.Ref) string {
+	return editDocument(t, values, func(doc *document) {
+		editYaml(doc, desired)
+	})
+}
+
+func TestEditYaml(t *testing.T) {
+	desired := &image.Ref{
+		Registry:   "foo.io",
+		Repository: "bar",
+		Tag:        "newtag",
+	}
+
+	edited := editImage(t, `# the release's values
+values:
+    image:
+        repository: foo.io/bar  # the app
+        tag: oldtag
+    other:
+        repository: foo.io/bar
+        tag: oldtag
+`, desired)
+
+	assert.Equal(t, `# the release's values
+values:
+    image:
+        repository: foo.io/bar  # the app
+        tag: newtag
+    other:
+        repository: foo.io/bar
+        tag: oldtag
+`, edited)
+}
+
+func TestEditYamlQuoting(t *testing.T) {
+	desired := &image.Ref{
+		Registry:   "foo.io",
+		Repository: "bar",
+		Tag:        "1.10",
+	}
+
+	testCases := map[string]struct {
+		values   string
+		expected string
+	}{
+		"double quoted": {
+			values:   "image:\n  repository: foo.io/bar\n  tag: \"1.9\"\n",
+			expected: "image:\n  repository: foo.io/bar\n  tag: \"1.10\"\n",
+		},
+		"single quoted": {
+			values:   "image:\n  repository: foo.io/bar\n  tag: 'v1'\n",
+			expected: "image:\n  repository: foo.io/bar\n  tag: '1.10'\n",
+		},
+		"plain tag that would be a number": {
+			values:   "image:\n  repository: foo.io/bar\n  tag: v1\n",
+			expected: "image:\n  repository: foo.io/bar\n  tag: \"1.10\"\n",
+		},
+		"flow mapping": {
+			values:   "image: {repository: foo.io/bar, tag: v1}\n",
+			expected: "image: {repository: foo.io/bar, tag: \"1.10\"}\n",
+		},
+		"anchored": {
+			values:   "image: &image\n  repository: foo.io/bar\n  tag: v1\nworker:\n  image: *image\n",
+			expected: "image: &image\n  repository: foo.io/bar\n  tag: \"1.10\"\nworker:\n  image: *image\n",
+		},
+	}
+
+	for name, tc := range testCases {
+		tc := tc // scopelint
+		t.Run(name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, editImage(t, tc.values, desired))
+		})
+	}
+}
+
+func TestEditYamlDigest(t *testing.T) {
+	desired := &image.Ref{
+		Registry:   "foo.io",
+		Repository: "bar",
+		Tag:        "newtag",
+		Digest:     "sha256:new",
+	}
+
+	// the digest is added after the tag
+	edited := editImage(t, "image:\n  repository: foo.io/bar\n  tag: oldtag # pinned\n  pullPolicy: Always\n", desired)
+	assert.Equal(t, "image:\n  repository: foo.io/bar\n  tag: newtag # pinned\n  digest: sha256:new\n  pullPolicy: Always\n", edited)
+
+	edited = editImage(t, "image: {repository: foo.io/bar, tag: oldtag}\n", desired)
+	assert.Equal(t, "image: {repository: foo.io/bar, tag: newtag, digest: sha256:new}\n", edited)
+
+	// an existing digest is replaced
+	edited = editImage(t, "image:\n  digest: sha256:old\n  repository: foo.io/bar\n  tag: oldtag\n", desired)
+	assert.Equal(t, "image:\n  digest: sha256:new\n  repository: foo.io/bar\n  tag: newtag\n", edited)
+
+	// a digest that isn't known is removed
+	desired.Digest = ""
+	edited = editImage(t, "image:\n  repository: foo.io/bar\n  digest: sha256:old # pinned\n  tag: oldtag\n", desired)
+	assert.Equal(t, "image:\n  repository: foo.io/bar\n  tag: newtag\n", edited)
+
+	// unless it can't be removed in place, when the document is reformatted
+	edited = editImage(t, "# values\nimage: {repository: foo.io/bar, digest: sha256:old, tag: oldtag}\n", desired)
+	assert.Equal(t, "# values\nimage: {repository: foo.io/bar, tag: newtag}\n", edited)
+}
+
+func TestEditYamlShapes(t *testing.T) {
+	values := `containers:
+- name: app
+  image:
+    repository: foo.io/bar
+    tag: oldtag
+- name: worker
+  image:
+    repository: foo.io/bar
+    tag: oldtag
+sidecars:
+- image: foo.io/bar:oldtag
+- image: foo.io/baz:oldtag
+proxy:
+  image: foo.io/bar:oldtag@` + testDigest + `
+`
+
+	desired := &image.Ref{
+		Registry:   "foo.io",
+		Repository: "bar",
+		Tag:        "newtag",
+	}
+
+	assert.Equal(t, `containers:
+- name: app
+  image:
+    repository: foo.io/bar
+    tag: newtag
+- name: worker
+  image:
+    repository: foo.io/bar
+    tag: newtag
+sidecars:
+- image: foo.io/bar:newtag
+- image: foo.io/baz:oldtag
+proxy:
+  image: foo.io/bar:newtag
+`, editImage(t, values, desired))
+}
+
+func TestEditYamlDockerHub(t *testing.T) {
+	desired := &image.Ref{
+		Registry:   "docker.io",
+		Repository: "library/redis",
+		Tag:        "6",
+	}
+
+	edited := editImage(t, "image: redis:5\nsidecar:\n  image:\n    repository: index.docker.io/library/redis\n    tag: \"5\"\n", desired)
+	assert.Equal(t, "image: redis:6\nsidecar:\n  image:\n    repository: index.docker.io/library/redis\n    tag: \"6\"\n", edited)
+}
+
+func TestEditChartVersion(t *testing.T) {
+	edited := editDocument(t, `kind: HelmRelease
+spec:
+  chart:
+    name: microservice
+    version: 1.0.0 # the chart's version
+  values:
+    chart:
+      version: unchanged
+`, func(doc *document) {
+		editChartVersion(doc, "1.1.0")
+	})
+
+	assert.Equal(t, `kind: HelmRelease
+spec:
+  chart:
+    name: microservice
+    version: 1.1.0 # the chart's version
+  values:
+    chart:
+      version: unchanged
+`, edited)
+}
+
+func TestEditPushedImage(t *testing.T) {
+	pushedAt := time.Date(2019, time.July, 11, 14, 19, 59, 0, time.UTC)
+
+	edit := func(values string, tag string, pushed time.Time) string {
+		return editDocument(t, values, func(doc *document) {
+			editPushedImage(doc, &image.Ref{Registry: "foo.io", Repository: "bar", Tag: tag, PushedAt: pushed})
+		})
+	}
+
+	values := `metadata:
+  name: foo
+  labels:
+    app: foo
+spec:
+  values:
+    image:
+      repository: foo.io/bar
+      tag: one
+`
+
+	// the push time is recorded in place, after the last scalar pair
+	edited := edit(values, "two", pushedAt)
+	assert.Equal(t, `metadata:
+  name: foo
+  annotations:
+    helmreleases.shipit.wattpad.com/pushed-at: '{"foo.io/bar":"2019-07-11T14:19:59Z"}'
+  labels:
+    app: foo
+spec:
+  values:
+    image:
+      repository: foo.io/bar
+      tag: two
+`, edited)
+
+	// an older push that's delivered later isn't applied
+	assert.Equal(t, edited, edit(edited, "one", pushedAt.Add(-time.Minute)))
+
+	// a newer push replaces the recorded time
+	newer := edit(edited, "three", pushedAt.Add(time.Minute))
+	assert.Contains(t, newer, `helmreleases.shipit.wattpad.com/pushed-at: '{"foo.io/bar":"2019-07-11T14:20:59Z"}'`)
+	assert.Contains(t, newer, "tag: three")
+
+	// pushes without a push time are always applied
+	assert.Contains(t, edit(edited, "one", time.Time{}), "tag: one")
+
+	// the annotation is added to the existing annotations
+	annotated := edit(`metadata:
+  name: foo
+  annotations:
+    helmreleases.shipit.wattpad.com/tag-policy: newest
+spec:
+  values:
+    image:
+      repository: foo.io/bar
+      tag: one
+`, "two", pushedAt)
+
+	assert.Contains(t, annotated, `    helmreleases.shipit.wattpad.com/tag-policy: newest
+    helmreleases.shipit.wattpad.com/pushed-at: '{"foo.io/bar":"2019-07-11T14:19:59Z"}'
+`)
+}
@@ -24,6 +24,11 @@ type RegistryChartListener struct {
 	logger     log.Logger
 	service    *sqsconsumer.SQSService
 	timer      metrics.Histogram
+
+	// queue is the name of the queue of push events, and deadLetters
+	// stores the events that can't be handled
+	queue       string
+	deadLetters middleware.DeadLetterQueue
 }
 
 func NewListener(l log.Logger, h metrics.Histogram, org string, r RepositoriesService, queue string, sqs sqsconsumer.SQSAPI) (*RegistryChartListener, error) {
@@ -37,12 +42,21 @@ func NewListener(l log.Logger, h metrics.Histogram, org string, r RepositoriesSe
 		logger:     log.With(l, "worker", "github"),
 		service:    svc,
 		timer:      h.With("worker", "github"),
+		queue:      queue,
 	}, nil
 }
 
+// WithDeadLetters sends the push events that fail permanently to the
+// dead-letter queue, instead of dropping them.
+func (l *RegistryChartListener) WithDeadLetters(q middleware.DeadLetterQueue) *RegistryChartListener {
+	l.deadLetters = q
+	return l
+}
+
 func (l *RegistryChartListener) Listen(ctx context.Context, r syncd.RegistryChartReconciler) error {
 	stack := sqsmiddleware.ApplyDecoratorsToHandler(
 		l.handler(r),
+		middleware.DeadLetter(l.logger, l.queue, l.deadLetters),
 		middleware.Timer(l.timer),
 		middleware.Logger(l.logger),
 	)
@@ -59,19 +73,30 @@ func (l *RegistryChartListener) handler(r syncd.RegistryChartReconciler) sqscons
 	return func(ctx context.Context, msg string) error {
 		var event pushEvent
 		if err := json.Unmarshal([]byte(msg), &event); err != nil {
-			return errors.Wrap(err, "failed to unmarshal github push event")
+			return syncd.Permanent(errors.Wrap(err, "failed to unmarshal github push event"))
 		}
 
-		chartFiles, err := l.downloader.BufferDirectory(ctx, event.Repository, event.Path, event.Ref)
-		if err != nil {
-			return errors.Wrap(err, "failed to download chart directory")
+		if event.Repository == "" || event.Ref == "" {
+			return syncd.Permanent(errors.Errorf("incomplete github push event: %s", msg))
 		}
 
-		chart, err := chartutil.LoadFiles(chartFiles)
-		if err != nil {
-			return errors.Wrap(err, "failed to load chart files")
-		}
+		return reconcileChart(ctx, l.downloader, r, event.Repository, event.Path, event.Ref)
+	}
+}
 
-		return r.Reconcile(ctx, chart)
+// reconcileChart downloads the chart in the repository's path at the ref, and
+// reconciles it.
+func reconcileChart(ctx context.Context, d githubDownloader, r syncd.RegistryChartReconciler, repo, path, ref string) error {
+	chartFiles, err := d.BufferDirectory(ctx, repo, path, ref)
+	if err != nil {
+		return errors.Wrap(err, "failed to download chart directory")
 	}
+
+	chart, err := chartutil.LoadFiles(chartFiles)
+	if err != nil {
+		// the chart at the ref is invalid, so loading it again fails too
+		return syncd.Permanent(errors.Wrap(err, "failed to load chart files"))
+	}
+
+	return r.Reconcile(ctx, chart)
 }
@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"testing"
 
+	"ship-it/internal/syncd"
+
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -56,6 +58,42 @@ func TestHandlerChartDownloadFails(t *testing.T) {
 
 	err = handler(context.Background(), string(eventBytes))
 	assert.Error(t, errNotFound, err)
+
+	// the chart may be downloaded by a retry
+	assert.False(t, syncd.IsPermanent(err))
+}
+
+func TestHandlerMalformedEvent(t *testing.T) {
+	listener := &RegistryChartListener{downloader: new(mockDownloader)}
+	handler := listener.handler(nil)
+
+	for _, msg := range []string{"not json", `{"path": "path"}`} {
+		err := handler(context.Background(), msg)
+		assert.True(t, syncd.IsPermanent(err), msg)
+	}
+}
+
+func TestHandlerInvalidChart(t *testing.T) {
+	testEvent := pushEvent{
+		Ref:        "ref",
+		Path:       "path",
+		Repository: "repository",
+	}
+
+	eventBytes, err := json.Marshal(testEvent)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+
+	var md mockDownloader
+	md.On("BufferDirectory", mock.Anything, testEvent.Repository, testEvent.Path, testEvent.Ref).Return([]*chartutil.BufferedFile{
+		{Name: "values.yaml", Data: []byte("image: foo")},
+	}, nil)
+
+	listener := &RegistryChartListener{downloader: &md}
+
+	err = listener.handler(nil)(context.Background(), string(eventBytes))
+	assert.True(t, syncd.IsPermanent(err))
 }
 
 func TestHandlerCallsReconciler(t *testing.T) {
@@ -0,0 +1,100 @@
+package github
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// The phases of a registry chart deployment
+	PhaseDeployed = "Deployed"
+	PhaseFailed   = "Failed"
+
+	// signatureHeader and timestampHeader sign the webhook requests like the
+	// operator's webhook notifications, so a receiver can verify both
+	signatureHeader = "X-Ship-It-Signature"
+	timestampHeader = "X-Ship-It-Timestamp"
+)
+
+// Deployment summarises a deployment of the registry chart, with the
+// HelmReleases that it added, changed and removed
+type Deployment struct {
+	Release      string   `json:"release"`
+	Namespace    string   `json:"namespace"`
+	ChartVersion string   `json:"chartVersion"`
+	Phase        string   `json:"phase"`
+	Added        []string `json:"added,omitempty"`
+	Changed      []string `json:"changed,omitempty"`
+	Removed      []string `json:"removed,omitempty"`
+
+	// Message explains a failed deployment
+	Message string `json:"message,omitempty"`
+}
+
+// Notifier sends the summaries of the registry chart's deployments
+type Notifier interface {
+	Send(Deployment) error
+}
+
+// WebhookNotifier posts the deployments as JSON to an HTTP endpoint. The
+// requests are signed when there's a secret: the X-Ship-It-Signature header
+// is "sha256=" and the hex HMAC-SHA256 of the X-Ship-It-Timestamp header, a
+// ".", and the body.
+type WebhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+	now    func() time.Time
+}
+
+func NewWebhookNotifier(url, secret string) *WebhookNotifier {
+	return &WebhookNotifier{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+func (w *WebhookNotifier) Send(d Deployment) error {
+	body, err := json.Marshal(d)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode deployment")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create webhook request")
+	}
+
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(timestampHeader, timestamp)
+	if w.secret != "" {
+		mac := hmac.New(sha256.New, []byte(w.secret))
+		mac.Write([]byte(timestamp + "."))
+		mac.Write(body)
+		req.Header.Set(signatureHeader, "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send webhook request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
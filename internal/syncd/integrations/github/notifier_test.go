@@ -0,0 +1,63 @@
+package github
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var (
+		received  Deployment
+		signature string
+		timestamp string
+		body      []byte
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+		signature = r.Header.Get(signatureHeader)
+		timestamp = r.Header.Get(timestampHeader)
+		json.Unmarshal(body, &received)
+	}))
+	defer server.Close()
+
+	n := NewWebhookNotifier(server.URL, "s3cr3t")
+	n.now = func() time.Time { return time.Unix(1561996800, 0) }
+
+	d := Deployment{
+		Release:      "registry",
+		Namespace:    "default",
+		ChartVersion: "1.0.0",
+		Phase:        PhaseDeployed,
+		Added:        []string{"foo"},
+	}
+
+	require.NoError(t, n.Send(d))
+	assert.Equal(t, d, received)
+	assert.Equal(t, "1561996800", timestamp)
+
+	mac := hmac.New(sha256.New, []byte("s3cr3t"))
+	mac.Write([]byte("1561996800."))
+	mac.Write(body)
+	assert.Equal(t, "sha256="+hex.EncodeToString(mac.Sum(nil)), signature)
+}
+
+func TestWebhookNotifierFailure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	err := NewWebhookNotifier(server.URL, "").Send(Deployment{Release: "registry"})
+	assert.EqualError(t, err, "webhook responded with status 502")
+}
@@ -2,50 +2,397 @@ package github
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
 	"time"
 
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+
+	"github.com/go-kit/kit/log"
 	"github.com/pkg/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8syaml "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/helm/pkg/chartutil"
 	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
 	rls "k8s.io/helm/pkg/proto/hapi/services"
+	"k8s.io/helm/pkg/releaseutil"
+	"k8s.io/helm/pkg/renderutil"
+	helmerrors "k8s.io/helm/pkg/storage/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 type helmClient interface {
 	InstallReleaseFromChart(chart *chart.Chart, namespace string, opts ...helm.InstallOption) (*rls.InstallReleaseResponse, error)
-	UpdateReleaseFromChart(rlsName string, chart *chart.Chart, opts ...helm.UpdateOption) (*rls.UpdateReleaseResponse, error)
+	ReleaseContent(rlsName string, opts ...helm.ContentOption) (*rls.GetReleaseContentResponse, error)
 }
 
+// registryLabel marks the HelmReleases that syncd created for the registry
+// chart's release, whose name is the label's value. The HelmReleases that
+// Tiller installed are listed in the release's manifest instead.
+const registryLabel = "shipit.wattpad.com/registry-release"
+
+// Reconciler deploys the registry chart. Tiller installs the chart when its
+// release doesn't exist. Afterwards, the chart is rendered with the release's
+// values, and only the HelmReleases whose labels, annotations or spec differ
+// from the ones in the cluster are created, updated or deleted, with the
+// Kubernetes API. The other HelmReleases are left as they are, and objects of
+// other kinds are only created by the install.
 type Reconciler struct {
 	Namespace string
 	Release   string
 	Timeout   time.Duration
 
-	client helmClient
+	client   helmClient
+	kube     client.Client
+	logger   log.Logger
+	notifier Notifier
 }
 
-func NewReconciler(c helmClient, namespace, release string, timeout time.Duration) *Reconciler {
+func NewReconciler(l log.Logger, c helmClient, k client.Client, namespace, release string, timeout time.Duration) *Reconciler {
 	return &Reconciler{
 		Namespace: namespace,
 		Release:   release,
 		Timeout:   timeout,
 		client:    c,
+		kube:      k,
+		logger:    log.With(l, "reconciler", "helm", "release", release),
 	}
 }
 
+// WithNotifier sends the summary of each deployment of the registry chart to
+// the notifier
+func (r *Reconciler) WithNotifier(n Notifier) *Reconciler {
+	r.notifier = n
+	return r
+}
+
 func (r *Reconciler) Reconcile(ctx context.Context, chart *chart.Chart) error {
-	timeoutSeconds := int64(timeoutForDeadline(ctx, r.Timeout).Seconds())
+	current, err := r.client.ReleaseContent(r.Release)
+	if err != nil {
+		// Tiller's errors lose their type over gRPC, so only their message
+		// tells that the release doesn't exist
+		if !strings.Contains(err.Error(), helmerrors.ErrReleaseNotFound(r.Release).Error()) {
+			return errors.Wrap(err, "failed to get release")
+		}
+
+		return r.install(ctx, chart)
+	}
 
-	_, err := r.client.UpdateReleaseFromChart(r.Release, chart, helm.UpgradeTimeout(timeoutSeconds))
-	err = errors.Wrap(err, "failed to update release from chart")
+	rendered, err := renderChart(chart, current.GetRelease().GetConfig(), r.Namespace, r.Release, current.GetRelease().GetVersion(), true)
+	if err != nil {
+		return err
+	}
 
+	desired, err := parseReleases(rendered)
 	if err != nil {
-		_, err = r.client.InstallReleaseFromChart(chart, r.Namespace, helm.InstallTimeout(timeoutSeconds), helm.ReleaseName(r.Release))
-		err = errors.Wrap(err, "failed to install release from chart")
+		return err
 	}
 
+	live, err := r.liveReleases(ctx, current.GetRelease())
+	if err != nil {
+		return err
+	}
+
+	diff := diffReleases(live, desired)
+	if diff.empty() {
+		r.logger.Log("event", "chart.unchanged")
+		return nil
+	}
+
+	err = r.apply(ctx, diff, live, desired)
+
+	r.report(chart, diff, err)
+
 	return err
 }
 
+func (r *Reconciler) install(ctx context.Context, chart *chart.Chart) error {
+	timeoutSeconds := int64(timeoutForDeadline(ctx, r.Timeout).Seconds())
+
+	_, err := r.client.InstallReleaseFromChart(chart, r.Namespace, helm.InstallTimeout(timeoutSeconds), helm.ReleaseName(r.Release))
+	err = errors.Wrap(err, "failed to install release from chart")
+
+	var diff releaseDiff
+	if rendered, renderErr := renderChart(chart, nil, r.Namespace, r.Release, 1, false); renderErr == nil {
+		if desired, parseErr := parseReleases(rendered); parseErr == nil {
+			diff = diffReleases(nil, desired)
+		}
+	}
+
+	r.report(chart, diff, err)
+
+	return err
+}
+
+// liveReleases returns the HelmReleases in the cluster that the registry
+// chart deployed: the ones in the release's manifest, which Tiller installed,
+// and the ones that syncd created.
+func (r *Reconciler) liveReleases(ctx context.Context, current *release.Release) (map[string]*shipitv1beta1.HelmRelease, error) {
+	var docs []string
+	for _, doc := range releaseutil.SplitManifests(current.GetManifest()) {
+		docs = append(docs, doc)
+	}
+
+	installed, err := parseReleases(docs)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse release manifest")
+	}
+
+	var list shipitv1beta1.HelmReleaseList
+	if err := r.kube.List(ctx, &list, client.InNamespace(r.Namespace)); err != nil {
+		return nil, errors.Wrap(err, "failed to list HelmReleases")
+	}
+
+	live := make(map[string]*shipitv1beta1.HelmRelease)
+	for i := range list.Items {
+		hr := &list.Items[i]
+		if _, ok := installed[hr.Name]; ok || hr.Labels[registryLabel] == r.Release {
+			live[hr.Name] = hr
+		}
+	}
+
+	return live, nil
+}
+
+// apply creates the added HelmReleases, updates the changed ones, and deletes
+// the removed ones. The HelmReleases that fail are reported together, after
+// the others have been applied.
+func (r *Reconciler) apply(ctx context.Context, diff releaseDiff, live, desired map[string]*shipitv1beta1.HelmRelease) error {
+	var msgs []string
+
+	fail := func(err error, action, name string) {
+		msgs = append(msgs, fmt.Sprintf("failed to %s %s: %s", action, name, err))
+	}
+
+	for _, name := range diff.Added {
+		hr := desired[name].DeepCopy()
+		if hr.Namespace == "" {
+			hr.Namespace = r.Namespace
+		}
+		hr.Labels = withRegistryLabel(hr.Labels, r.Release)
+
+		if err := r.kube.Create(ctx, hr); err != nil {
+			fail(err, "create", name)
+		}
+	}
+
+	for _, name := range diff.Changed {
+		// the live object keeps its status and the operator's finalizer
+		hr := live[name].DeepCopy()
+		hr.Labels = withRegistryLabel(desired[name].Labels, r.Release)
+		hr.SetAnnotations(desired[name].GetAnnotations())
+		hr.Spec = desired[name].Spec
+
+		if err := r.kube.Update(ctx, hr); err != nil {
+			fail(err, "update", name)
+		}
+	}
+
+	for _, name := range diff.Removed {
+		if err := r.kube.Delete(ctx, live[name]); err != nil && !apierrors.IsNotFound(err) {
+			fail(err, "delete", name)
+		}
+	}
+
+	if len(msgs) > 0 {
+		return errors.Errorf("failed to apply HelmReleases: %s", strings.Join(msgs, "; "))
+	}
+
+	return nil
+}
+
+func withRegistryLabel(labels map[string]string, release string) map[string]string {
+	l := make(map[string]string, len(labels)+1)
+	for k, v := range labels {
+		l[k] = v
+	}
+	l[registryLabel] = release
+	return l
+}
+
+// report logs the summary of a deployment, and sends it to the notifier
+func (r *Reconciler) report(chart *chart.Chart, diff releaseDiff, err error) {
+	d := Deployment{
+		Release:      r.Release,
+		Namespace:    r.Namespace,
+		ChartVersion: chart.GetMetadata().GetVersion(),
+		Phase:        PhaseDeployed,
+		Added:        diff.Added,
+		Changed:      diff.Changed,
+		Removed:      diff.Removed,
+	}
+
+	if err != nil {
+		d.Phase = PhaseFailed
+		d.Message = err.Error()
+	}
+
+	r.logger.Log(
+		"event", "chart.deployed",
+		"added", strings.Join(diff.Added, ","),
+		"changed", strings.Join(diff.Changed, ","),
+		"removed", strings.Join(diff.Removed, ","),
+		"err", err,
+	)
+
+	if r.notifier == nil {
+		return
+	}
+
+	if sendErr := r.notifier.Send(d); sendErr != nil {
+		r.logger.Log("event", "notification.failed", "err", sendErr)
+	}
+}
+
+// releaseDiff lists the HelmReleases that a chart adds to, changes in, and
+// removes from the cluster
+type releaseDiff struct {
+	Added   []string
+	Changed []string
+	Removed []string
+}
+
+func (d releaseDiff) empty() bool {
+	return len(d.Added)+len(d.Changed)+len(d.Removed) == 0
+}
+
+// renderChart renders the chart's manifests like Tiller renders them
+func renderChart(c *chart.Chart, config *chart.Config, namespace, name string, revision int32, upgrade bool) ([]string, error) {
+	if config.GetRaw() == "" {
+		config = &chart.Config{Raw: "{}"}
+	}
+
+	rendered, err := renderutil.Render(c, config, renderutil.Options{
+		ReleaseOptions: chartutil.ReleaseOptions{
+			Name:      name,
+			Namespace: namespace,
+			Revision:  int(revision),
+			IsInstall: !upgrade,
+			IsUpgrade: upgrade,
+		},
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render chart")
+	}
+
+	var docs []string
+	for name, content := range rendered {
+		// Tiller doesn't apply notes and partials
+		base := path.Base(name)
+		if base == "NOTES.txt" || strings.HasPrefix(base, "_") {
+			continue
+		}
+
+		for _, doc := range releaseutil.SplitManifests(content) {
+			docs = append(docs, doc)
+		}
+	}
+
+	return docs, nil
+}
+
+// parseReleases parses the HelmReleases of the manifests, by name. Objects of
+// other kinds, and documents that aren't objects, e.g. empty ones, are left
+// out.
+func parseReleases(docs []string) (map[string]*shipitv1beta1.HelmRelease, error) {
+	releases := make(map[string]*shipitv1beta1.HelmRelease)
+	for _, doc := range docs {
+		data, err := k8syaml.ToJSON([]byte(doc))
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid manifest")
+		}
+
+		var meta metav1.TypeMeta
+		if err := json.Unmarshal(data, &meta); err != nil || meta.Kind != helmReleaseKind {
+			continue
+		}
+
+		var hr shipitv1beta1.HelmRelease
+		if err := json.Unmarshal(data, &hr); err != nil {
+			return nil, errors.Wrap(err, "invalid HelmRelease")
+		}
+
+		releases[hr.Name] = &hr
+	}
+
+	return releases, nil
+}
+
+const helmReleaseKind = "HelmRelease"
+
+// diffReleases compares the live HelmReleases with the desired ones, by their
+// labels, annotations and spec. The live HelmReleases' status and other
+// metadata are the cluster's, and syncd's label isn't in the manifests.
+func diffReleases(live, desired map[string]*shipitv1beta1.HelmRelease) releaseDiff {
+	var diff releaseDiff
+
+	for name, hr := range desired {
+		current, ok := live[name]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, name)
+		case !sameRelease(current, hr):
+			diff.Changed = append(diff.Changed, name)
+		}
+	}
+
+	for name := range live {
+		if _, ok := desired[name]; !ok {
+			diff.Removed = append(diff.Removed, name)
+		}
+	}
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Changed)
+	sort.Strings(diff.Removed)
+
+	return diff
+}
+
+func sameRelease(live, desired *shipitv1beta1.HelmRelease) bool {
+	labels := make(map[string]string)
+	for k, v := range live.Labels {
+		if k != registryLabel {
+			labels[k] = v
+		}
+	}
+
+	return sameJSON(labels, desired.Labels) &&
+		sameJSON(live.GetAnnotations(), desired.GetAnnotations()) &&
+		sameJSON(live.Spec, desired.Spec)
+}
+
+// sameJSON compares values by their JSON, so the formatting of raw values and
+// the difference between empty and missing maps don't matter
+func sameJSON(a, b interface{}) bool {
+	decode := func(v interface{}) interface{} {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil
+		}
+
+		var decoded interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			return nil
+		}
+
+		if m, ok := decoded.(map[string]interface{}); ok && len(m) == 0 {
+			return nil
+		}
+
+		return decoded
+	}
+
+	return reflect.DeepEqual(decode(a), decode(b))
+}
+
 func timeoutForDeadline(ctx context.Context, def time.Duration) time.Duration {
 	if deadline, ok := ctx.Deadline(); ok {
 		return time.Until(deadline)
@@ -2,14 +2,24 @@ package github
 
 import (
 	"context"
+	"errors"
 	"testing"
 
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+
+	"github.com/go-kit/kit/log"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/proto/hapi/chart"
+	"k8s.io/helm/pkg/proto/hapi/release"
 	rls "k8s.io/helm/pkg/proto/hapi/services"
 	helmerrors "k8s.io/helm/pkg/storage/errors"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
 )
 
 type mockHelmClient struct {
@@ -27,45 +37,269 @@ func (m *mockHelmClient) InstallReleaseFromChart(chart *chart.Chart, namespace s
 	return ret0, args.Error(1)
 }
 
-func (m *mockHelmClient) UpdateReleaseFromChart(rlsName string, chart *chart.Chart, opts ...helm.UpdateOption) (*rls.UpdateReleaseResponse, error) {
-	args := m.Called(rlsName, chart, opts)
+func (m *mockHelmClient) ReleaseContent(rlsName string, opts ...helm.ContentOption) (*rls.GetReleaseContentResponse, error) {
+	args := m.Called(rlsName, opts)
 
-	var ret0 *rls.UpdateReleaseResponse
+	var ret0 *rls.GetReleaseContentResponse
 	if args0 := args.Get(0); args0 != nil {
-		ret0 = args0.(*rls.UpdateReleaseResponse)
+		ret0 = args0.(*rls.GetReleaseContentResponse)
 	}
 
 	return ret0, args.Error(1)
 }
 
-func TestUpdateOrInstallReleaseFound(t *testing.T) {
-	testChart := new(chart.Chart)
-	testRelease := "release"
+type mockNotifier struct {
+	deployments []Deployment
+}
+
+func (m *mockNotifier) Send(d Deployment) error {
+	m.deployments = append(m.deployments, d)
+	return nil
+}
+
+func helmRelease(name, tag string) string {
+	return `apiVersion: shipit.wattpad.com/v1beta1
+kind: HelmRelease
+metadata:
+  name: ` + name + `
+spec:
+  values:
+    image:
+      tag: ` + tag + `
+`
+}
+
+// liveRelease is a HelmRelease in the cluster, with the operator's finalizer
+// and status
+func liveRelease(t *testing.T, manifest string, labels map[string]string) *shipitv1beta1.HelmRelease {
+	releases, err := parseReleases([]string{manifest})
+	require.NoError(t, err)
+	require.Len(t, releases, 1)
+
+	for _, hr := range releases {
+		hr.Namespace = "namespace"
+		hr.Labels = labels
+		hr.Finalizers = []string{"finalizer"}
+		hr.Status.ObservedGeneration = 1
+		return hr
+	}
+
+	return nil
+}
+
+func newFakeKube(objs ...runtime.Object) client.Client {
+	scheme := runtime.NewScheme()
+	shipitv1beta1.AddToScheme(scheme)
+
+	return fake.NewFakeClientWithScheme(scheme, objs...)
+}
+
+func testRegistryChart(templates map[string]string) *chart.Chart {
+	c := &chart.Chart{
+		Metadata: &chart.Metadata{Name: "registry", Version: "1.0.0"},
+	}
+
+	for name, data := range templates {
+		c.Templates = append(c.Templates, &chart.Template{Name: name, Data: []byte(data)})
+	}
+
+	return c
+}
+
+func deployedRelease(name string, version int32, manifest string) *rls.GetReleaseContentResponse {
+	return &rls.GetReleaseContentResponse{
+		Release: &release.Release{
+			Name:     name,
+			Version:  version,
+			Manifest: manifest,
+			Info:     &release.Info{Status: &release.Status{Code: release.Status_DEPLOYED}},
+		},
+	}
+}
+
+func TestReconcileInstallsReleaseNotFound(t *testing.T) {
+	testChart := testRegistryChart(map[string]string{"templates/foo.yaml": helmRelease("foo", "one")})
+	testNamespace, testRelease := "namespace", "release"
 
 	var m mockHelmClient
-	m.On("UpdateReleaseFromChart", testRelease, testChart, mock.Anything).Return(&rls.UpdateReleaseResponse{}, nil)
+	m.On("ReleaseContent", testRelease, mock.Anything).Return(nil, errors.New("rpc error: code = Unknown desc = "+helmerrors.ErrReleaseNotFound(testRelease).Error()))
+	m.On("InstallReleaseFromChart", testChart, testNamespace, mock.Anything).Return(&rls.InstallReleaseResponse{}, nil)
 
-	r := NewReconciler(&m, "", testRelease, 0)
+	var n mockNotifier
+	r := NewReconciler(log.NewNopLogger(), &m, newFakeKube(), testNamespace, testRelease, 0).WithNotifier(&n)
 
 	err := r.Reconcile(context.Background(), testChart)
 	assert.NoError(t, err)
 
 	m.AssertExpectations(t)
+
+	require.Len(t, n.deployments, 1)
+	assert.Equal(t, PhaseDeployed, n.deployments[0].Phase)
+	assert.Equal(t, []string{"foo"}, n.deployments[0].Added)
+}
+
+func TestReconcileDoesNotInstallOnOtherErrors(t *testing.T) {
+	testChart := testRegistryChart(nil)
+	testRelease := "release"
+
+	var m mockHelmClient
+	m.On("ReleaseContent", testRelease, mock.Anything).Return(nil, errors.New("rpc error: code = Unavailable desc = transport is closing"))
+
+	r := NewReconciler(log.NewNopLogger(), &m, newFakeKube(), "", testRelease, 0)
+
+	err := r.Reconcile(context.Background(), testChart)
+	assert.Error(t, err)
+
 	m.AssertNotCalled(t, "InstallReleaseFromChart")
 }
 
-func TestUpdateOrInstallReleaseNotFound(t *testing.T) {
-	testChart := new(chart.Chart)
-	testNamespace, testRelease := "namespace", "release"
+func TestReconcileSkipsUnchangedChart(t *testing.T) {
+	testChart := testRegistryChart(map[string]string{"templates/foo.yaml": helmRelease("foo", "one")})
+	testRelease := "release"
 
 	var m mockHelmClient
-	m.On("UpdateReleaseFromChart", testRelease, testChart, mock.Anything).Return(nil, helmerrors.ErrReleaseNotFound(testRelease))
-	m.On("InstallReleaseFromChart", testChart, testNamespace, mock.Anything).Return(&rls.InstallReleaseResponse{}, nil)
+	m.On("ReleaseContent", testRelease, mock.Anything).Return(deployedRelease(testRelease, 2, "---\n# Source: registry/templates/foo.yaml\n"+helmRelease("foo", "one")), nil)
 
-	r := NewReconciler(&m, testNamespace, testRelease, 0)
+	kube := newFakeKube(liveRelease(t, helmRelease("foo", "one"), nil))
+
+	var n mockNotifier
+	r := NewReconciler(log.NewNopLogger(), &m, kube, "namespace", testRelease, 0).WithNotifier(&n)
 
 	err := r.Reconcile(context.Background(), testChart)
 	assert.NoError(t, err)
 
-	m.AssertExpectations(t)
+	m.AssertNotCalled(t, "InstallReleaseFromChart")
+	assert.Empty(t, n.deployments)
+}
+
+func TestReconcileAppliesChangedReleases(t *testing.T) {
+	testChart := testRegistryChart(map[string]string{
+		"templates/foo.yaml": helmRelease("foo", "two"),
+		"templates/bar.yaml": helmRelease("bar", "one"),
+		"templates/baz.yaml": helmRelease("baz", "one"),
+	})
+	testRelease := "release"
+
+	// Tiller installed foo, bar and qux, and syncd created quux, while
+	// other is deployed by something else
+	manifest := "---\n" + helmRelease("foo", "one") + "---\n" + helmRelease("bar", "one") + "---\n" + helmRelease("qux", "one")
+
+	kube := newFakeKube(
+		liveRelease(t, helmRelease("foo", "one"), nil),
+		liveRelease(t, helmRelease("bar", "one"), map[string]string{registryLabel: testRelease}),
+		liveRelease(t, helmRelease("qux", "one"), nil),
+		liveRelease(t, helmRelease("quux", "one"), map[string]string{registryLabel: testRelease}),
+		liveRelease(t, helmRelease("other", "one"), nil),
+	)
+
+	var m mockHelmClient
+	m.On("ReleaseContent", testRelease, mock.Anything).Return(deployedRelease(testRelease, 2, manifest), nil)
+
+	var n mockNotifier
+	r := NewReconciler(log.NewNopLogger(), &m, kube, "namespace", testRelease, 0).WithNotifier(&n)
+
+	ctx := context.Background()
+
+	err := r.Reconcile(ctx, testChart)
+	assert.NoError(t, err)
+
+	m.AssertNotCalled(t, "InstallReleaseFromChart")
+
+	require.Len(t, n.deployments, 1)
+
+	sent := n.deployments[0]
+	assert.Equal(t, PhaseDeployed, sent.Phase)
+	assert.Equal(t, "1.0.0", sent.ChartVersion)
+	assert.Equal(t, []string{"baz"}, sent.Added)
+	assert.Equal(t, []string{"foo"}, sent.Changed)
+	assert.Equal(t, []string{"quux", "qux"}, sent.Removed)
+
+	get := func(name string) (*shipitv1beta1.HelmRelease, error) {
+		var hr shipitv1beta1.HelmRelease
+		return &hr, kube.Get(ctx, types.NamespacedName{Namespace: "namespace", Name: name}, &hr)
+	}
+
+	baz, err := get("baz")
+	require.NoError(t, err)
+	assert.Equal(t, testRelease, baz.Labels[registryLabel])
+
+	// the update keeps the operator's finalizer and the release's status
+	foo, err := get("foo")
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"image": {"tag": "two"}}`, string(foo.Spec.Values.Raw))
+	assert.Equal(t, testRelease, foo.Labels[registryLabel])
+	assert.Equal(t, []string{"finalizer"}, foo.Finalizers)
+	assert.Equal(t, int64(1), foo.Status.ObservedGeneration)
+
+	for _, name := range []string{"qux", "quux"} {
+		_, err := get(name)
+		assert.Error(t, err, name)
+	}
+
+	for _, name := range []string{"bar", "other"} {
+		_, err := get(name)
+		assert.NoError(t, err, name)
+	}
+}
+
+func TestReconcileReportsFailedReleases(t *testing.T) {
+	testChart := testRegistryChart(map[string]string{
+		"templates/foo.yaml":   helmRelease("foo", "two"),
+		"templates/other.yaml": helmRelease("other", "one"),
+	})
+	testRelease := "release"
+
+	// other isn't the chart's, so it can't be created
+	kube := newFakeKube(
+		liveRelease(t, helmRelease("foo", "one"), nil),
+		liveRelease(t, helmRelease("other", "one"), nil),
+	)
+
+	var m mockHelmClient
+	m.On("ReleaseContent", testRelease, mock.Anything).Return(deployedRelease(testRelease, 2, helmRelease("foo", "one")), nil)
+
+	var n mockNotifier
+	r := NewReconciler(log.NewNopLogger(), &m, kube, "namespace", testRelease, 0).WithNotifier(&n)
+
+	err := r.Reconcile(context.Background(), testChart)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "failed to create other")
+	}
+
+	require.Len(t, n.deployments, 1)
+	assert.Equal(t, PhaseFailed, n.deployments[0].Phase)
+	assert.Equal(t, []string{"other"}, n.deployments[0].Added)
+	assert.Equal(t, []string{"foo"}, n.deployments[0].Changed)
+	assert.Contains(t, n.deployments[0].Message, "failed to create other")
+}
+
+func TestDiffReleases(t *testing.T) {
+	current, err := parseReleases([]string{
+		helmRelease("foo", "one"),
+		helmRelease("bar", "one"),
+		"apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\ndata:\n  a: b\n",
+		"# empty\n",
+	})
+	require.NoError(t, err)
+	assert.Len(t, current, 2)
+
+	// formatting and empty metadata don't change a release
+	desired, err := parseReleases([]string{
+		"kind: HelmRelease\napiVersion: shipit.wattpad.com/v1beta1\nmetadata: {name: foo, labels: {}}\nspec:\n  values: {image: {tag: one}}\n",
+		helmRelease("bar", "two"),
+	})
+	require.NoError(t, err)
+
+	diff := diffReleases(current, desired)
+	assert.Empty(t, diff.Added)
+	assert.Equal(t, []string{"bar"}, diff.Changed)
+	assert.Empty(t, diff.Removed)
+	assert.False(t, diff.empty())
+
+	assert.True(t, diffReleases(current, current).empty())
+
+	// syncd's label isn't in the manifests
+	labelled := current["foo"].DeepCopy()
+	labelled.Labels = map[string]string{registryLabel: "release"}
+	assert.True(t, sameRelease(labelled, current["foo"]))
 }
@@ -0,0 +1,39 @@
+package github
+
+import (
+	"context"
+	"strings"
+
+	"ship-it/internal/syncd"
+)
+
+// ChartSyncer reconciles the registry chart at any ref of the operations
+// repository, e.g. to redeploy the chart on demand.
+type ChartSyncer struct {
+	downloader githubDownloader
+	reconciler syncd.RegistryChartReconciler
+
+	repository string
+	branch     string
+	path       string
+}
+
+func NewChartSyncer(org string, r RepositoriesService, cr syncd.RegistryChartReconciler, repository, branch, path string) *ChartSyncer {
+	return &ChartSyncer{
+		downloader: newDownloader(r, org),
+		reconciler: cr,
+		repository: repository,
+		branch:     branch,
+		path:       strings.Trim(path, "/"),
+	}
+}
+
+// Sync reconciles the registry chart at the ref, which is a commit, branch or
+// tag. An empty ref is the release branch.
+func (s *ChartSyncer) Sync(ctx context.Context, ref string) error {
+	if ref == "" {
+		ref = s.branch
+	}
+
+	return reconcileChart(ctx, s.downloader, s.reconciler, s.repository, s.path, ref)
+}
@@ -0,0 +1,38 @@
+package github
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+func TestChartSyncer(t *testing.T) {
+	chartFiles := []*chartutil.BufferedFile{
+		{Name: "Chart.yaml", Data: []byte("apiVersion: v1\nname: foo\n")},
+	}
+
+	var md mockDownloader
+	md.On("BufferDirectory", mock.Anything, "operations", "charts/registry", "master").Return(chartFiles, nil)
+	md.On("BufferDirectory", mock.Anything, "operations", "charts/registry", "abc123").Return(chartFiles, nil)
+
+	var mr mockReconciler
+	mr.On("Reconcile", mock.Anything, mock.Anything).Return(nil)
+
+	syncer := &ChartSyncer{
+		downloader: &md,
+		reconciler: &mr,
+		repository: "operations",
+		branch:     "master",
+		path:       "charts/registry",
+	}
+
+	// the release branch is synced by default
+	assert.NoError(t, syncer.Sync(context.Background(), ""))
+	assert.NoError(t, syncer.Sync(context.Background(), "abc123"))
+
+	md.AssertExpectations(t)
+	mr.AssertNumberOfCalls(t, "Reconcile", 2)
+}
@@ -0,0 +1,248 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/google/go-github/v26/github"
+	"github.com/pkg/errors"
+)
+
+// WebhookListener receives GitHub push webhooks for the operations
+// repository, and reconciles the registry chart whenever a push to the
+// release branch changes it. Webhooks are verified with the webhook's secret.
+//
+// Pushes are reconciled one at a time, in the background, since an upgrade
+// can take longer than GitHub waits for a response. Pushes that arrive while
+// a reconcile is running are coalesced, and only the latest is reconciled.
+// A push that fails to reconcile is retried with backoff, until it succeeds,
+// fails permanently, or a newer push replaces it.
+type WebhookListener struct {
+	downloader githubDownloader
+	logger     log.Logger
+	timer      metrics.Histogram
+
+	addr       string
+	secret     string
+	owner      string
+	repository string
+	branch     string
+	path       string
+
+	// pending holds the latest push's commit waiting to be reconciled
+	pending chan string
+
+	// retryBase and retryMax bound the delay before a failed push is
+	// retried
+	retryBase time.Duration
+	retryMax  time.Duration
+}
+
+func NewWebhookListener(l log.Logger, h metrics.Histogram, org string, r RepositoriesService, addr, secret, repository, branch, path string) *WebhookListener {
+	return &WebhookListener{
+		downloader: newDownloader(r, org),
+		logger:     log.With(l, "worker", "github-webhook"),
+		timer:      h.With("worker", "github-webhook"),
+		addr:       addr,
+		secret:     secret,
+		owner:      org,
+		repository: repository,
+		branch:     branch,
+		path:       strings.Trim(path, "/"),
+		pending:    make(chan string, 1),
+		retryBase:  5 * time.Second,
+		retryMax:   5 * time.Minute,
+	}
+}
+
+func (l *WebhookListener) Listen(ctx context.Context, r syncd.RegistryChartReconciler) error {
+	srv := &http.Server{
+		Addr:    l.addr,
+		Handler: l,
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	done := make(chan struct{})
+	go func() {
+		l.reconcileLoop(ctx, r)
+		close(done)
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "github webhook listener failed")
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	srv.Shutdown(shutdown)
+	<-done
+
+	return ctx.Err()
+}
+
+func (l *WebhookListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// payloads aren't validated without a secret
+	if l.secret == "" {
+		http.Error(w, "webhook secret isn't configured", http.StatusUnauthorized)
+		return
+	}
+
+	payload, err := github.ValidatePayload(r, []byte(l.secret))
+	if err != nil {
+		http.Error(w, "invalid signature", http.StatusUnauthorized)
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(r), payload)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	push, ok := event.(*github.PushEvent)
+	if !ok {
+		// e.g. the ping sent when the webhook is created
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if !l.relevant(push) {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	l.enqueue(push.GetAfter())
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// relevant reports whether the push changed the registry chart on the
+// release branch. The repository is matched with its owner, since a
+// repository of the same name, e.g. a fork, can use the same webhook secret,
+// and GitHub compares owners and names case-insensitively.
+func (l *WebhookListener) relevant(push *github.PushEvent) bool {
+	if !strings.EqualFold(push.GetRepo().GetFullName(), l.owner+"/"+l.repository) || push.GetRef() != "refs/heads/"+l.branch {
+		return false
+	}
+
+	if push.GetDeleted() {
+		return false
+	}
+
+	// GitHub only includes the first 20 commits of a push, so larger pushes
+	// may have changed the chart in a commit that isn't listed
+	if len(push.Commits) >= 20 {
+		return true
+	}
+
+	for _, commit := range push.Commits {
+		for _, files := range [][]string{commit.Added, commit.Modified, commit.Removed} {
+			for _, file := range files {
+				if l.path == "" || strings.HasPrefix(file, l.path+"/") {
+					return true
+				}
+			}
+		}
+	}
+
+	return false
+}
+
+// enqueue replaces the pending commit with the pushed one
+func (l *WebhookListener) enqueue(sha string) {
+	for {
+		select {
+		case l.pending <- sha:
+			return
+		default:
+		}
+
+		select {
+		case <-l.pending:
+		default:
+		}
+	}
+}
+
+func (l *WebhookListener) reconcileLoop(ctx context.Context, r syncd.RegistryChartReconciler) {
+	var (
+		sha     string
+		attempt int
+		retry   *time.Timer
+	)
+
+	for {
+		var retryC <-chan time.Time
+		if retry != nil {
+			retryC = retry.C
+		}
+
+		select {
+		case <-ctx.Done():
+			if retry != nil {
+				retry.Stop()
+			}
+			return
+		case next := <-l.pending:
+			// a newer push replaces a failed one that's waiting to be
+			// retried
+			if retry != nil {
+				retry.Stop()
+			}
+			sha, attempt = next, 0
+		case <-retryC:
+		}
+
+		retry = nil
+		attempt++
+
+		err := l.reconcile(ctx, r, sha)
+		if err == nil || syncd.IsPermanent(err) || ctx.Err() != nil {
+			continue
+		}
+
+		delay := l.backoff(attempt)
+		l.logger.Log("event", "commit.retry", "commit", sha, "attempt", attempt, "delay", delay.String())
+
+		retry = time.NewTimer(delay)
+	}
+}
+
+func (l *WebhookListener) reconcile(ctx context.Context, r syncd.RegistryChartReconciler, sha string) error {
+	start := time.Now()
+
+	err := reconcileChart(ctx, l.downloader, r, l.repository, l.path, sha)
+
+	status := "success"
+	if err != nil {
+		status = "failure"
+		l.logger.Log("error", err, "commit", sha)
+	}
+
+	l.timer.With("status", status).Observe(time.Since(start).Seconds() * 1000)
+
+	return err
+}
+
+// backoff is the delay before a failed push's retry. It doubles with every
+// attempt, up to the maximum delay.
+func (l *WebhookListener) backoff(attempt int) time.Duration {
+	d := l.retryBase << uint(attempt-1)
+	if d <= 0 || d > l.retryMax {
+		return l.retryMax
+	}
+
+	return d
+}
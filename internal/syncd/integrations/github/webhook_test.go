@@ -0,0 +1,216 @@
+package github
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"k8s.io/helm/pkg/chartutil"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+)
+
+const testSecret = "s3cr3t"
+
+func newTestWebhookListener(d githubDownloader) *WebhookListener {
+	l := NewWebhookListener(log.NewNopLogger(), discard.NewHistogram(), "org", nil, ":0", testSecret, "operations", "master", "/charts/registry/")
+	l.downloader = d
+	return l
+}
+
+func webhookRequest(event, body, secret string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-GitHub-Event", event)
+
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(body))
+	req.Header.Set("X-Hub-Signature", "sha1="+hex.EncodeToString(mac.Sum(nil)))
+
+	return req
+}
+
+func pushPayload(ref string, files ...string) string {
+	quoted := make([]string, 0, len(files))
+	for _, f := range files {
+		quoted = append(quoted, `"`+f+`"`)
+	}
+
+	return `{
+  "ref": "` + ref + `",
+  "after": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c",
+  "repository": {"name": "operations", "full_name": "org/operations"},
+  "commits": [{"id": "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c", "added": [], "removed": [], "modified": [` + strings.Join(quoted, ", ") + `]}]
+}`
+}
+
+func TestWebhookFilters(t *testing.T) {
+	l := newTestWebhookListener(new(mockDownloader))
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		status int
+	}{
+		{"invalid signature", webhookRequest("push", pushPayload("refs/heads/master", "charts/registry/values.yaml"), "wrong"), http.StatusUnauthorized},
+		{"ping", webhookRequest("ping", `{"zen": "Keep it logically awesome."}`, testSecret), http.StatusNoContent},
+		{"other branch", webhookRequest("push", pushPayload("refs/heads/feature", "charts/registry/values.yaml"), testSecret), http.StatusNoContent},
+		{"other owner", webhookRequest("push", strings.Replace(pushPayload("refs/heads/master", "charts/registry/values.yaml"), "org/operations", "fork/operations", 1), testSecret), http.StatusNoContent},
+		{"other files", webhookRequest("push", pushPayload("refs/heads/master", "charts/registry-old/values.yaml", "README.md"), testSecret), http.StatusNoContent},
+		{"chart changed", webhookRequest("push", pushPayload("refs/heads/master", "README.md", "charts/registry/templates/foo.yaml"), testSecret), http.StatusAccepted},
+	}
+
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		l.ServeHTTP(w, test.req)
+		assert.Equal(t, test.status, w.Code, test.name)
+	}
+
+	assert.Equal(t, "0d1a26e67d8f5eaf1f6ba5c57fc3c7d91ac0fd1c", <-l.pending)
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	l := newTestWebhookListener(new(mockDownloader))
+	l.secret = ""
+
+	w := httptest.NewRecorder()
+	l.ServeHTTP(w, webhookRequest("push", pushPayload("refs/heads/master", "charts/registry/values.yaml"), ""))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestWebhookCoalescesPushes(t *testing.T) {
+	l := newTestWebhookListener(new(mockDownloader))
+
+	l.enqueue("first")
+	l.enqueue("second")
+
+	assert.Equal(t, "second", <-l.pending)
+	assert.Len(t, l.pending, 0)
+}
+
+func TestWebhookReconcilesPush(t *testing.T) {
+	downloader := new(mockDownloader)
+	reconciler := new(mockReconciler)
+
+	l := newTestWebhookListener(downloader)
+
+	files := []*chartutil.BufferedFile{
+		{Name: "Chart.yaml", Data: []byte("name: registry\nversion: 0.1.0\n")},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	downloader.On("BufferDirectory", ctx, "operations", "charts/registry", "sha").Return(files, nil)
+	reconciler.On("Reconcile", ctx, mock.AnythingOfType("*chart.Chart")).Run(func(args mock.Arguments) {
+		assert.Equal(t, "registry", args.Get(1).(*chart.Chart).GetMetadata().GetName())
+		cancel()
+	}).Return(nil)
+
+	l.enqueue("sha")
+	l.reconcileLoop(ctx, reconciler)
+
+	downloader.AssertExpectations(t)
+	reconciler.AssertExpectations(t)
+}
+
+func TestWebhookRetriesFailedPush(t *testing.T) {
+	downloader := new(mockDownloader)
+	reconciler := new(mockReconciler)
+
+	l := newTestWebhookListener(downloader)
+	l.retryBase = time.Millisecond
+
+	files := []*chartutil.BufferedFile{
+		{Name: "Chart.yaml", Data: []byte("name: registry\nversion: 0.1.0\n")},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	downloader.On("BufferDirectory", ctx, "operations", "charts/registry", "sha").Return(nil, errors.New("rate limited")).Once()
+	downloader.On("BufferDirectory", ctx, "operations", "charts/registry", "sha").Return(files, nil).Once()
+	reconciler.On("Reconcile", ctx, mock.AnythingOfType("*chart.Chart")).Run(func(mock.Arguments) {
+		cancel()
+	}).Return(nil)
+
+	l.enqueue("sha")
+	l.reconcileLoop(ctx, reconciler)
+
+	downloader.AssertExpectations(t)
+	reconciler.AssertExpectations(t)
+}
+
+func TestWebhookRetryReplacedByNewerPush(t *testing.T) {
+	downloader := new(mockDownloader)
+	reconciler := new(mockReconciler)
+
+	l := newTestWebhookListener(downloader)
+	l.retryBase = time.Hour
+
+	files := []*chartutil.BufferedFile{
+		{Name: "Chart.yaml", Data: []byte("name: registry\nversion: 0.1.0\n")},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the newer push arrives while the failed one waits to be retried
+	downloader.On("BufferDirectory", ctx, "operations", "charts/registry", "old").Return(nil, errors.New("rate limited")).Run(func(mock.Arguments) {
+		l.enqueue("new")
+	}).Once()
+	downloader.On("BufferDirectory", ctx, "operations", "charts/registry", "new").Return(files, nil).Once()
+	reconciler.On("Reconcile", ctx, mock.AnythingOfType("*chart.Chart")).Run(func(mock.Arguments) {
+		cancel()
+	}).Return(nil)
+
+	l.enqueue("old")
+	l.reconcileLoop(ctx, reconciler)
+
+	downloader.AssertExpectations(t)
+	reconciler.AssertExpectations(t)
+}
+
+func TestWebhookDoesntRetryPermanentFailure(t *testing.T) {
+	downloader := new(mockDownloader)
+
+	l := newTestWebhookListener(downloader)
+	l.retryBase = time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// the chart at the commit is invalid
+	invalid := []*chartutil.BufferedFile{
+		{Name: "values.yaml", Data: []byte("a: b\n")},
+	}
+
+	downloader.On("BufferDirectory", ctx, "operations", "charts/registry", "sha").Return(invalid, nil).Run(func(mock.Arguments) {
+		time.AfterFunc(50*time.Millisecond, cancel)
+	})
+
+	l.enqueue("sha")
+	l.reconcileLoop(ctx, new(mockReconciler))
+
+	downloader.AssertNumberOfCalls(t, "BufferDirectory", 1)
+}
+
+func TestWebhookBackoff(t *testing.T) {
+	l := newTestWebhookListener(new(mockDownloader))
+
+	assert.Equal(t, 5*time.Second, l.backoff(1))
+	assert.Equal(t, 20*time.Second, l.backoff(3))
+	assert.Equal(t, 5*time.Minute, l.backoff(10))
+	assert.Equal(t, 5*time.Minute, l.backoff(100))
+}
@@ -3,6 +3,7 @@ package k8s
 import (
 	"context"
 	"fmt"
+	"sort"
 
 	shipitv1beta1 "ship-it-operator/api/v1beta1"
 	"ship-it/internal/image"
@@ -14,6 +15,7 @@ import (
 	"k8s.io/client-go/rest"
 	toolscache "k8s.io/client-go/tools/cache"
 	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
 const imageRepositoriesIndex = "ImageRepositoriesIndex"
@@ -38,9 +40,21 @@ func eventHandler(indexer toolscache.Indexer) *toolscache.ResourceEventHandlerFu
 // for querying which releases are dependant on a specific image repository.
 type ImageRepositoryInformer struct {
 	indexer toolscache.Indexer
+	synced  toolscache.InformerSynced
 }
 
 func NewInformer(ctx context.Context, ns string) (*ImageRepositoryInformer, error) {
+	c, err := NewCache(ns)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewInformerWithCache(ctx, c)
+}
+
+// NewCache returns an in-cluster cache of the HelmReleases in the namespace,
+// which can be shared by the informer and the promotion listener.
+func NewCache(ns string) (cache.Cache, error) {
 	scheme := runtime.NewScheme()
 	shipitv1beta1.AddToScheme(scheme)
 
@@ -49,15 +63,27 @@ func NewInformer(ctx context.Context, ns string) (*ImageRepositoryInformer, erro
 		return nil, err
 	}
 
-	c, err := cache.New(config, cache.Options{
+	return cache.New(config, cache.Options{
 		Scheme:    scheme,
 		Namespace: ns,
 	})
+}
+
+// NewClient returns an in-cluster client, which the promotion listener uses
+// to mark committed promotions in the HelmReleases' statuses, and the chart
+// reconciler uses to apply the registry chart's HelmReleases.
+func NewClient() (client.Client, error) {
+	scheme := runtime.NewScheme()
+	shipitv1beta1.AddToScheme(scheme)
+
+	config, err := rest.InClusterConfig()
 	if err != nil {
 		return nil, err
 	}
 
-	return NewInformerWithCache(ctx, c)
+	return client.New(config, client.Options{
+		Scheme: scheme,
+	})
 }
 
 func NewInformerWithCache(ctx context.Context, c cache.Cache) (*ImageRepositoryInformer, error) {
@@ -81,7 +107,12 @@ func NewInformerWithCache(ctx context.Context, c cache.Cache) (*ImageRepositoryI
 		return nil, errors.New("repository informer: image repository cache sync failed")
 	}
 
-	return &ImageRepositoryInformer{indexer}, nil
+	return &ImageRepositoryInformer{indexer, informer.HasSynced}, nil
+}
+
+// HasSynced reports whether the informer's cache of releases has synced
+func (i *ImageRepositoryInformer) HasSynced() bool {
+	return i.synced != nil && i.synced()
 }
 
 // Lookup returns the namespaced names of all releases that depend on the given
@@ -107,6 +138,80 @@ func (i *ImageRepositoryInformer) Lookup(image *image.Ref) ([]types.NamespacedNa
 	return names, nil
 }
 
+// Repositories returns the URIs of every image repository that's used by a
+// release.
+func (i *ImageRepositoryInformer) Repositories() []string {
+	var repos []string
+
+	for _, repo := range i.indexer.ListIndexFuncValues(imageRepositoriesIndex) {
+		// the index keeps the repositories of deleted releases
+		if objs, err := i.indexer.ByIndex(imageRepositoriesIndex, repo); err == nil && len(objs) > 0 {
+			repos = append(repos, repo)
+		}
+	}
+
+	sort.Strings(repos)
+	return repos
+}
+
+// TagPolicy returns the release's image tag policy, and its current tag of the
+// given image's repository. The policy of a release that's pinned or opted out
+// of image updates is an image.PinnedPolicy, which allows no tags.
+func (i *ImageRepositoryInformer) TagPolicy(release types.NamespacedName, ref *image.Ref) (image.TagPolicy, string, error) {
+	obj, exists, err := i.indexer.GetByKey(release.String())
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "repository informer: failed to get release \"%s\"", release)
+	}
+
+	hr, ok := obj.(*shipitv1beta1.HelmRelease)
+	if !exists || !ok {
+		return nil, "", fmt.Errorf("repository informer: release \"%s\" not found", release)
+	}
+
+	if reason := pinReason(hr); reason != "" {
+		return image.PinnedPolicy{Reason: reason}, imageTag(hr, ref.URI()), nil
+	}
+
+	policy, err := image.ParseTagPolicy(hr.Annotations().TagPolicy())
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "repository informer: release \"%s\"", release)
+	}
+
+	return policy, imageTag(hr, ref.URI()), nil
+}
+
+// pinReason describes why the release's images aren't updated by pushes, or
+// returns "" if they are
+func pinReason(hr *shipitv1beta1.HelmRelease) string {
+	annotations := hr.Annotations()
+
+	if !annotations.ImageSync() {
+		return "opted out of image sync"
+	}
+
+	if by := annotations.PinnedBy(); by != "" {
+		if reason := annotations.PinReason(); reason != "" {
+			return fmt.Sprintf("pinned by %s: %s", by, reason)
+		}
+		return "pinned by " + by
+	}
+
+	return ""
+}
+
+// imageTag returns the release's tag of the image repository
+func imageTag(hr *shipitv1beta1.HelmRelease, repository string) string {
+	var tag string
+
+	unstructured.FindAll(hr.HelmValues(), "image", func(x interface{}) {
+		if ref, err := image.FromValue(x); err == nil && ref.URI() == repository {
+			tag = ref.Tag
+		}
+	})
+
+	return tag
+}
+
 func imageRepositoriesIndexFunc(obj interface{}) ([]string, error) {
 	if hr, ok := obj.(*shipitv1beta1.HelmRelease); ok {
 		return imageRepositories(hr), nil
@@ -117,11 +222,14 @@ func imageRepositoriesIndexFunc(obj interface{}) ([]string, error) {
 func imageRepositories(hr *shipitv1beta1.HelmRelease) []string {
 	var repos []string
 
+	seen := make(map[string]bool)
+
+	// a release can use a repository in several places, e.g. for several
+	// containers, but it's only indexed once
 	unstructured.FindAll(hr.HelmValues(), "image", func(x interface{}) {
-		if img, ok := x.(map[string]interface{}); ok {
-			if repo, ok := img["repository"].(string); ok {
-				repos = append(repos, repo)
-			}
+		if ref, err := image.FromValue(x); err == nil && !seen[ref.URI()] {
+			seen[ref.URI()] = true
+			repos = append(repos, ref.URI())
 		}
 	})
 
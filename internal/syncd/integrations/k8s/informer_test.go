@@ -31,7 +31,7 @@ func TestLookup(t *testing.T) {
 	wordCountsRelease := "word-counts-release"
 
 	testImage := &image.Ref{
-		Registry:   "foo",
+		Registry:   "foo.io",
 		Repository: "word-counts-repo",
 		Tag:        "",
 	}
@@ -83,6 +83,8 @@ func TestLookup(t *testing.T) {
 		assert.Equal(t, names[0], expected)
 	}
 
+	assert.Equal(t, []string{testImage.URI()}, informer.Repositories())
+
 	// modify the release
 	var updatedHR shipitv1beta1.HelmRelease
 	originalHR.DeepCopyInto(&updatedHR)
@@ -105,4 +107,113 @@ func TestLookup(t *testing.T) {
 	if assert.NoError(t, err) {
 		assert.Empty(t, names)
 	}
+	assert.Empty(t, informer.Repositories())
+}
+
+func TestImageRepositories(t *testing.T) {
+	values := map[string]interface{}{
+		"image": map[string]interface{}{
+			"repository": "foo.io/bar",
+			"tag":        "abc",
+		},
+		"worker": map[string]interface{}{
+			"image": map[string]interface{}{
+				"repository": "foo.io/bar",
+				"tag":        "abc",
+			},
+		},
+		"sidecars": []interface{}{
+			map[string]interface{}{
+				"image": "foo.io/team/baz:def",
+			},
+			map[string]interface{}{
+				"image": "not-an-image",
+			},
+		},
+	}
+
+	valuesRaw, err := json.Marshal(values)
+	require.NoError(t, err)
+
+	hr := &shipitv1beta1.HelmRelease{
+		Spec: shipitv1beta1.HelmReleaseSpec{
+			Values: runtime.RawExtension{
+				Raw: valuesRaw,
+			},
+		},
+	}
+
+	assert.ElementsMatch(t, []string{"foo.io/bar", "foo.io/team/baz"}, imageRepositories(hr))
+	assert.Equal(t, "def", imageTag(hr, "foo.io/team/baz"))
+}
+
+func TestTagPolicy(t *testing.T) {
+	fakeCache := newFakeCache()
+
+	fakeInformer, err := fakeCache.FakeInformerForKind(shipitv1beta1.Kind("HelmRelease"))
+	require.NoError(t, err)
+
+	informer, err := NewInformerWithCache(context.Background(), fakeCache)
+	require.NoError(t, err)
+
+	valuesRaw, err := json.Marshal(map[string]interface{}{
+		"image": map[string]interface{}{
+			"repository": "foo.io/bar",
+			"tag":        "1.2.3",
+		},
+	})
+	require.NoError(t, err)
+
+	newRelease := func(name, policy string, annotations ...string) *shipitv1beta1.HelmRelease {
+		hr := &shipitv1beta1.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: v1.NamespaceDefault,
+				Annotations: map[string]string{
+					"helmreleases.shipit.wattpad.com/tag-policy": policy,
+				},
+			},
+			Spec: shipitv1beta1.HelmReleaseSpec{
+				Values: runtime.RawExtension{Raw: valuesRaw},
+			},
+		}
+
+		for i := 0; i < len(annotations); i += 2 {
+			hr.ObjectMeta.Annotations["helmreleases.shipit.wattpad.com/"+annotations[i]] = annotations[i+1]
+		}
+
+		return hr
+	}
+
+	fakeInformer.Add(newRelease("semver", "semver:~1.2"))
+	fakeInformer.Add(newRelease("invalid", "oldest"))
+	fakeInformer.Add(newRelease("pinned", "newest", "pinned-by", "jane", "pin-reason", "investigating"))
+	fakeInformer.Add(newRelease("opted-out", "oldest", "image-sync", "false"))
+
+	ref := &image.Ref{Registry: "foo.io", Repository: "bar", Tag: "1.2.4"}
+
+	policy, current, err := informer.TagPolicy(types.NamespacedName{Namespace: v1.NamespaceDefault, Name: "semver"}, ref)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1.2.3", current)
+		assert.True(t, policy.Allows(ref.Tag, current))
+		assert.False(t, policy.Allows("1.3.0", current))
+	}
+
+	_, _, err = informer.TagPolicy(types.NamespacedName{Namespace: v1.NamespaceDefault, Name: "invalid"}, ref)
+	assert.Error(t, err)
+
+	_, _, err = informer.TagPolicy(types.NamespacedName{Namespace: v1.NamespaceDefault, Name: "missing"}, ref)
+	assert.Error(t, err)
+
+	// pins override the release's policy, even if it's invalid
+	policy, current, err = informer.TagPolicy(types.NamespacedName{Namespace: v1.NamespaceDefault, Name: "pinned"}, ref)
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.PinnedPolicy{Reason: "pinned by jane: investigating"}, policy)
+		assert.Equal(t, "1.2.3", current)
+	}
+
+	policy, _, err = informer.TagPolicy(types.NamespacedName{Namespace: v1.NamespaceDefault, Name: "opted-out"}, ref)
+	if assert.NoError(t, err) {
+		assert.Equal(t, image.PinnedPolicy{Reason: "opted out of image sync"}, policy)
+	}
 }
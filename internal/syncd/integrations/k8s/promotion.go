@@ -0,0 +1,211 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+const defaultPromotionRetryDelay = 30 * time.Second
+
+// PromotionListener watches HelmReleases that are promoted using the commit
+// strategy, and emits their pending promotions. Each pending promotion is
+// reconciled once, unless reconciling it fails. Reconciled promotions are
+// marked as committed in the HelmRelease's status, so that they aren't
+// committed again after a restart.
+type PromotionListener struct {
+	client     client.Client
+	logger     log.Logger
+	retryDelay time.Duration
+
+	mu      sync.Mutex
+	pending map[types.NamespacedName]*syncd.Promotion
+	handled map[types.NamespacedName]string
+	notify  chan struct{}
+}
+
+func NewPromotionListener(l log.Logger, c cache.Cache, cl client.Client) (*PromotionListener, error) {
+	informer, err := c.GetInformerForKind(shipitv1beta1.Kind("HelmRelease"))
+	if err != nil {
+		return nil, err
+	}
+
+	listener := &PromotionListener{
+		client:     cl,
+		logger:     log.With(l, "worker", "promotion"),
+		retryDelay: defaultPromotionRetryDelay,
+		pending:    make(map[types.NamespacedName]*syncd.Promotion),
+		handled:    make(map[types.NamespacedName]string),
+		notify:     make(chan struct{}, 1),
+	}
+
+	informer.AddEventHandler(toolscache.ResourceEventHandlerFuncs{
+		AddFunc: listener.enqueue,
+		UpdateFunc: func(_, newObj interface{}) {
+			listener.enqueue(newObj)
+		},
+	})
+
+	return listener, nil
+}
+
+func (l *PromotionListener) Listen(ctx context.Context, r syncd.PromotionReconciler) error {
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-l.notify:
+		}
+
+		for _, p := range l.drain() {
+			if err := r.Reconcile(ctx, p); err != nil {
+				l.logger.Log("error", err, "release", p.Release.String())
+				l.retry(p)
+				continue
+			}
+
+			l.logger.Log("info", fmt.Sprintf("promoted %s from %s", p.Release, p.Upstream))
+
+			// reconciling the promotion again is a no-op, so marking it
+			// is retried the same way
+			if err := l.committed(ctx, p); err != nil {
+				l.logger.Log("error", err, "release", p.Release.String())
+				l.retry(p)
+			}
+		}
+	}
+}
+
+func (l *PromotionListener) enqueue(obj interface{}) {
+	hr, ok := obj.(*shipitv1beta1.HelmRelease)
+	if !ok {
+		return
+	}
+
+	p, ok := l.promotion(hr)
+	if !ok || !hr.Status.Promotion.CommittedAt.IsZero() {
+		return
+	}
+
+	key := fingerprint(p)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.handled[p.Release] == key {
+		return
+	}
+
+	l.handled[p.Release] = key
+	l.pending[p.Release] = p
+
+	select {
+	case l.notify <- struct{}{}:
+	default:
+	}
+}
+
+func (l *PromotionListener) drain() []*syncd.Promotion {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	promotions := make([]*syncd.Promotion, 0, len(l.pending))
+	for name, p := range l.pending {
+		promotions = append(promotions, p)
+		delete(l.pending, name)
+	}
+
+	return promotions
+}
+
+// retry forgets a failed promotion, and reconciles it again after a delay
+// unless a newer promotion has replaced it.
+func (l *PromotionListener) retry(p *syncd.Promotion) {
+	key := fingerprint(p)
+
+	time.AfterFunc(l.retryDelay, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+
+		if l.handled[p.Release] != key {
+			return
+		}
+
+		l.pending[p.Release] = p
+
+		select {
+		case l.notify <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// promotion returns the pending promotion of a release that's promoted using
+// the commit strategy.
+func (l *PromotionListener) promotion(hr *shipitv1beta1.HelmRelease) (*syncd.Promotion, bool) {
+	spec, status := hr.Spec.Promotion, hr.Status.Promotion
+
+	if spec == nil || spec.GetStrategy() != shipitv1beta1.PromotionStrategyCommit {
+		return nil, false
+	}
+
+	if status == nil || status.Phase != shipitv1beta1.PromotionPending {
+		return nil, false
+	}
+
+	p := &syncd.Promotion{
+		Release: types.NamespacedName{
+			Name:      hr.GetName(),
+			Namespace: hr.GetNamespace(),
+		},
+		Upstream:     status.Upstream,
+		ChartVersion: status.ChartVersion,
+	}
+
+	for _, img := range status.Images {
+		ref, err := image.Parse(img.Repository, img.Tag)
+		if err != nil {
+			l.logger.Log("error", err, "release", p.Release.String())
+			continue
+		}
+
+		p.Images = append(p.Images, *ref)
+	}
+
+	return p, true
+}
+
+// committed marks the promotion as committed in its HelmRelease's status,
+// unless the operator has replaced it with a newer promotion.
+func (l *PromotionListener) committed(ctx context.Context, p *syncd.Promotion) error {
+	hr := new(shipitv1beta1.HelmRelease)
+	if err := l.client.Get(ctx, p.Release, hr); err != nil {
+		return errors.Wrap(err, "failed to get HelmRelease")
+	}
+
+	current, ok := l.promotion(hr)
+	if !ok || fingerprint(current) != fingerprint(p) {
+		return nil
+	}
+
+	hr.Status.Promotion.CommittedAt = metav1.Now()
+
+	return errors.Wrap(l.client.Status().Update(ctx, hr), "failed to update HelmRelease status")
+}
+
+func fingerprint(p *syncd.Promotion) string {
+	return fmt.Sprintf("%s %s %v", p.Upstream, p.ChartVersion, p.Images)
+}
@@ -0,0 +1,121 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+type promotionReconcilerFunc func(context.Context, *syncd.Promotion) error
+
+func (f promotionReconcilerFunc) Reconcile(ctx context.Context, p *syncd.Promotion) error {
+	return f(ctx, p)
+}
+
+func TestPromotionListener(t *testing.T) {
+	fakeCache := newFakeCache()
+
+	fakeInformer, err := fakeCache.FakeInformerForKind(shipitv1beta1.Kind("HelmRelease"))
+	require.NoError(t, err)
+
+	hr := &shipitv1beta1.HelmRelease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "production",
+			Namespace: v1.NamespaceDefault,
+		},
+		Spec: shipitv1beta1.HelmReleaseSpec{
+			Promotion: &shipitv1beta1.PromotionSpec{
+				Upstream: "staging",
+				Strategy: shipitv1beta1.PromotionStrategyCommit,
+			},
+		},
+		Status: shipitv1beta1.HelmReleaseStatus{
+			Promotion: &shipitv1beta1.PromotionStatus{
+				Upstream:     "staging",
+				Phase:        shipitv1beta1.PromotionPending,
+				ChartVersion: "1.1.0",
+				Images: []shipitv1beta1.PromotedImage{
+					{
+						Repository: "foo.io/bar",
+						Tag:        "newtag",
+					},
+				},
+			},
+		},
+	}
+
+	// resource strategy promotions are applied by the operator
+	resource := hr.DeepCopy()
+	resource.SetName("resource")
+	resource.Spec.Promotion.Strategy = shipitv1beta1.PromotionStrategyResource
+
+	// committed promotions were reconciled before a restart
+	committed := hr.DeepCopy()
+	committed.SetName("committed")
+	committed.Status.Promotion.CommittedAt = metav1.Now()
+
+	fakeClient := fake.NewFakeClientWithScheme(fakeCache.Scheme, hr.DeepCopy())
+
+	listener, err := NewPromotionListener(log.NewNopLogger(), fakeCache, fakeClient)
+	require.NoError(t, err)
+
+	fakeInformer.Add(resource)
+	fakeInformer.Add(committed)
+	fakeInformer.Add(hr)
+
+	// duplicate events are ignored
+	fakeInformer.Update(hr, hr.DeepCopy())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	promotions := make(chan *syncd.Promotion, 2)
+
+	go listener.Listen(ctx, promotionReconcilerFunc(func(_ context.Context, p *syncd.Promotion) error {
+		promotions <- p
+		return nil
+	}))
+
+	expected := &syncd.Promotion{
+		Release: types.NamespacedName{
+			Name:      "production",
+			Namespace: v1.NamespaceDefault,
+		},
+		Upstream:     "staging",
+		ChartVersion: "1.1.0",
+		Images: []image.Ref{
+			{
+				Registry:   "foo.io",
+				Repository: "bar",
+				Tag:        "newtag",
+			},
+		},
+	}
+
+	assert.Equal(t, expected, <-promotions)
+
+	// the promotion is marked as committed once it's reconciled
+	var marked shipitv1beta1.HelmRelease
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		require.NoError(t, fakeClient.Get(ctx, expected.Release, &marked))
+		if !marked.Status.Promotion.CommittedAt.IsZero() {
+			break
+		}
+	}
+	assert.False(t, marked.Status.Promotion.CommittedAt.IsZero())
+
+	cancel()
+	assert.Empty(t, promotions)
+}
@@ -0,0 +1,148 @@
+package registry
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"ship-it/internal/image"
+
+	"github.com/pkg/errors"
+)
+
+// envelope is a Docker Registry v2 notification envelope. See
+// https://docs.docker.com/registry/notifications/
+type envelope struct {
+	Events []struct {
+		Action    string    `json:"action"`
+		Timestamp time.Time `json:"timestamp"`
+		Target    struct {
+			MediaType  string `json:"mediaType"`
+			Digest     string `json:"digest"`
+			Repository string `json:"repository"`
+			Tag        string `json:"tag"`
+		} `json:"target"`
+		Request struct {
+			Host string `json:"host"`
+		} `json:"request"`
+	} `json:"events"`
+}
+
+// images returns the tagged manifests pushed to the registry. Pushes of
+// layers, and of manifests by digest, aren't releasable images.
+func (e envelope) images() []*image.Ref {
+	var refs []*image.Ref
+
+	for _, event := range e.Events {
+		if event.Action != "push" || event.Target.Tag == "" {
+			continue
+		}
+
+		if !strings.Contains(event.Target.MediaType, "manifest") {
+			continue
+		}
+
+		refs = append(refs, &image.Ref{
+			Registry:   event.Request.Host,
+			Repository: event.Target.Repository,
+			Tag:        event.Target.Tag,
+			Digest:     event.Target.Digest,
+			PushedAt:   event.Timestamp,
+		})
+	}
+
+	return refs
+}
+
+// dockerHubWebhook is a Docker Hub repository webhook. See
+// https://docs.docker.com/docker-hub/webhooks/
+type dockerHubWebhook struct {
+	PushData struct {
+		Tag      string `json:"tag"`
+		PushedAt int64  `json:"pushed_at"`
+	} `json:"push_data"`
+	Repository struct {
+		RepoName string `json:"repo_name"`
+	} `json:"repository"`
+}
+
+func (w dockerHubWebhook) images() []*image.Ref {
+	if w.PushData.Tag == "" || w.Repository.RepoName == "" {
+		return nil
+	}
+
+	// official images are normalized into the "library" namespace
+	ref, err := image.Parse(image.DockerHub+"/"+w.Repository.RepoName, w.PushData.Tag)
+	if err != nil {
+		return nil
+	}
+
+	ref.PushedAt = unixTime(w.PushData.PushedAt)
+
+	return []*image.Ref{ref}
+}
+
+// harborWebhook is a Harbor webhook. Harbor 1.x sends "pushImage" events,
+// and Harbor 2.x sends "PUSH_ARTIFACT" events.
+type harborWebhook struct {
+	Type      string `json:"type"`
+	OccurAt   int64  `json:"occur_at"`
+	EventData struct {
+		Resources []struct {
+			Digest      string `json:"digest"`
+			Tag         string `json:"tag"`
+			ResourceURL string `json:"resource_url"`
+		} `json:"resources"`
+		Repository struct {
+			RepoFullName string `json:"repo_full_name"`
+		} `json:"repository"`
+	} `json:"event_data"`
+}
+
+func (w harborWebhook) images() []*image.Ref {
+	if w.Type != "pushImage" && w.Type != "PUSH_ARTIFACT" {
+		return nil
+	}
+
+	var refs []*image.Ref
+
+	for _, r := range w.EventData.Resources {
+		// the resource URL is "<registry>/<repository>:<tag>"
+		registry := strings.SplitN(r.ResourceURL, "/", 2)[0]
+		if r.Tag == "" || registry == "" {
+			continue
+		}
+
+		refs = append(refs, &image.Ref{
+			Registry:   registry,
+			Repository: w.EventData.Repository.RepoFullName,
+			Tag:        r.Tag,
+			Digest:     r.Digest,
+			PushedAt:   unixTime(w.OccurAt),
+		})
+	}
+
+	return refs
+}
+
+// unixTime converts a webhook's Unix time, which is zero when it's missing
+func unixTime(sec int64) time.Time {
+	if sec == 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0).UTC()
+}
+
+type payload interface {
+	images() []*image.Ref
+}
+
+// parse parses the body of a registry's notification as the given kind of
+// payload, returning the pushed images.
+func parse(body []byte, p payload) ([]*image.Ref, error) {
+	if err := json.Unmarshal(body, p); err != nil {
+		return nil, errors.Wrap(err, "failed to parse registry notification")
+	}
+
+	return p.images(), nil
+}
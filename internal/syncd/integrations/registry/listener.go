@@ -0,0 +1,163 @@
+package registry
+
+import (
+	"context"
+	"crypto/subtle"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-chi/chi"
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+)
+
+// maxBodySize limits the size of the notifications that are read
+const maxBodySize = 1 << 20
+
+// ImageListener receives image push notifications from Docker Registry v2,
+// Docker Hub and Harbor webhooks. Each registry posts to its own path:
+//
+//	/registry    Docker Registry v2 notification envelopes
+//	/dockerhub   Docker Hub webhooks
+//	/harbor      Harbor webhooks
+//
+// Notifications are authenticated with a shared secret, sent either as the
+// Authorization header ("Bearer <secret>", or the secret itself), or as the
+// "secret" query parameter for registries that can't set headers.
+//
+// A notification's images are reconciled before it's answered, so a failure
+// is answered with an error status, and the registry redelivers it.
+type ImageListener struct {
+	logger log.Logger
+	timer  metrics.Histogram
+	addr   string
+	secret string
+}
+
+func NewListener(l log.Logger, h metrics.Histogram, addr, secret string) *ImageListener {
+	return &ImageListener{
+		logger: log.With(l, "worker", "registry"),
+		timer:  h.With("worker", "registry"),
+		addr:   addr,
+		secret: secret,
+	}
+}
+
+func (l *ImageListener) Listen(ctx context.Context, r syncd.ImageReconciler) error {
+	srv := &http.Server{
+		Addr:    l.addr,
+		Handler: l.router(r),
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return errors.Wrap(err, "registry listener failed")
+	case <-ctx.Done():
+	}
+
+	shutdown, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// notifications that are being reconciled are finished
+	srv.Shutdown(shutdown)
+
+	return ctx.Err()
+}
+
+func (l *ImageListener) router(r syncd.ImageReconciler) http.Handler {
+	router := chi.NewRouter()
+
+	router.Post("/registry", l.handler(r, func() payload { return new(envelope) }))
+	router.Post("/dockerhub", l.handler(r, func() payload { return new(dockerHubWebhook) }))
+	router.Post("/harbor", l.handler(r, func() payload { return new(harborWebhook) }))
+
+	return router
+}
+
+// handler reconciles the images of a registry's notifications, in the order
+// they're listed. A notification that fails to reconcile is answered with a
+// server error, so that the registry retries it, unless the failure is
+// permanent.
+func (l *ImageListener) handler(r syncd.ImageReconciler, newPayload func() payload) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if !l.authorized(req) {
+			http.Error(w, "invalid secret", http.StatusUnauthorized)
+			return
+		}
+
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxBodySize))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		images, err := parse(body, newPayload())
+		if err != nil {
+			l.logger.Log("error", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		var failed error
+
+		for _, img := range images {
+			if err := l.reconcile(req.Context(), r, img); err != nil && failed == nil {
+				failed = err
+			}
+		}
+
+		switch {
+		case syncd.IsPermanent(failed):
+			http.Error(w, failed.Error(), http.StatusUnprocessableEntity)
+		case failed != nil:
+			http.Error(w, failed.Error(), http.StatusInternalServerError)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}
+}
+
+func (l *ImageListener) reconcile(ctx context.Context, r syncd.ImageReconciler, img *image.Ref) error {
+	start := time.Now()
+
+	err := r.Reconcile(ctx, img)
+	if errors.Cause(err) == syncd.ErrNoRegisteredReleasesAffected {
+		l.logger.Log("info", err.Error())
+		err = nil
+	}
+
+	status := "success"
+	if err != nil {
+		status = "failure"
+		l.logger.Log("error", err, "image", img)
+	}
+
+	l.timer.With("status", status).Observe(time.Since(start).Seconds() * 1000)
+
+	return err
+}
+
+func (l *ImageListener) authorized(req *http.Request) bool {
+	if l.secret == "" {
+		return false
+	}
+
+	secret := req.URL.Query().Get("secret")
+
+	if auth := req.Header.Get("Authorization"); auth != "" {
+		secret = strings.TrimPrefix(auth, "Bearer ")
+	}
+
+	return subtle.ConstantTimeCompare([]byte(secret), []byte(l.secret)) == 1
+}
@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeReconciler struct {
+	mu     sync.Mutex
+	images []image.Ref
+}
+
+func (f *fakeReconciler) Reconcile(ctx context.Context, img *image.Ref) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.images = append(f.images, *img)
+	return nil
+}
+
+const registryEnvelope = `{
+  "events": [
+    {
+      "action": "push",
+      "timestamp": "2019-07-11T14:19:59.123Z",
+      "target": {
+        "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+        "digest": "sha256:fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf",
+        "repository": "squad/word-counts",
+        "tag": "1.2.3"
+      },
+      "request": {"host": "registry.example.com"}
+    },
+    {
+      "action": "push",
+      "target": {
+        "mediaType": "application/octet-stream",
+        "digest": "sha256:c3f9b7a8c5f1e7b2d4f6a8c0e2b4d6f8a0c2e4b6d8f0a2c4e6b8d0f2a4c6e8b0",
+        "repository": "squad/word-counts"
+      },
+      "request": {"host": "registry.example.com"}
+    },
+    {
+      "action": "pull",
+      "target": {
+        "mediaType": "application/vnd.docker.distribution.manifest.v2+json",
+        "repository": "squad/word-counts",
+        "tag": "1.2.2"
+      },
+      "request": {"host": "registry.example.com"}
+    }
+  ]
+}`
+
+const dockerHubPayload = `{
+  "push_data": {"pushed_at": 1417566161, "pusher": "trustedbuilder", "tag": "latest"},
+  "repository": {"name": "word-counts", "namespace": "squad", "repo_name": "squad/word-counts"}
+}`
+
+const harborPayload = `{
+  "type": "PUSH_ARTIFACT",
+  "occur_at": 1586922308,
+  "operator": "admin",
+  "event_data": {
+    "resources": [
+      {
+        "digest": "sha256:8a9e9863dbb6e10edb5adfe917c00da84e1700fa76e7ed02476aa6e6fb8ee0d8",
+        "tag": "v1.0.0",
+        "resource_url": "harbor.example.com/squad/word-counts:v1.0.0"
+      }
+    ],
+    "repository": {"name": "word-counts", "namespace": "squad", "repo_full_name": "squad/word-counts"}
+  }
+}`
+
+type reconcilerFunc func(context.Context, *image.Ref) error
+
+func (f reconcilerFunc) Reconcile(ctx context.Context, img *image.Ref) error {
+	return f(ctx, img)
+}
+
+func TestParse(t *testing.T) {
+	images, err := parse([]byte(registryEnvelope), new(envelope))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*image.Ref{
+			{
+				Registry:   "registry.example.com",
+				Repository: "squad/word-counts",
+				Tag:        "1.2.3",
+				Digest:     "sha256:fea8895f450959fa676bcc1df0611ea93823a735a01205fd8622846041d0c7cf",
+				PushedAt:   time.Date(2019, time.July, 11, 14, 19, 59, 123000000, time.UTC),
+			},
+		}, images)
+	}
+
+	images, err = parse([]byte(dockerHubPayload), new(dockerHubWebhook))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*image.Ref{
+			{Registry: "docker.io", Repository: "squad/word-counts", Tag: "latest", PushedAt: time.Unix(1417566161, 0).UTC()},
+		}, images)
+	}
+
+	images, err = parse([]byte(`{"push_data": {"tag": "5"}, "repository": {"repo_name": "redis"}}`), new(dockerHubWebhook))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "docker.io/library/redis:5", images[0].String())
+	}
+
+	images, err = parse([]byte(harborPayload), new(harborWebhook))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []*image.Ref{
+			{
+				Registry:   "harbor.example.com",
+				Repository: "squad/word-counts",
+				Tag:        "v1.0.0",
+				Digest:     "sha256:8a9e9863dbb6e10edb5adfe917c00da84e1700fa76e7ed02476aa6e6fb8ee0d8",
+				PushedAt:   time.Unix(1586922308, 0).UTC(),
+			},
+		}, images)
+	}
+
+	images, err = parse([]byte(`{"type": "DELETE_ARTIFACT"}`), new(harborWebhook))
+	assert.NoError(t, err)
+	assert.Empty(t, images)
+
+	_, err = parse([]byte("not json"), new(envelope))
+	assert.Error(t, err)
+}
+
+func TestListener(t *testing.T) {
+	listener := NewListener(log.NewNopLogger(), discard.NewHistogram(), ":0", "s3cr3t")
+	reconciler := new(fakeReconciler)
+	router := listener.router(reconciler)
+
+	post := func(path, auth, body string) int {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, post("/registry", "", registryEnvelope))
+	assert.Equal(t, http.StatusUnauthorized, post("/registry", "Bearer wrong", registryEnvelope))
+	assert.Equal(t, http.StatusBadRequest, post("/registry", "Bearer s3cr3t", "not json"))
+
+	assert.Equal(t, http.StatusOK, post("/registry", "Bearer s3cr3t", registryEnvelope))
+	assert.Equal(t, http.StatusOK, post("/harbor", "s3cr3t", harborPayload))
+	assert.Equal(t, http.StatusOK, post("/dockerhub?secret=s3cr3t", "", dockerHubPayload))
+
+	var refs []string
+	for _, img := range reconciler.images {
+		refs = append(refs, img.URI()+":"+img.Tag)
+	}
+
+	assert.ElementsMatch(t, []string{
+		"registry.example.com/squad/word-counts:1.2.3",
+		"harbor.example.com/squad/word-counts:v1.0.0",
+		"docker.io/squad/word-counts:latest",
+	}, refs)
+}
+
+func TestListenerWithoutSecret(t *testing.T) {
+	listener := NewListener(log.NewNopLogger(), discard.NewHistogram(), ":0", "")
+
+	req := httptest.NewRequest(http.MethodPost, "/registry", strings.NewReader(registryEnvelope))
+	w := httptest.NewRecorder()
+	listener.router(new(fakeReconciler)).ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestListenerReconcileFailure(t *testing.T) {
+	listener := NewListener(log.NewNopLogger(), discard.NewHistogram(), ":0", "s3cr3t")
+
+	post := func(r syncd.ImageReconciler) int {
+		req := httptest.NewRequest(http.MethodPost, "/registry", strings.NewReader(registryEnvelope))
+		req.Header.Set("Authorization", "Bearer s3cr3t")
+
+		w := httptest.NewRecorder()
+		listener.router(r).ServeHTTP(w, req)
+		return w.Code
+	}
+
+	// the registry retries notifications that fail
+	failing := &failingReconciler{fail: map[string]bool{"1.2.3": true}}
+	assert.Equal(t, http.StatusInternalServerError, post(failing))
+
+	// unless they can't succeed
+	permanent := reconcilerFunc(func(context.Context, *image.Ref) error {
+		return syncd.Permanent(errors.New("invalid chart"))
+	})
+	assert.Equal(t, http.StatusUnprocessableEntity, post(permanent))
+
+	// releases that don't use the image aren't a failure
+	unused := reconcilerFunc(func(context.Context, *image.Ref) error {
+		return syncd.ErrNoRegisteredReleasesAffected
+	})
+	assert.Equal(t, http.StatusOK, post(unused))
+}
+
+func TestListenStopsWithContext(t *testing.T) {
+	listener := NewListener(log.NewNopLogger(), discard.NewHistogram(), "127.0.0.1:0", "s3cr3t")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	assert.Equal(t, context.Canceled, listener.Listen(ctx, new(fakeReconciler)))
+}
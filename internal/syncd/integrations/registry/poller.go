@@ -0,0 +1,346 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/pkg/errors"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// RepositoryIndexer provides access to the image repositories used by the
+// deployed releases, and the releases' tag policies.
+type RepositoryIndexer interface {
+	Repositories() []string
+	Lookup(image *image.Ref) ([]types.NamespacedName, error)
+	TagPolicy(release types.NamespacedName, image *image.Ref) (image.TagPolicy, string, error)
+}
+
+// TagLister lists the tags of an image repository, and tells when a tag was
+// created, which is the zero time if the registry doesn't know
+type TagLister interface {
+	Tags(ctx context.Context, ref *image.Ref) ([]string, error)
+	Created(ctx context.Context, ref *image.Ref) (time.Time, error)
+}
+
+// StateStore persists the tags that the poller has seen, by repository
+type StateStore interface {
+	Load() (map[string][]string, error)
+	Save(map[string][]string) error
+}
+
+// PollingListener polls the registries of the deployed releases' image
+// repositories for new tags. It's used with registries that can't send push
+// notifications.
+//
+// The first poll of a repository only records its tags, so a listener that
+// has lost its state doesn't redeploy old tags. Afterwards, every new tag
+// that's allowed by the tag policy of a release using the repository is
+// reconciled. A tag that isn't allowed is evaluated again by the next poll,
+// so it's reconciled once a release's policy changes to allow it.
+// Registries list tags in lexical order, so the new tags are reconciled in
+// the order their images were created, which is their push time. Tags
+// without creation times, e.g. of reproducible builds, or with the same
+// ones, are reconciled in tag order.
+type PollingListener struct {
+	logger   log.Logger
+	timer    metrics.Histogram
+	index    RepositoryIndexer
+	tags     TagLister
+	store    StateStore
+	interval time.Duration
+}
+
+func NewPollingListener(l log.Logger, h metrics.Histogram, index RepositoryIndexer, tags TagLister, store StateStore, interval time.Duration) *PollingListener {
+	return &PollingListener{
+		logger:   log.With(l, "worker", "poller"),
+		timer:    h.With("worker", "poller"),
+		index:    index,
+		tags:     tags,
+		store:    store,
+		interval: interval,
+	}
+}
+
+func (p *PollingListener) Listen(ctx context.Context, r syncd.ImageReconciler) error {
+	seen, err := p.store.Load()
+	if err != nil {
+		return errors.Wrap(err, "failed to load poller state")
+	}
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	for {
+		p.poll(ctx, r, seen)
+
+		if err := p.store.Save(seen); err != nil {
+			p.logger.Log("error", errors.Wrap(err, "failed to save poller state"))
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// poll reconciles the new tags of every repository, oldest first, and updates
+// the seen tags. A tag that isn't allowed, or fails to reconcile, isn't seen,
+// so it's retried by the next poll.
+func (p *PollingListener) poll(ctx context.Context, r syncd.ImageReconciler, seen map[string][]string) {
+	repositories := p.index.Repositories()
+
+	current := make(map[string]bool, len(repositories))
+
+	for _, repo := range repositories {
+		current[repo] = true
+
+		ref, err := image.Parse(repo, "")
+		if err != nil {
+			continue
+		}
+
+		tags, err := p.tags.Tags(ctx, ref)
+		if err != nil {
+			p.logger.Log("error", err, "repository", repo)
+			continue
+		}
+
+		known, polled := seen[repo]
+		if !polled {
+			seen[repo] = sorted(tags)
+			continue
+		}
+
+		old := make(map[string]bool, len(known))
+		for _, tag := range known {
+			old[tag] = true
+		}
+
+		var (
+			handled []string
+			pushed  []*image.Ref
+		)
+
+		for _, tag := range tags {
+			if old[tag] {
+				handled = append(handled, tag)
+				continue
+			}
+
+			img := *ref
+			img.Tag = tag
+
+			if !p.allowed(&img) {
+				continue
+			}
+
+			// a tag whose creation time can't be read is retried
+			// by the next poll
+			created, err := p.tags.Created(ctx, &img)
+			if err != nil {
+				p.logger.Log("error", err, "image", img)
+				continue
+			}
+
+			img.PushedAt = created
+			pushed = append(pushed, &img)
+		}
+
+		sortPushed(pushed)
+
+		for _, img := range pushed {
+			if p.reconcile(ctx, r, img) {
+				handled = append(handled, img.Tag)
+			}
+		}
+
+		seen[repo] = sorted(handled)
+	}
+
+	// forget the repositories that aren't used anymore
+	for repo := range seen {
+		if !current[repo] {
+			delete(seen, repo)
+		}
+	}
+}
+
+// reconcile reconciles the new image. It reports whether the image was
+// handled.
+func (p *PollingListener) reconcile(ctx context.Context, r syncd.ImageReconciler, img *image.Ref) bool {
+	start := time.Now()
+
+	err := r.Reconcile(ctx, img)
+	if errors.Cause(err) == syncd.ErrNoRegisteredReleasesAffected {
+		p.logger.Log("info", err.Error())
+		err = nil
+	}
+
+	status := "success"
+	if err != nil {
+		status = "failure"
+		p.logger.Log("error", err, "image", img)
+	}
+
+	p.timer.With("status", status).Observe(time.Since(start).Seconds() * 1000)
+
+	return err == nil
+}
+
+// allowed reports whether the tag policy of any release using the image
+// allows it. Releases whose policies can't be evaluated are left to the
+// reconciler.
+func (p *PollingListener) allowed(img *image.Ref) bool {
+	releases, err := p.index.Lookup(img)
+	if err != nil {
+		return true
+	}
+
+	for _, release := range releases {
+		policy, current, err := p.index.TagPolicy(release, img)
+		if err != nil || policy.Allows(img.Tag, current) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortPushed sorts the images by their push times, or by their tags when the
+// times are tied, or aren't all known
+func sortPushed(images []*image.Ref) {
+	dated := true
+	for _, img := range images {
+		if img.PushedAt.IsZero() {
+			dated = false
+		}
+	}
+
+	sort.SliceStable(images, func(i, j int) bool {
+		a, b := images[i], images[j]
+		if dated && !a.PushedAt.Equal(b.PushedAt) {
+			return a.PushedAt.Before(b.PushedAt)
+		}
+		return tagLess(a.Tag, b.Tag)
+	})
+}
+
+// tagLess compares tags in natural order, comparing their runs of digits as
+// numbers, so v9 is before v10
+func tagLess(a, b string) bool {
+	for a != "" && b != "" {
+		x, y := leadingRun(a), leadingRun(b)
+		a, b = a[len(x):], b[len(y):]
+
+		if x == y {
+			continue
+		}
+
+		if isDigit(x[0]) && isDigit(y[0]) {
+			nx, ny := strings.TrimLeft(x, "0"), strings.TrimLeft(y, "0")
+			if len(nx) != len(ny) {
+				return len(nx) < len(ny)
+			}
+			if nx != ny {
+				return nx < ny
+			}
+			continue
+		}
+
+		return x < y
+	}
+
+	return len(a) < len(b)
+}
+
+// leadingRun returns the leading digits of a non-empty string, or else its
+// leading non-digits
+func leadingRun(s string) string {
+	digit := isDigit(s[0])
+
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digit {
+		i++
+	}
+
+	return s[:i]
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func sorted(tags []string) []string {
+	s := append([]string{}, tags...)
+	sort.Strings(s)
+	return s
+}
+
+// FileStore persists the poller's state as a JSON file
+type FileStore struct {
+	path string
+}
+
+func NewFileStore(path string) *FileStore {
+	return &FileStore{path}
+}
+
+// Load returns the saved state, which is empty if none has been saved
+func (s *FileStore) Load() (map[string][]string, error) {
+	state := make(map[string][]string)
+
+	data, err := ioutil.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &state); err != nil {
+		// the poller can't start until the state is fixed or removed
+		return nil, syncd.Permanent(errors.Wrapf(err, "invalid poller state in %s", s.path))
+	}
+
+	return state, nil
+}
+
+// Save replaces the saved state. The state is written to a temporary file
+// first, so a failed write doesn't corrupt the previous state.
+func (s *FileStore) Save(state map[string][]string) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
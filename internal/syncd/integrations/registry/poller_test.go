@@ -0,0 +1,203 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ship-it/internal/image"
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics/discard"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type fakeIndex struct {
+	repositories []string
+	policy       image.TagPolicy
+}
+
+func (f *fakeIndex) Repositories() []string {
+	return f.repositories
+}
+
+func (f *fakeIndex) Lookup(img *image.Ref) ([]types.NamespacedName, error) {
+	return []types.NamespacedName{{Namespace: "default", Name: "word-counts"}}, nil
+}
+
+func (f *fakeIndex) TagPolicy(release types.NamespacedName, img *image.Ref) (image.TagPolicy, string, error) {
+	return f.policy, "", nil
+}
+
+type fakeTags struct {
+	tags    map[string][]string
+	created map[string]time.Time
+}
+
+func (f fakeTags) Tags(ctx context.Context, ref *image.Ref) ([]string, error) {
+	tags, ok := f.tags[ref.URI()]
+	if !ok {
+		return nil, fmt.Errorf("repository %s not found", ref.URI())
+	}
+	return tags, nil
+}
+
+func (f fakeTags) Created(ctx context.Context, ref *image.Ref) (time.Time, error) {
+	created, ok := f.created[ref.Tag]
+	if !ok {
+		return time.Time{}, fmt.Errorf("image %s not found", ref)
+	}
+	return created, nil
+}
+
+type failingReconciler struct {
+	fakeReconciler
+	fail map[string]bool
+}
+
+func (f *failingReconciler) Reconcile(ctx context.Context, img *image.Ref) error {
+	if f.fail[img.Tag] {
+		return fmt.Errorf("failed to reconcile %s", img)
+	}
+	return f.fakeReconciler.Reconcile(ctx, img)
+}
+
+func TestPoll(t *testing.T) {
+	policy, err := image.ParseTagPolicy("regex:^v")
+	require.NoError(t, err)
+
+	index := &fakeIndex{
+		repositories: []string{"registry.io/foo", "registry.io/bar"},
+		policy:       policy,
+	}
+	tags := fakeTags{
+		tags: map[string][]string{
+			"registry.io/foo": {"v1"},
+		},
+		created: make(map[string]time.Time),
+	}
+
+	reconciler := &failingReconciler{fail: map[string]bool{"v3": true}}
+
+	p := NewPollingListener(log.NewNopLogger(), discard.NewHistogram(), index, tags, nil, 0)
+
+	seen := map[string][]string{
+		"registry.io/removed": {"v1"},
+	}
+
+	// the first poll only records the tags
+	p.poll(context.Background(), reconciler, seen)
+	assert.Equal(t, map[string][]string{"registry.io/foo": {"v1"}}, seen)
+	assert.Empty(t, reconciler.images)
+
+	created := time.Date(2019, time.July, 11, 14, 19, 59, 0, time.UTC)
+	tags.created["v2"] = created
+	tags.created["v10"] = created.Add(time.Minute)
+	tags.created["v3"] = created.Add(2 * time.Minute)
+
+	// new tags are reconciled if they're allowed, in the order they were
+	// created
+	tags.tags["registry.io/foo"] = []string{"latest", "v1", "v10", "v2", "v3", "v4"}
+	p.poll(context.Background(), reconciler, seen)
+
+	assert.Equal(t, []image.Ref{
+		{Registry: "registry.io", Repository: "foo", Tag: "v2", PushedAt: created},
+		{Registry: "registry.io", Repository: "foo", Tag: "v10", PushedAt: created.Add(time.Minute)},
+	}, reconciler.images)
+
+	// the failed tag, the tag whose creation time can't be read, and the
+	// tag that isn't allowed are retried by the next poll
+	assert.Equal(t, map[string][]string{"registry.io/foo": {"v1", "v10", "v2"}}, seen)
+
+	delete(reconciler.fail, "v3")
+	tags.created["v4"] = created.Add(3 * time.Minute)
+	p.poll(context.Background(), reconciler, seen)
+
+	assert.Len(t, reconciler.images, 4)
+	assert.Equal(t, "v3", reconciler.images[2].Tag)
+	assert.Equal(t, "v4", reconciler.images[3].Tag)
+
+	// the tag is reconciled once the policy allows it
+	index.policy, err = image.ParseTagPolicy("regex:.")
+	require.NoError(t, err)
+	tags.created["latest"] = created.Add(4 * time.Minute)
+	p.poll(context.Background(), reconciler, seen)
+
+	assert.Len(t, reconciler.images, 5)
+	assert.Equal(t, "latest", reconciler.images[4].Tag)
+	assert.Equal(t, map[string][]string{"registry.io/foo": {"latest", "v1", "v10", "v2", "v3", "v4"}}, seen)
+}
+
+func TestSortPushed(t *testing.T) {
+	created := time.Date(2019, time.July, 11, 14, 19, 59, 0, time.UTC)
+
+	pushed := func(tag string, at time.Time) *image.Ref {
+		return &image.Ref{Tag: tag, PushedAt: at}
+	}
+
+	tagsOf := func(images []*image.Ref) []string {
+		var tags []string
+		for _, img := range images {
+			tags = append(tags, img.Tag)
+		}
+		return tags
+	}
+
+	// tied times are sorted by tag
+	images := []*image.Ref{
+		pushed("v10", created),
+		pushed("v9", created),
+		pushed("v1", created.Add(-time.Minute)),
+	}
+	sortPushed(images)
+	assert.Equal(t, []string{"v1", "v9", "v10"}, tagsOf(images))
+
+	// the tags are sorted by tag if any time is unknown
+	images = []*image.Ref{
+		pushed("1.2.10", created),
+		pushed("1.2.9", created.Add(time.Minute)),
+		pushed("1.10.0", time.Time{}),
+	}
+	sortPushed(images)
+	assert.Equal(t, []string{"1.2.9", "1.2.10", "1.10.0"}, tagsOf(images))
+}
+
+func TestTagLess(t *testing.T) {
+	assert.True(t, tagLess("v9", "v10"))
+	assert.False(t, tagLess("v10", "v9"))
+	assert.True(t, tagLess("v1", "v1-rc1"))
+	assert.True(t, tagLess("v1.0-alpha", "v1.0-beta"))
+	assert.True(t, tagLess("build-007", "build-10"))
+	assert.False(t, tagLess("v1", "v1"))
+}
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "poller")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store := NewFileStore(filepath.Join(dir, "state.json"))
+
+	state, err := store.Load()
+	require.NoError(t, err)
+	assert.Empty(t, state)
+
+	require.NoError(t, store.Save(map[string][]string{"registry.io/foo": {"v1", "v2"}}))
+
+	state, err = store.Load()
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{"registry.io/foo": {"v1", "v2"}}, state)
+
+	// a corrupt state stops the poller instead of being retried
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "state.json"), []byte("{"), 0644))
+
+	_, err = store.Load()
+	assert.True(t, syncd.IsPermanent(err))
+}
@@ -0,0 +1,333 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"ship-it/internal/image"
+
+	"github.com/pkg/errors"
+)
+
+// dockerHubAPI is the host of Docker Hub's registry API
+const dockerHubAPI = "registry-1.docker.io"
+
+// TagClient lists the tags of repositories, and when they were created, using
+// the Docker Registry v2 API.
+// It authenticates with basic auth, or with the bearer tokens of registries
+// that use token authentication, like Docker Hub.
+type TagClient struct {
+	client   *http.Client
+	username string
+	password string
+
+	// scheme is "https", except for tests
+	scheme string
+}
+
+// TagClientOption configures a TagClient
+type TagClientOption func(*TagClient)
+
+// Credentials authenticates with the registries as the user
+func Credentials(username, password string) TagClientOption {
+	return func(c *TagClient) {
+		c.username = username
+		c.password = password
+	}
+}
+
+// HTTPClient sets the client used to call the registries
+func HTTPClient(client *http.Client) TagClientOption {
+	return func(c *TagClient) {
+		c.client = client
+	}
+}
+
+func NewTagClient(opts ...TagClientOption) *TagClient {
+	c := &TagClient{
+		client: http.DefaultClient,
+		scheme: "https",
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// Tags lists every tag of the image's repository
+func (c *TagClient) Tags(ctx context.Context, ref *image.Ref) ([]string, error) {
+	next := c.url(ref, "tags/list")
+
+	var (
+		tags  []string
+		token string
+	)
+
+	for next != "" {
+		resp, err := c.fetch(ctx, ref, next, "", &token)
+		if err != nil {
+			return nil, err
+		}
+
+		var list struct {
+			Tags []string `json:"tags"`
+		}
+
+		err = decode(resp, &list)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list tags of %s", ref.URI())
+		}
+
+		tags = append(tags, list.Tags...)
+
+		next, err = nextPage(next, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+// manifestTypes are the manifests that Created accepts, in order of
+// preference
+var manifestTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v1+prettyjws",
+}
+
+// manifest is an image manifest, or a list of the manifests of a
+// multi-platform image
+type manifest struct {
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+
+	Manifests []struct {
+		Digest string `json:"digest"`
+	} `json:"manifests"`
+
+	// History is the image's history in schema 1 manifests, whose
+	// entries are JSON image configs
+	History []struct {
+		V1Compatibility string `json:"v1Compatibility"`
+	} `json:"history"`
+}
+
+// Created returns when the image's tag was created, from its image config.
+// The image of a multi-platform tag is the first one listed. Images built
+// without a creation time, or with the epoch, e.g. by reproducible builds,
+// fall back to when the registry last modified the tag's manifest, which is
+// its push time. The time is zero if the registry doesn't report it either.
+func (c *TagClient) Created(ctx context.Context, ref *image.Ref) (time.Time, error) {
+	var (
+		token    string
+		modified time.Time
+	)
+
+	reference := ref.Tag
+
+	// a list of manifests is followed once
+	for i := 0; i < 2; i++ {
+		var m manifest
+		header, err := c.get(ctx, ref, "manifests/"+reference, strings.Join(manifestTypes, ", "), &token, &m)
+		if err != nil {
+			return time.Time{}, errors.Wrapf(err, "failed to get manifest of %s", ref)
+		}
+
+		// the tag's manifest was pushed with the tag, unlike the
+		// platforms' manifests of a list
+		if i == 0 {
+			modified, _ = http.ParseTime(header.Get("Last-Modified"))
+		}
+
+		var config struct {
+			Created time.Time `json:"created"`
+		}
+
+		switch {
+		case m.Config.Digest != "":
+			if _, err := c.get(ctx, ref, "blobs/"+m.Config.Digest, "", &token, &config); err != nil {
+				return time.Time{}, errors.Wrapf(err, "failed to get image config of %s", ref)
+			}
+
+		case len(m.History) > 0:
+			if err := json.Unmarshal([]byte(m.History[0].V1Compatibility), &config); err != nil {
+				return time.Time{}, errors.Wrapf(err, "invalid image history of %s", ref)
+			}
+
+		case len(m.Manifests) > 0:
+			reference = m.Manifests[0].Digest
+			continue
+
+		default:
+			return time.Time{}, errors.Errorf("invalid manifest of %s", ref)
+		}
+
+		if config.Created.Unix() <= 0 {
+			return modified, nil
+		}
+
+		return config.Created, nil
+	}
+
+	return time.Time{}, errors.Errorf("invalid manifest list of %s", ref)
+}
+
+// url returns the URL of a path of the image's repository in the v2 API
+func (c *TagClient) url(ref *image.Ref, path string) string {
+	host := ref.Registry
+	if host == image.DockerHub {
+		host = dockerHubAPI
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s/%s", c.scheme, host, ref.Repository, path)
+}
+
+// get decodes the JSON at the path of the image's repository, and returns
+// the response's header
+func (c *TagClient) get(ctx context.Context, ref *image.Ref, path, accept string, token *string, v interface{}) (http.Header, error) {
+	resp, err := c.fetch(ctx, ref, c.url(ref, path), accept, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Header, decode(resp, v)
+}
+
+// fetch requests the URL. Registries using token authentication challenge
+// the first request with where to get a token, which is kept for the
+// following requests.
+func (c *TagClient) fetch(ctx context.Context, ref *image.Ref, url, accept string, token *string) (*http.Response, error) {
+	resp, err := c.request(ctx, url, accept, *token)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || *token != "" {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	resp.Body.Close()
+
+	if *token, err = c.token(ctx, challenge); err != nil {
+		return nil, errors.Wrapf(err, "failed to authenticate with %s", ref.Registry)
+	}
+
+	return c.request(ctx, url, accept, *token)
+}
+
+func (c *TagClient) request(ctx context.Context, url, accept, token string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if c.username != "" {
+		req.SetBasicAuth(c.username, c.password)
+	}
+
+	return c.client.Do(req.WithContext(ctx))
+}
+
+var challengeParams = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// token gets a bearer token from the realm of the challenge
+func (c *TagClient) token(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", errors.New("access denied")
+	}
+
+	params := make(map[string]string)
+	for _, match := range challengeParams.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return "", errors.Errorf("invalid token realm %q", params["realm"])
+	}
+
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if v := params[key]; v != "" {
+			query.Set(key, v)
+		}
+	}
+	realm.RawQuery = query.Encode()
+
+	resp, err := c.request(ctx, realm.String(), "", "")
+	if err != nil {
+		return "", err
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+
+	if err := decode(resp, &token); err != nil {
+		return "", err
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+
+	return token.AccessToken, nil
+}
+
+// nextPage returns the URL of the next page of results, from the Link header
+// of the current page, e.g. `</v2/foo/tags/list?last=bar&n=100>; rel="next"`
+func nextPage(current, link string) (string, error) {
+	if !strings.Contains(link, `rel="next"`) {
+		return "", nil
+	}
+
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start {
+		return "", errors.Errorf("invalid link header %q", link)
+	}
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+
+	next, err := base.Parse(link[start+1 : end])
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid link header %q", link)
+	}
+
+	return next.String(), nil
+}
+
+func decode(resp *http.Response, v interface{}) error {
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("unexpected status %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return json.Unmarshal(body, v)
+}
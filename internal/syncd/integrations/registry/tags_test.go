@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"ship-it/internal/image"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestTagClient(srv *httptest.Server, opts ...TagClientOption) (*TagClient, *image.Ref) {
+	c := NewTagClient(append([]TagClientOption{HTTPClient(srv.Client())}, opts...)...)
+	c.scheme = "http"
+
+	return c, &image.Ref{
+		Registry:   strings.TrimPrefix(srv.URL, "http://"),
+		Repository: "squad/word-counts",
+	}
+}
+
+func TestTagsPaginated(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, pass, ok := r.BasicAuth()
+		if !ok || user != "user" || pass != "pass" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		assert.Equal(t, "/v2/squad/word-counts/tags/list", r.URL.Path)
+
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/squad/word-counts/tags/list?last=b&n=2>; rel="next"`)
+			fmt.Fprint(w, `{"name": "squad/word-counts", "tags": ["a", "b"]}`)
+			return
+		}
+
+		fmt.Fprint(w, `{"name": "squad/word-counts", "tags": ["c"]}`)
+	}))
+	defer srv.Close()
+
+	c, ref := newTestTagClient(srv, Credentials("user", "pass"))
+
+	tags, err := c.Tags(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, tags)
+}
+
+func TestTagsTokenAuth(t *testing.T) {
+	var srv *httptest.Server
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			assert.Equal(t, "registry", r.URL.Query().Get("service"))
+			assert.Equal(t, "repository:squad/word-counts:pull", r.URL.Query().Get("scope"))
+			fmt.Fprint(w, `{"token": "t0k3n"}`)
+
+		case "/v2/squad/word-counts/tags/list":
+			if r.Header.Get("Authorization") != "Bearer t0k3n" {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry",scope="repository:squad/word-counts:pull"`, srv.URL))
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			fmt.Fprint(w, `{"tags": ["1.0.0"]}`)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, ref := newTestTagClient(srv)
+
+	tags, err := c.Tags(context.Background(), ref)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.0.0"}, tags)
+}
+
+func TestTagsError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not found", http.StatusNotFound)
+	}))
+	defer srv.Close()
+
+	c, ref := newTestTagClient(srv)
+
+	_, err := c.Tags(context.Background(), ref)
+	assert.Error(t, err)
+}
+
+func TestCreated(t *testing.T) {
+	var srv *httptest.Server
+
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			fmt.Fprint(w, `{"token": "t0k3n"}`)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "Bearer t0k3n" {
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="%s/token",service="registry"`, srv.URL))
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if strings.Contains(r.URL.Path, "/manifests/") {
+			assert.Contains(t, r.Header.Get("Accept"), "application/vnd.docker.distribution.manifest.v2+json")
+		}
+
+		switch r.URL.Path {
+		case "/v2/squad/word-counts/manifests/v2":
+			fmt.Fprint(w, `{"schemaVersion": 2, "config": {"digest": "sha256:config"}}`)
+
+		case "/v2/squad/word-counts/manifests/multi":
+			fmt.Fprint(w, `{"schemaVersion": 2, "manifests": [{"digest": "sha256:amd64"}, {"digest": "sha256:arm64"}]}`)
+
+		case "/v2/squad/word-counts/manifests/sha256:amd64":
+			fmt.Fprint(w, `{"schemaVersion": 2, "config": {"digest": "sha256:config"}}`)
+
+		case "/v2/squad/word-counts/blobs/sha256:config":
+			fmt.Fprint(w, `{"architecture": "amd64", "created": "2019-07-11T14:19:59.123Z"}`)
+
+		case "/v2/squad/word-counts/manifests/v1":
+			fmt.Fprint(w, `{"schemaVersion": 1, "history": [{"v1Compatibility": "{\"created\": \"2019-07-10T08:00:00Z\"}"}]}`)
+
+		case "/v2/squad/word-counts/manifests/reproducible":
+			w.Header().Set("Last-Modified", "Thu, 11 Jul 2019 15:00:00 GMT")
+			fmt.Fprint(w, `{"schemaVersion": 2, "config": {"digest": "sha256:epoch"}}`)
+
+		case "/v2/squad/word-counts/manifests/undated":
+			fmt.Fprint(w, `{"schemaVersion": 2, "config": {"digest": "sha256:epoch"}}`)
+
+		case "/v2/squad/word-counts/blobs/sha256:epoch":
+			fmt.Fprint(w, `{"architecture": "amd64", "created": "1970-01-01T00:00:00Z"}`)
+
+		case "/v2/squad/word-counts/manifests/empty":
+			fmt.Fprint(w, `{"schemaVersion": 2}`)
+
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	c, ref := newTestTagClient(srv)
+
+	created := func(tag string) (time.Time, error) {
+		img := *ref
+		img.Tag = tag
+		return c.Created(context.Background(), &img)
+	}
+
+	for _, tag := range []string{"v2", "multi"} {
+		at, err := created(tag)
+		require.NoError(t, err, tag)
+		assert.Equal(t, time.Date(2019, time.July, 11, 14, 19, 59, 123000000, time.UTC), at, tag)
+	}
+
+	at, err := created("v1")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2019, time.July, 10, 8, 0, 0, 0, time.UTC), at)
+
+	// an image created at the epoch falls back to when its manifest was
+	// pushed, if the registry tells
+	at, err = created("reproducible")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2019, time.July, 11, 15, 0, 0, 0, time.UTC), at)
+
+	at, err = created("undated")
+	require.NoError(t, err)
+	assert.True(t, at.IsZero())
+
+	_, err = created("empty")
+	assert.Error(t, err)
+
+	_, err = created("missing")
+	assert.Error(t, err)
+}
@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+
+	"ship-it/internal/syncd"
+
+	"github.com/Wattpad/sqsconsumer"
+	"github.com/Wattpad/sqsconsumer/middleware"
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+)
+
+// DeadLetterQueue stores messages that failed permanently
+type DeadLetterQueue interface {
+	Send(ctx context.Context, source, msg string, cause error) error
+}
+
+// DeadLetter stops messages from the source queue that fail permanently from
+// being retried. They're sent to the dead-letter queue, or dropped if there
+// isn't one. Messages that can't be sent to the dead-letter queue fail, so
+// they're retried.
+func DeadLetter(logger log.Logger, source string, q DeadLetterQueue) middleware.MessageHandlerDecorator {
+	return func(fn sqsconsumer.MessageHandlerFunc) sqsconsumer.MessageHandlerFunc {
+		return func(ctx context.Context, msg string) error {
+			err := fn(ctx, msg)
+			if !syncd.IsPermanent(err) {
+				return err
+			}
+
+			if q == nil {
+				logger.Log("event", "message.dropped", "error", err)
+				return nil
+			}
+
+			if dlqErr := q.Send(ctx, source, msg, err); dlqErr != nil {
+				logger.Log("error", errors.Wrap(dlqErr, "failed to dead-letter message"))
+				return err
+			}
+
+			logger.Log("event", "message.dead_lettered", "error", err)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package middleware
+
+import (
+	"context"
+	"testing"
+
+	"ship-it/internal/syncd"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeQueue struct {
+	sent []string
+	err  error
+}
+
+func (q *fakeQueue) Send(ctx context.Context, source, msg string, cause error) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.sent = append(q.sent, msg)
+	return nil
+}
+
+func failWith(err error) func(context.Context, string) error {
+	return func(context.Context, string) error {
+		return err
+	}
+}
+
+func TestDeadLetter(t *testing.T) {
+	transient := errors.New("timeout")
+	permanent := syncd.Permanent(errors.New("malformed"))
+
+	tests := []struct {
+		name    string
+		err     error
+		queue   *fakeQueue
+		wantErr error
+		sent    int
+	}{
+		{"success", nil, &fakeQueue{}, nil, 0},
+		{"transient", transient, &fakeQueue{}, transient, 0},
+		{"permanent", permanent, &fakeQueue{}, nil, 1},
+		{"queue fails", permanent, &fakeQueue{err: errors.New("throttled")}, permanent, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := DeadLetter(log.NewNopLogger(), "ship-it-ecr", tt.queue)(failWith(tt.err))
+
+			assert.Equal(t, tt.wantErr, handler(context.Background(), "msg"))
+			assert.Len(t, tt.queue.sent, tt.sent)
+		})
+	}
+}
+
+func TestDeadLetterWithoutQueue(t *testing.T) {
+	handler := DeadLetter(log.NewNopLogger(), "ship-it-ecr", nil)(failWith(syncd.Permanent(errors.New("malformed"))))
+
+	assert.NoError(t, handler(context.Background(), "msg"))
+}
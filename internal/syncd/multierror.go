@@ -7,8 +7,24 @@ import (
 
 type multiError []error
 
+// Add adds the error, unless it's nil
 func (me *multiError) Add(err error) {
-	*me = append(*me, err)
+	if err != nil {
+		*me = append(*me, err)
+	}
+}
+
+// ErrorOrNil returns nil without any errors, and a single error on its own,
+// so it can still be inspected, e.g. by IsPermanent
+func (me multiError) ErrorOrNil() error {
+	switch len(me) {
+	case 0:
+		return nil
+	case 1:
+		return me[0]
+	default:
+		return me
+	}
 }
 
 func (me multiError) Error() string {
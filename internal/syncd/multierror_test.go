@@ -40,3 +40,17 @@ quux`
 
 	assert.EqualError(t, me, expected)
 }
+
+func TestMultiErrorOrNil(t *testing.T) {
+	errFoo := errors.New("foo")
+
+	var me multiError
+	me.Add(nil)
+	assert.NoError(t, me.ErrorOrNil())
+
+	me.Add(errFoo)
+	assert.Equal(t, errFoo, me.ErrorOrNil())
+
+	me.Add(errors.New("bar"))
+	assert.Len(t, me.ErrorOrNil(), 2)
+}
@@ -0,0 +1,44 @@
+package syncd
+
+// permanentError is an error that retrying can't fix
+type permanentError struct {
+	error
+}
+
+func (e permanentError) Cause() error {
+	return e.error
+}
+
+// Permanent marks the error as permanent: handling the event that caused it
+// again fails the same way, e.g. because the event is malformed. Listeners
+// don't retry events that fail permanently.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return permanentError{err}
+}
+
+// IsPermanent reports whether the error, or any error that it wraps, has been
+// marked as permanent.
+func IsPermanent(err error) bool {
+	type causer interface {
+		Cause() error
+	}
+
+	for err != nil {
+		if _, ok := err.(permanentError); ok {
+			return true
+		}
+
+		c, ok := err.(causer)
+		if !ok {
+			return false
+		}
+
+		err = c.Cause()
+	}
+
+	return false
+}
@@ -0,0 +1,22 @@
+package syncd
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPermanent(t *testing.T) {
+	err := errors.New("malformed event")
+
+	assert.False(t, IsPermanent(err))
+	assert.False(t, IsPermanent(nil))
+	assert.Nil(t, Permanent(nil))
+
+	permanent := errors.Wrap(Permanent(err), "failed to handle event")
+
+	assert.True(t, IsPermanent(permanent))
+	assert.Equal(t, err, errors.Cause(permanent))
+	assert.Equal(t, "failed to handle event: malformed event", permanent.Error())
+}
@@ -0,0 +1,165 @@
+package syncd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Registry holds named implementations of syncd's listeners and reconcilers,
+// so they can be chosen by configuration. Implementations are registered as
+// factories, and only the ones that are chosen are created.
+type Registry struct {
+	imageListeners   map[string]func() (ImageListener, error)
+	imageReconcilers map[string]func() (ImageReconciler, error)
+	chartListeners   map[string]func() (RegistryChartListener, error)
+	chartReconcilers map[string]func() (RegistryChartReconciler, error)
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		imageListeners:   make(map[string]func() (ImageListener, error)),
+		imageReconcilers: make(map[string]func() (ImageReconciler, error)),
+		chartListeners:   make(map[string]func() (RegistryChartListener, error)),
+		chartReconcilers: make(map[string]func() (RegistryChartReconciler, error)),
+	}
+}
+
+// RegisterImageListener registers the image listener created by the factory
+// under the name, replacing any listener that has the same name.
+func (r *Registry) RegisterImageListener(name string, factory func() (ImageListener, error)) {
+	r.imageListeners[name] = factory
+}
+
+// RegisterImageReconciler registers the image reconciler created by the
+// factory under the name, replacing any reconciler that has the same name.
+func (r *Registry) RegisterImageReconciler(name string, factory func() (ImageReconciler, error)) {
+	r.imageReconcilers[name] = factory
+}
+
+// RegisterChartListener registers the registry chart listener created by the
+// factory under the name, replacing any listener that has the same name.
+func (r *Registry) RegisterChartListener(name string, factory func() (RegistryChartListener, error)) {
+	r.chartListeners[name] = factory
+}
+
+// RegisterChartReconciler registers the registry chart reconciler created by
+// the factory under the name, replacing any reconciler that has the same name.
+func (r *Registry) RegisterChartReconciler(name string, factory func() (RegistryChartReconciler, error)) {
+	r.chartReconcilers[name] = factory
+}
+
+// ImageListener creates the named image listeners. Several listeners are
+// combined into ImageListeners, which feed the same reconciler.
+func (r *Registry) ImageListener(names ...string) (ImageListener, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no image listener, expected one or more of %s", known(r.imageListeners))
+	}
+
+	listeners := make(ImageListeners, len(names))
+
+	for _, name := range names {
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("duplicate image listener %q", name)
+		}
+
+		factory, ok := r.imageListeners[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown image listener %q, expected one of %s", name, known(r.imageListeners))
+		}
+
+		l, err := factory()
+		if err != nil {
+			return nil, err
+		}
+
+		listeners[name] = l
+	}
+
+	if len(listeners) == 1 {
+		return listeners[names[0]], nil
+	}
+
+	return listeners, nil
+}
+
+// ImageReconciler creates the named image reconciler
+func (r *Registry) ImageReconciler(name string) (ImageReconciler, error) {
+	factory, ok := r.imageReconcilers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown image reconciler %q, expected one of %s", name, known(r.imageReconcilers))
+	}
+	return factory()
+}
+
+// ChartListener creates the named registry chart listener
+func (r *Registry) ChartListener(name string) (RegistryChartListener, error) {
+	factory, ok := r.chartListeners[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown chart listener %q, expected one of %s", name, known(r.chartListeners))
+	}
+	return factory()
+}
+
+// ChartReconciler creates the named registry chart reconciler
+func (r *Registry) ChartReconciler(name string) (RegistryChartReconciler, error) {
+	factory, ok := r.chartReconcilers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown chart reconciler %q, expected one of %s", name, known(r.chartReconcilers))
+	}
+	return factory()
+}
+
+// known returns the sorted, quoted names of a map of factories
+func known(factories interface{}) string {
+	var names []string
+	for _, name := range reflect.ValueOf(factories).MapKeys() {
+		names = append(names, fmt.Sprintf("%q", name))
+	}
+
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// ImageListeners are named image listeners that feed the same reconciler.
+// Syncd supervises each of them on its own.
+type ImageListeners map[string]ImageListener
+
+// Listen runs every listener until one of them stops, and returns their
+// errors. The listeners that are stopped because another one stopped don't
+// add their cancellations.
+func (ls ImageListeners) Listen(parent context.Context, r ImageReconciler) error {
+	ctx, cancel := context.WithCancel(parent)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	wg.Add(len(ls))
+
+	errs := make(chan error, len(ls))
+
+	for _, l := range ls {
+		go func(l ImageListener) {
+			errs <- l.Listen(ctx, r)
+			wg.Done()
+			cancel()
+		}(l)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	var merr multiError
+	for err := range errs {
+		if errors.Cause(err) == context.Canceled && parent.Err() == nil {
+			continue
+		}
+		merr.Add(err)
+	}
+
+	return merr.ErrorOrNil()
+}
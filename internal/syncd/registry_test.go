@@ -0,0 +1,154 @@
+package syncd
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testRegistry() *Registry {
+	r := NewRegistry()
+
+	r.RegisterImageListener("sqs", func() (ImageListener, error) {
+		return blockingImageListener{}, nil
+	})
+	r.RegisterImageListener("webhook", func() (ImageListener, error) {
+		return &flakyImageListener{}, nil
+	})
+	r.RegisterImageListener("broken", func() (ImageListener, error) {
+		return nil, errors.New("QUEUE is required")
+	})
+	r.RegisterImageReconciler("nop", func() (ImageReconciler, error) {
+		return nopImageReconciler{}, nil
+	})
+	r.RegisterChartListener("sqs", func() (RegistryChartListener, error) {
+		return blockingChartListener{}, nil
+	})
+	r.RegisterChartReconciler("nop", func() (RegistryChartReconciler, error) {
+		return nopChartReconciler{}, nil
+	})
+
+	return r
+}
+
+func TestRegistry(t *testing.T) {
+	r := testRegistry()
+
+	il, err := r.ImageListener("sqs")
+	require.NoError(t, err)
+	assert.Equal(t, blockingImageListener{}, il)
+
+	ir, err := r.ImageReconciler("nop")
+	require.NoError(t, err)
+	assert.Equal(t, nopImageReconciler{}, ir)
+
+	cl, err := r.ChartListener("sqs")
+	require.NoError(t, err)
+	assert.Equal(t, blockingChartListener{}, cl)
+
+	cr, err := r.ChartReconciler("nop")
+	require.NoError(t, err)
+	assert.Equal(t, nopChartReconciler{}, cr)
+}
+
+func TestRegistryErrors(t *testing.T) {
+	r := testRegistry()
+
+	_, err := r.ImageListener("ecr")
+	assert.EqualError(t, err, `unknown image listener "ecr", expected one of "broken", "sqs", "webhook"`)
+
+	_, err = r.ImageListener()
+	assert.Error(t, err)
+
+	_, err = r.ImageListener("sqs", "sqs")
+	assert.EqualError(t, err, `duplicate image listener "sqs"`)
+
+	_, err = r.ImageListener("sqs", "broken")
+	assert.EqualError(t, err, "QUEUE is required")
+
+	_, err = r.ImageReconciler("batch")
+	assert.EqualError(t, err, `unknown image reconciler "batch", expected one of "nop"`)
+
+	_, err = r.ChartListener("webhook")
+	assert.EqualError(t, err, `unknown chart listener "webhook", expected one of "sqs"`)
+
+	_, err = r.ChartReconciler("helm")
+	assert.EqualError(t, err, `unknown chart reconciler "helm", expected one of "nop"`)
+}
+
+// asserts several image listeners feed the same reconciler, and are
+// supervised on their own
+func TestSyncdImageListeners(t *testing.T) {
+	il, err := testRegistry().ImageListener("sqs", "webhook")
+	require.NoError(t, err)
+	assert.IsType(t, ImageListeners{}, il)
+
+	s := New(blockingChartListener{}, nopChartReconciler{}, il, nopImageReconciler{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	waitFor(t, s.Running)
+
+	assert.Equal(t, map[string]ListenerState{
+		"chart":         ListenerRunning,
+		"image/sqs":     ListenerRunning,
+		"image/webhook": ListenerRunning,
+	}, s.States())
+
+	cancel()
+
+	err = <-done
+	assert.Len(t, err, 3)
+}
+
+// asserts the image listeners stop together when they're run on their own
+func TestImageListeners(t *testing.T) {
+	listenerErr := errors.New("queue unavailable")
+
+	ls := ImageListeners{
+		"sqs":     blockingImageListener{},
+		"webhook": blockingImageListener{ShouldFail: listenerErr},
+	}
+
+	// the listener that was stopped by the failure isn't an error
+	err := ls.Listen(context.Background(), nopImageReconciler{})
+	assert.Equal(t, listenerErr, err)
+}
+
+func TestImageListenersPermanent(t *testing.T) {
+	ls := ImageListeners{
+		"sqs":     blockingImageListener{},
+		"webhook": blockingImageListener{ShouldFail: Permanent(errors.New("invalid state"))},
+	}
+
+	err := ls.Listen(context.Background(), nopImageReconciler{})
+	assert.True(t, IsPermanent(err))
+}
+
+func TestImageListenersMultiple(t *testing.T) {
+	ls := ImageListeners{
+		"sqs":     blockingImageListener{ShouldFail: errors.New("queue unavailable")},
+		"webhook": blockingImageListener{ShouldFail: errors.New("address in use")},
+	}
+
+	err := ls.Listen(context.Background(), nopImageReconciler{})
+	assert.Len(t, err, 2)
+}
+
+func TestImageListenersCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ls := ImageListeners{"sqs": blockingImageListener{}}
+
+	err := ls.Listen(ctx, nopImageReconciler{})
+	assert.Equal(t, context.Canceled, err)
+}
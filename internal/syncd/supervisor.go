@@ -0,0 +1,142 @@
+package syncd
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/go-kit/kit/metrics/discard"
+)
+
+// ListenerState is the state of a supervised listener
+type ListenerState string
+
+const (
+	// ListenerStarting is a listener that hasn't been started yet
+	ListenerStarting ListenerState = "starting"
+
+	// ListenerRunning is a listener that's listening
+	ListenerRunning ListenerState = "running"
+
+	// ListenerBackoff is a failed listener that's waiting to be restarted
+	ListenerBackoff ListenerState = "backoff"
+
+	// ListenerStopped is a listener that was stopped by its context
+	ListenerStopped ListenerState = "stopped"
+
+	// ListenerFailed is a listener that failed permanently
+	ListenerFailed ListenerState = "failed"
+)
+
+// supervisor restarts failed listeners, and tracks their states
+type supervisor struct {
+	logger   log.Logger
+	restarts metrics.Counter
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu        sync.Mutex
+	listeners map[string]ListenerState
+}
+
+func newSupervisor() *supervisor {
+	return &supervisor{
+		logger:         log.NewNopLogger(),
+		restarts:       discard.NewCounter(),
+		initialBackoff: time.Second,
+		maxBackoff:     time.Minute,
+		listeners:      make(map[string]ListenerState),
+	}
+}
+
+// supervise runs the listener until its context is cancelled, or it fails
+// permanently. It's restarted whenever it fails otherwise, after a backoff.
+// The backoff is reset once the listener has run for as long as the max
+// backoff.
+func (s *supervisor) supervise(ctx context.Context, name string, listen func(context.Context) error) error {
+	backoff := s.initialBackoff
+
+	for {
+		s.setState(name, ListenerRunning)
+
+		start := time.Now()
+		err := run(ctx, listen)
+
+		if ctx.Err() != nil {
+			s.setState(name, ListenerStopped)
+			return err
+		}
+
+		if IsPermanent(err) {
+			s.setState(name, ListenerFailed)
+			s.logger.Log("event", "listener.failed", "listener", name, "error", err)
+			return err
+		}
+
+		if time.Since(start) >= s.maxBackoff {
+			backoff = s.initialBackoff
+		}
+
+		s.setState(name, ListenerBackoff)
+		s.logger.Log("event", "listener.restart", "listener", name, "error", err, "backoff", backoff)
+		s.restarts.With("listener", name).Add(1)
+
+		select {
+		case <-ctx.Done():
+			s.setState(name, ListenerStopped)
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+
+		if backoff *= 2; backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// run runs the listener, and returns its panic as an error
+func run(ctx context.Context, listen func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("listener panicked: %v", r)
+		}
+	}()
+
+	return listen(ctx)
+}
+
+func (s *supervisor) setState(name string, state ListenerState) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.listeners[name] = state
+}
+
+func (s *supervisor) states() map[string]ListenerState {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	states := make(map[string]ListenerState, len(s.listeners))
+	for name, state := range s.listeners {
+		states[name] = state
+	}
+	return states
+}
+
+// running reports whether every listener is running
+func (s *supervisor) running() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, state := range s.listeners {
+		if state != ListenerRunning {
+			return false
+		}
+	}
+
+	return len(s.listeners) > 0
+}
@@ -0,0 +1,171 @@
+package syncd
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyImageListener fails until it has been started the given number of
+// times, then blocks until it's cancelled
+type flakyImageListener struct {
+	mu       sync.Mutex
+	failures int
+	panics   bool
+	starts   int
+}
+
+func (l *flakyImageListener) Listen(ctx context.Context, _ ImageReconciler) error {
+	l.mu.Lock()
+	l.starts++
+	starts := l.starts
+	l.mu.Unlock()
+
+	if starts <= l.failures {
+		if l.panics {
+			panic("nil map")
+		}
+		return errors.New("queue unavailable")
+	}
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func (l *flakyImageListener) Starts() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.starts
+}
+
+// restartCounter counts the restarts of each listener
+type restartCounter struct {
+	mu       sync.Mutex
+	restarts map[string]float64
+}
+
+func (c *restartCounter) With(labelValues ...string) metrics.Counter {
+	return listenerRestarts{c, labelValues[1]}
+}
+
+func (c *restartCounter) Add(delta float64) {}
+
+func (c *restartCounter) Counts() map[string]float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.restarts
+}
+
+type listenerRestarts struct {
+	*restartCounter
+	listener string
+}
+
+func (c listenerRestarts) Add(delta float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.restarts[c.listener] += delta
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(time.Second)
+	for !cond() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, cond())
+}
+
+// asserts a failed listener is restarted, without stopping the others
+func TestSupervisorRestartsListener(t *testing.T) {
+	for _, panics := range []bool{false, true} {
+		listener := &flakyImageListener{failures: 3, panics: panics}
+		restarts := &restartCounter{restarts: make(map[string]float64)}
+
+		s := New(
+			blockingChartListener{},
+			nopChartReconciler{},
+			listener,
+			nopImageReconciler{},
+		).WithSupervision(log.NewNopLogger(), restarts).WithBackoff(time.Millisecond, 5*time.Millisecond)
+
+		ctx, cancel := context.WithCancel(context.Background())
+
+		done := make(chan error)
+		go func() {
+			done <- s.Run(ctx)
+		}()
+
+		waitFor(t, func() bool { return listener.Starts() == 4 && s.Running() })
+
+		assert.Equal(t, map[string]float64{"image": 3}, restarts.Counts())
+		assert.Equal(t, map[string]ListenerState{
+			"chart": ListenerRunning,
+			"image": ListenerRunning,
+		}, s.States())
+
+		cancel()
+
+		err := <-done
+		assert.Len(t, err, 2)
+		assert.Contains(t, err, context.Canceled)
+
+		assert.Equal(t, map[string]ListenerState{
+			"chart": ListenerStopped,
+			"image": ListenerStopped,
+		}, s.States())
+	}
+}
+
+// asserts a listener waiting to be restarted is stopped by its context
+func TestSupervisorBackoffCancelled(t *testing.T) {
+	listener := &flakyImageListener{failures: 1}
+
+	s := New(
+		blockingChartListener{},
+		nopChartReconciler{},
+		listener,
+		nopImageReconciler{},
+	).WithBackoff(time.Hour, time.Hour)
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	waitFor(t, func() bool { return s.States()["image"] == ListenerBackoff })
+	assert.False(t, s.Running())
+
+	cancel()
+
+	err := <-done
+	assert.Contains(t, err, context.Canceled)
+	assert.Equal(t, 1, listener.Starts())
+}
+
+// asserts a listener that fails permanently isn't restarted
+func TestSupervisorPermanentFailure(t *testing.T) {
+	listenerErr := Permanent(errors.New("invalid queue"))
+
+	s := New(
+		blockingChartListener{},
+		nopChartReconciler{},
+		blockingImageListener{ShouldFail: listenerErr},
+		nopImageReconciler{},
+	)
+
+	err := s.Run(context.Background())
+	assert.Contains(t, err, listenerErr)
+
+	assert.Equal(t, map[string]ListenerState{
+		"chart": ListenerStopped,
+		"image": ListenerFailed,
+	}, s.States())
+}
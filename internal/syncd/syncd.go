@@ -2,13 +2,23 @@ package syncd
 
 import (
 	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"ship-it/internal/image"
 
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/metrics"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/helm/pkg/proto/hapi/chart"
 )
 
+// ErrNoRegisteredReleasesAffected is returned by an ImageReconciler when a new
+// image doesn't change any of the registered releases. Listeners treat it as
+// a successfully handled image.
+var ErrNoRegisteredReleasesAffected = errors.New("no registered releases affected")
+
 // ImageReconciler reconciles a new image with the state of ship-it's service
 // registry chart. For example, by updating chart values in a remote
 // repository to use the new image.
@@ -35,6 +45,29 @@ type RegistryChartListener interface {
 	Listen(context.Context, RegistryChartReconciler) error
 }
 
+// Promotion is a pending promotion of a release's chart version and images
+// from its upstream release.
+type Promotion struct {
+	Release      types.NamespacedName
+	Upstream     string
+	ChartVersion string
+	Images       []image.Ref
+}
+
+// PromotionReconciler reconciles a release promotion with ship-it's service
+// registry chart. For example, by committing the promoted chart version and
+// images to the release's HelmRelease in a remote repository.
+type PromotionReconciler interface {
+	Reconcile(context.Context, *Promotion) error
+}
+
+// PromotionListener models a stream of `*Promotion` events for releases that
+// follow an upstream release. It calls the PromotionReconciler to reconcile
+// each promotion with ship-it's service registry chart.
+type PromotionListener interface {
+	Listen(context.Context, PromotionReconciler) error
+}
+
 // Syncd facilitates background synchronization between a docker image registry,
 // ship-it's service registry helm chart, and kubernetes cluster state.
 type Syncd struct {
@@ -43,6 +76,11 @@ type Syncd struct {
 
 	imageListener   ImageListener
 	imageReconciler ImageReconciler
+
+	promotionListener   PromotionListener
+	promotionReconciler PromotionReconciler
+
+	supervisor *supervisor
 }
 
 func New(cl RegistryChartListener, cr RegistryChartReconciler, il ImageListener, ir ImageReconciler) *Syncd {
@@ -52,29 +90,93 @@ func New(cl RegistryChartListener, cr RegistryChartReconciler, il ImageListener,
 
 		imageListener:   il,
 		imageReconciler: ir,
+
+		supervisor: newSupervisor(),
 	}
 }
 
+// WithPromotions configures syncd to also synchronize release promotions
+// with ship-it's service registry chart.
+func (s *Syncd) WithPromotions(pl PromotionListener, pr PromotionReconciler) *Syncd {
+	s.promotionListener = pl
+	s.promotionReconciler = pr
+	return s
+}
+
+// WithSupervision logs the listeners' restarts, and counts them by listener.
+func (s *Syncd) WithSupervision(l log.Logger, restarts metrics.Counter) *Syncd {
+	s.supervisor.logger = l
+	s.supervisor.restarts = restarts
+	return s
+}
+
+// WithBackoff sets how long a failed listener waits before it's restarted.
+// The wait doubles with each consecutive failure, up to the max.
+func (s *Syncd) WithBackoff(initial, max time.Duration) *Syncd {
+	s.supervisor.initialBackoff = initial
+	s.supervisor.maxBackoff = max
+	return s
+}
+
+// Running reports whether every listener is running
+func (s *Syncd) Running() bool {
+	return s.supervisor.running()
+}
+
+// States returns the state of each listener, by name
+func (s *Syncd) States() map[string]ListenerState {
+	return s.supervisor.states()
+}
+
+// Run runs the listeners until the context is cancelled, or a listener fails
+// permanently. Listeners that fail otherwise are restarted, with backoff.
 func (s *Syncd) Run(ctx context.Context) error {
+	listeners := map[string]func(context.Context) error{
+		"chart": func(ctx context.Context) error {
+			return s.chartListener.Listen(ctx, s.chartReconciler)
+		},
+	}
+
+	// several image listeners are supervised on their own
+	if ls, ok := s.imageListener.(ImageListeners); ok {
+		for name, l := range ls {
+			l := l
+			listeners["image/"+name] = func(ctx context.Context) error {
+				return l.Listen(ctx, s.imageReconciler)
+			}
+		}
+	} else {
+		listeners["image"] = func(ctx context.Context) error {
+			return s.imageListener.Listen(ctx, s.imageReconciler)
+		}
+	}
+
+	if s.promotionListener != nil {
+		listeners["promotion"] = func(ctx context.Context) error {
+			return s.promotionListener.Listen(ctx, s.promotionReconciler)
+		}
+	}
+
 	var wg sync.WaitGroup
-	wg.Add(2)
+	wg.Add(len(listeners))
 
-	errs := make(chan error, 2)
+	errs := make(chan error, len(listeners))
 
-	// cancel both listeners if either one exits
+	// cancel every listener if any one stops
 	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	go func() {
-		errs <- s.chartListener.Listen(ctx, s.chartReconciler)
-		wg.Done()
-		cancel()
-	}()
+	for name := range listeners {
+		s.supervisor.setState(name, ListenerStarting)
+	}
 
-	go func() {
-		errs <- s.imageListener.Listen(ctx, s.imageReconciler)
-		wg.Done()
-		cancel()
-	}()
+	for name, listen := range listeners {
+		go func(name string, listen func(context.Context) error) {
+			errs <- s.supervisor.supervise(ctx, name, listen)
+			wg.Done()
+			cancel()
+		}(name, listen)
+	}
 
 	go func() {
 		wg.Wait()
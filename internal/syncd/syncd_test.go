@@ -3,6 +3,7 @@ package syncd
 import (
 	"context"
 	"testing"
+	"time"
 
 	"ship-it/internal/image"
 
@@ -66,8 +67,9 @@ func TestSyncdNoFailures(t *testing.T) {
 }
 
 // asserts the chart listener is cancelled when the image listener fails
+// permanently
 func TestSyncdImageListenerFailure(t *testing.T) {
-	listenerErr := errors.New("image listener internal error")
+	listenerErr := Permanent(errors.New("image listener internal error"))
 
 	s := New(
 		blockingChartListener{},
@@ -84,8 +86,9 @@ func TestSyncdImageListenerFailure(t *testing.T) {
 }
 
 // asserts the image listener is cancelled when the chart listener fails
+// permanently
 func TestSyncdChartListenerFailure(t *testing.T) {
-	listenerErr := errors.New("chart listener internal error")
+	listenerErr := Permanent(errors.New("chart listener internal error"))
 
 	s := New(
 		blockingChartListener{ShouldFail: listenerErr},
@@ -100,3 +103,59 @@ func TestSyncdChartListenerFailure(t *testing.T) {
 	assert.Contains(t, err, listenerErr)
 	assert.Contains(t, err, context.Canceled)
 }
+
+type blockingPromotionListener struct{}
+
+func (blockingPromotionListener) Listen(ctx context.Context, _ PromotionReconciler) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// asserts the promotion listener is cancelled with the other listeners
+func TestSyncdWithPromotions(t *testing.T) {
+	s := New(
+		blockingChartListener{},
+		nopChartReconciler{},
+		blockingImageListener{},
+		nopImageReconciler{},
+	).WithPromotions(blockingPromotionListener{}, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := s.Run(ctx)
+
+	assert.Len(t, err, 3)
+	assert.Contains(t, err, context.Canceled)
+}
+
+// asserts syncd is running until a listener exits
+func TestSyncdRunning(t *testing.T) {
+	s := New(
+		blockingChartListener{},
+		nopChartReconciler{},
+		blockingImageListener{},
+		nopImageReconciler{},
+	)
+
+	assert.False(t, s.Running())
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		s.Run(ctx)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for !s.Running() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	assert.True(t, s.Running())
+
+	cancel()
+	<-done
+
+	assert.False(t, s.Running())
+}
@@ -6,7 +6,8 @@ import "gopkg.in/yaml.v2"
 
 // FindAll recursively finds every instance of the 'key' in the object, and
 // calls the provided callback function for each value at the matching keys. It
-// will not recurse through the values of a matching key.
+// recurses through nested objects and lists, but not through the values of a
+// matching key.
 func FindAll(obj map[string]interface{}, key string, cb func(interface{})) {
 	for k, v := range obj {
 		if key == k {
@@ -14,23 +15,43 @@ func FindAll(obj map[string]interface{}, key string, cb func(interface{})) {
 			continue
 		}
 
-		if nested, ok := v.(map[string]interface{}); ok {
-			FindAll(nested, key, cb)
+		findAll(v, key, cb)
+	}
+}
+
+func findAll(v interface{}, key string, cb func(interface{})) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		FindAll(v, key, cb)
+	case []interface{}:
+		for _, elem := range v {
+			findAll(elem, key, cb)
 		}
 	}
 }
 
-// VisitOne recursively finds the first item in the MapSlice that satisfies the
-// predicate, and calls the provided callback function on the selected item.
-func VisitOne(obj yaml.MapSlice, pred func(yaml.MapItem) bool, visit func(*yaml.MapItem)) {
+// VisitAll recursively finds every item in the MapSlice that satisfies the
+// predicate, including the items of objects in lists, and calls the provided
+// callback function on each selected item. It will not recurse through the
+// value of a selected item.
+func VisitAll(obj yaml.MapSlice, pred func(yaml.MapItem) bool, visit func(*yaml.MapItem)) {
 	for i := range obj {
 		if pred(obj[i]) {
 			visit(&obj[i])
-			break
+			continue
 		}
 
-		if nested, ok := obj[i].Value.(yaml.MapSlice); ok {
-			VisitOne(nested, pred, visit)
+		visitAll(obj[i].Value, pred, visit)
+	}
+}
+
+func visitAll(v interface{}, pred func(yaml.MapItem) bool, visit func(*yaml.MapItem)) {
+	switch v := v.(type) {
+	case yaml.MapSlice:
+		VisitAll(v, pred, visit)
+	case []interface{}:
+		for _, elem := range v {
+			visitAll(elem, pred, visit)
 		}
 	}
 }
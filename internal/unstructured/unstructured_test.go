@@ -14,6 +14,12 @@ func TestFindAll(t *testing.T) {
 			"bar": "path",
 		},
 		"qux": 2,
+		"baz": []interface{}{
+			map[string]interface{}{
+				"bar": "lists",
+			},
+			"bar",
+		},
 	}
 
 	var values []string
@@ -21,10 +27,10 @@ func TestFindAll(t *testing.T) {
 		values = append(values, x.(string))
 	})
 
-	assert.ElementsMatch(t, []string{"happy", "path"}, values)
+	assert.ElementsMatch(t, []string{"happy", "path", "lists"}, values)
 }
 
-func TestVisitOne(t *testing.T) {
+func TestVisitAll(t *testing.T) {
 	expected := "newvalue"
 
 	obj := yaml.MapSlice{
@@ -41,6 +47,18 @@ func TestVisitOne(t *testing.T) {
 				},
 			},
 		},
+		{
+			Key: "baz",
+			Value: []interface{}{
+				yaml.MapSlice{
+					{
+						Key:   "bar",
+						Value: "oldvalue",
+					},
+				},
+				"bar",
+			},
+		},
 		{
 			Key:   "qux",
 			Value: 2,
@@ -56,6 +74,8 @@ func TestVisitOne(t *testing.T) {
 		item.Value = expected
 	}
 
-	VisitOne(obj, pred, visit)
+	VisitAll(obj, pred, visit)
 	assert.Equal(t, expected, obj[1].Value.(yaml.MapSlice)[0].Value)
+	assert.Equal(t, expected, obj[2].Value.([]interface{})[0].(yaml.MapSlice)[0].Value)
+	assert.Equal(t, "bar", obj[2].Value.([]interface{})[1])
 }
@@ -23,6 +23,7 @@ import (
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	runtime "k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/helm/pkg/proto/hapi/release"
 )
 
 // EDIT THIS FILE!  THIS IS SCAFFOLDING FOR YOU TO OWN!
@@ -49,6 +50,63 @@ type HelmReleaseSpec struct {
 	ReleaseName string               `json:"releaseName"`
 	Chart       ChartSpec            `json:"chart"`
 	Values      runtime.RawExtension `json:"values"`
+
+	// Promotion declares that the release follows another release, and
+	// is promoted once its upstream has been deployed for the soak time.
+	// +optional
+	Promotion *PromotionSpec `json:"promotion,omitempty"`
+
+	// Approval declares whether upgrades of the release must be manually
+	// approved before they're deployed.
+	// +optional
+	Approval *ApprovalSpec `json:"approval,omitempty"`
+}
+
+// ApprovalSpec defines the manual approval policy of release upgrades
+type ApprovalSpec struct {
+	// Required upgrades wait in the Pending approval phase until they're
+	// approved.
+	Required bool `json:"required"`
+}
+
+type PromotionStrategy string
+
+const (
+	// PromotionStrategyResource promotes a release by updating its
+	// HelmRelease resource in the cluster. It's only for releases that
+	// aren't deployed by the registry chart, whose next deployment would
+	// overwrite the promotion.
+	PromotionStrategyResource PromotionStrategy = "Resource"
+
+	// PromotionStrategyCommit promotes a release by committing the change
+	// to the HelmRelease's file in the operations repository.
+	PromotionStrategyCommit PromotionStrategy = "Commit"
+)
+
+// PromotionSpec defines the upstream release that a release is promoted from
+type PromotionSpec struct {
+	// Upstream is the name of the HelmRelease to promote from. It must be
+	// in the same namespace as the promoted release.
+	Upstream string `json:"upstream"`
+
+	// SoakTime is how long the upstream release must be deployed before
+	// its chart version and images are promoted.
+	// +optional
+	SoakTime metav1.Duration `json:"soakTime,omitempty"`
+
+	// Strategy is how promotions are applied. Defaults to "Resource".
+	// +kubebuilder:validation:Enum=Resource;Commit
+	// +optional
+	Strategy PromotionStrategy `json:"strategy,omitempty"`
+}
+
+// GetStrategy returns the promotion strategy, or the default strategy if
+// none is set.
+func (p PromotionSpec) GetStrategy() PromotionStrategy {
+	if p.Strategy == "" {
+		return PromotionStrategyResource
+	}
+	return p.Strategy
 }
 
 // HelmReleaseStatus defines the observed state of HelmRelease
@@ -57,6 +115,86 @@ type HelmReleaseStatus struct {
 	// Important: Run "make" to regenerate code after modifying this file
 
 	Conditions []HelmReleaseCondition `json:"conditions,omitempty"`
+
+	// ObservedGeneration is the most recent generation of the HelmRelease
+	// spec that the operator has acted on.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Promotion is the most recent promotion of the release from its
+	// upstream release.
+	Promotion *PromotionStatus `json:"promotion,omitempty"`
+
+	// Approval is the approval state of the release's most recent spec
+	// change.
+	Approval *ApprovalStatus `json:"approval,omitempty"`
+
+	// LastNotification is the most recent notification sent about the
+	// release's rollout.
+	LastNotification *NotificationStatus `json:"lastNotification,omitempty"`
+}
+
+// NotificationStatus defines a notification sent about a release's rollout
+type NotificationStatus struct {
+	// Phase is the rollout phase that was announced
+	Phase string `json:"phase"`
+
+	// Generation is the HelmRelease generation that was being rolled out
+	Generation int64       `json:"generation"`
+	SentAt     metav1.Time `json:"sentAt,omitempty"`
+}
+
+type ApprovalPhase string
+
+const (
+	// ApprovalPending means the spec change is waiting to be approved.
+	ApprovalPending ApprovalPhase = "Pending"
+
+	// ApprovalApproved means the spec change has been approved, and can
+	// be deployed.
+	ApprovalApproved ApprovalPhase = "Approved"
+)
+
+// ApprovalStatus defines the approval state of a pending spec change
+type ApprovalStatus struct {
+	Phase ApprovalPhase `json:"phase"`
+
+	// Generation is the HelmRelease generation waiting to be approved,
+	// or that has been approved.
+	Generation   int64       `json:"generation"`
+	ChartVersion string      `json:"chartVersion,omitempty"`
+	RequestedAt  metav1.Time `json:"requestedAt,omitempty"`
+	ApprovedBy   string      `json:"approvedBy,omitempty"`
+	ApprovedAt   metav1.Time `json:"approvedAt,omitempty"`
+}
+
+type PromotionPhase string
+
+const (
+	// PromotionPending means the promotion has been decided, but hasn't
+	// been applied to the release's spec yet.
+	PromotionPending PromotionPhase = "Pending"
+
+	// PromotionPromoted means the release's spec has been promoted.
+	PromotionPromoted PromotionPhase = "Promoted"
+)
+
+// PromotionStatus defines the observed state of a release promotion
+type PromotionStatus struct {
+	Upstream           string          `json:"upstream"`
+	Phase              PromotionPhase  `json:"phase"`
+	ChartVersion       string          `json:"chartVersion,omitempty"`
+	Images             []PromotedImage `json:"images,omitempty"`
+	LastTransitionTime metav1.Time     `json:"lastTransitionTime,omitempty"`
+
+	// CommittedAt is when syncd committed a pending promotion to the
+	// operations repository. Committed promotions aren't committed again.
+	CommittedAt metav1.Time `json:"committedAt,omitempty"`
+}
+
+// PromotedImage is a container image promoted from the upstream release
+type PromotedImage struct {
+	Repository string `json:"repository"`
+	Tag        string `json:"tag"`
 }
 
 type HelmReleaseCondition struct {
@@ -102,6 +240,35 @@ func (hr HelmRelease) HelmValues() map[string]interface{} {
 	return obj
 }
 
+// Deployed returns true when the release's current spec has been deployed.
+func (hr HelmRelease) Deployed() bool {
+	// releases deployed before the operator recorded the observed
+	// generation have none until their next upgrade, so it's unknown
+	observed := hr.Status.ObservedGeneration
+	return (observed == 0 || observed == hr.GetGeneration()) &&
+		hr.Status.GetCondition().Type == release.Status_DEPLOYED.String()
+}
+
+// ApprovalRequired returns true when the release's current spec must be
+// approved before it's deployed.
+func (hr HelmRelease) ApprovalRequired() bool {
+	return hr.Spec.Approval != nil && hr.Spec.Approval.Required &&
+		hr.Status.ObservedGeneration != hr.GetGeneration() && !hr.Approved()
+}
+
+// ApprovalPending returns true when the release's current spec is waiting
+// to be approved.
+func (hr HelmRelease) ApprovalPending() bool {
+	a := hr.Status.Approval
+	return a != nil && a.Phase == ApprovalPending && a.Generation == hr.GetGeneration()
+}
+
+// Approved returns true when the release's current spec has been approved.
+func (hr HelmRelease) Approved() bool {
+	a := hr.Status.Approval
+	return a != nil && a.Phase == ApprovalApproved && a.Generation == hr.GetGeneration()
+}
+
 func (s *HelmReleaseStatus) SetCondition(condition HelmReleaseCondition) {
 	now := metav1.Now()
 	condition.LastUpdateTime = now
@@ -189,6 +356,34 @@ func (a helmReleaseAnnotations) Sumologic() string {
 	return a.GetNamespaced("sumologic")
 }
 
+// TagPolicy returns the policy deciding which pushed image tags syncd
+// updates the release with
+func (a helmReleaseAnnotations) TagPolicy() string {
+	return a.GetNamespaced("tag-policy")
+}
+
+// ImageSync reports whether syncd updates the release's images when new ones
+// are pushed. Releases opt out with image-sync: "false".
+func (a helmReleaseAnnotations) ImageSync() bool {
+	v, err := strconv.ParseBool(a.GetNamespaced("image-sync"))
+	if err != nil {
+		return true
+	}
+
+	return v
+}
+
+// PinnedBy returns who pinned the release's images to their current tags, or
+// "" if they aren't pinned
+func (a helmReleaseAnnotations) PinnedBy() string {
+	return a.GetNamespaced("pinned-by")
+}
+
+// PinReason returns why the release's images are pinned
+func (a helmReleaseAnnotations) PinReason() string {
+	return a.GetNamespaced("pin-reason")
+}
+
 func init() {
 	SchemeBuilder.Register(&HelmRelease{}, &HelmReleaseList{})
 }
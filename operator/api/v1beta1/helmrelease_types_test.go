@@ -176,6 +176,9 @@ var _ = Describe("HelmRelease", func() {
 						"test": "annotation",
 						"helmreleases.shipit.wattpad.com/autodeploy": "true",
 						"helmreleases.shipit.wattpad.com/code":       "code",
+						"helmreleases.shipit.wattpad.com/tag-policy": "semver",
+						"helmreleases.shipit.wattpad.com/pinned-by":  "jane",
+						"helmreleases.shipit.wattpad.com/pin-reason": "investigating",
 					},
 				},
 				Spec: HelmReleaseSpec{},
@@ -191,6 +194,29 @@ var _ = Describe("HelmRelease", func() {
 
 			By("calling GetNamespaced")
 			Expect(annotations.GetNamespaced("code")).To(Equal("code"))
+
+			By("calling TagPolicy")
+			Expect(annotations.TagPolicy()).To(Equal("semver"))
+
+			By("calling ImageSync")
+			Expect(annotations.ImageSync()).To(BeTrue())
+
+			By("calling PinnedBy and PinReason")
+			Expect(annotations.PinnedBy()).To(Equal("jane"))
+			Expect(annotations.PinReason()).To(Equal("investigating"))
+		})
+
+		It("should opt out of image sync", func() {
+			hr := &HelmRelease{
+				ObjectMeta: metav1.ObjectMeta{
+					Annotations: map[string]string{
+						"helmreleases.shipit.wattpad.com/image-sync": "false",
+					},
+				},
+			}
+
+			Expect(hr.Annotations().ImageSync()).To(BeFalse())
+			Expect(hr.Annotations().PinnedBy()).To(BeEmpty())
 		})
 	})
 })
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	shipitv1beta1 "ship-it-operator/api/v1beta1"
+	"ship-it-operator/notifications"
 
 	"github.com/go-logr/logr"
 	"github.com/pkg/errors"
@@ -34,6 +35,7 @@ import (
 	helmerrors "k8s.io/helm/pkg/storage/errors"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
 )
 
@@ -47,12 +49,13 @@ type ChartDownloader interface {
 
 // Notifier sends a notification
 type Notifier interface {
-	Send(string) error
+	Send(notifications.Notification) error
 }
 
 type HelmClient interface {
 	DeleteRelease(rlsName string, opts ...helm.DeleteOption) (*hapi.UninstallReleaseResponse, error)
 	InstallReleaseFromChart(chart *chart.Chart, ns string, opts ...helm.InstallOption) (*hapi.InstallReleaseResponse, error)
+	ReleaseContent(rlsName string, opts ...helm.ContentOption) (*hapi.GetReleaseContentResponse, error)
 	ReleaseStatus(rlsName string, opts ...helm.StatusOption) (*hapi.GetReleaseStatusResponse, error)
 	RollbackRelease(rlsName string, opts ...helm.RollbackOption) (*hapi.RollbackReleaseResponse, error)
 	UpdateReleaseFromChart(rlsName string, chart *chart.Chart, opts ...helm.UpdateOption) (*hapi.UpdateReleaseResponse, error)
@@ -151,10 +154,36 @@ func (r *HelmReleaseReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error)
 func (r *HelmReleaseReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&shipitv1beta1.HelmRelease{}).
-		WithEventFilter(predicate.GenerationChangedPredicate{}).
+		WithEventFilter(deployPredicate()).
 		Complete(r)
 }
 
+// deployPredicate filters out HelmRelease events that don't change its spec,
+// except for the approval of a pending spec change.
+func deployPredicate() predicate.Predicate {
+	generationChanged := predicate.GenerationChangedPredicate{}
+
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			if generationChanged.Update(e) {
+				return true
+			}
+
+			oldRls, ok := e.ObjectOld.(*shipitv1beta1.HelmRelease)
+			if !ok {
+				return false
+			}
+
+			newRls, ok := e.ObjectNew.(*shipitv1beta1.HelmRelease)
+			if !ok {
+				return false
+			}
+
+			return !oldRls.Approved() && newRls.Approved()
+		},
+	}
+}
+
 func contains(strs []string, x string) bool {
 	for _, s := range strs {
 		if s == x {
@@ -204,7 +233,7 @@ func (r *HelmReleaseReconciler) delete(ctx context.Context, rls *shipitv1beta1.H
 	case release.Status_DELETING:
 		return ctrl.Result{RequeueAfter: r.GracePeriod}, nil
 	case release.Status_DELETED:
-		r.notifier.Send(fmt.Sprintf("🗑️ `%s` has been deleted.", releaseName))
+		r.notify(ctx, rls, r.notification(rls, notifications.PhaseDeleted))
 		return ctrl.Result{}, r.Update(ctx, clearFinalizer(rls))
 	}
 
@@ -248,14 +277,20 @@ func (r *HelmReleaseReconciler) deploy(ctx context.Context, rls *shipitv1beta1.H
 		return r.install(ctx, rls)
 	case release.Status_DEPLOYED:
 		if oldCondition.Type == release.Status_DEPLOYED.String() {
+			if rls.ApprovalRequired() {
+				return r.requestApproval(ctx, rls)
+			}
 			return r.upgrade(ctx, rls)
 		}
 
-		r.notifier.Send(fmt.Sprintf("🚢 `%s` is now deployed.", releaseName))
+		r.notify(ctx, rls, r.notification(rls, notifications.PhaseDeployed))
 		return ctrl.Result{}, r.Status().Update(ctx, r.manager.Deployed(rls))
 	case release.Status_FAILED:
+		n := r.notification(rls, notifications.PhaseFailed)
+		n.Message = resp.GetInfo().GetDescription()
+		r.notify(ctx, rls, n)
+
 		if err := r.Status().Update(ctx, r.manager.Failed(rls)); err != nil {
-			r.notifier.Send(fmt.Sprintf("🔥 `%s` failed to deploy.", releaseName))
 			return ctrl.Result{}, err
 		}
 
@@ -273,7 +308,7 @@ func (r *HelmReleaseReconciler) install(ctx context.Context, rls *shipitv1beta1.
 	chartSpec := rls.Spec.Chart
 	releaseName := rls.Spec.ReleaseName
 
-	chart, err := r.downloader.Download(ctx, chartSpec.URL(), chartSpec.Version)
+	chart, err := r.download(ctx, chartSpec)
 	if err != nil {
 		return ctrl.Result{}, errors.Wrapf(err, "failed to download chart %s", chartSpec.URL())
 	}
@@ -288,10 +323,26 @@ func (r *HelmReleaseReconciler) install(ctx context.Context, rls *shipitv1beta1.
 	}
 
 	r.Log.Info("installing HelmRelease", "release", releaseName)
-	r.notifier.Send(fmt.Sprintf("⌛ `%s` is being installed.", releaseName))
+	n := r.notification(rls, notifications.PhaseInstalling)
+	n.Images = imageChanges(rls, nil)
+	r.notify(ctx, rls, n)
 	return ctrl.Result{RequeueAfter: r.GracePeriod}, nil
 }
 
+// download downloads the chart, observing the download's latency and errors
+func (r *HelmReleaseReconciler) download(ctx context.Context, spec shipitv1beta1.ChartSpec) (*chart.Chart, error) {
+	start := time.Now()
+
+	chart, err := r.downloader.Download(ctx, spec.URL(), spec.Version)
+	chartDownloadDuration.WithLabelValues(spec.Name).Observe(time.Since(start).Seconds())
+
+	if err != nil {
+		chartDownloadErrors.WithLabelValues(spec.Name).Inc()
+	}
+
+	return chart, err
+}
+
 func (r *HelmReleaseReconciler) rollback(ctx context.Context, rls *shipitv1beta1.HelmRelease) (ctrl.Result, error) {
 	releaseName := rls.Spec.ReleaseName
 
@@ -305,19 +356,42 @@ func (r *HelmReleaseReconciler) rollback(ctx context.Context, rls *shipitv1beta1
 	}
 
 	r.Log.Info("rolling back HelmRelease", "release", releaseName)
-	r.notifier.Send(fmt.Sprintf("⚠️ `%s` is being rolled back.", releaseName))
+	r.notify(ctx, rls, r.notification(rls, notifications.PhaseRollingBack))
 	return ctrl.Result{RequeueAfter: r.GracePeriod}, nil
 }
 
+func (r *HelmReleaseReconciler) requestApproval(ctx context.Context, rls *shipitv1beta1.HelmRelease) (ctrl.Result, error) {
+	releaseName := rls.Spec.ReleaseName
+
+	if rls.ApprovalPending() {
+		// the approval has already been requested
+		return ctrl.Result{}, nil
+	}
+
+	if err := r.Status().Update(ctx, r.manager.RequestApproval(rls)); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	r.Log.Info("HelmRelease upgrade is waiting for approval", "release", releaseName)
+
+	n := r.notification(rls, notifications.PhaseApprovalPending)
+	n.Images = imageChanges(rls, r.deployedImages(releaseName))
+	r.notify(ctx, rls, n)
+
+	return ctrl.Result{}, nil
+}
+
 func (r *HelmReleaseReconciler) upgrade(ctx context.Context, rls *shipitv1beta1.HelmRelease) (ctrl.Result, error) {
 	chartSpec := rls.Spec.Chart
 	releaseName := rls.Spec.ReleaseName
 
-	chart, err := r.downloader.Download(ctx, chartSpec.URL(), chartSpec.Version)
+	chart, err := r.download(ctx, chartSpec)
 	if err != nil {
 		return ctrl.Result{}, errors.Wrapf(err, "failed to download chart %s", chartSpec.URL())
 	}
 
+	previous := r.deployedImages(releaseName)
+
 	rls, err = r.manager.Upgrade(rls, chart)
 	if err != nil {
 		return ctrl.Result{}, errors.Wrapf(err, "failed to upgrade release %s using chart %s", releaseName, chartSpec.URL())
@@ -328,6 +402,8 @@ func (r *HelmReleaseReconciler) upgrade(ctx context.Context, rls *shipitv1beta1.
 	}
 
 	r.Log.Info("upgrading HelmRelease", "release", releaseName)
-	r.notifier.Send(fmt.Sprintf("⌛ `%s` is being upgraded.", releaseName))
+	n := r.notification(rls, notifications.PhaseUpgrading)
+	n.Images = imageChanges(rls, previous)
+	r.notify(ctx, rls, n)
 	return ctrl.Result{RequeueAfter: r.GracePeriod}, nil
 }
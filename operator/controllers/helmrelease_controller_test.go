@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	shipitv1beta1 "ship-it-operator/api/v1beta1"
+	"ship-it-operator/notifications"
 
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -20,17 +21,28 @@ import (
 	"k8s.io/helm/pkg/proto/hapi/release"
 	hapi "k8s.io/helm/pkg/proto/hapi/release"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 )
 
 type fakeNotifier struct {
-	sentNotifications []string
+	sentNotifications []notifications.Notification
 }
 
-func (m *fakeNotifier) Send(msg string) error {
-	m.sentNotifications = append(m.sentNotifications, msg)
+func (m *fakeNotifier) Send(n notifications.Notification) error {
+	m.sentNotifications = append(m.sentNotifications, n)
 	return nil
 }
 
+func (m *fakeNotifier) approvalRequests() []int64 {
+	var generations []int64
+	for _, n := range m.sentNotifications {
+		if n.Phase == notifications.PhaseApprovalPending {
+			generations = append(generations, n.Generation)
+		}
+	}
+	return generations
+}
+
 type mockDownloader struct {
 	mock.Mock
 }
@@ -77,6 +89,7 @@ var _ = Describe("HelmReleaseReconciler", func() {
 	var (
 		downloader  *mockDownloader
 		helmClient  *helm.FakeClient
+		notifier    *fakeNotifier
 		reconciler  *HelmReleaseReconciler
 		testRelease *shipitv1beta1.HelmRelease
 	)
@@ -84,7 +97,8 @@ var _ = Describe("HelmReleaseReconciler", func() {
 	BeforeEach(func() {
 		downloader = new(mockDownloader)
 		helmClient = new(helm.FakeClient)
-		reconciler = NewHelmReleaseReconciler(log, k8sClient, &fakeNotifier{}, helmClient, downloader, &recorder, GracePeriod(42), Namespace("test"))
+		notifier = new(fakeNotifier)
+		reconciler = NewHelmReleaseReconciler(log, k8sClient, notifier, helmClient, downloader, &recorder, GracePeriod(42), Namespace("test"))
 
 		testRelease = &shipitv1beta1.HelmRelease{
 			TypeMeta: metav1.TypeMeta{
@@ -277,6 +291,86 @@ var _ = Describe("HelmReleaseReconciler", func() {
 			Expect(isHelmReleaseNotFound(releaseName, err)).To(BeTrue())
 		})
 	})
+
+	When("the HelmRelease requires approval", func() {
+		It("should wait for the upgrade to be approved", func() {
+			testRelease.SetGeneration(1)
+			testRelease.Spec.Approval = &shipitv1beta1.ApprovalSpec{Required: true}
+			Expect(k8sClient.Create(ctx, setFinalizer(testRelease))).To(Succeed())
+
+			_, err := helmClient.InstallReleaseFromChart(testChart, releaseNamespace, helm.ReleaseName(releaseName))
+			Expect(err).To(BeNil())
+
+			// fake a previously deployed spec
+			var got shipitv1beta1.HelmRelease
+			Expect(k8sClient.Get(ctx, releaseKey, &got)).To(Succeed())
+			got.Status.SetCondition(shipitv1beta1.HelmReleaseCondition{
+				Type: hapi.Status_DEPLOYED.String(),
+			})
+			got.Status.ObservedGeneration = got.GetGeneration() - 1
+			Expect(k8sClient.Status().Update(ctx, &got)).To(Succeed())
+
+			By("requesting an approval for the spec change")
+
+			res, err := reconciler.Reconcile(request)
+			Expect(err).To(BeNil())
+			Expect(res).To(BeZero())
+
+			Expect(k8sClient.Get(ctx, releaseKey, &got)).To(Succeed())
+			Expect(got.Status.GetCondition().Type).To(Equal(hapi.Status_DEPLOYED.String()))
+			Expect(got.ApprovalPending()).To(BeTrue())
+			Expect(got.Status.Approval.ChartVersion).To(Equal(testRelease.Spec.Chart.Version))
+			Expect(notifier.approvalRequests()).To(Equal([]int64{got.GetGeneration()}))
+
+			By("requesting the approval only once")
+
+			_, err = reconciler.Reconcile(request)
+			Expect(err).To(BeNil())
+			Expect(notifier.approvalRequests()).To(HaveLen(1))
+
+			By("upgrading the release once it's approved")
+
+			got.Status.Approval.Phase = shipitv1beta1.ApprovalApproved
+			got.Status.Approval.ApprovedBy = "approver"
+			Expect(k8sClient.Status().Update(ctx, &got)).To(Succeed())
+
+			downloader.On("Download", ctx, testRelease.Spec.Chart.URL(), testRelease.Spec.Chart.Version).Return(testChart, nil)
+
+			res, err = reconciler.Reconcile(request)
+			Expect(err).To(BeNil())
+			Expect(res.RequeueAfter).To(Equal(reconciler.GracePeriod))
+
+			Expect(k8sClient.Get(ctx, releaseKey, &got)).To(Succeed())
+			Expect(got.Status.GetCondition().Type).To(Equal(hapi.Status_PENDING_UPGRADE.String()))
+			Expect(got.Status.GetCondition().Message).To(ContainSubstring("approver"))
+			Expect(got.ApprovalRequired()).To(BeFalse())
+		})
+	})
+
+	Context("deployPredicate", func() {
+		It("should observe approvals of pending spec changes", func() {
+			pending := testRelease.DeepCopy()
+			pending.Status.Approval = &shipitv1beta1.ApprovalStatus{
+				Phase: shipitv1beta1.ApprovalPending,
+			}
+
+			approved := pending.DeepCopy()
+			approved.Status.Approval.Phase = shipitv1beta1.ApprovalApproved
+
+			update := func(oldRls, newRls *shipitv1beta1.HelmRelease) event.UpdateEvent {
+				return event.UpdateEvent{
+					MetaOld:   oldRls,
+					ObjectOld: oldRls,
+					MetaNew:   newRls,
+					ObjectNew: newRls,
+				}
+			}
+
+			Expect(deployPredicate().Update(update(pending, approved))).To(BeTrue())
+			Expect(deployPredicate().Update(update(approved, approved))).To(BeFalse())
+			Expect(deployPredicate().Update(update(testRelease, pending))).To(BeFalse())
+		})
+	})
 })
 
 // reaching into the fake client internals to fake a failed release
@@ -0,0 +1,122 @@
+package controllers
+
+import (
+	"context"
+	"time"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/helm/pkg/proto/hapi/release"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+const (
+	operationInstall  = "install"
+	operationUpgrade  = "upgrade"
+	operationRollback = "rollback"
+	operationDelete   = "delete"
+)
+
+var (
+	releaseOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shipit_release_operations_total",
+		Help: "Total number of helm operations performed on releases",
+	}, []string{"release", "operation", "result"})
+
+	releaseFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shipit_release_failures_total",
+		Help: "Total number of releases that entered the FAILED state",
+	}, []string{"release", "reason"})
+
+	releaseTimeToDeployed = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "shipit_release_time_to_deployed_seconds",
+		Help:    "Time from starting a helm operation until the release is deployed",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+	}, []string{"release", "operation"})
+
+	chartDownloadDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "shipit_chart_download_duration_seconds",
+		Help: "Latency of chart downloads from the chart repository",
+	}, []string{"chart"})
+
+	chartDownloadErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shipit_chart_download_errors_total",
+		Help: "Total number of failed chart downloads",
+	}, []string{"chart"})
+
+	releaseNotifications = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "shipit_release_notifications_total",
+		Help: "Total number of release notifications, by whether they were sent, suppressed as duplicates, or failed",
+	}, []string{"phase", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		releaseOperations,
+		releaseFailures,
+		releaseTimeToDeployed,
+		chartDownloadDuration,
+		chartDownloadErrors,
+		releaseNotifications,
+	)
+}
+
+func observeOperation(rls *shipitv1beta1.HelmRelease, operation string, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+
+	releaseOperations.WithLabelValues(rls.Spec.ReleaseName, operation, result).Inc()
+}
+
+var releasesDesc = prometheus.NewDesc(
+	"shipit_releases",
+	"Number of HelmReleases per status condition",
+	[]string{"condition"},
+	nil,
+)
+
+// ReleaseCollector collects the number of HelmReleases in each status
+// condition whenever the metrics are scraped.
+type ReleaseCollector struct {
+	client  client.Reader
+	timeout time.Duration
+}
+
+func NewReleaseCollector(c client.Reader) *ReleaseCollector {
+	return &ReleaseCollector{
+		client:  c,
+		timeout: 10 * time.Second,
+	}
+}
+
+func (c *ReleaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- releasesDesc
+}
+
+func (c *ReleaseCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	var list shipitv1beta1.HelmReleaseList
+	if err := c.client.List(ctx, &list); err != nil {
+		ch <- prometheus.NewInvalidMetric(releasesDesc, err)
+		return
+	}
+
+	counts := make(map[string]int)
+	for _, rls := range list.Items {
+		condition := rls.Status.GetCondition().Type
+		if condition == "" {
+			condition = release.Status_UNKNOWN.String()
+		}
+		counts[condition]++
+	}
+
+	for condition, n := range counts {
+		ch <- prometheus.MustNewConstMetric(releasesDesc, prometheus.GaugeValue, float64(n), condition)
+	}
+}
@@ -0,0 +1,58 @@
+package controllers
+
+import (
+	"strings"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	hapi "k8s.io/helm/pkg/proto/hapi/release"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("ReleaseCollector", func() {
+	newRelease := func(name string, condition hapi.Status_Code) *shipitv1beta1.HelmRelease {
+		rls := &shipitv1beta1.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+			},
+		}
+
+		if condition != hapi.Status_UNKNOWN {
+			rls.Status.SetCondition(shipitv1beta1.HelmReleaseCondition{
+				Type: condition.String(),
+			})
+		}
+
+		return rls
+	}
+
+	It("should count the releases in each condition", func() {
+		scheme := runtime.NewScheme()
+		shipitv1beta1.AddToScheme(scheme)
+
+		client := fake.NewFakeClientWithScheme(scheme,
+			newRelease("foo", hapi.Status_DEPLOYED),
+			newRelease("bar", hapi.Status_DEPLOYED),
+			newRelease("baz", hapi.Status_FAILED),
+			newRelease("qux", hapi.Status_UNKNOWN),
+		)
+
+		expected := `
+# HELP shipit_releases Number of HelmReleases per status condition
+# TYPE shipit_releases gauge
+shipit_releases{condition="DEPLOYED"} 2
+shipit_releases{condition="FAILED"} 1
+shipit_releases{condition="UNKNOWN"} 1
+`
+
+		err := testutil.CollectAndCompare(NewReleaseCollector(client), strings.NewReader(expected))
+		Expect(err).To(BeNil())
+	})
+})
@@ -0,0 +1,113 @@
+package controllers
+
+import (
+	"context"
+	"sort"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+	"ship-it-operator/notifications"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/helm/pkg/chartutil"
+)
+
+// notification describes the release's rollout phase, without any image
+// changes.
+func (r *HelmReleaseReconciler) notification(rls *shipitv1beta1.HelmRelease, phase notifications.Phase) notifications.Notification {
+	annotations := rls.Annotations()
+
+	n := notifications.Notification{
+		Name:         rls.GetName(),
+		Namespace:    rls.GetNamespace(),
+		Generation:   rls.GetGeneration(),
+		Release:      rls.Spec.ReleaseName,
+		Phase:        phase,
+		ChartVersion: rls.Spec.Chart.Version,
+		Squad:        annotations.Squad(),
+		Channel:      annotations.Slack(),
+		Links: notifications.Links{
+			Code:      annotations.Code(),
+			Datadog:   annotations.Datadog(),
+			Sumologic: annotations.Sumologic(),
+		},
+	}
+
+	if resp, err := r.helm.ReleaseContent(n.Release); err == nil {
+		n.Revision = resp.GetRelease().GetVersion()
+	}
+
+	return n
+}
+
+// deployedImages returns the image tags of the release's currently deployed
+// revision. The result is empty if the release can't be read.
+func (r *HelmReleaseReconciler) deployedImages(releaseName string) map[string]string {
+	resp, err := r.helm.ReleaseContent(releaseName)
+	if err != nil {
+		return nil
+	}
+
+	values, err := chartutil.ReadValues([]byte(resp.GetRelease().GetConfig().GetRaw()))
+	if err != nil {
+		return nil
+	}
+
+	return valuesImageTags(values)
+}
+
+// imageChanges lists the release's images, along with the tags that they're
+// replacing.
+func imageChanges(rls *shipitv1beta1.HelmRelease, previous map[string]string) []notifications.Image {
+	var images []notifications.Image
+
+	for repo, tag := range imageTags(rls) {
+		img := notifications.Image{
+			Repository: repo,
+			Tag:        tag,
+		}
+
+		if old, ok := previous[repo]; ok && old != tag {
+			img.PreviousTag = old
+		}
+
+		images = append(images, img)
+	}
+
+	sort.Slice(images, func(i, j int) bool {
+		return images[i].Repository < images[j].Repository
+	})
+
+	return images
+}
+
+// notify sends the notification, unless the phase has already been
+// announced for the release's generation. The last notification is recorded
+// in the release's status, so duplicates are suppressed across reconciles and
+// operator restarts. Notifications are best effort, so failures are only
+// logged.
+func (r *HelmReleaseReconciler) notify(ctx context.Context, rls *shipitv1beta1.HelmRelease, n notifications.Notification) {
+	phase := string(n.Phase)
+
+	if last := rls.Status.LastNotification; last != nil && last.Phase == phase && last.Generation == n.Generation {
+		releaseNotifications.WithLabelValues(phase, "suppressed").Inc()
+		return
+	}
+
+	if err := r.notifier.Send(n); err != nil {
+		releaseNotifications.WithLabelValues(phase, "error").Inc()
+		r.Log.Error(err, "failed to send notification", "release", n.Release, "phase", n.Phase)
+		return
+	}
+
+	releaseNotifications.WithLabelValues(phase, "sent").Inc()
+
+	rls.Status.LastNotification = &shipitv1beta1.NotificationStatus{
+		Phase:      phase,
+		Generation: n.Generation,
+		SentAt:     metav1.Now(),
+	}
+
+	if err := r.Status().Update(ctx, rls); err != nil {
+		r.Log.Error(err, "failed to record notification", "release", n.Release, "phase", n.Phase)
+	}
+}
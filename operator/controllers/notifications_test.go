@@ -0,0 +1,125 @@
+package controllers
+
+import (
+	"context"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+	"ship-it-operator/notifications"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/helm/pkg/helm"
+	"k8s.io/helm/pkg/proto/hapi/chart"
+	hapi "k8s.io/helm/pkg/proto/hapi/release"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+var _ = Describe("Notifications", func() {
+	var (
+		helmClient *helm.FakeClient
+		notifier   *fakeNotifier
+		reconciler *HelmReleaseReconciler
+		rls        *shipitv1beta1.HelmRelease
+	)
+
+	BeforeEach(func() {
+		helmClient = &helm.FakeClient{
+			Rels: []*hapi.Release{
+				{
+					Name:    "foo",
+					Version: 3,
+					Config:  &chart.Config{Raw: "image:\n  repository: foo\n  tag: old\n"},
+				},
+			},
+		}
+
+		scheme := runtime.NewScheme()
+		shipitv1beta1.AddToScheme(scheme)
+
+		notifier = new(fakeNotifier)
+		reconciler = NewHelmReleaseReconciler(ctrl.Log, fake.NewFakeClientWithScheme(scheme), notifier, helmClient, nil, nil)
+
+		rls = &shipitv1beta1.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       "foo",
+				Namespace:  "default",
+				Generation: 2,
+				Annotations: map[string]string{
+					"helmreleases.shipit.wattpad.com/code":  "https://github.com/example/foo",
+					"helmreleases.shipit.wattpad.com/slack": "foo-alerts",
+					"helmreleases.shipit.wattpad.com/squad": "bar",
+				},
+			},
+			Spec: shipitv1beta1.HelmReleaseSpec{
+				ReleaseName: "foo",
+				Chart: shipitv1beta1.ChartSpec{
+					Name:    "foo",
+					Version: "1.2.3",
+				},
+				Values: runtime.RawExtension{
+					Raw: []byte(`{"image":{"repository":"foo","tag":"new"},"sidecar":{"image":{"repository":"bar","tag":"baz"}}}`),
+				},
+			},
+		}
+	})
+
+	It("should describe the release", func() {
+		n := reconciler.notification(rls, notifications.PhaseUpgrading)
+
+		Expect(n.Name).To(Equal("foo"))
+		Expect(n.Namespace).To(Equal("default"))
+		Expect(n.Generation).To(Equal(int64(2)))
+		Expect(n.Release).To(Equal("foo"))
+		Expect(n.Phase).To(Equal(notifications.PhaseUpgrading))
+		Expect(n.ChartVersion).To(Equal("1.2.3"))
+		Expect(n.Revision).To(Equal(int32(3)))
+		Expect(n.Squad).To(Equal("bar"))
+		Expect(n.Channel).To(Equal("foo-alerts"))
+		Expect(n.Links.Code).To(Equal("https://github.com/example/foo"))
+	})
+
+	It("should list the release's image changes", func() {
+		images := imageChanges(rls, reconciler.deployedImages("foo"))
+
+		Expect(images).To(Equal([]notifications.Image{
+			{Repository: "docker.io/library/bar", Tag: "baz"},
+			{Repository: "docker.io/library/foo", Tag: "new", PreviousTag: "old"},
+		}))
+	})
+
+	It("should tolerate a missing release", func() {
+		rls.Spec.ReleaseName = "missing"
+
+		Expect(reconciler.deployedImages("missing")).To(BeEmpty())
+		Expect(reconciler.notification(rls, notifications.PhaseInstalling).Revision).To(BeZero())
+	})
+
+	It("should not repeat a notification", func() {
+		ctx := context.Background()
+		Expect(reconciler.Create(ctx, rls)).To(Succeed())
+
+		By("sending the first notification of the phase")
+		reconciler.notify(ctx, rls, reconciler.notification(rls, notifications.PhaseFailed))
+		Expect(notifier.sentNotifications).To(HaveLen(1))
+
+		var got shipitv1beta1.HelmRelease
+		Expect(reconciler.Get(ctx, types.NamespacedName{Name: "foo", Namespace: "default"}, &got)).To(Succeed())
+		Expect(got.Status.LastNotification.Phase).To(Equal(string(notifications.PhaseFailed)))
+		Expect(got.Status.LastNotification.Generation).To(Equal(int64(2)))
+
+		By("suppressing the phase's repeated notifications")
+		reconciler.notify(ctx, &got, reconciler.notification(&got, notifications.PhaseFailed))
+		Expect(notifier.sentNotifications).To(HaveLen(1))
+
+		By("sending the notifications of other phases and generations")
+		reconciler.notify(ctx, &got, reconciler.notification(&got, notifications.PhaseRollingBack))
+		got.SetGeneration(3)
+		reconciler.notify(ctx, &got, reconciler.notification(&got, notifications.PhaseRollingBack))
+		Expect(notifier.sentNotifications).To(HaveLen(3))
+	})
+})
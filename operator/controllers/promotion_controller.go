@@ -0,0 +1,307 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+	"ship-it-operator/image"
+
+	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
+	apierrs "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+)
+
+// PromotionReconciler promotes HelmReleases that follow an upstream release.
+// Once the upstream release has been deployed for the promotion's soak time,
+// its chart version and image tags are copied to the downstream release.
+type PromotionReconciler struct {
+	client.Client
+
+	Log logr.Logger
+
+	recorder record.EventRecorder
+	now      func() time.Time
+}
+
+func NewPromotionReconciler(l logr.Logger, client client.Client, rec record.EventRecorder) *PromotionReconciler {
+	return &PromotionReconciler{
+		Client: client,
+		Log:    l.WithName("controllers").WithName("Promotion"),
+
+		recorder: rec,
+		now:      time.Now,
+	}
+}
+
+func (r *PromotionReconciler) SetupWithManager(mgr ctrl.Manager) error {
+	// upstream status changes don't change the upstream's generation, so
+	// unlike the HelmReleaseReconciler every event is observed
+	return ctrl.NewControllerManagedBy(mgr).
+		Named("promotion").
+		For(&shipitv1beta1.HelmRelease{}).
+		Watches(&source.Kind{Type: &shipitv1beta1.HelmRelease{}}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: handler.ToRequestsFunc(r.followers),
+		}).
+		Complete(r)
+}
+
+// followers maps an upstream release to the releases that are promoted from it
+func (r *PromotionReconciler) followers(obj handler.MapObject) []reconcile.Request {
+	var list shipitv1beta1.HelmReleaseList
+	if err := r.List(context.Background(), &list, client.InNamespace(obj.Meta.GetNamespace())); err != nil {
+		r.Log.Error(err, "failed to list HelmReleases", "upstream", obj.Meta.GetName())
+		return nil
+	}
+
+	var reqs []reconcile.Request
+
+	for _, hr := range list.Items {
+		if p := hr.Spec.Promotion; p != nil && p.Upstream == obj.Meta.GetName() {
+			reqs = append(reqs, reconcile.Request{
+				NamespacedName: types.NamespacedName{
+					Name:      hr.GetName(),
+					Namespace: hr.GetNamespace(),
+				},
+			})
+		}
+	}
+
+	return reqs
+}
+
+func (r *PromotionReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
+	ctx := context.Background()
+	log := r.Log.WithValues("name", req.NamespacedName)
+
+	downstream := new(shipitv1beta1.HelmRelease)
+	if err := r.Get(ctx, req.NamespacedName, downstream); err != nil {
+		if apierrs.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	promotion := downstream.Spec.Promotion
+	if promotion == nil || !downstream.DeletionTimestamp.IsZero() {
+		return ctrl.Result{}, nil
+	}
+
+	upstream := new(shipitv1beta1.HelmRelease)
+	upstreamKey := types.NamespacedName{
+		Name:      promotion.Upstream,
+		Namespace: req.Namespace,
+	}
+
+	if err := r.Get(ctx, upstreamKey, upstream); err != nil {
+		if apierrs.IsNotFound(err) {
+			log.Info("upstream HelmRelease doesn't exist", "upstream", promotion.Upstream)
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, err
+	}
+
+	target := promotionTarget(upstream, downstream)
+
+	if target.empty() {
+		// the downstream spec is up to date with its upstream, which
+		// completes any pending promotion
+		if status := downstream.Status.Promotion; status != nil && status.Phase == shipitv1beta1.PromotionPending {
+			return ctrl.Result{}, r.promoted(ctx, downstream, status)
+		}
+		return ctrl.Result{}, nil
+	}
+
+	if !upstream.Deployed() {
+		log.Info("upstream HelmRelease isn't deployed", "upstream", upstream.GetName())
+		return ctrl.Result{}, nil
+	}
+
+	if wait := soakRemaining(upstream, promotion.SoakTime.Duration, r.now()); wait > 0 {
+		log.Info("upstream HelmRelease is soaking", "upstream", upstream.GetName(), "remaining", wait.String())
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	status := target.status(upstream.GetName())
+
+	switch promotion.GetStrategy() {
+	case shipitv1beta1.PromotionStrategyCommit:
+		// syncd commits pending promotions to the operations repository,
+		// and the promotion completes once the registry chart is deployed
+		if current := downstream.Status.Promotion; current != nil && current.Phase == shipitv1beta1.PromotionPending && target.equals(current) {
+			return ctrl.Result{}, nil
+		}
+
+		return ctrl.Result{}, r.pending(ctx, downstream, status)
+	default:
+		// the registry chart overwrites the spec of the releases it
+		// deploys, so this is only for releases outside the chart
+		target.apply(downstream)
+
+		if err := r.Update(ctx, downstream); err != nil {
+			return ctrl.Result{}, err
+		}
+
+		log.Info("promoted HelmRelease", "upstream", upstream.GetName())
+		return ctrl.Result{}, r.promoted(ctx, downstream, status)
+	}
+}
+
+func (r *PromotionReconciler) pending(ctx context.Context, rls *shipitv1beta1.HelmRelease, status *shipitv1beta1.PromotionStatus) error {
+	status.Phase = shipitv1beta1.PromotionPending
+	status.LastTransitionTime = metav1.NewTime(r.now())
+	rls.Status.Promotion = status
+
+	r.recorder.Event(rls, v1.EventTypeNormal, string(status.Phase), fmt.Sprintf("Promoting release from %s", status.Upstream))
+	return r.Status().Update(ctx, rls)
+}
+
+func (r *PromotionReconciler) promoted(ctx context.Context, rls *shipitv1beta1.HelmRelease, status *shipitv1beta1.PromotionStatus) error {
+	status.Phase = shipitv1beta1.PromotionPromoted
+	status.LastTransitionTime = metav1.NewTime(r.now())
+	rls.Status.Promotion = status
+
+	r.recorder.Event(rls, v1.EventTypeNormal, string(status.Phase), fmt.Sprintf("Promoted release from %s", status.Upstream))
+	return r.Status().Update(ctx, rls)
+}
+
+// soakRemaining returns how much longer the upstream release must stay
+// deployed before it can be promoted.
+func soakRemaining(upstream *shipitv1beta1.HelmRelease, soak time.Duration, now time.Time) time.Duration {
+	deployedAt := upstream.Status.GetCondition().LastTransitionTime.Time
+	return deployedAt.Add(soak).Sub(now)
+}
+
+// promotion is the difference between a downstream release's spec and the
+// spec of its upstream release.
+type promotion struct {
+	ChartVersion string
+	Images       map[string]string
+}
+
+func (p promotion) empty() bool {
+	return p.ChartVersion == "" && len(p.Images) == 0
+}
+
+func (p promotion) status(upstream string) *shipitv1beta1.PromotionStatus {
+	status := &shipitv1beta1.PromotionStatus{
+		Upstream:     upstream,
+		ChartVersion: p.ChartVersion,
+	}
+
+	for repo, tag := range p.Images {
+		status.Images = append(status.Images, shipitv1beta1.PromotedImage{
+			Repository: repo,
+			Tag:        tag,
+		})
+	}
+
+	sort.Slice(status.Images, func(i, j int) bool {
+		return status.Images[i].Repository < status.Images[j].Repository
+	})
+
+	return status
+}
+
+func (p promotion) equals(status *shipitv1beta1.PromotionStatus) bool {
+	if p.ChartVersion != status.ChartVersion || len(p.Images) != len(status.Images) {
+		return false
+	}
+
+	for _, img := range status.Images {
+		if p.Images[img.Repository] != img.Tag {
+			return false
+		}
+	}
+
+	return true
+}
+
+// apply promotes the release's spec in place
+func (p promotion) apply(rls *shipitv1beta1.HelmRelease) {
+	if p.ChartVersion != "" {
+		rls.Spec.Chart.Version = p.ChartVersion
+	}
+
+	if len(p.Images) == 0 {
+		return
+	}
+
+	values := rls.HelmValues()
+
+	image.VisitValues(values, func(ref *image.Reference, setTag func(string)) {
+		if tag, ok := p.Images[ref.Name()]; ok {
+			setTag(tag)
+		}
+	})
+
+	if raw, err := json.Marshal(values); err == nil {
+		rls.Spec.Values.Raw = raw
+	}
+}
+
+// promotionTarget returns the chart version and image tags of the upstream
+// release that differ from the downstream release. Images are only promoted
+// when the downstream release uses the same image repository.
+func promotionTarget(upstream, downstream *shipitv1beta1.HelmRelease) promotion {
+	var p promotion
+
+	if upstream.Spec.Chart.Name == downstream.Spec.Chart.Name &&
+		upstream.Spec.Chart.Version != downstream.Spec.Chart.Version {
+		p.ChartVersion = upstream.Spec.Chart.Version
+	}
+
+	upstreamTags := imageTags(upstream)
+
+	for repo, tag := range imageTags(downstream) {
+		if desired, ok := upstreamTags[repo]; ok && desired != "" && desired != tag {
+			if p.Images == nil {
+				p.Images = make(map[string]string)
+			}
+			p.Images[repo] = desired
+		}
+	}
+
+	return p
+}
+
+// imageTags returns the tag of every image repository in the release's
+// values. Repositories used with more than one tag are ambiguous, and are
+// omitted.
+func imageTags(rls *shipitv1beta1.HelmRelease) map[string]string {
+	return valuesImageTags(rls.HelmValues())
+}
+
+// valuesImageTags returns the tag of every unambiguous image repository in
+// the values, keyed by its canonical name, e.g. "docker.io/library/redis".
+// Images are found wherever syncd edits them, in blocks, strings and lists.
+func valuesImageTags(values map[string]interface{}) map[string]string {
+	tags := make(map[string]string)
+	ambiguous := make(map[string]bool)
+
+	image.VisitValues(values, func(ref *image.Reference, _ func(string)) {
+		repo := ref.Name()
+
+		if existing, ok := tags[repo]; ok && existing != ref.Tag {
+			ambiguous[repo] = true
+		}
+		tags[repo] = ref.Tag
+	})
+
+	for repo := range ambiguous {
+		delete(tags, repo)
+	}
+
+	return tags
+}
@@ -0,0 +1,127 @@
+package controllers
+
+import (
+	"time"
+
+	shipitv1beta1 "ship-it-operator/api/v1beta1"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	hapi "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+var _ = Describe("PromotionReconciler", func() {
+	var upstream, downstream *shipitv1beta1.HelmRelease
+
+	newRelease := func(name, version, tag string) *shipitv1beta1.HelmRelease {
+		return &shipitv1beta1.HelmRelease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       name,
+				Generation: 1,
+			},
+			Spec: shipitv1beta1.HelmReleaseSpec{
+				ReleaseName: name,
+				Chart: shipitv1beta1.ChartSpec{
+					Repository: "s3://charts",
+					Name:       "microservice",
+					Version:    version,
+				},
+				Values: runtime.RawExtension{
+					Raw: []byte(`{"image":{"repository":"foo/bar","tag":"` + tag + `"},"sidecar":{"image":{"repository":"foo/baz","tag":"v1"}}}`),
+				},
+			},
+		}
+	}
+
+	BeforeEach(func() {
+		upstream = newRelease("staging", "1.1.0", "newtag")
+		downstream = newRelease("production", "1.0.0", "oldtag")
+		downstream.Spec.Promotion = &shipitv1beta1.PromotionSpec{
+			Upstream: upstream.GetName(),
+		}
+	})
+
+	It("should find the upstream's changes", func() {
+		target := promotionTarget(upstream, downstream)
+		Expect(target.ChartVersion).To(Equal("1.1.0"))
+		Expect(target.Images).To(Equal(map[string]string{"docker.io/foo/bar": "newtag"}))
+
+		By("ignoring releases that are up to date")
+		Expect(promotionTarget(upstream, upstream).empty()).To(BeTrue())
+
+		By("ignoring releases of a different chart")
+		downstream.Spec.Chart.Name = "other"
+		Expect(promotionTarget(upstream, downstream).ChartVersion).To(BeEmpty())
+	})
+
+	It("should find images in strings and lists", func() {
+		upstream.Spec.Values.Raw = []byte(`{"image":"index.docker.io/foo/bar:newtag","sidecars":[{"image":"registry.io/baz:v2"}]}`)
+		downstream.Spec.Values.Raw = []byte(`{"image":{"repository":"foo/bar","tag":"oldtag"},"sidecars":[{"image":{"repository":"registry.io/baz","tag":"v1","digest":"sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"}}]}`)
+
+		target := promotionTarget(upstream, downstream)
+		Expect(target.Images).To(Equal(map[string]string{
+			"docker.io/foo/bar": "newtag",
+			"registry.io/baz":   "v2",
+		}))
+
+		By("setting the tags where the downstream release's images are written")
+		target.apply(downstream)
+		Expect(string(downstream.Spec.Values.Raw)).To(MatchJSON(`{"image":{"repository":"foo/bar","tag":"newtag"},"sidecars":[{"image":{"repository":"registry.io/baz","tag":"v2"}}]}`))
+		Expect(promotionTarget(upstream, downstream).Images).To(BeEmpty())
+
+		By("keeping the names of images set as strings")
+		upstream.Spec.Values.Raw, downstream.Spec.Values.Raw = []byte(`{"image":{"repository":"foo/bar","tag":"v3"}}`), []byte(`{"image":"foo/bar:newtag"}`)
+		promotionTarget(upstream, downstream).apply(downstream)
+		Expect(string(downstream.Spec.Values.Raw)).To(MatchJSON(`{"image":"foo/bar:v3"}`))
+	})
+
+	It("should apply the promotion to the downstream release", func() {
+		target := promotionTarget(upstream, downstream)
+		target.apply(downstream)
+
+		Expect(downstream.Spec.Chart.Version).To(Equal("1.1.0"))
+		Expect(downstream.HelmValues()).To(Equal(upstream.HelmValues()))
+		Expect(promotionTarget(upstream, downstream).empty()).To(BeTrue())
+	})
+
+	It("should record the promotion's status", func() {
+		status := promotionTarget(upstream, downstream).status(upstream.GetName())
+
+		Expect(status.Upstream).To(Equal(upstream.GetName()))
+		Expect(status.ChartVersion).To(Equal("1.1.0"))
+		Expect(status.Images).To(ConsistOf(shipitv1beta1.PromotedImage{
+			Repository: "docker.io/foo/bar",
+			Tag:        "newtag",
+		}))
+
+		Expect(promotionTarget(upstream, downstream).equals(status)).To(BeTrue())
+	})
+
+	It("should wait for the upstream release to soak", func() {
+		Expect(upstream.Deployed()).To(BeFalse())
+
+		now := time.Now()
+		upstream.Status.ObservedGeneration = upstream.GetGeneration()
+		upstream.Status.Conditions = []shipitv1beta1.HelmReleaseCondition{
+			{
+				Type:               hapi.Status_DEPLOYED.String(),
+				LastTransitionTime: metav1.NewTime(now.Add(-time.Minute)),
+			},
+		}
+
+		Expect(upstream.Deployed()).To(BeTrue())
+		Expect(soakRemaining(upstream, 5*time.Minute, now)).To(Equal(4 * time.Minute))
+		Expect(soakRemaining(upstream, time.Minute, now)).To(BeNumerically("<=", 0))
+
+		By("ignoring upstream releases with an undeployed spec")
+		upstream.SetGeneration(2)
+		Expect(upstream.Deployed()).To(BeFalse())
+
+		By("trusting releases deployed before their generation was observed")
+		upstream.Status.ObservedGeneration = 0
+		Expect(upstream.Deployed()).To(BeTrue())
+	})
+})
@@ -1,9 +1,13 @@
 package controllers
 
 import (
+	"fmt"
+	"time"
+
 	shipitv1beta1 "ship-it-operator/api/v1beta1"
 
 	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/proto/hapi/chart"
@@ -20,6 +24,7 @@ type ReleaseManager struct {
 
 func (m *ReleaseManager) updateCondition(rls *shipitv1beta1.HelmRelease, cond shipitv1beta1.HelmReleaseCondition) *shipitv1beta1.HelmRelease {
 	rls.Status.SetCondition(cond)
+	rls.Status.ObservedGeneration = rls.GetGeneration()
 	m.recorder.Event(rls, v1.EventTypeNormal, cond.Type, cond.Message)
 
 	return rls
@@ -33,9 +38,12 @@ func (m *ReleaseManager) Install(rls *shipitv1beta1.HelmRelease, chart *chart.Ch
 		helm.ReleaseName(rls.Spec.ReleaseName),
 		helm.ValueOverrides(rls.Spec.Values.Raw),
 	); err != nil {
+		observeOperation(rls, operationInstall, err)
 		return nil, err
 	}
 
+	observeOperation(rls, operationInstall, nil)
+
 	cond := shipitv1beta1.HelmReleaseCondition{
 		Type:    release.Status_PENDING_INSTALL.String(),
 		Message: "Installing release",
@@ -46,9 +54,12 @@ func (m *ReleaseManager) Install(rls *shipitv1beta1.HelmRelease, chart *chart.Ch
 
 func (m *ReleaseManager) Delete(rls *shipitv1beta1.HelmRelease) (*shipitv1beta1.HelmRelease, error) {
 	if _, err := m.helm.DeleteRelease(rls.Spec.ReleaseName); err != nil {
+		observeOperation(rls, operationDelete, err)
 		return nil, err
 	}
 
+	observeOperation(rls, operationDelete, nil)
+
 	cond := shipitv1beta1.HelmReleaseCondition{
 		Type:    release.Status_DELETING.String(),
 		Message: "Deleting release",
@@ -63,22 +74,46 @@ func (m *ReleaseManager) Upgrade(rls *shipitv1beta1.HelmRelease, chart *chart.Ch
 		chart,
 		helm.UpdateValueOverrides(rls.Spec.Values.Raw),
 	); err != nil {
+		observeOperation(rls, operationUpgrade, err)
 		return nil, err
 	}
 
+	observeOperation(rls, operationUpgrade, nil)
+
 	cond := shipitv1beta1.HelmReleaseCondition{
 		Type:    release.Status_PENDING_UPGRADE.String(),
 		Message: "Upgrading release",
 	}
 
+	if rls.Approved() {
+		cond.Message = fmt.Sprintf("Upgrading release approved by %s", rls.Status.Approval.ApprovedBy)
+	}
+
 	return m.updateCondition(rls, cond), nil
 }
 
+// RequestApproval marks the release's current spec as waiting for a manual
+// approval.
+func (m *ReleaseManager) RequestApproval(rls *shipitv1beta1.HelmRelease) *shipitv1beta1.HelmRelease {
+	rls.Status.Approval = &shipitv1beta1.ApprovalStatus{
+		Phase:        shipitv1beta1.ApprovalPending,
+		Generation:   rls.GetGeneration(),
+		ChartVersion: rls.Spec.Chart.Version,
+		RequestedAt:  metav1.Now(),
+	}
+
+	m.recorder.Event(rls, v1.EventTypeNormal, string(shipitv1beta1.ApprovalPending), "Upgrade is waiting for approval")
+	return rls
+}
+
 func (m *ReleaseManager) Rollback(rls *shipitv1beta1.HelmRelease) (*shipitv1beta1.HelmRelease, error) {
 	if _, err := m.helm.RollbackRelease(rls.Spec.ReleaseName); err != nil {
+		observeOperation(rls, operationRollback, err)
 		return nil, err
 	}
 
+	observeOperation(rls, operationRollback, nil)
+
 	cond := shipitv1beta1.HelmReleaseCondition{
 		Type:    release.Status_PENDING_ROLLBACK.String(),
 		Message: "Rolling back release",
@@ -90,15 +125,21 @@ func (m *ReleaseManager) Rollback(rls *shipitv1beta1.HelmRelease) (*shipitv1beta
 func (m *ReleaseManager) Deployed(rls *shipitv1beta1.HelmRelease) *shipitv1beta1.HelmRelease {
 	oldCondition := rls.Status.GetCondition()
 
-	var reason shipitv1beta1.HelmReleaseStatusReason
+	var (
+		reason    shipitv1beta1.HelmReleaseStatusReason
+		operation string
+	)
 
 	switch oldCondition.Type {
 	case release.Status_PENDING_INSTALL.String():
 		reason = shipitv1beta1.ReasonInstallSuccess
+		operation = operationInstall
 	case release.Status_PENDING_UPGRADE.String():
 		reason = shipitv1beta1.ReasonUpdateSuccess
+		operation = operationUpgrade
 	case release.Status_PENDING_ROLLBACK.String():
 		reason = shipitv1beta1.ReasonRollbackSuccess
+		operation = operationRollback
 	case release.Status_DEPLOYED.String():
 		reason = oldCondition.Reason
 	default:
@@ -111,6 +152,10 @@ func (m *ReleaseManager) Deployed(rls *shipitv1beta1.HelmRelease) *shipitv1beta1
 		Message: "Release deployed",
 	}
 
+	if started := oldCondition.LastTransitionTime; operation != "" && !started.IsZero() {
+		releaseTimeToDeployed.WithLabelValues(rls.Spec.ReleaseName, operation).Observe(time.Since(started.Time).Seconds())
+	}
+
 	return m.updateCondition(rls, cond)
 }
 
@@ -138,5 +183,9 @@ func (m *ReleaseManager) Failed(rls *shipitv1beta1.HelmRelease) *shipitv1beta1.H
 		Message: "Release failed",
 	}
 
+	if oldCondition.Type != release.Status_FAILED.String() {
+		releaseFailures.WithLabelValues(rls.Spec.ReleaseName, string(reason)).Inc()
+	}
+
 	return m.updateCondition(rls, cond)
 }
@@ -6,6 +6,7 @@ import (
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/helm/pkg/helm"
 	"k8s.io/helm/pkg/proto/hapi/chart"
@@ -96,4 +97,39 @@ var _ = Describe("ReleaseManager", func() {
 		_, err = fakeHelm.ReleaseStatus(releaseName)
 		Expect(err).To(Not(BeNil()))
 	})
+
+	It("should observe the release's lifecycle metrics", func() {
+		release.Spec.ReleaseName = "metrics-release"
+
+		operations := func(operation, result string) float64 {
+			return testutil.ToFloat64(releaseOperations.WithLabelValues("metrics-release", operation, result))
+		}
+
+		_, err := manager.Install(release, &chart.Chart{}, releaseName)
+		Expect(err).To(BeNil())
+		<-fakeRecorder.Events
+		Expect(operations(operationInstall, "success")).To(Equal(1.0))
+
+		By("counting failed releases once")
+		manager.Failed(release)
+		<-fakeRecorder.Events
+		manager.Failed(release)
+		<-fakeRecorder.Events
+
+		failures := releaseFailures.WithLabelValues("metrics-release", string(v1beta1.ReasonInstallError))
+		Expect(testutil.ToFloat64(failures)).To(Equal(1.0))
+
+		By("counting operation errors")
+		missing := &v1beta1.HelmRelease{
+			Spec: v1beta1.HelmReleaseSpec{
+				ReleaseName: "missing-release",
+			},
+		}
+
+		_, err = manager.Upgrade(missing, &chart.Chart{})
+		Expect(err).NotTo(BeNil())
+
+		upgradeErrors := releaseOperations.WithLabelValues("missing-release", operationUpgrade, "error")
+		Expect(testutil.ToFloat64(upgradeErrors)).To(Equal(1.0))
+	})
 })
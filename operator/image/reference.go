@@ -0,0 +1,230 @@
+// Package image parses the container image references of HelmReleases' values.
+// It's shared by the operator and syncd, so that both agree on which images a
+// release uses.
+package image
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// The grammar of image references, from the distribution project's reference
+// package. See https://github.com/distribution/distribution/blob/main/reference/reference.go
+var (
+	pathComponentPattern = regexp.MustCompile(`^[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*$`)
+	domainPattern        = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?$`)
+	tagPattern           = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+	digestPattern        = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]*(?:[-_+.][A-Za-z][A-Za-z0-9]*)*:[0-9a-fA-F]{32,}$`)
+)
+
+const (
+	// DockerHub is the canonical registry of images without a registry
+	DockerHub = "docker.io"
+
+	// officialNamespace is the Docker Hub namespace of official images, e.g.
+	// "redis" is "docker.io/library/redis"
+	officialNamespace = "library"
+
+	maxNameLength = 255
+)
+
+// dockerHubAliases are the other hosts that refer to Docker Hub
+var dockerHubAliases = map[string]bool{
+	"index.docker.io":      true,
+	"registry-1.docker.io": true,
+}
+
+// Reference is a parsed image reference, with its canonical registry and
+// repository
+type Reference struct {
+	Registry   string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// Name formats the canonical "registry/repository" of the reference
+func (r Reference) Name() string {
+	return r.Registry + "/" + r.Repository
+}
+
+// Split splits an image reference into its name, tag and digest, without
+// validating them, e.g. "registry:5000/app:1.0@sha256:..." is split into
+// "registry:5000/app", "1.0" and "sha256:...".
+func Split(s string) (name, tag, digest string) {
+	name = s
+
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, digest = name[:i], name[i+1:]
+	}
+
+	// the tag follows the last colon that's after the name's slashes, since
+	// the registry's port is also separated by a colon
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, tag = name[:i], name[i+1:]
+	}
+
+	return name, tag, digest
+}
+
+func join(name, tag, digest string) string {
+	if tag != "" {
+		name += ":" + tag
+	}
+
+	if digest != "" {
+		name += "@" + digest
+	}
+
+	return name
+}
+
+// ParseReference parses and normalizes an image reference of the form
+// "[registry/]repository[:tag][@digest]". The registry can have a port, and
+// the repository can have any number of path components. References without a
+// registry are Docker Hub images, and Docker Hub images without a namespace
+// are official images, so "redis:5" is "docker.io/library/redis:5".
+func ParseReference(s string) (*Reference, error) {
+	name, tag, digest := Split(s)
+
+	// separators without a tag or digest, e.g. "app:", are invalid
+	if join(name, tag, digest) != s {
+		return nil, fmt.Errorf("invalid image reference: %s", s)
+	}
+
+	ref, err := parseName(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag != "" && !ValidTag(tag) {
+		return nil, fmt.Errorf("invalid image tag: %s", s)
+	}
+
+	if digest != "" && !digestPattern.MatchString(digest) {
+		return nil, fmt.Errorf("invalid image digest: %s", s)
+	}
+
+	ref.Tag = tag
+	ref.Digest = digest
+
+	return ref, nil
+}
+
+// Parse parses and normalizes an image repository, and sets its tag. The
+// repository can be any reference accepted by ParseReference, e.g.
+// "registry:5000/team/app" or "redis", and the tag replaces its tag, if any.
+func Parse(repo string, tag string) (*Reference, error) {
+	ref, err := ParseReference(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if tag != "" {
+		if !ValidTag(tag) {
+			return nil, fmt.Errorf("invalid image tag: %s", tag)
+		}
+
+		ref.Tag = tag
+	}
+
+	return ref, nil
+}
+
+// ValidTag tells whether the tag is a valid image tag
+func ValidTag(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+func parseName(name string) (*Reference, error) {
+	if name == "" || len(name) > maxNameLength {
+		return nil, fmt.Errorf("invalid image repo: %s", name)
+	}
+
+	registry, repo := DockerHub, name
+
+	// the first component is a registry if it can't be a repository's, i.e.
+	// it has a port or a domain, or it's localhost
+	if i := strings.Index(name, "/"); i >= 0 {
+		first := name[:i]
+		if strings.ContainsAny(first, ".:") || first == "localhost" || first != strings.ToLower(first) {
+			registry, repo = first, name[i+1:]
+		}
+	}
+
+	if !domainPattern.MatchString(registry) {
+		return nil, fmt.Errorf("invalid image registry: %s", name)
+	}
+
+	for _, component := range strings.Split(repo, "/") {
+		if !pathComponentPattern.MatchString(component) {
+			return nil, fmt.Errorf("invalid image repo: %s", name)
+		}
+	}
+
+	registry, repo = Normalize(registry, repo)
+	return &Reference{Registry: registry, Repository: repo}, nil
+}
+
+// Normalize returns the canonical registry and repository of an image, e.g.
+// "index.docker.io" and "redis" are "docker.io" and "library/redis".
+func Normalize(registry, repository string) (string, string) {
+	if dockerHubAliases[registry] {
+		registry = DockerHub
+	}
+
+	if registry == DockerHub && !strings.Contains(repository, "/") {
+		repository = officialNamespace + "/" + repository
+	}
+
+	return registry, repository
+}
+
+// FromValue parses an "image" value of a release's decoded Helm values. The
+// image is either a block with "repository", "tag" and optional "digest" keys,
+// or a string like "registry/repository:tag".
+func FromValue(x interface{}) (*Reference, error) {
+	switch v := x.(type) {
+	case string:
+		ref, err := ParseReference(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if ref.Tag == "" {
+			return nil, fmt.Errorf("invalid image: missing tag: %s", v)
+		}
+
+		return ref, nil
+
+	case map[string]interface{}:
+		repo, _ := v["repository"].(string)
+		tag, _ := v["tag"].(string)
+		digest, _ := v["digest"].(string)
+
+		return FromFields(repo, tag, digest)
+	}
+
+	return nil, errors.New("invalid image: expected a string or an object")
+}
+
+// FromFields parses the fields of an image block
+func FromFields(repo, tag, digest string) (*Reference, error) {
+	if repo == "" {
+		return nil, errors.New("invalid yaml: missing \"repository\" key")
+	}
+
+	if tag == "" {
+		return nil, errors.New("invalid yaml: missing \"tag\" key")
+	}
+
+	ref, err := Parse(repo, tag)
+	if err != nil {
+		return nil, err
+	}
+
+	ref.Digest = digest
+	return ref, nil
+}
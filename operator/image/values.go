@@ -0,0 +1,43 @@
+package image
+
+// VisitValues calls visit with every image in a release's decoded Helm values,
+// whether it's a block or a string, and wherever it's nested, e.g. in a list
+// of sidecars. Values that aren't valid images are skipped.
+//
+// setTag replaces the image's tag in the values, and removes its digest,
+// which would pin it to the previous image. Images set as strings keep their
+// name as it's written, e.g. "redis:5" becomes "redis:6", like syncd's edits.
+func VisitValues(values interface{}, visit func(ref *Reference, setTag func(string))) {
+	switch v := values.(type) {
+	case []interface{}:
+		for _, item := range v {
+			VisitValues(item, visit)
+		}
+
+	case map[string]interface{}:
+		for key, value := range v {
+			if key == "image" {
+				if ref, err := FromValue(value); err == nil {
+					visit(ref, imageSetter(v, value))
+					continue
+				}
+			}
+
+			VisitValues(value, visit)
+		}
+	}
+}
+
+func imageSetter(parent map[string]interface{}, value interface{}) func(string) {
+	return func(tag string) {
+		switch img := value.(type) {
+		case string:
+			name, _, _ := Split(img)
+			parent["image"] = name + ":" + tag
+
+		case map[string]interface{}:
+			img["tag"] = tag
+			delete(img, "digest")
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package image
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVisitValues(t *testing.T) {
+	var values map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(`{
+		"image": {"repository": "foo/bar", "tag": "v1", "digest": "sha256:2c26b46b68ffc68ff99b453c1d30413413422d706483bfa0f98a5e886266e7ae"},
+		"sidecars": [
+			{"image": "registry.io/baz:v1"},
+			{"image": "redis:5"}
+		],
+		"init": {"image": "registry.io/missing-tag"}
+	}`), &values))
+
+	found := make(map[string]string)
+	VisitValues(values, func(ref *Reference, setTag func(string)) {
+		found[ref.Name()] = ref.Tag
+		setTag("v2")
+	})
+
+	assert.Equal(t, map[string]string{
+		"docker.io/foo/bar":       "v1",
+		"registry.io/baz":         "v1",
+		"docker.io/library/redis": "5",
+	}, found)
+
+	edited, err := json.Marshal(values)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"image": {"repository": "foo/bar", "tag": "v2"},
+		"sidecars": [
+			{"image": "registry.io/baz:v2"},
+			{"image": "redis:v2"}
+		],
+		"init": {"image": "registry.io/missing-tag"}
+	}`, string(edited))
+}
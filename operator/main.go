@@ -18,6 +18,7 @@ package main
 import (
 	"flag"
 	"os"
+	"strings"
 	"time"
 
 	shipitv1beta1 "ship-it-operator/api/v1beta1"
@@ -34,6 +35,7 @@ import (
 	"k8s.io/helm/pkg/helm"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -59,6 +61,21 @@ func main() {
 		enableLeaderElection bool
 		slackChannel         string
 		slackToken           string
+		slackEvents          string
+		webhookURL           string
+		webhookSecret        string
+		webhookEvents        string
+		teamsURL             string
+		teamsEvents          string
+		smtpAddr             string
+		smtpUsername         string
+		smtpPassword         string
+		smtpFrom             string
+		smtpTo               string
+		smtpEvents           string
+		notificationsConfig  string
+		notificationWindow   time.Duration
+		notificationBurst    int
 	)
 
 	flag.StringVar(&awsRegion, "aws-region", "us-east-1", "The AWS region where the operator's chart repository is hosted")
@@ -71,6 +88,21 @@ func main() {
 		"Enable leader election for controller manager. Enabling this will ensure there is only one active controller manager.")
 	flag.StringVar(&slackChannel, "slack-channel", "", "The channel to send Slack notifications to")
 	flag.StringVar(&slackToken, "slack-token", "", "API token for Slack")
+	flag.StringVar(&slackEvents, "slack-events", "", "Comma separated release phases to send Slack notifications for. The default is all of them")
+	flag.StringVar(&webhookURL, "webhook-url", "", "The URL to post JSON notifications to")
+	flag.StringVar(&webhookSecret, "webhook-secret", "", "The secret that signs webhook notifications")
+	flag.StringVar(&webhookEvents, "webhook-events", "", "Comma separated release phases to send webhook notifications for. The default is all of them")
+	flag.StringVar(&teamsURL, "teams-url", "", "The Microsoft Teams incoming webhook URL to send notifications to")
+	flag.StringVar(&teamsEvents, "teams-events", "", "Comma separated release phases to send Microsoft Teams notifications for. The default is all of them")
+	flag.StringVar(&smtpAddr, "smtp-addr", "", "The host:port of the SMTP server to email notifications through")
+	flag.StringVar(&smtpUsername, "smtp-username", "", "The SMTP server username")
+	flag.StringVar(&smtpPassword, "smtp-password", "", "The SMTP server password")
+	flag.StringVar(&smtpFrom, "smtp-from", "", "The sender address of email notifications")
+	flag.StringVar(&smtpTo, "smtp-to", "", "Comma separated recipients of email notifications")
+	flag.StringVar(&smtpEvents, "smtp-events", "", "Comma separated release phases to email notifications for. The default is all of them")
+	flag.StringVar(&notificationsConfig, "notifications-config", "", "A YAML file that configures additional notification backends")
+	flag.DurationVar(&notificationWindow, "notification-window", time.Minute, "The window in which a release's notifications are rate limited")
+	flag.IntVar(&notificationBurst, "notification-burst", 5, "The number of notifications a release can send per window before the rest are batched into a digest. Zero disables rate limiting")
 
 	flag.Parse()
 
@@ -100,10 +132,64 @@ func main() {
 		"s3": chartdownloader.NewS3Downloader(s3manager.NewDownloader(session)),
 	}
 
+	var notificationsCfg notifications.Config
+	if notificationsConfig != "" {
+		notificationsCfg, err = notifications.LoadConfig(notificationsConfig)
+		if err != nil {
+			setupLog.Error(err, "unable to load notifications config")
+			os.Exit(1)
+		}
+	}
+
+	if slackToken != "" {
+		notificationsCfg.Slack = append(notificationsCfg.Slack, notifications.SlackConfig{
+			Token:   slackToken,
+			Channel: slackChannel,
+			Events:  mustParsePhases(slackEvents),
+		})
+	}
+
+	if webhookURL != "" {
+		notificationsCfg.Webhooks = append(notificationsCfg.Webhooks, notifications.WebhookConfig{
+			URL:    webhookURL,
+			Secret: webhookSecret,
+			Events: mustParsePhases(webhookEvents),
+		})
+	}
+
+	if teamsURL != "" {
+		notificationsCfg.Teams = append(notificationsCfg.Teams, notifications.TeamsConfig{
+			URL:    teamsURL,
+			Events: mustParsePhases(teamsEvents),
+		})
+	}
+
+	if smtpAddr != "" {
+		notificationsCfg.SMTP = append(notificationsCfg.SMTP, notifications.SMTPConfig{
+			Addr:     smtpAddr,
+			Username: smtpUsername,
+			Password: smtpPassword,
+			From:     smtpFrom,
+			To:       strings.Split(smtpTo, ","),
+			Events:   mustParsePhases(smtpEvents),
+		})
+	}
+
+	fanout, err := notificationsCfg.Notifier()
+	if err != nil {
+		setupLog.Error(err, "unable to create notifiers")
+		os.Exit(1)
+	}
+
+	var notifier controllers.Notifier = fanout
+	if notificationBurst > 0 {
+		notifier = notifications.NewLimiter(fanout, notificationWindow, notificationBurst, ctrl.Log)
+	}
+
 	reconciler := controllers.NewHelmReleaseReconciler(
 		ctrl.Log,
 		mgr.GetClient(),
-		notifications.NewSlack(slackToken, slackChannel),
+		notifier,
 		helm.NewClient(helm.Host(tillerAddr)),
 		chartdownloader.New(downloaders),
 		mgr.GetEventRecorderFor("ship-it"),
@@ -116,6 +202,15 @@ func main() {
 		setupLog.Error(err, "unable to create controller", "controller", "HelmRelease")
 		os.Exit(1)
 	}
+
+	metrics.Registry.MustRegister(controllers.NewReleaseCollector(mgr.GetClient()))
+
+	setupLog.Info("setting up promotion controller")
+	promotions := controllers.NewPromotionReconciler(ctrl.Log, mgr.GetClient(), mgr.GetEventRecorderFor("ship-it"))
+	if err := promotions.SetupWithManager(mgr); err != nil {
+		setupLog.Error(err, "unable to create controller", "controller", "Promotion")
+		os.Exit(1)
+	}
 	// +kubebuilder:scaffold:builder
 
 	setupLog.Info("starting manager")
@@ -124,3 +219,12 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+func mustParsePhases(s string) []notifications.Phase {
+	phases, err := notifications.ParsePhases(s)
+	if err != nil {
+		setupLog.Error(err, "unable to parse notification events")
+		os.Exit(1)
+	}
+	return phases
+}
@@ -0,0 +1,156 @@
+package notifications
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// Config configures the notification backends. Each backend only sends the
+// notifications of its events, or all of them if it has no events.
+type Config struct {
+	Slack    []SlackConfig   `json:"slack,omitempty"`
+	Webhooks []WebhookConfig `json:"webhooks,omitempty"`
+	SMTP     []SMTPConfig    `json:"smtp,omitempty"`
+	Teams    []TeamsConfig   `json:"teams,omitempty"`
+}
+
+// SlackConfig configures a Slack notifier. Without a channel, notifications
+// are only sent to the releases' own channels.
+type SlackConfig struct {
+	Token   string  `json:"token"`
+	Channel string  `json:"channel"`
+	Events  []Phase `json:"events,omitempty"`
+}
+
+type WebhookConfig struct {
+	URL    string  `json:"url"`
+	Secret string  `json:"secret,omitempty"`
+	Events []Phase `json:"events,omitempty"`
+}
+
+type SMTPConfig struct {
+	Addr     string   `json:"addr"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Events   []Phase  `json:"events,omitempty"`
+}
+
+type TeamsConfig struct {
+	URL    string  `json:"url"`
+	Events []Phase `json:"events,omitempty"`
+}
+
+var phases = []Phase{
+	PhaseInstalling,
+	PhaseUpgrading,
+	PhaseApprovalPending,
+	PhaseDeployed,
+	PhaseFailed,
+	PhaseRollingBack,
+	PhaseDeleted,
+}
+
+// ParsePhases parses a comma separated list of phases
+func ParsePhases(s string) ([]Phase, error) {
+	var result []Phase
+
+	for _, p := range strings.Split(s, ",") {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		result = append(result, Phase(p))
+	}
+
+	return result, validatePhases(result)
+}
+
+func validatePhases(ps []Phase) error {
+	for _, p := range ps {
+		valid := false
+		for _, known := range phases {
+			valid = valid || p == known
+		}
+
+		if !valid {
+			return fmt.Errorf("unknown notification event %q", p)
+		}
+	}
+
+	return nil
+}
+
+// LoadConfig reads the YAML config file. Environment variables in the file,
+// such as $SLACK_TOKEN, are expanded so that secrets can be kept out of it.
+func LoadConfig(path string) (Config, error) {
+	var c Config
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return c, errors.Wrapf(err, "failed to read notifications config %s", path)
+	}
+
+	if err := yaml.Unmarshal([]byte(os.ExpandEnv(string(data))), &c); err != nil {
+		return c, errors.Wrapf(err, "failed to parse notifications config %s", path)
+	}
+
+	return c, nil
+}
+
+// Notifier creates a notifier that fans out to every configured backend
+func (c Config) Notifier() (*Fanout, error) {
+	var routes []Route
+
+	route := func(n Notifier, events []Phase) error {
+		if err := validatePhases(events); err != nil {
+			return err
+		}
+		routes = append(routes, Route{Notifier: n, Phases: events})
+		return nil
+	}
+
+	for _, s := range c.Slack {
+		if s.Token == "" {
+			return nil, errors.New("slack notifications require a token")
+		}
+		if err := route(NewSlack(s.Token, s.Channel), s.Events); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, w := range c.Webhooks {
+		if w.URL == "" {
+			return nil, errors.New("webhook notifications require a url")
+		}
+		if err := route(NewWebhook(w.URL, w.Secret), w.Events); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, s := range c.SMTP {
+		if s.Addr == "" || s.From == "" || len(s.To) == 0 {
+			return nil, errors.New("email notifications require an addr, a from address, and recipients")
+		}
+		if err := route(NewSMTP(s.Addr, s.Username, s.Password, s.From, s.To), s.Events); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, t := range c.Teams {
+		if t.URL == "" {
+			return nil, errors.New("teams notifications require a url")
+		}
+		if err := route(NewTeams(t.URL), t.Events); err != nil {
+			return nil, err
+		}
+	}
+
+	return NewFanout(routes...), nil
+}
@@ -0,0 +1,78 @@
+package notifications
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePhases(t *testing.T) {
+	phases, err := ParsePhases("Deployed, Failed,")
+	require.NoError(t, err)
+	assert.Equal(t, []Phase{PhaseDeployed, PhaseFailed}, phases)
+
+	phases, err = ParsePhases("")
+	require.NoError(t, err)
+	assert.Empty(t, phases)
+
+	_, err = ParsePhases("Deployed,Exploded")
+	assert.EqualError(t, err, `unknown notification event "Exploded"`)
+}
+
+func TestLoadConfig(t *testing.T) {
+	f, err := ioutil.TempFile("", "notifications")
+	require.NoError(t, err)
+	defer os.Remove(f.Name())
+
+	os.Setenv("TEST_WEBHOOK_SECRET", "secret")
+	defer os.Unsetenv("TEST_WEBHOOK_SECRET")
+
+	f.WriteString(`
+webhooks:
+- url: https://example.com/hook
+  secret: $TEST_WEBHOOK_SECRET
+  events: [Deployed, Failed]
+smtp:
+- addr: smtp.example.com:587
+  from: ship-it@example.com
+  to: [team@example.com]
+teams:
+- url: https://example.webhook.office.com/hook
+`)
+	f.Close()
+
+	cfg, err := LoadConfig(f.Name())
+	require.NoError(t, err)
+
+	assert.Equal(t, []WebhookConfig{{
+		URL:    "https://example.com/hook",
+		Secret: "secret",
+		Events: []Phase{PhaseDeployed, PhaseFailed},
+	}}, cfg.Webhooks)
+	assert.Equal(t, []string{"team@example.com"}, cfg.SMTP[0].To)
+	assert.Equal(t, "https://example.webhook.office.com/hook", cfg.Teams[0].URL)
+
+	notifier, err := cfg.Notifier()
+	require.NoError(t, err)
+	assert.Len(t, notifier.routes, 3)
+}
+
+func TestConfigNotifierValidation(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"slack without token": {Slack: []SlackConfig{{Channel: "deploys"}}},
+		"webhook without url": {Webhooks: []WebhookConfig{{Secret: "secret"}}},
+		"smtp without to":     {SMTP: []SMTPConfig{{Addr: "localhost:25", From: "ship-it@example.com"}}},
+		"teams without url":   {Teams: []TeamsConfig{{}}},
+		"unknown event":       {Teams: []TeamsConfig{{URL: "https://example.com", Events: []Phase{"Exploded"}}}},
+	} {
+		_, err := cfg.Notifier()
+		assert.Error(t, err, name)
+	}
+
+	notifier, err := Config{}.Notifier()
+	require.NoError(t, err)
+	assert.Empty(t, notifier.routes)
+}
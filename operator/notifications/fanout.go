@@ -0,0 +1,64 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Notifier sends a notification
+type Notifier interface {
+	Send(Notification) error
+}
+
+// Route sends notifications to a notifier. Only the notifications of the
+// route's phases are sent, or all of them if the route has no phases.
+type Route struct {
+	Notifier Notifier
+	Phases   []Phase
+}
+
+func (r Route) matches(n Notification) bool {
+	if len(r.Phases) == 0 {
+		return true
+	}
+
+	for _, p := range r.Phases {
+		if p == n.Phase {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Fanout sends notifications to all of its matching routes
+type Fanout struct {
+	routes []Route
+}
+
+// NewFanout creates a notifier that fans out to the routes
+func NewFanout(routes ...Route) *Fanout {
+	return &Fanout{routes: routes}
+}
+
+// Send sends the notification to every matching route. Every route is tried,
+// even if a previous one failed.
+func (f *Fanout) Send(n Notification) error {
+	var errs []string
+
+	for _, r := range f.routes {
+		if !r.matches(n) {
+			continue
+		}
+
+		if err := r.Notifier.Send(n); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send %d notification(s): %s", len(errs), strings.Join(errs, "; "))
+	}
+
+	return nil
+}
@@ -0,0 +1,46 @@
+package notifications
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingNotifier struct {
+	sent []Phase
+	err  error
+}
+
+func (r *recordingNotifier) Send(n Notification) error {
+	r.sent = append(r.sent, n.Phase)
+	return r.err
+}
+
+func TestFanoutFiltersRoutes(t *testing.T) {
+	all := new(recordingNotifier)
+	failures := new(recordingNotifier)
+
+	f := NewFanout(
+		Route{Notifier: all},
+		Route{Notifier: failures, Phases: []Phase{PhaseFailed, PhaseRollingBack}},
+	)
+
+	for _, p := range []Phase{PhaseUpgrading, PhaseFailed, PhaseRollingBack, PhaseDeployed} {
+		assert.NoError(t, f.Send(testNotification(p)))
+	}
+
+	assert.Equal(t, []Phase{PhaseUpgrading, PhaseFailed, PhaseRollingBack, PhaseDeployed}, all.sent)
+	assert.Equal(t, []Phase{PhaseFailed, PhaseRollingBack}, failures.sent)
+}
+
+func TestFanoutTriesEveryRoute(t *testing.T) {
+	failing := &recordingNotifier{err: errors.New("unavailable")}
+	working := new(recordingNotifier)
+
+	f := NewFanout(Route{Notifier: failing}, Route{Notifier: working})
+
+	err := f.Send(testNotification(PhaseDeployed))
+	assert.EqualError(t, err, "failed to send 1 notification(s): unavailable")
+	assert.Len(t, working.sent, 1)
+}
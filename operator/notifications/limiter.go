@@ -0,0 +1,122 @@
+package notifications
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+)
+
+// Limiter rate limits the notifications of each release. Up to burst
+// notifications per window are sent right away. Any more are held until the
+// end of the window, then sent together as a single digest notification.
+type Limiter struct {
+	next   Notifier
+	window time.Duration
+	burst  int
+	log    logr.Logger
+
+	mu       sync.Mutex
+	releases map[string]*bucket
+	now      func() time.Time
+	after    func(time.Duration, func())
+}
+
+type bucket struct {
+	start   time.Time
+	sent    int
+	pending []Notification
+}
+
+// NewLimiter creates a notifier that rate limits notifications to next.
+// Errors sending digests are logged.
+func NewLimiter(next Notifier, window time.Duration, burst int, log logr.Logger) *Limiter {
+	return &Limiter{
+		next:     next,
+		window:   window,
+		burst:    burst,
+		log:      log.WithName("notifications").WithName("Limiter"),
+		releases: make(map[string]*bucket),
+		now:      time.Now,
+		after: func(d time.Duration, f func()) {
+			time.AfterFunc(d, f)
+		},
+	}
+}
+
+// Send sends the notification, or holds it for the release's next digest
+func (l *Limiter) Send(n Notification) error {
+	key := n.Namespace + "/" + n.Name
+
+	l.mu.Lock()
+
+	now := l.now()
+	l.expire(now)
+
+	b, ok := l.releases[key]
+	if !ok {
+		b = &bucket{start: now}
+		l.releases[key] = b
+	}
+
+	if len(b.pending) > 0 || b.sent >= l.burst {
+		b.pending = append(b.pending, n)
+		if len(b.pending) == 1 {
+			l.after(b.start.Add(l.window).Sub(now), func() { l.flush(key) })
+		}
+
+		l.mu.Unlock()
+		return nil
+	}
+
+	b.sent++
+	l.mu.Unlock()
+
+	return l.next.Send(n)
+}
+
+// expire forgets the releases whose window has ended without any held
+// notifications
+func (l *Limiter) expire(now time.Time) {
+	for key, b := range l.releases {
+		if len(b.pending) == 0 && now.Sub(b.start) >= l.window {
+			delete(l.releases, key)
+		}
+	}
+}
+
+// flush sends the release's held notifications as a digest, which starts
+// the release's next window
+func (l *Limiter) flush(key string) {
+	l.mu.Lock()
+
+	b, ok := l.releases[key]
+	if !ok || len(b.pending) == 0 {
+		l.mu.Unlock()
+		return
+	}
+
+	pending := b.pending
+	l.releases[key] = &bucket{
+		start: l.now(),
+		sent:  1,
+	}
+
+	l.mu.Unlock()
+
+	n := Digest(pending)
+	if err := l.next.Send(n); err != nil {
+		l.log.Error(err, "failed to send notification digest", "release", n.Release, "phase", n.Phase)
+	}
+}
+
+// Digest combines the notifications into the most recent one, which lists
+// the earlier ones in its digest.
+func Digest(notifications []Notification) Notification {
+	latest := notifications[len(notifications)-1]
+
+	latest.Digest = make([]Notification, len(notifications)-1)
+	copy(latest.Digest, notifications)
+
+	return latest
+}
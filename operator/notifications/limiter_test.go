@@ -0,0 +1,118 @@
+package notifications
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+type capturingNotifier struct {
+	sent []Notification
+}
+
+func (c *capturingNotifier) Send(n Notification) error {
+	c.sent = append(c.sent, n)
+	return nil
+}
+
+func newTestLimiter(next Notifier) (*Limiter, *time.Time, *[]func()) {
+	now := time.Unix(1500000000, 0)
+	var scheduled []func()
+
+	l := NewLimiter(next, time.Minute, 2, log.Log)
+	l.now = func() time.Time { return now }
+	l.after = func(d time.Duration, f func()) {
+		scheduled = append(scheduled, f)
+	}
+
+	return l, &now, &scheduled
+}
+
+func TestLimiterSendsBurst(t *testing.T) {
+	next := new(capturingNotifier)
+	l, _, scheduled := newTestLimiter(next)
+
+	require.NoError(t, l.Send(testNotification(PhaseUpgrading)))
+	require.NoError(t, l.Send(testNotification(PhaseDeployed)))
+
+	assert.Len(t, next.sent, 2)
+	assert.Empty(t, *scheduled)
+}
+
+func TestLimiterDigestsExcess(t *testing.T) {
+	next := new(capturingNotifier)
+	l, _, scheduled := newTestLimiter(next)
+
+	for _, p := range []Phase{PhaseUpgrading, PhaseFailed, PhaseRollingBack, PhaseDeployed} {
+		require.NoError(t, l.Send(testNotification(p)))
+	}
+
+	require.Len(t, next.sent, 2)
+	require.Len(t, *scheduled, 1, "the digest is scheduled once")
+
+	(*scheduled)[0]()
+
+	require.Len(t, next.sent, 3)
+	digest := next.sent[2]
+	assert.Equal(t, PhaseDeployed, digest.Phase)
+	require.Len(t, digest.Digest, 1)
+	assert.Equal(t, PhaseRollingBack, digest.Digest[0].Phase)
+
+	// the digest counts towards the next window
+	require.NoError(t, l.Send(testNotification(PhaseUpgrading)))
+	require.NoError(t, l.Send(testNotification(PhaseDeployed)))
+	assert.Len(t, next.sent, 4)
+	assert.Len(t, *scheduled, 2)
+}
+
+func TestLimiterWindowResets(t *testing.T) {
+	next := new(capturingNotifier)
+	l, now, _ := newTestLimiter(next)
+
+	require.NoError(t, l.Send(testNotification(PhaseUpgrading)))
+	require.NoError(t, l.Send(testNotification(PhaseDeployed)))
+
+	*now = now.Add(time.Minute)
+
+	require.NoError(t, l.Send(testNotification(PhaseUpgrading)))
+	assert.Len(t, next.sent, 3)
+}
+
+func TestLimiterIsPerRelease(t *testing.T) {
+	next := new(capturingNotifier)
+	l, _, _ := newTestLimiter(next)
+
+	for _, name := range []string{"foo", "bar", "baz"} {
+		n := testNotification(PhaseUpgrading)
+		n.Name = name
+
+		require.NoError(t, l.Send(n))
+		require.NoError(t, l.Send(n))
+	}
+
+	assert.Len(t, next.sent, 6)
+}
+
+func TestDigest(t *testing.T) {
+	n := Digest([]Notification{
+		testNotification(PhaseUpgrading),
+		testNotification(PhaseFailed),
+		testNotification(PhaseRollingBack),
+	})
+
+	assert.Equal(t, PhaseRollingBack, n.Phase)
+	require.Len(t, n.Digest, 2)
+	assert.Equal(t, PhaseUpgrading, n.Digest[0].Phase)
+	assert.Equal(t, PhaseFailed, n.Digest[1].Phase)
+
+	var earlier string
+	for _, f := range n.facts() {
+		if f.name == "Earlier updates" {
+			earlier = f.value
+		}
+	}
+	assert.Equal(t, "⌛ `foo` is being upgraded.; 🔥 `foo` failed to deploy.", earlier)
+}
@@ -0,0 +1,149 @@
+package notifications
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Phase is the stage of a release rollout that a notification announces
+type Phase string
+
+const (
+	PhaseInstalling      Phase = "Installing"
+	PhaseUpgrading       Phase = "Upgrading"
+	PhaseApprovalPending Phase = "ApprovalPending"
+	PhaseDeployed        Phase = "Deployed"
+	PhaseFailed          Phase = "Failed"
+	PhaseRollingBack     Phase = "RollingBack"
+	PhaseDeleted         Phase = "Deleted"
+)
+
+// Image is a container image of a release. PreviousTag is set when the image
+// is replacing a previously deployed tag.
+type Image struct {
+	Repository  string `json:"repository"`
+	Tag         string `json:"tag"`
+	PreviousTag string `json:"previousTag,omitempty"`
+}
+
+// Links are the release's resources, from its HelmRelease annotations
+type Links struct {
+	Code      string `json:"code,omitempty"`
+	Datadog   string `json:"datadog,omitempty"`
+	Sumologic string `json:"sumologic,omitempty"`
+}
+
+// Notification describes a change in a release's rollout
+type Notification struct {
+	// Name, Namespace and Generation identify the HelmRelease spec that's
+	// being rolled out
+	Name       string `json:"name"`
+	Namespace  string `json:"namespace"`
+	Generation int64  `json:"generation"`
+
+	Release      string  `json:"release"`
+	Phase        Phase   `json:"phase"`
+	ChartVersion string  `json:"chartVersion,omitempty"`
+	Revision     int32   `json:"revision,omitempty"`
+	Images       []Image `json:"images,omitempty"`
+
+	// Message explains a failed rollout
+	Message string `json:"message,omitempty"`
+
+	// Squad and Channel are the release's owner, and the owner's Slack
+	// channel
+	Squad   string `json:"squad,omitempty"`
+	Channel string `json:"channel,omitempty"`
+
+	Links Links `json:"links"`
+
+	// Digest lists the earlier notifications that were held back by rate
+	// limiting, oldest first
+	Digest []Notification `json:"digest,omitempty"`
+}
+
+// Summary formats a short, human readable description of the notification
+func (n Notification) Summary() string {
+	switch n.Phase {
+	case PhaseInstalling:
+		return fmt.Sprintf("⌛ `%s` is being installed.", n.Release)
+	case PhaseUpgrading:
+		return fmt.Sprintf("⌛ `%s` is being upgraded.", n.Release)
+	case PhaseApprovalPending:
+		return fmt.Sprintf("✋ `%s` is waiting for approval to upgrade.", n.Release)
+	case PhaseDeployed:
+		return fmt.Sprintf("🚢 `%s` is now deployed.", n.Release)
+	case PhaseFailed:
+		return fmt.Sprintf("🔥 `%s` failed to deploy.", n.Release)
+	case PhaseRollingBack:
+		return fmt.Sprintf("⚠️ `%s` is being rolled back.", n.Release)
+	case PhaseDeleted:
+		return fmt.Sprintf("🗑️ `%s` has been deleted.", n.Release)
+	default:
+		return fmt.Sprintf("`%s` is %s.", n.Release, n.Phase)
+	}
+}
+
+type fact struct {
+	name  string
+	value string
+}
+
+// facts lists the notification's details for notifiers that render them as
+// name and value pairs
+func (n Notification) facts() []fact {
+	var facts []fact
+
+	if n.ChartVersion != "" {
+		facts = append(facts, fact{"Chart version", n.ChartVersion})
+	}
+	if n.Revision > 0 {
+		facts = append(facts, fact{"Revision", fmt.Sprint(n.Revision)})
+	}
+	if n.Squad != "" {
+		facts = append(facts, fact{"Squad", n.Squad})
+	}
+
+	for _, img := range n.Images {
+		tag := img.Tag
+		if img.PreviousTag != "" {
+			tag = fmt.Sprintf("%s → %s", img.PreviousTag, img.Tag)
+		}
+		facts = append(facts, fact{img.Repository, tag})
+	}
+
+	if n.Message != "" {
+		facts = append(facts, fact{"Error", n.Message})
+	}
+
+	if len(n.Digest) > 0 {
+		facts = append(facts, fact{"Earlier updates", strings.Join(n.digestSummaries(), "; ")})
+	}
+
+	return facts
+}
+
+// links lists the release's non-empty links
+func (l Links) links() []fact {
+	var links []fact
+
+	for _, link := range []fact{
+		{"Code", l.Code},
+		{"Datadog", l.Datadog},
+		{"Sumologic", l.Sumologic},
+	} {
+		if link.value != "" {
+			links = append(links, link)
+		}
+	}
+
+	return links
+}
+
+func (n Notification) digestSummaries() []string {
+	var summaries []string
+	for _, d := range n.Digest {
+		summaries = append(summaries, d.Summary())
+	}
+	return summaries
+}
@@ -1,26 +1,239 @@
 package notifications
 
-import "github.com/nlopes/slack"
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
-// Slack handles notifications to a slack channel
+	"github.com/nlopes/slack"
+)
+
+// ApproveActionID identifies the interactive Slack button that approves a
+// release's pending upgrade. The button's value is the HelmRelease name and
+// generation, formatted as "name/generation".
+const ApproveActionID = "approve"
+
+// threadTTL is how long a rollout's thread is remembered. Notifications
+// for older rollouts start a new thread.
+const threadTTL = 24 * time.Hour
+
+// Slack handles notifications to slack channels. Notifications are sent to
+// the global channel, and to the release's own channel. All notifications
+// for a rollout are threaded under the rollout's first message.
 type Slack struct {
 	client  *slack.Client
+	options []slack.Option
 	channel string
+
+	mu      sync.Mutex
+	threads map[threadKey]thread
+	now     func() time.Time
+}
+
+type threadKey struct {
+	channel    string
+	release    string
+	generation int64
+}
+
+type thread struct {
+	timestamp string
+	started   time.Time
+}
+
+// SlackOption configures a Slack notifier
+type SlackOption func(*Slack)
+
+// SlackAPIURL overrides the URL of the Slack API
+func SlackAPIURL(url string) SlackOption {
+	return func(s *Slack) {
+		s.options = append(s.options, slack.OptionAPIURL(url))
+	}
 }
 
 // NewSlack creates a new slack notifiier
-func NewSlack(token string, channel string) *Slack {
-	return &Slack{
-		client:  slack.New(token),
+func NewSlack(token string, channel string, opts ...SlackOption) *Slack {
+	s := &Slack{
 		channel: channel,
+		threads: make(map[threadKey]thread),
+		now:     time.Now,
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	s.client = slack.New(token, s.options...)
+	return s
+}
+
+// Send sends the notification to the global channel, and to the release's
+// channel
+func (s *Slack) Send(n Notification) error {
+	var errs []string
+
+	for _, channel := range s.channels(n) {
+		if err := s.post(channel, n); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", channel, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to send slack notification to %s", strings.Join(errs, ", "))
+	}
+
+	return nil
+}
+
+func (s *Slack) channels(n Notification) []string {
+	var channels []string
+
+	if s.channel != "" {
+		channels = append(channels, s.channel)
+	}
+
+	if n.Channel != "" && n.Channel != s.channel {
+		channels = append(channels, n.Channel)
+	}
+
+	return channels
+}
+
+func (s *Slack) post(channel string, n Notification) error {
+	key := threadKey{
+		channel:    channel,
+		release:    n.Namespace + "/" + n.Name,
+		generation: n.Generation,
+	}
+
+	opts := []slack.MsgOption{
+		slack.MsgOptionText(n.Summary(), false),
+		slack.MsgOptionBlocks(blocks(n)...),
+		slack.MsgOptionAsUser(true),
+	}
+
+	ts, threaded := s.thread(key)
+	if threaded {
+		opts = append(opts, slack.MsgOptionTS(ts))
+	}
+
+	_, timestamp, err := s.client.PostMessage(channel, opts...)
+	if err != nil {
+		return err
+	}
+
+	if !threaded {
+		s.startThread(key, timestamp)
 	}
+
+	return nil
+}
+
+// thread returns the timestamp of the rollout's first message
+func (s *Slack) thread(key threadKey) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	t, ok := s.threads[key]
+	if !ok || s.now().Sub(t.started) > threadTTL {
+		return "", false
+	}
+
+	return t.timestamp, true
+}
+
+func (s *Slack) startThread(key threadKey, timestamp string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+
+	// forget the threads of old rollouts
+	for k, t := range s.threads {
+		if now.Sub(t.started) > threadTTL {
+			delete(s.threads, k)
+		}
+	}
+
+	s.threads[key] = thread{
+		timestamp: timestamp,
+		started:   now,
+	}
+}
+
+// blocks renders the notification's Block Kit layout
+func blocks(n Notification) []slack.Block {
+	markdown := func(text string) *slack.TextBlockObject {
+		return slack.NewTextBlockObject(slack.MarkdownType, text, false, false)
+	}
+
+	var fields []*slack.TextBlockObject
+	if n.ChartVersion != "" {
+		fields = append(fields, markdown(fmt.Sprintf("*Chart version*\n%s", n.ChartVersion)))
+	}
+	if n.Revision > 0 {
+		fields = append(fields, markdown(fmt.Sprintf("*Revision*\n%d", n.Revision)))
+	}
+	if n.Squad != "" {
+		fields = append(fields, markdown(fmt.Sprintf("*Squad*\n%s", n.Squad)))
+	}
+
+	blocks := []slack.Block{
+		slack.NewSectionBlock(markdown(n.Summary()), fields, nil),
+	}
+
+	if len(n.Images) > 0 {
+		lines := []string{"*Images*"}
+		for _, img := range n.Images {
+			lines = append(lines, imageLine(img))
+		}
+		blocks = append(blocks, slack.NewSectionBlock(markdown(strings.Join(lines, "\n")), nil, nil))
+	}
+
+	if n.Message != "" {
+		blocks = append(blocks, slack.NewSectionBlock(markdown(fmt.Sprintf("*Error*\n```%s```", n.Message)), nil, nil))
+	}
+
+	if len(n.Digest) > 0 {
+		lines := []string{"*Earlier updates*"}
+		for _, summary := range n.digestSummaries() {
+			lines = append(lines, "• "+summary)
+		}
+		blocks = append(blocks, slack.NewSectionBlock(markdown(strings.Join(lines, "\n")), nil, nil))
+	}
+
+	if links := links(n.Links); links != "" {
+		blocks = append(blocks, slack.NewContextBlock("", markdown(links)))
+	}
+
+	if n.Phase == PhaseApprovalPending {
+		button := slack.NewButtonBlockElement(
+			ApproveActionID,
+			fmt.Sprintf("%s/%d", n.Name, n.Generation),
+			slack.NewTextBlockObject(slack.PlainTextType, "Approve", false, false),
+		)
+		button.WithStyle(slack.StylePrimary)
+
+		blocks = append(blocks, slack.NewActionBlock("", button))
+	}
+
+	return blocks
+}
+
+func imageLine(img Image) string {
+	if img.PreviousTag == "" {
+		return fmt.Sprintf("`%s`: `%s`", img.Repository, img.Tag)
+	}
+	return fmt.Sprintf("`%s`: `%s` → `%s`", img.Repository, img.PreviousTag, img.Tag)
 }
 
-// Send sends a message to the slack channel
-func (s *Slack) Send(message string) error {
-	_, _, err := s.client.PostMessage(
-		s.channel,
-		slack.MsgOptionText(message, false),
-		slack.MsgOptionAsUser(true))
-	return err
+func links(l Links) string {
+	var links []string
+
+	for _, link := range l.links() {
+		links = append(links, fmt.Sprintf("<%s|%s>", link.value, link.name))
+	}
+
+	return strings.Join(links, " · ")
 }
@@ -0,0 +1,201 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSlackAPI struct {
+	sync.Mutex
+	messages []url.Values
+}
+
+func (f *fakeSlackAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.Lock()
+	defer f.Unlock()
+
+	r.ParseForm()
+	f.messages = append(f.messages, r.PostForm)
+
+	fmt.Fprintf(w, `{"ok":true,"channel":%q,"ts":"%d.000"}`, r.PostForm.Get("channel"), len(f.messages))
+}
+
+func newTestSlack(channel string) (*Slack, *fakeSlackAPI, func()) {
+	api := new(fakeSlackAPI)
+	server := httptest.NewServer(api)
+
+	return NewSlack("token", channel, SlackAPIURL(server.URL+"/")), api, server.Close
+}
+
+func testNotification(phase Phase) Notification {
+	return Notification{
+		Name:         "foo",
+		Namespace:    "default",
+		Generation:   2,
+		Release:      "foo",
+		Phase:        phase,
+		ChartVersion: "1.2.3",
+		Revision:     3,
+		Images: []Image{
+			{Repository: "foo", Tag: "new", PreviousTag: "old"},
+		},
+		Squad:   "bar",
+		Channel: "foo-alerts",
+		Links: Links{
+			Code:    "https://github.com/example/foo",
+			Datadog: "https://app.datadoghq.com/dashboard/foo",
+		},
+	}
+}
+
+func TestSlackSendsToGlobalAndReleaseChannels(t *testing.T) {
+	s, api, done := newTestSlack("deploys")
+	defer done()
+
+	require.NoError(t, s.Send(testNotification(PhaseUpgrading)))
+	require.Len(t, api.messages, 2)
+
+	assert.Equal(t, "deploys", api.messages[0].Get("channel"))
+	assert.Equal(t, "foo-alerts", api.messages[1].Get("channel"))
+
+	for _, msg := range api.messages {
+		assert.Equal(t, "⌛ `foo` is being upgraded.", msg.Get("text"))
+		assert.Empty(t, msg.Get("thread_ts"))
+	}
+}
+
+func TestSlackSendsOnceToSharedChannel(t *testing.T) {
+	s, api, done := newTestSlack("foo-alerts")
+	defer done()
+
+	require.NoError(t, s.Send(testNotification(PhaseUpgrading)))
+	assert.Len(t, api.messages, 1)
+}
+
+func TestSlackThreadsRollout(t *testing.T) {
+	s, api, done := newTestSlack("deploys")
+	defer done()
+
+	require.NoError(t, s.Send(testNotification(PhaseUpgrading)))
+	require.NoError(t, s.Send(testNotification(PhaseDeployed)))
+	require.Len(t, api.messages, 4)
+
+	// each channel's rollout messages are replies to its first message
+	assert.Equal(t, "1.000", api.messages[2].Get("thread_ts"))
+	assert.Equal(t, "2.000", api.messages[3].Get("thread_ts"))
+
+	next := testNotification(PhaseUpgrading)
+	next.Generation = 3
+
+	require.NoError(t, s.Send(next))
+	require.Len(t, api.messages, 6)
+	assert.Empty(t, api.messages[4].Get("thread_ts"), "a new rollout starts a new thread")
+}
+
+func TestSlackForgetsOldThreads(t *testing.T) {
+	s, api, done := newTestSlack("deploys")
+	defer done()
+
+	now := time.Now()
+	s.now = func() time.Time { return now }
+
+	require.NoError(t, s.Send(testNotification(PhaseUpgrading)))
+
+	now = now.Add(threadTTL + time.Minute)
+	require.NoError(t, s.Send(testNotification(PhaseDeployed)))
+
+	require.Len(t, api.messages, 4)
+	assert.Empty(t, api.messages[2].Get("thread_ts"))
+	assert.Len(t, s.threads, 2)
+}
+
+func TestSlackBlocks(t *testing.T) {
+	s, api, done := newTestSlack("")
+	defer done()
+
+	n := testNotification(PhaseFailed)
+	n.Message = "timed out waiting for the condition"
+
+	require.NoError(t, s.Send(n))
+	require.Len(t, api.messages, 1)
+
+	blocks := api.messages[0].Get("blocks")
+	var decoded interface{}
+	require.NoError(t, json.Unmarshal([]byte(blocks), &decoded))
+
+	text := strings.Join(texts(decoded), "\n")
+	for _, expected := range []string{
+		"🔥 `foo` failed to deploy.",
+		"*Chart version*\n1.2.3",
+		"*Revision*\n3",
+		"*Squad*\nbar",
+		"`foo`: `old` → `new`",
+		"timed out waiting for the condition",
+		"<https://github.com/example/foo|Code> · <https://app.datadoghq.com/dashboard/foo|Datadog>",
+	} {
+		assert.Contains(t, text, expected)
+	}
+
+	assert.NotContains(t, blocks, ApproveActionID)
+}
+
+// texts returns every text in the decoded blocks
+func texts(v interface{}) []string {
+	var result []string
+
+	switch v := v.(type) {
+	case []interface{}:
+		for _, e := range v {
+			result = append(result, texts(e)...)
+		}
+	case map[string]interface{}:
+		for k, e := range v {
+			if s, ok := e.(string); ok && k == "text" {
+				result = append(result, s)
+				continue
+			}
+			result = append(result, texts(e)...)
+		}
+	}
+
+	return result
+}
+
+func TestSlackApprovalButton(t *testing.T) {
+	s, api, done := newTestSlack("deploys")
+	defer done()
+
+	require.NoError(t, s.Send(testNotification(PhaseApprovalPending)))
+
+	blocks := api.messages[0].Get("blocks")
+	assert.Contains(t, blocks, fmt.Sprintf(`"action_id":%q`, ApproveActionID))
+	assert.Contains(t, blocks, `"value":"foo/2"`)
+}
+
+func TestSlackReportsFailedChannels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.PostForm.Get("channel") == "foo-alerts" {
+			fmt.Fprint(w, `{"ok":false,"error":"channel_not_found"}`)
+			return
+		}
+		fmt.Fprint(w, `{"ok":true,"ts":"1.000"}`)
+	}))
+	defer server.Close()
+
+	s := NewSlack("token", "deploys", SlackAPIURL(server.URL+"/"))
+
+	err := s.Send(testNotification(PhaseDeployed))
+	require.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "foo-alerts: channel_not_found"), err.Error())
+}
@@ -0,0 +1,72 @@
+package notifications
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SMTP emails notifications
+type SMTP struct {
+	addr string
+	auth smtp.Auth
+	from string
+	to   []string
+}
+
+// NewSMTP creates an email notifier that sends through the SMTP server at
+// addr, formatted as "host:port". The server is authenticated with PLAIN auth
+// when the username isn't empty.
+func NewSMTP(addr, username, password, from string, to []string) *SMTP {
+	s := &SMTP{
+		addr: addr,
+		from: from,
+		to:   to,
+	}
+
+	if username != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		s.auth = smtp.PlainAuth("", username, password, host)
+	}
+
+	return s
+}
+
+// Send emails the notification to the recipients
+func (s *SMTP) Send(n Notification) error {
+	if err := smtp.SendMail(s.addr, s.auth, s.from, s.to, s.message(n)); err != nil {
+		return errors.Wrapf(err, "failed to send email to %s", strings.Join(s.to, ", "))
+	}
+	return nil
+}
+
+func (s *SMTP) message(n Notification) []byte {
+	var msg bytes.Buffer
+
+	fmt.Fprintf(&msg, "From: %s\r\n", s.from)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.to, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", n.Summary()))
+	fmt.Fprint(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprint(&msg, "Content-Type: text/plain; charset=utf-8\r\n")
+	fmt.Fprint(&msg, "\r\n")
+
+	fmt.Fprintf(&msg, "%s\r\n\r\n", n.Summary())
+
+	for _, f := range n.facts() {
+		fmt.Fprintf(&msg, "%s: %s\r\n", f.name, f.value)
+	}
+
+	if links := n.Links.links(); len(links) > 0 {
+		fmt.Fprint(&msg, "\r\n")
+		for _, link := range links {
+			fmt.Fprintf(&msg, "%s: %s\r\n", link.name, link.value)
+		}
+	}
+
+	return msg.Bytes()
+}
@@ -0,0 +1,107 @@
+package notifications
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type email struct {
+	from string
+	to   []string
+	data string
+}
+
+// serveSMTP accepts a single SMTP session on the listener, and sends the
+// received email to the channel
+func serveSMTP(l net.Listener, emails chan<- email) {
+	conn, err := l.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%s\r\n", line) }
+
+	var e email
+	reply("220 localhost ESMTP")
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		switch cmd := strings.ToUpper(strings.SplitN(line, " ", 2)[0]); {
+		case cmd == "EHLO" || cmd == "HELO":
+			reply("250 localhost")
+		case strings.HasPrefix(strings.ToUpper(line), "MAIL FROM:"):
+			e.from = strings.Trim(line[len("MAIL FROM:"):], "<>")
+			reply("250 OK")
+		case strings.HasPrefix(strings.ToUpper(line), "RCPT TO:"):
+			e.to = append(e.to, strings.Trim(line[len("RCPT TO:"):], "<>"))
+			reply("250 OK")
+		case cmd == "DATA":
+			reply("354 End data with <CR><LF>.<CR><LF>")
+			var data strings.Builder
+			for {
+				l, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if l == ".\r\n" {
+					break
+				}
+				data.WriteString(l)
+			}
+			e.data = data.String()
+			reply("250 OK")
+		case cmd == "QUIT":
+			reply("221 Bye")
+			emails <- e
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSMTPSendsEmail(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer l.Close()
+
+	emails := make(chan email, 1)
+	go serveSMTP(l, emails)
+
+	s := NewSMTP(l.Addr().String(), "", "", "ship-it@example.com", []string{"a@example.com", "b@example.com"})
+	require.NoError(t, s.Send(testNotification(PhaseDeployed)))
+
+	e := <-emails
+	assert.Equal(t, "ship-it@example.com", e.from)
+	assert.Equal(t, []string{"a@example.com", "b@example.com"}, e.to)
+
+	assert.Contains(t, e.data, "To: a@example.com, b@example.com\r\n")
+	assert.Contains(t, e.data, "Subject: =?utf-8?q?")
+	assert.Contains(t, e.data, "🚢 `foo` is now deployed.\r\n")
+	assert.Contains(t, e.data, "Chart version: 1.2.3\r\n")
+	assert.Contains(t, e.data, "foo: old → new\r\n")
+	assert.Contains(t, e.data, "Code: https://github.com/example/foo\r\n")
+}
+
+func TestSMTPConnectionFailure(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	s := NewSMTP(addr, "", "", "ship-it@example.com", []string{"a@example.com"})
+	assert.Error(t, s.Send(testNotification(PhaseDeployed)))
+}
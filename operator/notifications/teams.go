@@ -0,0 +1,111 @@
+package notifications
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// themeColors are the accent colors of Teams cards for each phase
+var themeColors = map[Phase]string{
+	PhaseDeployed:        "2EB67D",
+	PhaseFailed:          "E01E5A",
+	PhaseRollingBack:     "ECB22E",
+	PhaseApprovalPending: "ECB22E",
+}
+
+// Teams posts notifications to a Microsoft Teams incoming webhook
+type Teams struct {
+	url    string
+	client *http.Client
+}
+
+// NewTeams creates a Microsoft Teams notifier for the incoming webhook URL
+func NewTeams(url string) *Teams {
+	return &Teams{
+		url:    url,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+type messageCard struct {
+	Type            string        `json:"@type"`
+	Context         string        `json:"@context"`
+	Summary         string        `json:"summary"`
+	Title           string        `json:"title"`
+	ThemeColor      string        `json:"themeColor,omitempty"`
+	Sections        []cardSection `json:"sections,omitempty"`
+	PotentialAction []cardAction  `json:"potentialAction,omitempty"`
+}
+
+type cardSection struct {
+	Facts []cardFact `json:"facts"`
+}
+
+type cardFact struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type cardAction struct {
+	Type    string       `json:"@type"`
+	Name    string       `json:"name"`
+	Targets []cardTarget `json:"targets"`
+}
+
+type cardTarget struct {
+	OS  string `json:"os"`
+	URI string `json:"uri"`
+}
+
+// Send posts the notification as a message card
+func (t *Teams) Send(n Notification) error {
+	body, err := json.Marshal(card(n))
+	if err != nil {
+		return errors.Wrap(err, "failed to encode teams message card")
+	}
+
+	resp, err := t.client.Post(t.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to send teams message card")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("teams responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func card(n Notification) messageCard {
+	c := messageCard{
+		Type:       "MessageCard",
+		Context:    "https://schema.org/extensions",
+		Summary:    n.Summary(),
+		Title:      n.Summary(),
+		ThemeColor: themeColors[n.Phase],
+	}
+
+	if facts := n.facts(); len(facts) > 0 {
+		var section cardSection
+		for _, f := range facts {
+			section.Facts = append(section.Facts, cardFact{Name: f.name, Value: f.value})
+		}
+		c.Sections = append(c.Sections, section)
+	}
+
+	for _, link := range n.Links.links() {
+		c.PotentialAction = append(c.PotentialAction, cardAction{
+			Type:    "OpenUri",
+			Name:    link.name,
+			Targets: []cardTarget{{OS: "default", URI: link.value}},
+		})
+	}
+
+	return c
+}
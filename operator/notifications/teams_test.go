@@ -0,0 +1,53 @@
+package notifications
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTeamsSendsMessageCard(t *testing.T) {
+	var card messageCard
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&card)
+		w.Write([]byte("1"))
+	}))
+	defer server.Close()
+
+	n := testNotification(PhaseFailed)
+	n.Message = "timed out waiting for the condition"
+
+	require.NoError(t, NewTeams(server.URL).Send(n))
+
+	assert.Equal(t, "MessageCard", card.Type)
+	assert.Equal(t, "🔥 `foo` failed to deploy.", card.Title)
+	assert.Equal(t, themeColors[PhaseFailed], card.ThemeColor)
+
+	require.Len(t, card.Sections, 1)
+	assert.Equal(t, []cardFact{
+		{Name: "Chart version", Value: "1.2.3"},
+		{Name: "Revision", Value: "3"},
+		{Name: "Squad", Value: "bar"},
+		{Name: "foo", Value: "old → new"},
+		{Name: "Error", Value: "timed out waiting for the condition"},
+	}, card.Sections[0].Facts)
+
+	require.Len(t, card.PotentialAction, 2)
+	assert.Equal(t, "Code", card.PotentialAction[0].Name)
+	assert.Equal(t, "https://github.com/example/foo", card.PotentialAction[0].Targets[0].URI)
+}
+
+func TestTeamsFailureStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	err := NewTeams(server.URL).Send(testNotification(PhaseDeployed))
+	assert.EqualError(t, err, "teams responded with status 400")
+}
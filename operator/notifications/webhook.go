@@ -0,0 +1,95 @@
+package notifications
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// SignatureHeader is the webhook request header with the HMAC-SHA256
+	// signature of the request, formatted as "sha256=<hex digest>". The
+	// signature covers the timestamp header's value, a ".", and the body.
+	SignatureHeader = "X-Ship-It-Signature"
+
+	// TimestampHeader is the webhook request header with the unix time of
+	// the request
+	TimestampHeader = "X-Ship-It-Timestamp"
+)
+
+// webhookPayload is the JSON body of a webhook request
+type webhookPayload struct {
+	Notification
+	Summary string `json:"summary"`
+}
+
+// Webhook posts notifications as JSON to an HTTP endpoint
+type Webhook struct {
+	url    string
+	secret string
+	client *http.Client
+	now    func() time.Time
+}
+
+// NewWebhook creates a webhook notifier. Requests are signed when the secret
+// isn't empty.
+func NewWebhook(url string, secret string) *Webhook {
+	return &Webhook{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: 10 * time.Second},
+		now:    time.Now,
+	}
+}
+
+// Send posts the notification to the webhook
+func (w *Webhook) Send(n Notification) error {
+	body, err := json.Marshal(webhookPayload{
+		Notification: n,
+		Summary:      n.Summary(),
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode webhook payload")
+	}
+
+	req, err := http.NewRequest(http.MethodPost, w.url, bytes.NewReader(body))
+	if err != nil {
+		return errors.Wrap(err, "failed to create webhook request")
+	}
+
+	timestamp := strconv.FormatInt(w.now().Unix(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(TimestampHeader, timestamp)
+	if w.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(w.secret, timestamp, body))
+	}
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return errors.Wrap(err, "failed to send webhook request")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded with status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// Sign computes the webhook signature of the timestamp and body
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,65 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookSendsSignedNotification(t *testing.T) {
+	var (
+		header http.Header
+		body   []byte
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	webhook := NewWebhook(server.URL, "secret")
+	webhook.now = func() time.Time { return time.Unix(1500000000, 0) }
+
+	require.NoError(t, webhook.Send(testNotification(PhaseDeployed)))
+
+	assert.Equal(t, "application/json", header.Get("Content-Type"))
+	assert.Equal(t, "1500000000", header.Get(TimestampHeader))
+	assert.Equal(t, Sign("secret", "1500000000", body), header.Get(SignatureHeader))
+
+	var payload map[string]interface{}
+	require.NoError(t, json.Unmarshal(body, &payload))
+
+	assert.Equal(t, "foo", payload["release"])
+	assert.Equal(t, "Deployed", payload["phase"])
+	assert.Equal(t, "🚢 `foo` is now deployed.", payload["summary"])
+	assert.Equal(t, "1.2.3", payload["chartVersion"])
+}
+
+func TestWebhookWithoutSecretIsUnsigned(t *testing.T) {
+	var header http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+	}))
+	defer server.Close()
+
+	require.NoError(t, NewWebhook(server.URL, "").Send(testNotification(PhaseDeployed)))
+	assert.Empty(t, header.Get(SignatureHeader))
+}
+
+func TestWebhookFailureStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	err := NewWebhook(server.URL, "secret").Send(testNotification(PhaseDeployed))
+	assert.EqualError(t, err, "webhook responded with status 502")
+}
@@ -57,7 +57,20 @@ class ExpandedCard extends React.Component {
                                     }
                                     label="AutoDeploy"
                                 />
+                                <FormControlLabel
+                                    control={
+                                        <Switch color="primary" checked={this.props.data.imageSync && !this.props.data.pin} />
+                                    }
+                                    label="ImageSync"
+                                />
                             </div>
+                            {this.props.data.pin &&
+                                <div className="pin-status">
+                                    <Typography variant="h7">
+                                        Pinned by {this.props.data.pin.by}{this.props.data.pin.reason && `: ${this.props.data.pin.reason}`}
+                                    </Typography>
+                                </div>
+                            }
                         </MuiThemeProvider>
 
                         <MuiThemeProvider theme={themes.link}>
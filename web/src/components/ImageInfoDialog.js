@@ -29,6 +29,7 @@ class ImageInfoDialog extends React.Component {
                         <Typography><label>Registry:</label> {this.getRegistry(d)}</Typography>
                         <Typography><label>Repository:</label> {this.getRepo(d)}</Typography>
                         <Typography><label>Tag:</label> {this.getTag(d)}</Typography>
+                        {d.digest && <Typography><label>Digest:</label> {d.digest}</Typography>}
                     </ListItemText>
                 </ListItem>
             </div>